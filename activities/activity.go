@@ -0,0 +1,111 @@
+// Package activities lets workers outside the Go process run Task states. A Task whose
+// Resource is "activity:<name>" is queued for the named activity; external workers
+// long-poll for it, then report success, failure or heartbeats with its task token.
+// A Task whose Resource ends in ".waitForTaskToken" runs the handler of the rest of the
+// resource with a generated task token, then waits for another system to report the
+// outcome with that token. Tasks are kept in Redis, so the process running the execution
+// and the process serving the workers may differ.
+package activities
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ResourcePrefix marks the Task resources served by activities
+const ResourcePrefix = "activity:"
+
+// Callback tasks. The handler of a "<resource>.waitForTaskToken" Task receives its
+// Parameters with the token under TaskTokenField; TaskTokenTimeoutField, when set, is
+// how many seconds to wait for the callback and is not passed on.
+const (
+	WaitForTaskTokenSuffix = ".waitForTaskToken"
+	TaskTokenField         = "TaskToken"
+	TaskTokenTimeoutField  = "TaskTokenTimeoutSeconds"
+)
+
+// Errors reported to the Task state. Retry and Catch match them by name.
+const (
+	ErrorTaskFailed       = "States.TaskFailed"
+	ErrorHeartbeatTimeout = "States.HeartbeatTimeout"
+	ErrorTimeout          = "States.Timeout"
+	ErrorRuntime          = "States.Runtime"
+)
+
+// Task statuses
+const (
+	TaskScheduled = "SCHEDULED" // Waiting for a worker to poll it
+	TaskStarted   = "STARTED"   // Polled by a worker, or handed to the system that calls back
+	TaskSucceeded = "SUCCEEDED"
+	TaskFailed    = "FAILED"
+	TaskTimedOut  = "TIMED_OUT" // The worker stopped heartbeating, no callback came in time, or the Task state timed out
+)
+
+var (
+	// ErrNotFound is returned when an activity does not exist
+	ErrNotFound = errors.New("activity not found")
+	// ErrExists is returned when an activity is created twice
+	ErrExists = errors.New("activity already exists")
+	// ErrTaskNotFound is returned for an unknown or expired task token
+	ErrTaskNotFound = errors.New("activity task not found")
+	// ErrTaskNotRunning is returned when a task reported on has finished or timed out
+	ErrTaskNotRunning = errors.New("activity task is not running")
+)
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,80}$`)
+
+// Activity is a kind of work done by external workers
+type Activity struct {
+	Name             string    `json:"name"`
+	Description      string    `json:"description,omitempty"`
+	HeartbeatSeconds int       `json:"heartbeatSeconds,omitempty"` // A started task fails when no heartbeat arrives for this long; 0 disables it
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+// Task is a Task state execution handed to a worker, or waiting for a callback
+type Task struct {
+	Token            string      `json:"taskToken"`
+	Activity         string      `json:"activity,omitempty"`
+	Resource         string      `json:"resource,omitempty"` // The ".waitForTaskToken" resource of a callback task
+	Input            interface{} `json:"input"`
+	HeartbeatSeconds int         `json:"heartbeatSeconds,omitempty"`
+	Status           string      `json:"status"`
+	WorkerName       string      `json:"workerName,omitempty"`
+	CreatedAt        time.Time   `json:"createdAt"`
+	StartedAt        *time.Time  `json:"startedAt,omitempty"`
+}
+
+// ValidName reports whether name can name an activity
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// Resource returns the Task resource that runs on an activity
+func Resource(name string) string {
+	return ResourcePrefix + name
+}
+
+// activityName returns the activity a Task resource targets
+func activityName(resource string) (string, bool) {
+	name, ok := strings.CutPrefix(resource, ResourcePrefix)
+	return name, ok && name != ""
+}
+
+// callbackResource returns the resource whose handler a ".waitForTaskToken" Task runs
+func callbackResource(resource string) (string, bool) {
+	base, ok := strings.CutSuffix(resource, WaitForTaskTokenSuffix)
+	return base, ok && base != ""
+}
+
+// TaskError is a failure reported by a worker. Its message is the error name, which the
+// Retry and Catch fields of the Task state match; Cause holds the worker's description.
+type TaskError struct {
+	Name  string
+	Cause string
+}
+
+func (e *TaskError) Error() string {
+	return e.Name
+}
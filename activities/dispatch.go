@@ -0,0 +1,207 @@
+package activities
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/types"
+	"github.com/redis/go-redis/v9"
+)
+
+// waitInterval bounds each blocking read of task events, so a cancelled execution or a
+// missed heartbeat is noticed
+const waitInterval = time.Second
+
+// ExecutionContext resolves "activity:<name>" Task resources to activities, runs
+// "<resource>.waitForTaskToken" resources as callback tasks and leaves the other
+// resources to the handlers registered on the base context
+type ExecutionContext struct {
+	base  types.ExecutionContext
+	store *Store
+}
+
+// NewExecutionContext wraps base so Task states can run on activities
+func NewExecutionContext(base types.ExecutionContext, store *Store) *ExecutionContext {
+	return &ExecutionContext{base: base, store: store}
+}
+
+// GetTaskHandler implements types.ExecutionContext
+func (e *ExecutionContext) GetTaskHandler(resource string) (func(context.Context, interface{}) (interface{}, error), bool) {
+	if base, ok := callbackResource(resource); ok {
+		handler, exists := e.GetTaskHandler(base)
+		if !exists {
+			return nil, false
+		}
+		return func(ctx context.Context, input interface{}) (interface{}, error) {
+			return e.store.Callback(ctx, resource, handler, input)
+		}, true
+	}
+	if name, ok := activityName(resource); ok {
+		return func(ctx context.Context, input interface{}) (interface{}, error) {
+			return e.store.Run(ctx, name, input)
+		}, true
+	}
+	if e.base == nil {
+		return nil, false
+	}
+	return e.base.GetTaskHandler(resource)
+}
+
+// Run schedules a task on an activity and waits until a worker completes it. A started
+// task whose worker stops heartbeating fails with States.HeartbeatTimeout; a failure
+// reported by the worker is returned as a *TaskError.
+func (s *Store) Run(ctx context.Context, name string, input interface{}) (interface{}, error) {
+	activity, err := s.Get(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("activity %s: %w", name, err)
+	}
+	token, err := s.schedule(ctx, activity, input)
+	if err != nil {
+		return nil, err
+	}
+	return s.wait(ctx, token, name, 0, time.Duration(activity.HeartbeatSeconds)*time.Second)
+}
+
+// Callback runs the handler of a ".waitForTaskToken" resource with a new task token
+// added to its input, then waits until the token is reported on. When the input sets
+// TaskTokenTimeoutSeconds, a task with no report by then fails with States.Timeout. On a
+// context with parking, the execution parks on the token instead of waiting, and the
+// rerun of the Task state by its resume returns the reported outcome.
+func (s *Store) Callback(ctx context.Context, resource string, handler func(context.Context, interface{}) (interface{}, error), input interface{}) (interface{}, error) {
+	params, ok := input.(map[string]interface{})
+	if !ok {
+		return nil, &TaskError{Name: ErrorRuntime, Cause: resource + " needs Parameters that are an object"}
+	}
+	params = maps.Clone(params)
+	var timeout time.Duration
+	if value, ok := params[TaskTokenTimeoutField]; ok {
+		seconds, ok := value.(float64)
+		if !ok || seconds <= 0 {
+			return nil, &TaskError{Name: ErrorRuntime, Cause: TaskTokenTimeoutField + " must be a positive number"}
+		}
+		timeout = time.Duration(seconds * float64(time.Second))
+		delete(params, TaskTokenTimeoutField)
+	}
+
+	parking := ParkingFrom(ctx)
+	if parking != nil {
+		if token, ok := parking.replay(); ok {
+			return s.outcome(ctx, token)
+		}
+	}
+
+	token, err := s.start(ctx, resource, params)
+	if err != nil {
+		return nil, err
+	}
+	params[TaskTokenField] = token
+	if _, err := handler(ctx, params); err != nil {
+		return s.abandonAs(token, "", TaskFailed, err)
+	}
+	if parking != nil {
+		parking.park(&ParkedTask{Token: token, Timeout: timeout})
+		return nil, errParked
+	}
+	return s.wait(ctx, token, "", timeout, 0)
+}
+
+// outcome returns the outcome reported on a callback task its execution parked on
+func (s *Store) outcome(ctx context.Context, token string) (interface{}, error) {
+	data, err := s.client.LIndex(ctx, eventsKey(token), -1).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("%w: %s has no outcome", ErrTaskNotFound, token)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e event
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("invalid outcome of task %s: %w", token, err)
+	}
+	output, done, err := e.result()
+	if !done {
+		return nil, fmt.Errorf("task %s has no outcome yet", token)
+	}
+	return output, err
+}
+
+// wait waits for the outcome of a started or scheduled task. A task whose events stop
+// for longer than heartbeat, or that has no outcome after timeout, is abandoned.
+func (s *Store) wait(ctx context.Context, token, activity string, timeout, heartbeat time.Duration) (interface{}, error) {
+	var timeoutAt time.Time
+	if timeout > 0 {
+		timeoutAt = s.now().Add(timeout)
+	}
+	var deadline time.Time // Set once the task started, when the activity has heartbeats
+	for {
+		switch {
+		case ctx.Err() != nil:
+			return s.abandon(token, activity, ctx.Err())
+		case !timeoutAt.IsZero() && !s.now().Before(timeoutAt):
+			return s.abandon(token, activity, &TaskError{Name: ErrorTimeout, Cause: fmt.Sprintf("no callback within %s", timeout)})
+		case !deadline.IsZero() && !s.now().Before(deadline):
+			return s.abandon(token, activity, &TaskError{Name: ErrorHeartbeatTimeout, Cause: fmt.Sprintf("no heartbeat for %s", heartbeat)})
+		}
+
+		popped, err := s.client.BLPop(ctx, waitInterval, eventsKey(token)).Result()
+		if errors.Is(err, redis.Nil) || (err != nil && ctx.Err() != nil) {
+			continue
+		}
+		if err != nil {
+			return s.abandon(token, activity, err)
+		}
+		var e event
+		if err := json.Unmarshal([]byte(popped[1]), &e); err != nil {
+			continue
+		}
+		if output, done, err := e.result(); done {
+			return output, err
+		}
+		if heartbeat > 0 {
+			deadline = s.now().Add(heartbeat)
+		}
+	}
+}
+
+// result returns the outcome of a task when the event finished it
+func (e *event) result() (output interface{}, done bool, err error) {
+	switch e.Type {
+	case "succeeded":
+		return e.Output, true, nil
+	case "failed":
+		return nil, true, &TaskError{Name: e.Error, Cause: e.Cause}
+	}
+	return nil, false, nil
+}
+
+// abandon times out a task the execution stops waiting on. A task the worker finished
+// in the meantime keeps its outcome.
+func (s *Store) abandon(token, activity string, cause error) (interface{}, error) {
+	return s.abandonAs(token, activity, TaskTimedOut, cause)
+}
+
+// abandonAs ends a task with status unless it finished in the meantime
+func (s *Store) abandonAs(token, activity, status string, cause error) (interface{}, error) {
+	ctx := context.Background()
+	status, err := abandonTask.Run(ctx, s.client, []string{taskKey(token), pendingPrefix + activity}, token, status).Text()
+	if err != nil || (status != TaskSucceeded && status != TaskFailed) {
+		return nil, cause
+	}
+	for {
+		data, err := s.client.LPop(ctx, eventsKey(token)).Bytes()
+		if err != nil {
+			return nil, cause
+		}
+		var e event
+		if json.Unmarshal(data, &e) != nil {
+			continue
+		}
+		if output, done, err := e.result(); done {
+			return output, err
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// TimeoutCorrelationPrefix prefixes the correlation ID of the timeout task scheduled for a
+// parked callback task; the rest is the task token
+const TimeoutCorrelationPrefix = "task-"
+
+// errParked is returned to the Task state that parked its execution. The execution stops
+// on it, since the parking context is cancelled at the same time.
+var errParked = errors.New("execution parked on a callback task")
+
+// ParkedTask is the callback task an execution parked on
+type ParkedTask struct {
+	Token   string
+	Timeout time.Duration // From TaskTokenTimeoutSeconds; 0 waits for ever
+
+	// The tokens of the earlier attempts of the Task state, which failed, and Token
+	Attempts []string
+
+	// The Task state that parked and its input, set by the repository saving the
+	// execution once the state's history is saved
+	StateName string
+	Input     interface{}
+}
+
+// ParkedExecution is the execution a callback task resumes once it is reported on
+type ParkedExecution struct {
+	ExecutionID    string
+	StateMachineID string
+	ExecutionName  string
+}
+
+// Parking lets the ".waitForTaskToken" Tasks of an execution park it rather than hold
+// the process running it until the callback. A Task that parks cancels the execution's
+// context, so the execution stops after the Task state; the repository then saves it
+// PAUSED and the report on the token queues its resume. The resumed execution reruns the
+// Task state, which returns the outcomes reported on its attempts in turn, so its Retry
+// policy counts the attempts made before it parked.
+type Parking struct {
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	parked   *ParkedTask
+	replays  []string // Tokens whose outcomes the rerun Task state returns, in turn
+	attempts []string // Tokens replayed since the last state finished
+}
+
+type parkingContextKey struct{}
+
+// WithParking returns a context on which ".waitForTaskToken" Tasks park the execution run
+// with it. Run a single execution per parking context, and call cancel when it returns.
+func WithParking(ctx context.Context) (context.Context, *Parking, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	parking := &Parking{cancel: cancel}
+	return context.WithValue(ctx, parkingContextKey{}, parking), parking, cancel
+}
+
+// ParkingFrom returns the parking set with WithParking, or nil
+func ParkingFrom(ctx context.Context) *Parking {
+	parking, _ := ctx.Value(parkingContextKey{}).(*Parking)
+	return parking
+}
+
+// Resume makes the rerun of the Task state an execution parked in return the outcomes
+// reported on attempts, the Attempts of the task it parked on, instead of handing out new
+// tokens
+func (p *Parking) Resume(attempts []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.replays = append([]string(nil), attempts...)
+	p.attempts = nil
+}
+
+// StateFinished tells the parking that a state of the execution finished, so a Task state
+// parking later starts counting its attempts afresh
+func (p *Parking) StateFinished() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.replays = nil
+	p.attempts = nil
+}
+
+// Parked returns the task the execution parked on, or nil
+func (p *Parking) Parked() *ParkedTask {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.parked
+}
+
+// replay returns the next token whose outcome the rerun Task state returns
+func (p *Parking) replay() (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.replays) == 0 {
+		return "", false
+	}
+	token := p.replays[0]
+	p.replays = p.replays[1:]
+	p.attempts = append(p.attempts, token)
+	return token, true
+}
+
+// park records the task the execution parks on and stops the execution
+func (p *Parking) park(task *ParkedTask) {
+	p.mu.Lock()
+	task.Attempts = append(append([]string(nil), p.attempts...), task.Token)
+	p.parked = task
+	p.mu.Unlock()
+	p.cancel()
+}
@@ -0,0 +1,388 @@
+package activities
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis keys. Every task is a hash, and the messages for the execution waiting on it
+// (started, heartbeat, succeeded, failed) are pushed to its events list, which expires
+// with the task.
+const (
+	activitiesKey = "state-machine:activities"
+	pendingPrefix = "state-machine:activities:pending:" // + activity name: tokens waiting for a worker
+	taskPrefix    = "state-machine:activities:task:"    // + token
+	parkedPrefix  = "state-machine:activities:parked:"  // + execution ID: attempts of the task it is parked on
+	eventsSuffix  = ":events"
+)
+
+// taskTTL bounds how long a task is kept, finished or not
+const taskTTL = 7 * 24 * time.Hour
+
+// claimTask starts a scheduled task for a worker
+var claimTask = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "status") ~= "SCHEDULED" then
+	return 0
+end
+redis.call("HSET", KEYS[1], "status", "STARTED", "startedAt", ARGV[1], "workerName", ARGV[2])
+redis.call("RPUSH", KEYS[2], ARGV[3])
+redis.call("EXPIRE", KEYS[2], math.max(redis.call("TTL", KEYS[1]), 1))
+return 1`)
+
+// reportTask pushes an event for a started task, finishing it when ARGV[1] is set. It
+// returns PARKED when it finishes a task an execution is parked on.
+var reportTask = redis.NewScript(`
+local status = redis.call("HGET", KEYS[1], "status")
+if not status then
+	return "NOT_FOUND"
+end
+if status ~= "STARTED" then
+	return status
+end
+if ARGV[1] ~= "" then
+	redis.call("HSET", KEYS[1], "status", ARGV[1])
+end
+redis.call("RPUSH", KEYS[2], ARGV[2])
+redis.call("EXPIRE", KEYS[2], math.max(redis.call("TTL", KEYS[1]), 1))
+if ARGV[1] ~= "" and redis.call("HEXISTS", KEYS[1], "executionId") == 1 then
+	return "PARKED"
+end
+return "OK"`)
+
+// parkTask records the execution parked on a task, and the attempts of the task on the
+// execution, and returns the task status. Together with reportTask, exactly one of the
+// report and the parking sees the other.
+var parkTask = redis.NewScript(`
+local status = redis.call("HGET", KEYS[1], "status")
+if not status then
+	return "NOT_FOUND"
+end
+redis.call("HSET", KEYS[1], "executionId", ARGV[1], "stateMachineId", ARGV[2], "executionName", ARGV[3])
+redis.call("SET", KEYS[2], ARGV[4], "EX", ARGV[5])
+return status`)
+
+// abandonTask ends a task that has not finished with the status ARGV[2]
+var abandonTask = redis.NewScript(`
+local status = redis.call("HGET", KEYS[1], "status")
+if status == "SCHEDULED" or status == "STARTED" then
+	redis.call("HSET", KEYS[1], "status", ARGV[2])
+	redis.call("LREM", KEYS[2], 0, ARGV[1])
+	return "OK"
+end
+return status or "NOT_FOUND"`)
+
+// event is a message for the execution waiting on a task
+type event struct {
+	Type   string      `json:"type"` // started, heartbeat, succeeded or failed
+	Output interface{} `json:"output,omitempty"`
+	Error  string      `json:"error,omitempty"`
+	Cause  string      `json:"cause,omitempty"`
+}
+
+// Store keeps activities and their tasks in Redis
+type Store struct {
+	client *redis.Client
+	now    func() time.Time
+}
+
+// NewStore returns an activity store over client
+func NewStore(client *redis.Client) *Store {
+	return &Store{client: client, now: time.Now}
+}
+
+func taskKey(token string) string {
+	return taskPrefix + token
+}
+
+func parkedKey(executionID string) string {
+	return parkedPrefix + executionID
+}
+
+func eventsKey(token string) string {
+	return taskPrefix + token + eventsSuffix
+}
+
+// Create saves a new activity; it returns ErrExists if the name is taken
+func (s *Store) Create(ctx context.Context, activity *Activity) error {
+	activity.CreatedAt = s.now().UTC()
+	data, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	created, err := s.client.HSetNX(ctx, activitiesKey, activity.Name, data).Result()
+	if err != nil {
+		return err
+	}
+	if !created {
+		return ErrExists
+	}
+	return nil
+}
+
+// Get returns an activity, or ErrNotFound
+func (s *Store) Get(ctx context.Context, name string) (*Activity, error) {
+	data, err := s.client.HGet(ctx, activitiesKey, name).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var activity Activity
+	if err := json.Unmarshal(data, &activity); err != nil {
+		return nil, fmt.Errorf("invalid activity %s: %w", name, err)
+	}
+	return &activity, nil
+}
+
+// List returns every activity, by name
+func (s *Store) List(ctx context.Context) ([]*Activity, error) {
+	entries, err := s.client.HGetAll(ctx, activitiesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	activities := make([]*Activity, 0, len(entries))
+	for name, data := range entries {
+		var activity Activity
+		if err := json.Unmarshal([]byte(data), &activity); err != nil {
+			return nil, fmt.Errorf("invalid activity %s: %w", name, err)
+		}
+		activities = append(activities, &activity)
+	}
+	sort.Slice(activities, func(i, j int) bool { return activities[i].Name < activities[j].Name })
+	return activities, nil
+}
+
+// Delete removes an activity. Tasks already scheduled on it keep running.
+func (s *Store) Delete(ctx context.Context, name string) error {
+	removed, err := s.client.HDel(ctx, activitiesKey, name).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// schedule queues a task for the workers of an activity
+func (s *Store) schedule(ctx context.Context, activity *Activity, input interface{}) (string, error) {
+	token := uuid.NewString()
+	data, err := json.Marshal(input)
+	if err != nil {
+		return "", fmt.Errorf("invalid activity input: %w", err)
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, taskKey(token),
+			"activity", activity.Name,
+			"input", data,
+			"heartbeatSeconds", activity.HeartbeatSeconds,
+			"status", TaskScheduled,
+			"createdAt", s.now().UTC().Format(time.RFC3339Nano),
+		)
+		pipe.Expire(ctx, taskKey(token), taskTTL)
+		pipe.LPush(ctx, pendingPrefix+activity.Name, token)
+		return nil
+	})
+	return token, err
+}
+
+// start creates a callback task, started as soon as its handler runs
+func (s *Store) start(ctx context.Context, resource string, input interface{}) (string, error) {
+	token := uuid.NewString()
+	data, err := json.Marshal(input)
+	if err != nil {
+		return "", fmt.Errorf("invalid task input: %w", err)
+	}
+	now := s.now().UTC().Format(time.RFC3339Nano)
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, taskKey(token),
+			"resource", resource,
+			"input", data,
+			"status", TaskStarted,
+			"createdAt", now,
+			"startedAt", now,
+		)
+		pipe.Expire(ctx, taskKey(token), taskTTL)
+		return nil
+	})
+	return token, err
+}
+
+// Poll waits up to wait for a task of an activity and starts it for the worker. It
+// returns nil when no task arrived in time.
+func (s *Store) Poll(ctx context.Context, name, workerName string, wait time.Duration) (*Task, error) {
+	deadline := s.now().Add(wait)
+	for {
+		remaining := deadline.Sub(s.now())
+		if remaining < time.Second {
+			remaining = time.Second
+		}
+		popped, err := s.client.BRPop(ctx, remaining, pendingPrefix+name).Result()
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		token := popped[1]
+
+		// A worker that went away while the task was popped leaves it for the next one
+		if ctx.Err() != nil {
+			s.client.RPush(context.Background(), pendingPrefix+name, token)
+			return nil, ctx.Err()
+		}
+
+		startedAt := s.now().UTC()
+		started, _ := json.Marshal(event{Type: "started"})
+		claimed, err := claimTask.Run(ctx, s.client, []string{taskKey(token), eventsKey(token)},
+			startedAt.Format(time.RFC3339Nano), workerName, started).Int()
+		if err != nil {
+			return nil, err
+		}
+		if claimed == 1 {
+			return s.task(ctx, token)
+		}
+		// The task timed out before a worker picked it up
+		if !s.now().Before(deadline) {
+			return nil, nil
+		}
+	}
+}
+
+// task reads a task
+func (s *Store) task(ctx context.Context, token string) (*Task, error) {
+	fields, err := s.client.HGetAll(ctx, taskKey(token)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, ErrTaskNotFound
+	}
+	task := &Task{
+		Token:      token,
+		Activity:   fields["activity"],
+		Resource:   fields["resource"],
+		Status:     fields["status"],
+		WorkerName: fields["workerName"],
+	}
+	task.HeartbeatSeconds, _ = strconv.Atoi(fields["heartbeatSeconds"])
+	task.CreatedAt, _ = time.Parse(time.RFC3339Nano, fields["createdAt"])
+	if startedAt, err := time.Parse(time.RFC3339Nano, fields["startedAt"]); err == nil {
+		task.StartedAt = &startedAt
+	}
+	if err := json.Unmarshal([]byte(fields["input"]), &task.Input); err != nil {
+		return nil, fmt.Errorf("invalid input of activity task %s: %w", token, err)
+	}
+	return task, nil
+}
+
+// report pushes an event for a started task. It returns the execution parked on the
+// task when the event finished it.
+func (s *Store) report(ctx context.Context, token, status string, e event) (*ParkedExecution, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("invalid activity output: %w", err)
+	}
+	result, err := reportTask.Run(ctx, s.client, []string{taskKey(token), eventsKey(token)}, status, data).Text()
+	switch {
+	case err != nil:
+		return nil, err
+	case result == "NOT_FOUND":
+		return nil, ErrTaskNotFound
+	case result == "PARKED":
+		return s.parkedExecution(ctx, token)
+	case result != "OK":
+		return nil, fmt.Errorf("%w: it is %s", ErrTaskNotRunning, result)
+	}
+	return nil, nil
+}
+
+// parkedExecution reads the execution parked on a task
+func (s *Store) parkedExecution(ctx context.Context, token string) (*ParkedExecution, error) {
+	fields, err := s.client.HMGet(ctx, taskKey(token), "executionId", "stateMachineId", "executionName").Result()
+	if err != nil {
+		return nil, err
+	}
+	execution := &ParkedExecution{}
+	execution.ExecutionID, _ = fields[0].(string)
+	execution.StateMachineID, _ = fields[1].(string)
+	execution.ExecutionName, _ = fields[2].(string)
+	return execution, nil
+}
+
+// Succeed completes a task with its output. It returns the execution parked on the task,
+// for the caller to resume, or nil when none is.
+func (s *Store) Succeed(ctx context.Context, token string, output interface{}) (*ParkedExecution, error) {
+	return s.report(ctx, token, TaskSucceeded, event{Type: "succeeded", Output: output})
+}
+
+// Fail completes a task with an error. The error name is what the Retry and Catch
+// fields of the Task state match; it defaults to States.TaskFailed. Like Succeed, it
+// returns the execution parked on the task.
+func (s *Store) Fail(ctx context.Context, token, errorName, cause string) (*ParkedExecution, error) {
+	if errorName == "" {
+		errorName = ErrorTaskFailed
+	}
+	return s.report(ctx, token, TaskFailed, event{Type: "failed", Error: errorName, Cause: cause})
+}
+
+// TimeOut fails a callback task that got no report within timeout with States.Timeout.
+// Like Succeed, it returns the execution parked on the task.
+func (s *Store) TimeOut(ctx context.Context, token string, timeout time.Duration) (*ParkedExecution, error) {
+	return s.report(ctx, token, TaskTimedOut, event{Type: "failed", Error: ErrorTimeout, Cause: fmt.Sprintf("no callback within %s", timeout)})
+}
+
+// Heartbeat tells the execution waiting on a task that its worker is still working
+func (s *Store) Heartbeat(ctx context.Context, token string) error {
+	_, err := s.report(ctx, token, "", event{Type: "heartbeat"})
+	return err
+}
+
+// Park records that execution is parked on a callback task, the last of the task
+// attempts. It reports whether the task was already reported on, in which case the
+// caller resumes the execution at once.
+func (s *Store) Park(ctx context.Context, attempts []string, execution *ParkedExecution) (bool, error) {
+	if len(attempts) == 0 {
+		return false, ErrTaskNotFound
+	}
+	data, err := json.Marshal(attempts)
+	if err != nil {
+		return false, err
+	}
+	token := attempts[len(attempts)-1]
+	status, err := parkTask.Run(ctx, s.client, []string{taskKey(token), parkedKey(execution.ExecutionID)},
+		execution.ExecutionID, execution.StateMachineID, execution.ExecutionName, data, int(taskTTL/time.Second)).Text()
+	switch {
+	case err != nil:
+		return false, err
+	case status == "NOT_FOUND":
+		return false, ErrTaskNotFound
+	}
+	return status != TaskStarted, nil
+}
+
+// ParkedAttempts returns the attempts of the callback task execution was last parked on,
+// the last one being the token it waits on, or nil when it was not parked
+func (s *Store) ParkedAttempts(ctx context.Context, executionID string) ([]string, error) {
+	data, err := s.client.Get(ctx, parkedKey(executionID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var attempts []string
+	if err := json.Unmarshal(data, &attempts); err != nil {
+		return nil, fmt.Errorf("invalid parked attempts: %w", err)
+	}
+	return attempts, nil
+}
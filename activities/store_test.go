@@ -0,0 +1,173 @@
+package activities
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newStore(t *testing.T) *Store {
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return NewStore(client)
+}
+
+type runResult struct {
+	output interface{}
+	err    error
+}
+
+// runAsync runs a task on an activity the way an execution does, in the background
+func runAsync(ctx context.Context, store *Store, name string, input interface{}) <-chan runResult {
+	done := make(chan runResult, 1)
+	go func() {
+		output, err := store.Run(ctx, name, input)
+		done <- runResult{output: output, err: err}
+	}()
+	return done
+}
+
+func result(t *testing.T, done <-chan runResult) runResult {
+	select {
+	case r := <-done:
+		return r
+	case <-time.After(10 * time.Second):
+		t.Fatal("the execution is still waiting on its task")
+		return runResult{}
+	}
+}
+
+func TestStore_PollAndSucceed(t *testing.T) {
+	store := newStore(t)
+	ctx := context.Background()
+	assert.NoError(t, store.Create(ctx, &Activity{Name: "review"}))
+	assert.ErrorIs(t, store.Create(ctx, &Activity{Name: "review"}), ErrExists)
+
+	done := runAsync(ctx, store, "review", map[string]interface{}{"orderId": "A1"})
+	task, err := store.Poll(ctx, "review", "worker-1", 5*time.Second)
+	assert.NoError(t, err)
+	if !assert.NotNil(t, task) {
+		return
+	}
+	assert.Equal(t, "review", task.Activity)
+	assert.Equal(t, TaskStarted, task.Status)
+	assert.Equal(t, "worker-1", task.WorkerName)
+	assert.Equal(t, map[string]interface{}{"orderId": "A1"}, task.Input)
+	assert.NotNil(t, task.StartedAt)
+
+	// A claimed task is handed to one worker only
+	other, err := store.Poll(ctx, "review", "worker-2", 0)
+	assert.NoError(t, err)
+	assert.Nil(t, other)
+
+	assert.NoError(t, store.Heartbeat(ctx, task.Token))
+	parked, err := store.Succeed(ctx, task.Token, map[string]interface{}{"approved": true})
+	assert.NoError(t, err)
+	assert.Nil(t, parked)
+	r := result(t, done)
+	assert.NoError(t, r.err)
+	assert.Equal(t, map[string]interface{}{"approved": true}, r.output)
+
+	// A finished task takes no further reports
+	_, err = store.Fail(ctx, task.Token, "", "late")
+	assert.ErrorIs(t, err, ErrTaskNotRunning)
+	assert.ErrorIs(t, store.Heartbeat(ctx, "unknown"), ErrTaskNotFound)
+	finished, err := store.task(ctx, task.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, TaskSucceeded, finished.Status)
+}
+
+func TestStore_RunReturnsWorkerFailures(t *testing.T) {
+	store := newStore(t)
+	ctx := context.Background()
+	assert.NoError(t, store.Create(ctx, &Activity{Name: "review"}))
+
+	done := runAsync(ctx, store, "review", nil)
+	task, err := store.Poll(ctx, "review", "worker-1", 5*time.Second)
+	assert.NoError(t, err)
+	_, err = store.Fail(ctx, task.Token, "", "document unreadable")
+	assert.NoError(t, err)
+	assert.Equal(t, &TaskError{Name: ErrorTaskFailed, Cause: "document unreadable"}, result(t, done).err)
+
+	done = runAsync(ctx, store, "review", nil)
+	task, err = store.Poll(ctx, "review", "worker-1", 5*time.Second)
+	assert.NoError(t, err)
+	_, err = store.Fail(ctx, task.Token, "Review.Rejected", "fraud")
+	assert.NoError(t, err)
+	assert.Equal(t, &TaskError{Name: "Review.Rejected", Cause: "fraud"}, result(t, done).err)
+
+	_, err = store.Run(ctx, "missing", nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestStore_HeartbeatTimeout(t *testing.T) {
+	store := newStore(t)
+	ctx := context.Background()
+	assert.NoError(t, store.Create(ctx, &Activity{Name: "review", HeartbeatSeconds: 1}))
+
+	done := runAsync(ctx, store, "review", nil)
+	task, err := store.Poll(ctx, "review", "worker-1", 5*time.Second)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Heartbeat(ctx, task.Token))
+
+	// The worker stops heartbeating
+	r := result(t, done)
+	var taskErr *TaskError
+	if assert.ErrorAs(t, r.err, &taskErr) {
+		assert.Equal(t, ErrorHeartbeatTimeout, taskErr.Name)
+	}
+	timedOut, err := store.task(ctx, task.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, TaskTimedOut, timedOut.Status)
+	_, err = store.Succeed(ctx, task.Token, nil)
+	assert.ErrorIs(t, err, ErrTaskNotRunning)
+}
+
+func TestStore_AbandonedTasks(t *testing.T) {
+	store := newStore(t)
+	ctx := context.Background()
+	activity := &Activity{Name: "review"}
+	assert.NoError(t, store.Create(ctx, activity))
+
+	// A scheduled task is withdrawn from the workers
+	token, err := store.schedule(ctx, activity, nil)
+	assert.NoError(t, err)
+	cause := &TaskError{Name: ErrorTimeout}
+	_, err = store.abandon(token, "review", cause)
+	assert.Equal(t, cause, err)
+	task, err := store.Poll(ctx, "review", "worker-1", 0)
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+
+	// A task the worker finished before it was abandoned keeps its outcome
+	token, err = store.schedule(ctx, activity, nil)
+	assert.NoError(t, err)
+	task, err = store.Poll(ctx, "review", "worker-1", 5*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, token, task.Token)
+	_, err = store.Succeed(ctx, token, "done")
+	assert.NoError(t, err)
+	output, err := store.abandon(token, "review", cause)
+	assert.NoError(t, err)
+	assert.Equal(t, "done", output)
+	finished, err := store.task(ctx, token)
+	assert.NoError(t, err)
+	assert.Equal(t, TaskSucceeded, finished.Status)
+
+	// An execution that stops waiting abandons its task
+	cancelled, cancel := context.WithCancel(ctx)
+	done := runAsync(cancelled, store, "review", nil)
+	assert.Eventually(t, func() bool {
+		pending, _ := store.client.LLen(ctx, pendingPrefix+"review").Result()
+		return pending == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, result(t, done).err, context.Canceled)
+	task, err = store.Poll(ctx, "review", "worker-1", 0)
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+}
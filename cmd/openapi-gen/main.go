@@ -0,0 +1,64 @@
+// Command openapi-gen keeps openapi/openapi.json in sync with the routes registered by
+// SetupRouter and the structs of the models package.
+//
+// Usage:
+//
+//	go run ./cmd/openapi-gen -spec openapi/openapi.json -models models [-check]
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	statemachinegin "github.com/hussainpithawala/state-machine-amz-gin"
+	"github.com/hussainpithawala/state-machine-amz-gin/openapi"
+)
+
+func main() {
+	specPath := flag.String("spec", "openapi/openapi.json", "path of the OpenAPI document to update")
+	modelsDir := flag.String("models", "models", "directory of the models package")
+	check := flag.Bool("check", false, "report drift and exit non-zero instead of writing the spec")
+	flag.Parse()
+
+	specData, err := os.ReadFile(*specPath)
+	if err != nil {
+		log.Fatalf("Failed to read spec: %v", err)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(specData, &doc); err != nil {
+		log.Fatalf("Failed to parse spec: %v", err)
+	}
+
+	models, err := openapi.ParseModels(*modelsDir)
+	if err != nil {
+		log.Fatalf("Failed to parse models: %v", err)
+	}
+
+	drift := openapi.Sync(doc, statemachinegin.APIRoutes(), models)
+	if drift.Empty() {
+		fmt.Println("OpenAPI specification is up to date")
+		return
+	}
+
+	fmt.Print(drift.String())
+	if *check {
+		os.Exit(1)
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(doc); err != nil {
+		log.Fatalf("Failed to encode spec: %v", err)
+	}
+	if err := os.WriteFile(*specPath, buf.Bytes(), 0o600); err != nil {
+		log.Fatalf("Failed to write spec: %v", err)
+	}
+	fmt.Printf("Updated %s\n", *specPath)
+}
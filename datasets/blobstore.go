@@ -0,0 +1,30 @@
+// Package datasets stores bulk input files uploaded ahead of the bulk executions that
+// read them. Files are uploaded in chunks to a BlobStore and described by a manifest.
+package datasets
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned when a blob or dataset does not exist
+var ErrNotFound = errors.New("not found")
+
+// BlobInfo describes a stored blob
+type BlobInfo struct {
+	Key  string
+	Size int64
+}
+
+// BlobStore is the storage datasets are kept in. Keys are slash-separated paths.
+type BlobStore interface {
+	// Put stores the body under key, replacing an existing blob, and returns its size
+	Put(ctx context.Context, key string, body io.Reader) (int64, error)
+	// Get opens a blob; it returns ErrNotFound when the key does not exist
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes a blob; deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+	// List returns the blobs whose keys start with prefix, sorted by key
+	List(ctx context.Context, prefix string) ([]BlobInfo, error)
+}
@@ -0,0 +1,278 @@
+package datasets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Dataset statuses. A dataset accepts chunks while it is uploading and can be read by
+// bulk executions once it is ready.
+const (
+	StatusUploading = "UPLOADING"
+	StatusReady     = "READY"
+)
+
+// MaxChunks bounds the number of chunks of a dataset
+const MaxChunks = 10000
+
+// Blob key layout: one manifest per dataset and its chunks below chunks/<id>/
+const (
+	manifestPrefix = "manifests/"
+	chunkPrefix    = "chunks/"
+)
+
+// Dataset is the manifest of an uploaded input file
+type Dataset struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name,omitempty"`
+	Format      string            `json:"format"`            // "ndjson" or "csv"
+	Mapping     map[string]string `json:"mapping,omitempty"` // CSV header -> input field
+	Types       map[string]string `json:"types,omitempty"`   // CSV input field -> type hint
+	Status      string            `json:"status"`
+	Chunks      int               `json:"chunks"`   // Number of chunks, set when the upload completes
+	Size        int64             `json:"size"`     // Total size in bytes, set when the upload completes
+	RowCount    int               `json:"rowCount"` // Number of inputs, set when the upload completes
+	CreatedAt   time.Time         `json:"createdAt"`
+	CompletedAt *time.Time        `json:"completedAt,omitempty"`
+	ExpiresAt   time.Time         `json:"expiresAt"`
+}
+
+// Expired reports whether the dataset expired at the given time
+func (d *Dataset) Expired(now time.Time) bool {
+	return !d.ExpiresAt.IsZero() && !now.Before(d.ExpiresAt)
+}
+
+// Chunk describes an uploaded chunk of a dataset
+type Chunk struct {
+	Index int
+	Size  int64
+}
+
+// Store keeps datasets in a BlobStore. Expired datasets are reported as not found and
+// deleted when they are next read or listed.
+type Store struct {
+	blobs BlobStore
+	now   func() time.Time
+}
+
+// NewStore returns a dataset store over blobs
+func NewStore(blobs BlobStore) *Store {
+	return &Store{blobs: blobs, now: time.Now}
+}
+
+func manifestKey(id string) string {
+	return manifestPrefix + id + ".json"
+}
+
+func chunkKey(id string, index int) string {
+	return fmt.Sprintf("%s%s/%08d", chunkPrefix, id, index)
+}
+
+// validID rejects IDs that were not issued by Create, which also keeps them from
+// escaping the key layout
+func validID(id string) bool {
+	_, err := uuid.Parse(id)
+	return err == nil && len(id) == 36
+}
+
+// Create assigns an ID to a new dataset and saves it in the uploading state
+func (s *Store) Create(ctx context.Context, dataset *Dataset) error {
+	dataset.ID = uuid.NewString()
+	dataset.Status = StatusUploading
+	dataset.CreatedAt = s.now().UTC()
+	return s.Save(ctx, dataset)
+}
+
+// Save writes the manifest of a dataset
+func (s *Store) Save(ctx context.Context, dataset *Dataset) error {
+	data, err := json.Marshal(dataset)
+	if err != nil {
+		return err
+	}
+	_, err = s.blobs.Put(ctx, manifestKey(dataset.ID), bytes.NewReader(data))
+	return err
+}
+
+// Get returns a dataset, or ErrNotFound if it does not exist or has expired
+func (s *Store) Get(ctx context.Context, id string) (*Dataset, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	dataset, err := s.load(ctx, manifestKey(id))
+	if err != nil {
+		return nil, err
+	}
+	if dataset.Expired(s.now()) {
+		_ = s.Delete(ctx, id)
+		return nil, ErrNotFound
+	}
+	return dataset, nil
+}
+
+func (s *Store) load(ctx context.Context, key string) (*Dataset, error) {
+	blob, err := s.blobs.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = blob.Close()
+	}()
+
+	var dataset Dataset
+	if err := json.NewDecoder(blob).Decode(&dataset); err != nil {
+		return nil, fmt.Errorf("invalid dataset manifest %s: %w", key, err)
+	}
+	return &dataset, nil
+}
+
+// List returns the datasets that have not expired, newest first, and deletes the
+// expired ones
+func (s *Store) List(ctx context.Context) ([]*Dataset, error) {
+	manifests, err := s.blobs.List(ctx, manifestPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	datasets := make([]*Dataset, 0, len(manifests))
+	for _, manifest := range manifests {
+		dataset, err := s.load(ctx, manifest.Key)
+		if errors.Is(err, ErrNotFound) {
+			continue // deleted while listing
+		}
+		if err != nil {
+			return nil, err
+		}
+		if dataset.Expired(now) {
+			_ = s.Delete(ctx, dataset.ID)
+			continue
+		}
+		datasets = append(datasets, dataset)
+	}
+	sort.SliceStable(datasets, func(i, j int) bool { return datasets[i].CreatedAt.After(datasets[j].CreatedAt) })
+	return datasets, nil
+}
+
+// Delete removes a dataset and its chunks. The manifest goes last, so a failed delete
+// can be retried.
+func (s *Store) Delete(ctx context.Context, id string) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+	chunks, err := s.blobs.List(ctx, chunkPrefix+id+"/")
+	if err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		if err := s.blobs.Delete(ctx, chunk.Key); err != nil {
+			return err
+		}
+	}
+	return s.blobs.Delete(ctx, manifestKey(id))
+}
+
+// PutChunk stores a chunk of a dataset, replacing an earlier upload of the same index
+func (s *Store) PutChunk(ctx context.Context, id string, index int, body io.Reader) (int64, error) {
+	if index < 0 || index >= MaxChunks {
+		return 0, fmt.Errorf("chunk index must be between 0 and %d", MaxChunks-1)
+	}
+	return s.blobs.Put(ctx, chunkKey(id, index), body)
+}
+
+// DeleteChunk removes an uploaded chunk
+func (s *Store) DeleteChunk(ctx context.Context, id string, index int) error {
+	return s.blobs.Delete(ctx, chunkKey(id, index))
+}
+
+// Chunks returns the uploaded chunks of a dataset by index
+func (s *Store) Chunks(ctx context.Context, id string) ([]Chunk, error) {
+	prefix := chunkPrefix + id + "/"
+	blobs, err := s.blobs.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make([]Chunk, 0, len(blobs))
+	for _, blob := range blobs {
+		index, err := strconv.Atoi(strings.TrimPrefix(blob.Key, prefix))
+		if err != nil {
+			continue
+		}
+		chunks = append(chunks, Chunk{Index: index, Size: blob.Size})
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Index < chunks[j].Index })
+	return chunks, nil
+}
+
+// Open returns the content of a dataset: its first chunks concatenated in order.
+// Chunks are opened one at a time as the previous one is read to the end.
+func (s *Store) Open(ctx context.Context, id string, chunks int) io.ReadCloser {
+	return &chunkReader{ctx: ctx, store: s, id: id, chunks: chunks}
+}
+
+// Complete marks a dataset ready after its content was read and counted
+func (s *Store) Complete(ctx context.Context, dataset *Dataset, chunks int, size int64, rows int) error {
+	completedAt := s.now().UTC()
+	dataset.Status = StatusReady
+	dataset.Chunks = chunks
+	dataset.Size = size
+	dataset.RowCount = rows
+	dataset.CompletedAt = &completedAt
+	return s.Save(ctx, dataset)
+}
+
+// chunkReader reads the chunks of a dataset one after the other
+type chunkReader struct {
+	ctx     context.Context
+	store   *Store
+	id      string
+	chunks  int
+	next    int
+	current io.ReadCloser
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if r.next >= r.chunks {
+				return 0, io.EOF
+			}
+			blob, err := r.store.blobs.Get(r.ctx, chunkKey(r.id, r.next))
+			if err != nil {
+				return 0, fmt.Errorf("failed to open chunk %d: %w", r.next, err)
+			}
+			r.current = blob
+			r.next++
+		}
+
+		n, err := r.current.Read(p)
+		if errors.Is(err, io.EOF) {
+			_ = r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	err := r.current.Close()
+	r.current = nil
+	r.next = r.chunks
+	return err
+}
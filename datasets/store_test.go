@@ -0,0 +1,153 @@
+package datasets
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func localStore(t *testing.T) (*Store, *LocalStore) {
+	blobs, err := NewLocalStore(t.TempDir())
+	assert.NoError(t, err)
+	return NewStore(blobs), blobs
+}
+
+func TestStore_UploadAndRead(t *testing.T) {
+	store, _ := localStore(t)
+	ctx := context.Background()
+
+	dataset := &Dataset{Name: "orders", Format: "ndjson", ExpiresAt: time.Now().Add(time.Hour)}
+	assert.NoError(t, store.Create(ctx, dataset))
+	assert.Equal(t, StatusUploading, dataset.Status)
+	assert.Len(t, dataset.ID, 36)
+
+	// Chunks are read in index order, whatever order they were uploaded in, and an
+	// uploaded index can be replaced
+	for index, content := range map[int]string{1: "{\"n\": 2}\n", 0: "{\"n\": 0}\n", 2: "{\"n\": 3}\n"} {
+		_, err := store.PutChunk(ctx, dataset.ID, index, strings.NewReader(content))
+		assert.NoError(t, err)
+	}
+	_, err := store.PutChunk(ctx, dataset.ID, 1, strings.NewReader("{\"n\": 1}\n"))
+	assert.NoError(t, err)
+	_, err = store.PutChunk(ctx, dataset.ID, MaxChunks, strings.NewReader(""))
+	assert.Error(t, err)
+
+	chunks, err := store.Chunks(ctx, dataset.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []Chunk{{Index: 0, Size: 9}, {Index: 1, Size: 9}, {Index: 2, Size: 9}}, chunks)
+
+	content := store.Open(ctx, dataset.ID, 2)
+	data, err := io.ReadAll(content)
+	assert.NoError(t, err)
+	assert.NoError(t, content.Close())
+	assert.Equal(t, "{\"n\": 0}\n{\"n\": 1}\n", string(data))
+
+	assert.NoError(t, store.Complete(ctx, dataset, 3, 27, 3))
+	loaded, err := store.Get(ctx, dataset.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusReady, loaded.Status)
+	assert.Equal(t, 3, loaded.RowCount)
+	assert.NotNil(t, loaded.CompletedAt)
+
+	assert.NoError(t, store.DeleteChunk(ctx, dataset.ID, 2))
+	_, err = io.ReadAll(store.Open(ctx, dataset.ID, 3))
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestStore_ExpiresAndDeletesDatasets(t *testing.T) {
+	store, blobs := localStore(t)
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	older := &Dataset{Format: "csv", ExpiresAt: now.Add(2 * time.Hour)}
+	assert.NoError(t, store.Create(ctx, older))
+	now = now.Add(time.Minute)
+	newer := &Dataset{Format: "ndjson", ExpiresAt: now.Add(time.Hour)}
+	assert.NoError(t, store.Create(ctx, newer))
+	_, err := store.PutChunk(ctx, newer.ID, 0, strings.NewReader("{}\n"))
+	assert.NoError(t, err)
+
+	list, err := store.List(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, newer.ID, list[0].ID)
+	}
+
+	// An expired dataset is not found and its blobs are removed
+	now = now.Add(90 * time.Minute)
+	_, err = store.Get(ctx, newer.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	remaining, err := blobs.List(ctx, "")
+	assert.NoError(t, err)
+	if assert.Len(t, remaining, 1) {
+		assert.Equal(t, manifestKey(older.ID), remaining[0].Key)
+	}
+
+	assert.NoError(t, store.Delete(ctx, older.ID))
+	list, err = store.List(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestStore_RejectsUnknownIDs(t *testing.T) {
+	store, _ := localStore(t)
+	ctx := context.Background()
+
+	for _, id := range []string{"", "../manifests/x", "not-a-uuid"} {
+		_, err := store.Get(ctx, id)
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.ErrorIs(t, store.Delete(ctx, id), ErrNotFound)
+	}
+	_, err := store.Get(ctx, "6f1c7a4e-3f7b-4a8e-9a55-2f0a9f3b2c11")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestLocalStore_Blobs(t *testing.T) {
+	dir := t.TempDir()
+	blobs, err := NewLocalStore(dir)
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	for _, key := range []string{"../escape", "/absolute", "a//b", ""} {
+		_, err := blobs.Put(ctx, key, strings.NewReader("x"))
+		assert.Error(t, err, key)
+	}
+
+	size, err := blobs.Put(ctx, "chunks/a/00000000", strings.NewReader("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), size)
+	_, err = blobs.Put(ctx, "chunks/b/00000000", strings.NewReader("x"))
+	assert.NoError(t, err)
+
+	// Leftovers of interrupted uploads are not listed
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "chunks", "a", ".upload-1"), []byte("partial"), 0o644))
+	list, err := blobs.List(ctx, "chunks/a/")
+	assert.NoError(t, err)
+	assert.Equal(t, []BlobInfo{{Key: "chunks/a/00000000", Size: 5}}, list)
+	list, err = blobs.List(ctx, "missing/")
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+
+	// A cancelled upload leaves the stored blob in place
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = blobs.Put(cancelled, "chunks/a/00000000", strings.NewReader("replaced"))
+	assert.ErrorIs(t, err, context.Canceled)
+	blob, err := blobs.Get(ctx, "chunks/a/00000000")
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(blob)
+	_ = blob.Close()
+	assert.Equal(t, "hello", string(data))
+
+	assert.NoError(t, blobs.Delete(ctx, "chunks/a/00000000"))
+	assert.NoError(t, blobs.Delete(ctx, "chunks/a/00000000"))
+	_, err = blobs.Get(ctx, "chunks/a/00000000")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -9,11 +9,10 @@ import (
 	"github.com/hibiken/asynq"
 	statemachinegin "github.com/hussainpithawala/state-machine-amz-gin"
 	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
+	"github.com/hussainpithawala/state-machine-amz-gin/recovery"
 	"github.com/hussainpithawala/state-machine-amz-go/pkg/executor"
 	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
-	"github.com/hussainpithawala/state-machine-amz-go/pkg/recovery"
 	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
-	"github.com/hussainpithawala/state-machine-amz-go/pkg/statemachine/persistent"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -22,7 +21,7 @@ func main() {
 
 	// Recovery scanner configuration
 	const (
-		scanInterval        = 5 * time.Second  // How often to scan for orphaned executions
+		scanInterval        = 5 * time.Second  // How often to scan each state machine for orphaned executions
 		orphanedThreshold   = 10 * time.Second // Time after which RUNNING execution is considered orphaned
 		maxRecoveryAttempts = 3                // Maximum recovery attempts
 	)
@@ -86,49 +85,14 @@ func main() {
 		log.Printf("Failed to list state machines: %v", err)
 	}
 
-	// Create persistent state machines and start recovery scanner for each
-	var persistentStateMachines []*persistent.StateMachine
+	// Give every state machine a queue of its own
 	for i := 0; i < len(allStateMachines); i++ {
 		queueName := allStateMachines[i].ID
 		queueConfig.Queues[queueName] = 5
 		queueConfig.Concurrency = 10
 		queueConfig.RetryPolicy = &queue.RetryPolicy{}
-
-		// Create persistent state machine with recovery support
-		sm := allStateMachines[i]
-		psm, err := persistent.NewFromDefnId(ctx, sm.ID, repoManager)
-		if err != nil {
-			log.Printf("Warning: failed to create persistent state machine for %s: %v", sm.ID, err)
-			continue
-		}
-		persistentStateMachines = append(persistentStateMachines, psm)
-
-		// Configure and start recovery scanner for this state machine
-		recoveryConfig := &recovery.RecoveryConfig{
-			Enabled:                    true,
-			ScanInterval:               scanInterval,
-			OrphanedThreshold:          orphanedThreshold,
-			DefaultRecoveryStrategy:    recovery.StrategyRetry,
-			DefaultMaxRecoveryAttempts: maxRecoveryAttempts,
-			StateMachineID:             sm.ID,
-		}
-
-		if err := psm.StartRecoveryScanner(recoveryConfig); err != nil {
-			log.Printf("Failed to start recovery scanner for %s: %v", sm.ID, err)
-		} else {
-			log.Printf("Recovery scanner started for state machine: %s", sm.ID)
-		}
 	}
 
-	// Defer stopping all recovery scanners
-	defer func() {
-		for _, psm := range persistentStateMachines {
-			if err := psm.StopRecoveryScanner(); err != nil {
-				log.Printf("Warning: failed to stop recovery scanner for %s: %v", psm.GetID(), err)
-			}
-		}
-	}()
-
 	queueClient, err := queue.NewClient(queueConfig)
 	if err != nil {
 		log.Printf("Warning: Failed to create queue client: %v (continuing without queue support)", err)
@@ -161,13 +125,23 @@ func main() {
 	// Setup background worker configuration (optional)
 	var workerConfig *middleware.WorkerConfig
 	if queueClient != nil {
+		// Recover orphaned executions of every state machine, including the ones created
+		// later, while the worker runs. State machines can override these defaults through
+		// PUT /state-machines/:id/recovery.
+		recoveryScanner := recovery.NewScanner(redisClient, repoManager, queueClient)
+		recoveryScanner.ScanInterval = scanInterval
+		recoveryScanner.Defaults.OrphanedThreshold = orphanedThreshold
+		recoveryScanner.Defaults.MaxRecoveryAttempts = maxRecoveryAttempts
+
 		workerConfig = &middleware.WorkerConfig{
 			QueueConfig:       queueConfig,
 			RepositoryManager: repoManager,
 			BaseExecutor:      baseExecutor,
 			EnableWorker:      true, // Set to true to enable background worker
+			EnableScheduler:   true, // Fire schedules from this worker
 			RedisClient:       redisClient,
 			QueueClient:       queueClient,
+			Recovery:          recoveryScanner,
 		}
 	}
 
@@ -192,6 +166,7 @@ func main() {
 		if err := worker.Start(); err != nil {
 			log.Printf("Failed to start worker: %v", err)
 		}
+		serverConfig.Worker = worker
 	}
 
 	router := statemachinegin.NewServer(serverConfig)
@@ -208,6 +183,8 @@ func main() {
 	log.Printf("  - POST   http://localhost%s/api/v1/state-machines/:id/executions", addr)
 	log.Printf("  - GET    http://localhost%s/api/v1/executions/:id", addr)
 	log.Printf("  - GET    http://localhost%s/api/v1/health", addr)
+	log.Printf("  - GET    http://localhost%s/api/v1/health/live", addr)
+	log.Printf("  - GET    http://localhost%s/api/v1/health/ready", addr)
 
 	if err := router.Run(addr); err != nil {
 		log.Printf("Failed to start server: %v", err)
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hussainpithawala/state-machine-amz-gin/activities"
+	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
+	"github.com/hussainpithawala/state-machine-amz-gin/models"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/executor"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/types"
+)
+
+// Long-poll bounds of GET /activities/:name/poll, in seconds
+const (
+	defaultPollSeconds = 20
+	maxPollSeconds     = 60
+)
+
+// executionContext returns the context Task states of an execution look their handlers
+// up in. With Redis, "activity:<name>" resources run on activities.
+func executionContext(c *gin.Context, baseExecutor *executor.BaseExecutor) types.ExecutionContext {
+	adapter := executor.NewExecutionContextAdapter(baseExecutor)
+	if redisClient, ok := middleware.GetRedisClient(c); ok {
+		return activities.NewExecutionContext(adapter, activities.NewStore(redisClient))
+	}
+	return adapter
+}
+
+// activityStore returns the store of activities, kept in Redis, or writes a 500
+func activityStore(c *gin.Context) (*activities.Store, bool) {
+	redisClient, ok := middleware.GetRedisClient(c)
+	if !ok {
+		respondNotConfigured(c, models.CodeRedisNotConfigured, "Redis client not configured")
+		return nil, false
+	}
+	return activities.NewStore(redisClient), true
+}
+
+// respondActivityError maps the errors of the activity store onto problems
+func respondActivityError(c *gin.Context, err error, title string) {
+	switch {
+	case errors.Is(err, activities.ErrNotFound):
+		respondError(c, http.StatusNotFound, models.CodeActivityNotFound, "Activity not found", "")
+	case errors.Is(err, activities.ErrExists):
+		respondError(c, http.StatusConflict, models.CodeActivityExists, "Activity already exists", "")
+	case errors.Is(err, activities.ErrTaskNotFound):
+		respondError(c, http.StatusNotFound, models.CodeActivityTaskNotFound, "Activity task not found", "The task token is unknown or has expired")
+	case errors.Is(err, activities.ErrTaskNotRunning):
+		respondError(c, http.StatusConflict, models.CodeActivityTaskNotRunning, "Activity task is not running", err.Error())
+	default:
+		respondError(c, http.StatusServiceUnavailable, models.CodeRedisUnavailable, title, err.Error())
+	}
+}
+
+func activityResponse(activity *activities.Activity) *models.ActivityResponse {
+	return &models.ActivityResponse{
+		Name:             activity.Name,
+		Resource:         activities.Resource(activity.Name),
+		Description:      activity.Description,
+		HeartbeatSeconds: activity.HeartbeatSeconds,
+		CreatedAt:        activity.CreatedAt,
+	}
+}
+
+// CreateActivity creates an activity. Task states with the resource "activity:<name>"
+// are then handed to the workers polling it.
+func CreateActivity(c *gin.Context) {
+	store, ok := activityStore(c)
+	if !ok {
+		return
+	}
+
+	var req models.CreateActivityRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	var errs fieldErrors
+	if !activities.ValidName(req.Name) {
+		errs.add("name", "must be 1-80 letters, digits, '.', '_' or '-'")
+	}
+	if errs.respond(c) {
+		return
+	}
+
+	activity := &activities.Activity{
+		Name:             req.Name,
+		Description:      req.Description,
+		HeartbeatSeconds: req.HeartbeatSeconds,
+	}
+	if err := store.Create(c.Request.Context(), activity); err != nil {
+		respondActivityError(c, err, "Failed to create activity")
+		return
+	}
+	c.JSON(http.StatusCreated, activityResponse(activity))
+}
+
+// ListActivities lists the activities by name
+func ListActivities(c *gin.Context) {
+	store, ok := activityStore(c)
+	if !ok {
+		return
+	}
+	all, err := store.List(c.Request.Context())
+	if err != nil {
+		respondActivityError(c, err, "Failed to list activities")
+		return
+	}
+	response := models.ListActivitiesResponse{
+		Activities: make([]*models.ActivityResponse, 0, len(all)),
+		Total:      len(all),
+	}
+	for _, activity := range all {
+		response.Activities = append(response.Activities, activityResponse(activity))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetActivity returns an activity
+func GetActivity(c *gin.Context) {
+	store, ok := activityStore(c)
+	if !ok {
+		return
+	}
+	activity, err := store.Get(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		respondActivityError(c, err, "Failed to read activity")
+		return
+	}
+	c.JSON(http.StatusOK, activityResponse(activity))
+}
+
+// DeleteActivity deletes an activity. Tasks already handed to workers can still be
+// completed; Task states that start later fail.
+func DeleteActivity(c *gin.Context) {
+	store, ok := activityStore(c)
+	if !ok {
+		return
+	}
+	name := c.Param("name")
+	if err := store.Delete(c.Request.Context(), name); err != nil {
+		respondActivityError(c, err, "Failed to delete activity")
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Message: "Activity deleted",
+		Data:    gin.H{"name": name},
+	})
+}
+
+// PollActivityTask long-polls for a task of an activity. It answers 200 with the task
+// as soon as one is available, or 204 when none arrived in time.
+// Query parameters:
+// - workerName: Name of the polling worker, recorded with the task (optional)
+// - waitSeconds: How long to wait for a task, 1-60 (optional, default: 20)
+func PollActivityTask(c *gin.Context) {
+	store, ok := activityStore(c)
+	if !ok {
+		return
+	}
+
+	var errs fieldErrors
+	waitSeconds := queryInt(c, &errs, "waitSeconds", defaultPollSeconds, 1, maxPollSeconds)
+	if errs.respond(c) {
+		return
+	}
+
+	name := c.Param("name")
+	if _, err := store.Get(c.Request.Context(), name); err != nil {
+		respondActivityError(c, err, "Failed to read activity")
+		return
+	}
+
+	task, err := store.Poll(c.Request.Context(), name, c.Query("workerName"), time.Duration(waitSeconds)*time.Second)
+	if err != nil {
+		if c.Request.Context().Err() != nil {
+			return
+		}
+		respondActivityError(c, err, "Failed to poll activity")
+		return
+	}
+	if task == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.JSON(http.StatusOK, models.ActivityTaskResponse{
+		TaskToken:        task.Token,
+		Activity:         task.Activity,
+		Input:            task.Input,
+		HeartbeatSeconds: task.HeartbeatSeconds,
+		StartedAt:        task.StartedAt,
+	})
+}
+
+// SendActivityTaskSuccess completes an activity task; its output becomes the result of
+// the Task state
+func SendActivityTaskSuccess(c *gin.Context) {
+	store, ok := activityStore(c)
+	if !ok {
+		return
+	}
+	var req models.ActivityTaskSuccessRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	token := c.Param("token")
+	if _, err := store.Succeed(c.Request.Context(), token, req.Output); err != nil {
+		respondActivityError(c, err, "Failed to complete activity task")
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Message: "Activity task succeeded",
+		Data:    gin.H{"taskToken": token},
+	})
+}
+
+// SendActivityTaskFailure fails an activity task. The error name is matched by the
+// Retry and Catch fields of the Task state.
+func SendActivityTaskFailure(c *gin.Context) {
+	store, ok := activityStore(c)
+	if !ok {
+		return
+	}
+	var req models.ActivityTaskFailureRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	token := c.Param("token")
+	if _, err := store.Fail(c.Request.Context(), token, req.Error, req.Cause); err != nil {
+		respondActivityError(c, err, "Failed to fail activity task")
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Message: "Activity task failed",
+		Data:    gin.H{"taskToken": token},
+	})
+}
+
+// SendActivityTaskHeartbeat reports that a worker is still working on a task. Activities
+// created with heartbeatSeconds fail a task that gets no heartbeat for that long.
+func SendActivityTaskHeartbeat(c *gin.Context) {
+	store, ok := activityStore(c)
+	if !ok {
+		return
+	}
+	token := c.Param("token")
+	if err := store.Heartbeat(c.Request.Context(), token); err != nil {
+		respondActivityError(c, err, "Failed to record heartbeat")
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Message: "Heartbeat recorded",
+		Data:    gin.H{"taskToken": token},
+	})
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
+	"github.com/hussainpithawala/state-machine-amz-gin/models"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"gorm.io/gorm"
+)
+
+// searchAttributesKey is the metadata key holding the search attributes of a state machine
+const searchAttributesKey = "searchAttributes"
+
+// searchAttribute is a declared search attribute with its parsed path
+type searchAttribute struct {
+	models.SearchAttribute
+	path jsonPathExpr
+}
+
+// newSearchAttribute validates a declaration. The source defaults to "input".
+func newSearchAttribute(declaration models.SearchAttribute) (searchAttribute, error) {
+	if strings.Contains(declaration.Name, ":") {
+		return searchAttribute{}, fmt.Errorf("name must not contain ':'")
+	}
+	if declaration.Source == "" {
+		declaration.Source = "input"
+	}
+	if declaration.Source != "input" && declaration.Source != "output" {
+		return searchAttribute{}, fmt.Errorf("source must be input or output")
+	}
+	path, err := parseJSONPath(declaration.Path)
+	if err != nil {
+		return searchAttribute{}, err
+	}
+	if len(path) == 0 {
+		return searchAttribute{}, fmt.Errorf("path must select a field, not the whole document")
+	}
+	return searchAttribute{SearchAttribute: declaration, path: path}, nil
+}
+
+// expression is the SQL expression extracting the attribute as text. It is built from a
+// validated declaration only, so the same constant expression is used by the index and
+// by queries, which lets Postgres match them.
+func (a searchAttribute) expression() string {
+	return "(" + a.Source + " #>> " + a.path.textArray() + ")"
+}
+
+// indexName returns a stable index name for the attribute expression
+func (a searchAttribute) indexName() string {
+	sum := sha1.Sum([]byte(a.expression()))
+	return "idx_exec_attr_" + hex.EncodeToString(sum[:8])
+}
+
+// value returns the attribute of an execution rendered as text, like the #>> operator
+func (a searchAttribute) value(record *repository.ExecutionRecord) (string, bool) {
+	document := record.Input
+	if a.Source == "output" {
+		document = record.Output
+	}
+	value, ok := a.path.lookup(document)
+	if !ok || value == nil {
+		return "", false
+	}
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	}
+	data, err := json.Marshal(value)
+	return string(data), err == nil
+}
+
+// attributeFilter matches executions whose search attribute equals a value
+type attributeFilter struct {
+	attribute searchAttribute
+	value     string
+}
+
+func (f attributeFilter) match(record *repository.ExecutionRecord) bool {
+	value, ok := f.attribute.value(record)
+	return ok && value == f.value
+}
+
+// searchAttributesFrom reads the search attributes declared in state machine metadata
+func searchAttributesFrom(metadata map[string]interface{}) (map[string]searchAttribute, error) {
+	attributes := make(map[string]searchAttribute)
+	raw, ok := metadata[searchAttributesKey]
+	if !ok || raw == nil {
+		return attributes, nil
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var declarations []models.SearchAttribute
+	if err := json.Unmarshal(data, &declarations); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", searchAttributesKey, err)
+	}
+	for _, declaration := range declarations {
+		attribute, err := newSearchAttribute(declaration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid search attribute %q: %w", declaration.Name, err)
+		}
+		attributes[declaration.Name] = attribute
+	}
+	return attributes, nil
+}
+
+// createSearchAttributeIndex creates the expression index backing a search attribute
+func createSearchAttributeIndex(ctx context.Context, db *gorm.DB, attribute searchAttribute) error {
+	statement := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON executions (state_machine_id, %s)", attribute.indexName(), attribute.expression())
+	return db.WithContext(ctx).Exec(statement).Error
+}
+
+// UpdateSearchAttributes replaces the search attributes of a state machine. Attributes are
+// JSONPaths into execution input or output that can be queried with attr=name:value; on the
+// gorm-postgres repository each one is backed by an expression index.
+func UpdateSearchAttributes(c *gin.Context) {
+	repoManager, ok := middleware.GetRepositoryManager(c)
+	if !ok {
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
+		return
+	}
+
+	var req models.UpdateSearchAttributesRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	var errs fieldErrors
+	attributes := make([]searchAttribute, 0, len(req.Attributes))
+	seen := make(map[string]bool, len(req.Attributes))
+	for i, declaration := range req.Attributes {
+		if seen[declaration.Name] {
+			errs.add(fmt.Sprintf("attributes[%d].name", i), "duplicate search attribute %q", declaration.Name)
+			continue
+		}
+		seen[declaration.Name] = true
+
+		attribute, err := newSearchAttribute(declaration)
+		if err != nil {
+			errs.add(fmt.Sprintf("attributes[%d]", i), "%v", err)
+			continue
+		}
+		attributes = append(attributes, attribute)
+	}
+	if errs.respond(c) {
+		return
+	}
+
+	stateMachineID := c.Param("stateMachineId")
+	record, err := repoManager.GetStateMachine(c.Request.Context(), stateMachineID)
+	if err != nil {
+		respondLookupError(c, err, models.CodeStateMachineNotFound, "State machine not found")
+		return
+	}
+
+	// Build the indexes before the attributes become queryable
+	db, indexed := executionsDB(repoManager)
+	if indexed {
+		for _, attribute := range attributes {
+			if err := createSearchAttributeIndex(c.Request.Context(), db, attribute); err != nil {
+				respondRepositoryError(c, "Failed to create search attribute index", err)
+				return
+			}
+		}
+	}
+
+	declarations := make([]models.SearchAttribute, len(attributes))
+	for i, attribute := range attributes {
+		declarations[i] = attribute.SearchAttribute
+	}
+
+	var value interface{}
+	if len(declarations) > 0 {
+		value = declarations
+	}
+	if _, err := updateStateMachineMetadata(c.Request.Context(), repoManager, record, map[string]interface{}{searchAttributesKey: value}); err != nil {
+		respondRepositoryError(c, "Failed to save state machine metadata", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SearchAttributesResponse{
+		StateMachineID: stateMachineID,
+		Attributes:     declarations,
+		Indexed:        indexed,
+	})
+}
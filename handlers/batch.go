@@ -22,22 +22,22 @@ const (
 	StatusSucceeded = "SUCCEEDED"
 	StatusFailed    = "FAILED"
 	StatusPaused    = "PAUSED"
+	StatusScheduled = "SCHEDULED" // Delayed and not started yet
+	StatusPending   = "PENDING"   // Queued to start now
 )
 
-// ExecuteBatch executes a batch of executions
+// ExecuteBatch executes a batch of executions chained from the source executions the
+// filter selects. With dryRun set, the selection is previewed and nothing is started.
 func ExecuteBatch(c *gin.Context) {
 	repoManager, ok := middleware.GetRepositoryManager(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Repository manager not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 
 	redisClient, ok := middleware.GetRedisClient(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get redis client"})
+		respondNotConfigured(c, models.CodeRedisNotConfigured, "Redis client not configured")
 		return
 	}
 
@@ -46,23 +46,25 @@ func ExecuteBatch(c *gin.Context) {
 
 	var req models.ExecuteBatchRequest
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Invalid request",
-			Message: err.Error(),
-			Code:    http.StatusBadRequest,
-		})
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	var errs fieldErrors
+	validateTags(&errs, "tags", req.Tags)
+	// Micro-batch children are named by the orchestrator, so their tags cannot be resolved
+	if len(req.Tags) > 0 && req.DoMicroBatch {
+		errs.add("tags", "cannot be combined with doMicroBatch")
+	}
+	validateBatchSelection(&errs, &req)
+	if errs.respond(c) {
 		return
 	}
 
 	// Load state machine
 	sm, err := persistent.NewFromDefnId(c.Request.Context(), targetStateMachineId, repoManager)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error:   "Target State machine not found",
-			Message: err.Error(),
-			Code:    http.StatusNotFound,
-		})
+		respondStateMachineLoadError(c, err, "Target state machine not found")
 		return
 	}
 
@@ -71,53 +73,18 @@ func ExecuteBatch(c *gin.Context) {
 		sm.SetQueueClient(queueClient)
 	}
 
-	// Build execution sourceExecutionFilter from request
-	var sourceExecutionFilter *repository.ExecutionFilter
-	if req.Filter != nil {
-		sourceExecutionFilter = &repository.ExecutionFilter{}
-
-		if req.Filter.SourceStateMachineId != "" {
-			sourceStateMachineId := req.Filter.SourceStateMachineId
-			_, err := persistent.NewFromDefnId(c.Request.Context(), sourceStateMachineId, repoManager)
-			if err != nil {
-				c.JSON(http.StatusNotFound, models.ErrorResponse{
-					Error:   "Source State machine not found",
-					Message: err.Error(),
-					Code:    http.StatusNotFound,
-				})
-				return
-			}
-			sourceExecutionFilter.StateMachineID = sourceStateMachineId
-		}
-
-		if req.Filter.CurrentState != "" {
-			sourceExecutionFilter.CurrentState = req.Filter.CurrentState
-		}
-
-		if req.Filter.SourceStateName != "" {
-			sourceExecutionFilter.CurrentState = req.Filter.SourceStateName
-		}
-
-		if req.Filter.Status != "" {
-			sourceExecutionFilter.Status = req.Filter.Status
-		}
-
-		if req.Filter.Limit != 0 {
-			sourceExecutionFilter.Limit = req.Filter.Limit
-		}
-
-		if req.Filter.Offset != 0 {
-			sourceExecutionFilter.Offset = req.Filter.Offset
-		}
-
-		if req.Filter.StartTimeFrom != 0 {
-			sourceExecutionFilter.StartAfter = time.Unix(req.Filter.StartTimeFrom, 0)
-		}
-
-		if req.Filter.StartTimeTo != 0 {
-			sourceExecutionFilter.StartBefore = time.Unix(req.Filter.StartTimeTo, 0)
+	// The source state machine must exist; the rest of the filter selects its executions
+	if req.Filter != nil && req.Filter.SourceStateMachineId != "" {
+		if _, err := persistent.NewFromDefnId(c.Request.Context(), req.Filter.SourceStateMachineId, repoManager); err != nil {
+			respondStateMachineLoadError(c, err, "Source state machine not found")
+			return
 		}
 	}
+	selection := newBatchSelection(&req)
+	if _, ok := executionsDB(repoManager); selection.extended() && !ok {
+		respondSearchError(c, "Unsupported batch filter", errSearchNotSupported)
+		return
+	}
 
 	// Default values
 	if req.NamePrefix == "" {
@@ -167,16 +134,40 @@ func ExecuteBatch(c *gin.Context) {
 		}
 	}
 
+	// A dry run previews the source selection and starts nothing
+	if req.DryRun {
+		previewBatch(c, repoManager, &req, selection)
+		return
+	}
+
 	// Generate batch ID
 	batchID := fmt.Sprintf("%s-%d", req.NamePrefix, time.Now().Unix())
 	batchOpts.BatchId = batchID
 
+	run := &batchRun{
+		Kind:             runKindBatch,
+		NamePrefix:       req.NamePrefix,
+		StateMachineID:   targetStateMachineId,
+		SourceStateName:  sourceStateName,
+		InputTransformer: sourceInputTransformer,
+		GroupEnqueue:     req.GroupEnqueue,
+		Concurrency:      req.Concurrency,
+		StopOnError:      req.StopOnError,
+		DoMicroBatch:     req.DoMicroBatch,
+		MicroBatchSize:   req.MicroBatchSize,
+		Mode:             req.Mode,
+		Tags:             req.Tags,
+	}
+	if !registerBatchRun(c, redisClient, batchID, run) {
+		return
+	}
+
 	// Execute batch in goroutine with background context to prevent cancellation
 	go func() {
-		bgCtx := context.Background()
+		bgCtx, taggedManager := taggedRepositoryManager(context.Background(), repoManager, req.Tags)
 
 		// Reload state machine with background context
-		smBg, err := persistent.NewFromDefnId(bgCtx, targetStateMachineId, repoManager)
+		smBg, err := persistent.NewFromDefnId(bgCtx, targetStateMachineId, taggedManager)
 		if err != nil {
 			fmt.Printf("Failed to load state machine for batch: %v\n", err)
 			return
@@ -187,8 +178,27 @@ func ExecuteBatch(c *gin.Context) {
 			smBg.SetQueueClient(queueClient)
 		}
 
+		// Filters the repository cannot evaluate are resolved to the IDs of the sources.
+		// Tagged batches are started from the IDs too, so every queued execution carries
+		// the tags in its options.
+		if selection.extended() || len(req.Tags) > 0 {
+			ids, err := selection.ids(bgCtx, taggedManager, 0)
+			if err != nil {
+				fmt.Printf("Batch execution failed: %v\n", err)
+				return
+			}
+			var enqueuer executionEnqueuer
+			if hasQueue {
+				enqueuer = queueClient
+			}
+			failed := startChainedBatch(bgCtx, smBg, enqueuer, req.NamePrefix, run, ids, execOpts)
+			fmt.Printf("Batch %s completed: %d enqueued, %d failed\n", batchID, len(ids)-failed, failed)
+			return
+		}
+
 		// Execute batch with background context
-		results, err := smBg.ExecuteBatch(bgCtx, sourceExecutionFilter, sourceStateName, batchOpts, execOpts...)
+		filter := selection.filter
+		results, err := smBg.ExecuteBatch(bgCtx, &filter, sourceStateName, batchOpts, execOpts...)
 		if err != nil {
 			fmt.Printf("Batch execution failed: %v\n", err)
 			return
@@ -220,24 +230,34 @@ func ExecuteBatch(c *gin.Context) {
 func EnqueueExecution(c *gin.Context) {
 	queueClient, ok := middleware.GetQueueClient(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Queue client not configured",
-			Message: "Distributed queue is not available",
-			Code:    http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeQueueNotConfigured, "Queue client not configured")
 		return
 	}
 
 	var req models.EnqueueExecutionRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Invalid request",
-			Message: err.Error(),
-			Code:    http.StatusBadRequest,
-		})
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	var errs fieldErrors
+	validateTags(&errs, "tags", req.Tags)
+	startAt := delayedStart(&errs, req.StartAt, req.DelaySeconds)
+	queueName := enqueueQueue(c, &errs, &req)
+	if errs.respond(c) {
 		return
 	}
 
+	// Validate the input against the state machine's schema before the task is queued
+	if repoManager, ok := middleware.GetRepositoryManager(c); ok && (req.Input != nil || req.SourceExecutionID == "") {
+		schemas, ok := executionSchemasFor(c, repoManager, req.StateMachineID)
+		if !ok {
+			return
+		}
+		if schemas.validateInput("input", req.Input).respond(c) {
+			return
+		}
+	}
+
 	// Create task payload
 	payload := &queue.ExecutionTaskPayload{
 		StateMachineID:    req.StateMachineID,
@@ -246,33 +266,38 @@ func EnqueueExecution(c *gin.Context) {
 		SourceExecutionID: req.SourceExecutionID,
 		SourceStateName:   req.SourceStateName,
 	}
+	if len(req.Tags) > 0 {
+		payload.Options = map[string]interface{}{middleware.ExecutionTagsOption: req.Tags}
+	}
+	if req.UniqueForSeconds > 0 {
+		if payload.Options == nil {
+			payload.Options = map[string]interface{}{}
+		}
+		payload.Options[uniqueForOption] = req.UniqueForSeconds
+	}
 
-	// Enqueue the task
-	taskInfo, err := queueClient.EnqueueExecution(payload)
+	// Enqueue the task on the requested queue, to be processed at its start time when it is delayed
+	response := models.EnqueueExecutionResponse{}
+	if !startAt.IsZero() {
+		response.ScheduledAt = &startAt
+	}
+	taskInfo, err := queueClient.EnqueueExecution(payload, executionTaskOptions(payload, enqueueOptions(c, &req, queueName, startAt))...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to enqueue execution",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondEnqueueError(c, err, "Failed to enqueue execution")
 		return
 	}
 
-	c.JSON(http.StatusCreated, models.EnqueueExecutionResponse{
-		TaskID:     taskInfo.ID,
-		Queue:      taskInfo.Queue,
-		EnqueuedAt: time.Now(),
-	})
+	response.TaskID = taskInfo.ID
+	response.Queue = taskInfo.Queue
+	response.EnqueuedAt = time.Now()
+	c.JSON(http.StatusCreated, response)
 }
 
 // GetBatchStatus retrieves the status of a batch execution
 func GetBatchStatus(c *gin.Context) {
 	repoManager, ok := middleware.GetRepositoryManager(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Repository manager not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 
@@ -287,11 +312,7 @@ func GetBatchStatus(c *gin.Context) {
 
 	executions, err := repoManager.ListExecutions(c.Request.Context(), filter)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to fetch batch status",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondError(c, http.StatusInternalServerError, models.CodeOrchestratorFailed, "Failed to fetch batch status", err.Error())
 		return
 	}
 
@@ -327,7 +348,7 @@ func GetBatchStatus(c *gin.Context) {
 		status = "Completed"
 	}
 
-	c.JSON(http.StatusOK, models.BulkStatusResponse{
+	response := models.BulkStatusResponse{
 		OrchestratorID: batchID,
 		Status:         status,
 		Progress: &models.BulkProgress{
@@ -339,17 +360,16 @@ func GetBatchStatus(c *gin.Context) {
 			FailureRate: float64(failed) / float64(total) * 100,
 			LastUpdated: time.Now().Unix(),
 		},
-	})
+	}
+	describeRunAttempts(c, batchID, &response)
+	c.JSON(http.StatusOK, response)
 }
 
 // PauseBatchExecution pauses a running batch execution
 func PauseBatchExecution(c *gin.Context) {
 	repoManager, ok := middleware.GetRepositoryManager(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Repository manager not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 
@@ -365,11 +385,7 @@ func PauseBatchExecution(c *gin.Context) {
 
 	executions, err := repoManager.ListExecutions(c.Request.Context(), filter)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to fetch batch executions",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondRepositoryError(c, "Failed to fetch batch executions", err)
 		return
 	}
 
@@ -389,10 +405,7 @@ func PauseBatchExecution(c *gin.Context) {
 func ResumeBatchExecution(c *gin.Context) {
 	repoManager, ok := middleware.GetRepositoryManager(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Repository manager not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 
@@ -408,11 +421,7 @@ func ResumeBatchExecution(c *gin.Context) {
 
 	executions, err := repoManager.ListExecutions(c.Request.Context(), filter)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to fetch paused executions",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondRepositoryError(c, "Failed to fetch paused executions", err)
 		return
 	}
 
@@ -430,10 +439,7 @@ func ResumeBatchExecution(c *gin.Context) {
 func CancelBatchExecution(c *gin.Context) {
 	repoManager, ok := middleware.GetRepositoryManager(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Repository manager not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 
@@ -448,11 +454,7 @@ func CancelBatchExecution(c *gin.Context) {
 
 	executions, err := repoManager.ListExecutions(c.Request.Context(), filter)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to fetch batch executions",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondRepositoryError(c, "Failed to fetch batch executions", err)
 		return
 	}
 
@@ -478,10 +480,7 @@ func CancelBatchExecution(c *gin.Context) {
 func ListBatches(c *gin.Context) {
 	repoManager, ok := middleware.GetRepositoryManager(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Repository manager not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 
@@ -493,11 +492,7 @@ func ListBatches(c *gin.Context) {
 
 	executions, err := repoManager.ListExecutions(c.Request.Context(), filter)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to list executions",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondRepositoryError(c, "Failed to list executions", err)
 		return
 	}
 
@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
+	"github.com/hussainpithawala/state-machine-amz-gin/models"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"gorm.io/gorm"
+)
+
+// Dry-run sample sizes
+const (
+	defaultDryRunSample = 5
+	maxDryRunSample     = 100
+)
+
+// maxBatchNames bounds the executionNameList of a batch
+const maxBatchNames = 10000
+
+// batchSelection selects the source executions a batch chains from. All filters must
+// match: the repository filter is evaluated like ListExecutionIDs does, and the name
+// list, states and name pattern, which need the gorm-postgres repository, narrow it
+// further. Limit and offset apply last, to the matches ordered by execution ID.
+type batchSelection struct {
+	filter      repository.ExecutionFilter
+	names       []string // Explicit execution names
+	states      []string // Current states, any of which matches
+	namePattern string   // Glob using * and ?, or a regular expression between slashes
+	unique      *repository.LinkedExecutionFilter
+}
+
+// newBatchSelection reads the source selection of a batch request. The source state
+// name picks the output a chained execution starts from; it does not select sources.
+func newBatchSelection(req *models.ExecuteBatchRequest) *batchSelection {
+	selection := &batchSelection{names: req.ExecutionNameList}
+	f := req.Filter
+	if f == nil {
+		return selection
+	}
+
+	selection.filter = repository.ExecutionFilter{
+		StateMachineID: f.SourceStateMachineId,
+		Status:         f.Status,
+		CurrentState:   f.CurrentState,
+		Limit:          f.Limit,
+		Offset:         f.Offset,
+	}
+	if f.StartTimeFrom != 0 {
+		selection.filter.StartAfter = time.Unix(f.StartTimeFrom, 0)
+	}
+	if f.StartTimeTo != 0 {
+		selection.filter.StartBefore = time.Unix(f.StartTimeTo, 0)
+	}
+	selection.states = f.States
+	selection.namePattern = f.NamePattern
+	if f.ApplyUnique {
+		selection.unique = &repository.LinkedExecutionFilter{
+			SourceStateMachineID: f.SourceStateMachineId,
+			SourceStateName:      f.SourceStateName,
+			InputTransformerName: f.SourceInputTransformer,
+		}
+	}
+	return selection
+}
+
+// validateBatchSelection rejects filters that cannot be combined
+func validateBatchSelection(errs *fieldErrors, req *models.ExecuteBatchRequest) {
+	if len(req.ExecutionNameList) > maxBatchNames {
+		errs.add("executionNameList", "must not have more than %d names", maxBatchNames)
+	}
+	f := req.Filter
+	if f == nil {
+		return
+	}
+	if f.CurrentState != "" && len(f.States) > 0 {
+		errs.add("filter.states", "cannot be combined with filter.currentState")
+	}
+	if expr, ok := regexPattern(f.NamePattern); ok {
+		if _, err := regexp.Compile(expr); err != nil {
+			errs.add("filter.namePattern", "invalid regular expression: %v", err)
+		}
+	}
+	if req.DoMicroBatch && (len(req.ExecutionNameList) > 0 || len(f.States) > 0 || f.NamePattern != "") {
+		errs.add("doMicroBatch", "cannot be combined with executionNameList, filter.states or filter.namePattern")
+	}
+}
+
+// regexPattern returns the regular expression of a name pattern written as /expr/
+func regexPattern(pattern string) (string, bool) {
+	if len(pattern) < 2 || !strings.HasPrefix(pattern, "/") || !strings.HasSuffix(pattern, "/") {
+		return "", false
+	}
+	return pattern[1 : len(pattern)-1], true
+}
+
+// extended reports whether the selection uses filters only the database can evaluate
+func (s *batchSelection) extended() bool {
+	return len(s.names) > 0 || len(s.states) > 0 || s.namePattern != ""
+}
+
+// where applies the selection, without its window, to a query on the executions table
+func (s *batchSelection) where(db *gorm.DB) *gorm.DB {
+	query := db.Model(&repository.ExecutionModel{})
+	if s.filter.StateMachineID != "" {
+		query = query.Where("state_machine_id = ?", s.filter.StateMachineID)
+	}
+	if s.filter.Status != "" {
+		query = query.Where("status = ?", s.filter.Status)
+	}
+	if s.filter.CurrentState != "" {
+		query = query.Where("current_state ILIKE ?", "%"+escapeLike(s.filter.CurrentState)+"%")
+	}
+	if s.filter.Name != "" {
+		query = query.Where("name ILIKE ?", "%"+escapeLike(s.filter.Name)+"%")
+	}
+	if !s.filter.StartAfter.IsZero() {
+		query = query.Where("start_time >= ?", s.filter.StartAfter)
+	}
+	if !s.filter.StartBefore.IsZero() {
+		query = query.Where("start_time <= ?", s.filter.StartBefore)
+	}
+	if len(s.names) > 0 {
+		query = query.Where("name IN ?", s.names)
+	}
+	if len(s.states) > 0 {
+		query = query.Where("current_state IN ?", s.states)
+	}
+	if expr, ok := regexPattern(s.namePattern); ok {
+		query = query.Where("name ~ ?", expr)
+	} else if s.namePattern != "" {
+		query = query.Where("name LIKE ?", globToLike(s.namePattern))
+	}
+	if s.unique != nil {
+		linked := db.Table("linked_executions").Select("DISTINCT source_execution_id").Where("source_execution_id IS NOT NULL")
+		if s.unique.SourceStateMachineID != "" {
+			linked = linked.Where("source_state_machine_id = ?", s.unique.SourceStateMachineID)
+		}
+		if s.unique.SourceStateName != "" {
+			linked = linked.Where("source_state_name = ?", s.unique.SourceStateName)
+		}
+		if s.unique.InputTransformerName != "" {
+			linked = linked.Where("input_transformer_name = ?", s.unique.InputTransformerName)
+		}
+		query = query.Where("execution_id NOT IN (?)", linked)
+	}
+	return query
+}
+
+// window applies the offset and limit of the filter to a number of matches
+func (s *batchSelection) window(total int) int {
+	total = max(total-s.filter.Offset, 0)
+	if s.filter.Limit > 0 {
+		total = min(total, s.filter.Limit)
+	}
+	return total
+}
+
+// count returns the number of source executions the batch would start from
+func (s *batchSelection) count(ctx context.Context, repoManager *repository.Manager) (int, error) {
+	db, ok := executionsDB(repoManager)
+	if !ok {
+		ids, err := s.ids(ctx, repoManager, 0)
+		return len(ids), err
+	}
+	var total int64
+	if err := s.where(db.WithContext(ctx)).Count(&total).Error; err != nil {
+		return 0, err
+	}
+	return s.window(int(total)), nil
+}
+
+// ids returns the IDs of the first n source executions, or of all of them when n is 0,
+// in the order the batch would start them
+func (s *batchSelection) ids(ctx context.Context, repoManager *repository.Manager, n int) ([]string, error) {
+	limit := s.filter.Limit
+	if n > 0 && (limit == 0 || n < limit) {
+		limit = n
+	}
+
+	db, ok := executionsDB(repoManager)
+	if !ok {
+		if s.extended() {
+			return nil, errSearchNotSupported
+		}
+		ids, err := s.listIDs(ctx, repoManager)
+		if err != nil {
+			return nil, err
+		}
+		if limit > 0 && len(ids) > limit {
+			ids = ids[:limit]
+		}
+		return ids, nil
+	}
+
+	query := s.where(db.WithContext(ctx)).Order("execution_id")
+	if s.filter.Offset > 0 {
+		query = query.Offset(s.filter.Offset)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	var ids []string
+	err := query.Pluck("execution_id", &ids).Error
+	return ids, err
+}
+
+// listIDs lists the source executions through the repository interface, as
+// ExecuteBatch does
+func (s *batchSelection) listIDs(ctx context.Context, repoManager *repository.Manager) ([]string, error) {
+	filter := s.filter
+	if s.unique == nil {
+		return repoManager.ListExecutionIDs(ctx, &filter)
+	}
+	records, err := repoManager.ListNonLinkedExecutions(ctx, &filter, s.unique)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = record.ExecutionID
+	}
+	return ids, nil
+}
+
+// previewBatch answers a dry run: it counts the source executions the batch would start
+// from and derives the input of a sample of them, without starting anything
+func previewBatch(c *gin.Context, repoManager *repository.Manager, req *models.ExecuteBatchRequest, selection *batchSelection) {
+	ctx := c.Request.Context()
+	sampleSize := req.SampleSize
+	if sampleSize == 0 {
+		sampleSize = defaultDryRunSample
+	}
+
+	matches, err := selection.count(ctx, repoManager)
+	if err != nil {
+		respondSearchError(c, "Failed to count source executions", err)
+		return
+	}
+	ids, err := selection.ids(ctx, repoManager, sampleSize)
+	if err != nil {
+		respondSearchError(c, "Failed to list source executions", err)
+		return
+	}
+
+	response := models.BatchDryRunResponse{
+		DryRun:     true,
+		MatchCount: matches,
+		Sample:     make([]models.BatchDryRunItem, 0, len(ids)),
+	}
+	if selection.unique != nil {
+		withLinked := *selection
+		withLinked.unique = nil
+		total, err := withLinked.count(ctx, repoManager)
+		if err != nil {
+			respondSearchError(c, "Failed to count source executions", err)
+			return
+		}
+		response.ExcludedByUnique = max(total-matches, 0)
+	}
+	if req.DoMicroBatch && req.MicroBatchSize > 0 {
+		response.MicroBatchCount = (matches + req.MicroBatchSize - 1) / req.MicroBatchSize
+	}
+
+	var sourceStateName string
+	var transformer func(interface{}) (interface{}, error)
+	if req.Filter != nil {
+		sourceStateName = req.Filter.SourceStateName
+		if name := req.Filter.SourceInputTransformer; name != "" {
+			registry, _ := middleware.GetTransformerRegistry(c)
+			if transformer = registry[name]; transformer == nil {
+				response.Warnings = append(response.Warnings,
+					fmt.Sprintf("input transformer %q is not registered; the source output is used as input", name))
+			}
+		}
+	}
+
+	for i, id := range ids {
+		item := models.BatchDryRunItem{
+			SourceExecutionID: id,
+			ExecutionName:     fmt.Sprintf("%s-%d", req.NamePrefix, i),
+		}
+		input, err := repoManager.GetExecutionOutput(ctx, id, sourceStateName)
+		if err == nil && transformer != nil {
+			input, err = transformer(input)
+		}
+		if err != nil {
+			item.Error = err.Error()
+		} else {
+			item.Input = input
+		}
+		response.Sample = append(response.Sample, item)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -5,8 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -17,55 +17,69 @@ import (
 	"github.com/hussainpithawala/state-machine-amz-go/pkg/statemachine/persistent"
 )
 
-// ExecuteBulk executes a bulk operation with direct inputs
+// ExecuteBulk executes a bulk operation with direct inputs, or with the rows of an
+// uploaded dataset when datasetId is set. A selector picks the input out of every row.
 func ExecuteBulk(c *gin.Context) {
 	repoManager, ok := middleware.GetRepositoryManager(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Repository manager not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 
 	redisClient, ok := middleware.GetRedisClient(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Redis client not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRedisNotConfigured, "Redis client not configured")
 		return
 	}
 	queueClient, okQueue := middleware.GetQueueClient(c)
 	if !okQueue {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Queue client not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeQueueNotConfigured, "Queue client not configured")
 		return
 	}
 
 	stateMachineID := c.Param("stateMachineId")
 
 	var req models.ExecuteBulkRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Invalid request",
-			Message: err.Error(),
-			Code:    http.StatusBadRequest,
-		})
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	// Build inputs from request
-	inputs := req.Inputs
+	var errs fieldErrors
+	validateTags(&errs, "tags", req.Tags)
+	// Micro-batch children are queued by the orchestrator, without the tags
+	if len(req.Tags) > 0 && req.DoMicroBatch {
+		errs.add("tags", "cannot be combined with doMicroBatch")
+	}
+	if req.DatasetID != "" && len(req.Inputs) > 0 {
+		errs.add("datasetId", "provide either inputs or datasetId, not both")
+	}
+	if req.Selector != "" && req.DatasetID == "" {
+		errs.add("selector", "only applies to datasetId")
+	}
+	// Dataset rows are queued as they are read
+	if req.DatasetID != "" && req.DoMicroBatch {
+		errs.add("doMicroBatch", "cannot be combined with datasetId")
+	}
+	startAt := delayedStart(&errs, req.StartAt, req.DelaySeconds)
+	validateDelayedBulk(&errs, startAt, req.DoMicroBatch, req.GroupEnqueue)
+	if errs.respond(c) {
+		return
+	}
 
-	if len(inputs) == 0 {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "No inputs provided",
-			Message: "Provide inputs as a JSON array",
-			Code:    http.StatusBadRequest,
-		})
+	// Inputs rejected by the input schema are reported and skipped
+	var inputs []interface{}
+	var rejected []models.RejectedItem
+	var dataset *datasetBulk
+	if req.DatasetID != "" {
+		dataset, ok = openDatasetBulk(c, repoManager, stateMachineID, req.DatasetID, req.Selector)
+	} else {
+		if len(req.Inputs) == 0 {
+			respondError(c, http.StatusBadRequest, models.CodeInvalidRequest, "No inputs provided", "Provide inputs as a JSON array or a datasetId")
+			return
+		}
+		inputs, rejected, ok = validateBulkInputs(c, repoManager, stateMachineID, req.Inputs)
+	}
+	if !ok {
 		return
 	}
 
@@ -94,13 +108,47 @@ func ExecuteBulk(c *gin.Context) {
 	// Generate batch ID
 	batchID := fmt.Sprintf("%s-%d", req.NamePrefix, time.Now().Unix())
 
+	if !registerBatchRun(c, redisClient, batchID, &batchRun{
+		Kind:           runKindBulk,
+		NamePrefix:     req.NamePrefix,
+		StateMachineID: stateMachineID,
+		GroupEnqueue:   req.GroupEnqueue,
+		Concurrency:    req.Concurrency,
+		StopOnError:    req.StopOnError,
+		DoMicroBatch:   req.DoMicroBatch,
+		MicroBatchSize: req.MicroBatchSize,
+		Mode:           req.Mode,
+		Tags:           req.Tags,
+	}) {
+		return
+	}
+
+	opts := taskOptions(c, stateMachineID, startAt)
+	if dataset != nil {
+		executeDatasetBulk(c, dataset, queueClient, stateMachineID, batchID, &req, startAt, opts)
+		return
+	}
+	if !startAt.IsZero() {
+		failed := enqueueBulk(queueClient, stateMachineID, req.NamePrefix, inputs, req.Tags, req.StopOnError, opts)
+		respondDelayedBulk(c, batchID, req.Mode, startAt, len(inputs), failed, rejected)
+		return
+	}
+
 	// Execute bulk asynchronously using background context
 	// We don't wait for completion - run in background
 	go func() {
-		bgCtx := context.Background()
+		// Every execution is queued with the tags of the request in its options
+		if !req.DoMicroBatch {
+			if failed := enqueueBulk(queueClient, stateMachineID, req.NamePrefix, inputs, req.Tags, req.StopOnError, opts); failed > 0 {
+				log.Printf("Bulk %s: %d executions could not be queued", batchID, failed)
+			}
+			return
+		}
+
+		bgCtx, taggedManager := taggedRepositoryManager(context.Background(), repoManager, req.Tags)
 
 		// Load state machine with background context to avoid cancellation
-		sm, err := persistent.NewFromDefnId(bgCtx, stateMachineID, repoManager)
+		sm, err := persistent.NewFromDefnId(bgCtx, stateMachineID, taggedManager)
 		if err != nil {
 			fmt.Printf("Failed to load state machine for bulk execution: %v\n", err)
 			return
@@ -121,6 +169,8 @@ func ExecuteBulk(c *gin.Context) {
 		TotalEnqueued:  len(inputs),
 		TotalFailed:    0,
 		Mode:           req.Mode,
+		TotalRejected:  len(rejected),
+		Rejected:       rejected,
 	})
 }
 
@@ -138,53 +188,39 @@ func ExecuteBulk(c *gin.Context) {
 // - pauseThreshold: Failure rate threshold for auto-pause 0.0-1.0 (optional)
 // - resumeStrategy: Resume strategy - "manual", "automatic", "timeout" (optional)
 // - timeoutSeconds: Timeout for automatic resume (optional)
+// - tags: JSON object of tags applied to every execution (optional)
+// - startAt: Start every execution at this RFC 3339 time (optional)
+// - delaySeconds: Start every execution after this delay (optional)
 func ExecuteBulkForm(c *gin.Context) {
 	repoManager, ok := middleware.GetRepositoryManager(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Repository manager not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 	stateMachineID := c.Param("stateMachineId")
 
 	redisClient, ok := middleware.GetRedisClient(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Redis client not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRedisNotConfigured, "Redis client not configured")
 		return
 	}
 
 	queueClient, okQueue := middleware.GetQueueClient(c)
 	if !okQueue {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Queue client not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeQueueNotConfigured, "Queue client not configured")
 		return
 	}
 
 	// Parse form-data with memory limit of 32MB
 	if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Invalid form data",
-			Message: fmt.Sprintf("Failed to parse form: %v", err),
-			Code:    http.StatusBadRequest,
-		})
+		respondError(c, http.StatusBadRequest, models.CodeInvalidRequest, "Invalid form data", fmt.Sprintf("Failed to parse form: %v", err))
 		return
 	}
 
 	// Get inputs file
 	file, _, err := c.Request.FormFile("inputs")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Missing inputs file",
-			Message: "Please provide a JSON file containing the inputs array",
-			Code:    http.StatusBadRequest,
-		})
+		respondError(c, http.StatusBadRequest, models.CodeInvalidRequest, "Missing inputs file", "Please provide a JSON file containing the inputs array")
 		return
 	}
 	defer func() {
@@ -196,76 +232,57 @@ func ExecuteBulkForm(c *gin.Context) {
 	// Read and parse JSON file
 	fileBytes, err := io.ReadAll(file)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Failed to read file",
-			Message: err.Error(),
-			Code:    http.StatusBadRequest,
-		})
+		respondError(c, http.StatusBadRequest, models.CodeInvalidRequest, "Failed to read file", err.Error())
 		return
 	}
 
 	// Parse JSON array
 	var inputs []interface{}
 	if err := json.Unmarshal(fileBytes, &inputs); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Invalid JSON format",
-			Message: fmt.Sprintf("Inputs file must contain a valid JSON array: %v", err),
-			Code:    http.StatusBadRequest,
-		})
+		respondError(c, http.StatusBadRequest, models.CodeInvalidRequest, "Invalid JSON format", fmt.Sprintf("Inputs file must contain a valid JSON array: %v", err))
 		return
 	}
 
 	if len(inputs) == 0 {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "No inputs provided",
-			Message: "Inputs array must contain at least one item",
-			Code:    http.StatusBadRequest,
-		})
+		respondError(c, http.StatusBadRequest, models.CodeInvalidRequest, "No inputs provided", "Inputs array must contain at least one item")
+		return
+	}
+
+	// Inputs rejected by the input schema are reported and skipped
+	inputs, rejected, ok := validateBulkInputs(c, repoManager, stateMachineID, inputs)
+	if !ok {
 		return
 	}
 
 	// Parse form fields
+	var errs fieldErrors
 	namePrefix := c.PostForm("namePrefix")
-	concurrencyStr := c.PostForm("concurrency")
-	mode := c.PostForm("mode")
-	stopOnErrorStr := c.PostForm("stopOnError")
-	doMicroBatchStr := c.PostForm("doMicroBatch")
-	microBatchSizeStr := c.PostForm("microBatchSize")
+	concurrency := formInt(c, &errs, "concurrency", 10, 1)
+	mode := oneOf(&errs, "mode", c.PostForm("mode"), executionModes...)
+	stopOnError := formBool(c, &errs, "stopOnError")
+	doMicroBatch := formBool(c, &errs, "doMicroBatch")
+	microBatchSize := formInt(c, &errs, "microBatchSize", 100, 1)
 	orchestratorID := c.PostForm("orchestratorId")
-	groupEnqueueStr := c.PostForm("groupEnqueue")
-
-	// Set defaults
-	if namePrefix == "" {
-		namePrefix = fmt.Sprintf("bulk-%d", time.Now().Unix())
-	}
-
-	concurrency := 10
-	if concurrencyStr != "" {
-		if parsed, err := strconv.Atoi(concurrencyStr); err == nil && parsed > 0 {
-			concurrency = parsed
+	groupEnqueue := formBool(c, &errs, "groupEnqueue")
+	var tags map[string]string
+	if raw := c.PostForm("tags"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &tags); err != nil {
+			errs.add("tags", "must be a JSON object of string values")
 		}
 	}
-
-	stopOnError := false
-	if stopOnErrorStr != "" {
-		stopOnError, _ = strconv.ParseBool(stopOnErrorStr)
-	}
-
-	doMicroBatch := false
-	if doMicroBatchStr != "" {
-		doMicroBatch, _ = strconv.ParseBool(doMicroBatchStr)
+	validateTags(&errs, "tags", tags)
+	if len(tags) > 0 && doMicroBatch {
+		errs.add("tags", "cannot be combined with doMicroBatch")
 	}
-
-	microBatchSize := 100
-	if microBatchSizeStr != "" {
-		if parsed, err := strconv.Atoi(microBatchSizeStr); err == nil && parsed > 0 {
-			microBatchSize = parsed
-		}
+	startAt := delayedStart(&errs, formTime(c, &errs, "startAt"), formInt(c, &errs, "delaySeconds", 0, 0))
+	validateDelayedBulk(&errs, startAt, doMicroBatch, groupEnqueue)
+	if errs.respond(c) {
+		return
 	}
 
-	groupEnqueue := false
-	if groupEnqueueStr != "" {
-		groupEnqueue, _ = strconv.ParseBool(groupEnqueueStr)
+	// Set defaults
+	if namePrefix == "" {
+		namePrefix = fmt.Sprintf("bulk-%d", time.Now().Unix())
 	}
 
 	// Build bulk options
@@ -288,12 +305,42 @@ func ExecuteBulkForm(c *gin.Context) {
 		batchID = orchestratorID
 	}
 
+	if !registerBatchRun(c, redisClient, batchID, &batchRun{
+		Kind:           runKindBulk,
+		NamePrefix:     namePrefix,
+		StateMachineID: stateMachineID,
+		GroupEnqueue:   groupEnqueue,
+		Concurrency:    concurrency,
+		StopOnError:    stopOnError,
+		DoMicroBatch:   doMicroBatch,
+		MicroBatchSize: microBatchSize,
+		Mode:           mode,
+		Tags:           tags,
+	}) {
+		return
+	}
+
+	opts := taskOptions(c, stateMachineID, startAt)
+	if !startAt.IsZero() {
+		failed := enqueueBulk(queueClient, stateMachineID, namePrefix, inputs, tags, stopOnError, opts)
+		respondDelayedBulk(c, batchID, mode, startAt, len(inputs), failed, rejected)
+		return
+	}
+
 	// Execute bulk asynchronously using background context
 	go func() {
-		bgCtx := context.Background()
+		// Every execution is queued with the tags of the request in its options
+		if !doMicroBatch {
+			if failed := enqueueBulk(queueClient, stateMachineID, namePrefix, inputs, tags, stopOnError, opts); failed > 0 {
+				log.Printf("Bulk %s: %d executions could not be queued", batchID, failed)
+			}
+			return
+		}
+
+		bgCtx, taggedManager := taggedRepositoryManager(context.Background(), repoManager, tags)
 
 		// Load state machine with background context to avoid cancellation
-		sm, err := persistent.NewFromDefnId(bgCtx, stateMachineID, repoManager)
+		sm, err := persistent.NewFromDefnId(bgCtx, stateMachineID, taggedManager)
 		if err != nil {
 			fmt.Printf("Failed to load state machine for bulk execution: %v\n", err)
 			return
@@ -315,6 +362,8 @@ func ExecuteBulkForm(c *gin.Context) {
 		TotalEnqueued:  len(inputs),
 		TotalFailed:    0,
 		Mode:           mode,
+		TotalRejected:  len(rejected),
+		Rejected:       rejected,
 	})
 }
 
@@ -322,11 +371,7 @@ func ExecuteBulkForm(c *gin.Context) {
 func GetBulkStatus(c *gin.Context) {
 	orchestrator, ok := middleware.GetBulkOrchestrator(c)
 	if !ok || orchestrator == nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "BulkOrchestrator not configured",
-			Message: "Bulk orchestration is not available",
-			Code:    http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeOrchestratorNotConfigured, "BulkOrchestrator not configured")
 		return
 	}
 
@@ -334,7 +379,7 @@ func GetBulkStatus(c *gin.Context) {
 
 	// For now, return a basic status response
 	// The orchestrator tracks state internally via Redis
-	c.JSON(http.StatusOK, models.BulkStatusResponse{
+	response := models.BulkStatusResponse{
 		OrchestratorID: batchID,
 		Status:         "Running",
 		Progress: &models.BulkProgress{
@@ -342,18 +387,16 @@ func GetBulkStatus(c *gin.Context) {
 			CurrentBatch:    0,
 			TotalExecutions: 0,
 		},
-	})
+	}
+	describeRunAttempts(c, batchID, &response)
+	c.JSON(http.StatusOK, response)
 }
 
 // PauseBulkExecution pauses a running bulk execution by signaling the orchestrator
 func PauseBulkExecution(c *gin.Context) {
 	orchestrator, ok := middleware.GetBulkOrchestrator(c)
 	if !ok || orchestrator == nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "BulkOrchestrator not configured",
-			Message: "Bulk orchestration is not available",
-			Code:    http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeOrchestratorNotConfigured, "BulkOrchestrator not configured")
 		return
 	}
 
@@ -362,11 +405,7 @@ func PauseBulkExecution(c *gin.Context) {
 	// Signal pause to the orchestrator
 	err := orchestrator.Signal(c.Request.Context(), batchID, "pause", "User requested pause")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to pause bulk execution",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondError(c, http.StatusInternalServerError, models.CodeOrchestratorFailed, "Failed to pause bulk execution", err.Error())
 		return
 	}
 
@@ -382,11 +421,7 @@ func PauseBulkExecution(c *gin.Context) {
 func ResumeBulkExecution(c *gin.Context) {
 	orchestrator, ok := middleware.GetBulkOrchestrator(c)
 	if !ok || orchestrator == nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "BulkOrchestrator not configured",
-			Message: "Bulk orchestration is not available",
-			Code:    http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeOrchestratorNotConfigured, "BulkOrchestrator not configured")
 		return
 	}
 
@@ -395,11 +430,7 @@ func ResumeBulkExecution(c *gin.Context) {
 	// Signal resume to the orchestrator
 	err := orchestrator.Signal(c.Request.Context(), batchID, "resume", "User requested resume")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to resume bulk execution",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondError(c, http.StatusInternalServerError, models.CodeOrchestratorFailed, "Failed to resume bulk execution", err.Error())
 		return
 	}
 
@@ -415,11 +446,7 @@ func ResumeBulkExecution(c *gin.Context) {
 func CancelBulkExecution(c *gin.Context) {
 	orchestrator, ok := middleware.GetBulkOrchestrator(c)
 	if !ok || orchestrator == nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "BulkOrchestrator not configured",
-			Message: "Bulk orchestration is not available",
-			Code:    http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeOrchestratorNotConfigured, "BulkOrchestrator not configured")
 		return
 	}
 
@@ -428,11 +455,7 @@ func CancelBulkExecution(c *gin.Context) {
 	// Signal cancel to the orchestrator
 	err := orchestrator.Signal(c.Request.Context(), batchID, "cancel", "User requested cancel")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to cancel bulk execution",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondError(c, http.StatusInternalServerError, models.CodeOrchestratorFailed, "Failed to cancel bulk execution", err.Error())
 		return
 	}
 
@@ -448,10 +471,7 @@ func CancelBulkExecution(c *gin.Context) {
 func ListBulkExecutions(c *gin.Context) {
 	repoManager, ok := middleware.GetRepositoryManager(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Repository manager not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 
@@ -463,11 +483,7 @@ func ListBulkExecutions(c *gin.Context) {
 
 	executions, err := repoManager.ListExecutions(c.Request.Context(), filter)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to list executions",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondRepositoryError(c, "Failed to list executions", err)
 		return
 	}
 
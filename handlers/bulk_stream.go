@@ -0,0 +1,650 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hibiken/asynq"
+	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
+	"github.com/hussainpithawala/state-machine-amz-gin/models"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+)
+
+// Upload formats accepted by ExecuteBulkStream
+const (
+	bulkFormatNDJSON = "ndjson"
+	bulkFormatCSV    = "csv"
+)
+
+// CSV type hints
+var csvTypes = []string{"string", "number", "integer", "boolean", "json"}
+
+const (
+	defaultBulkChunkSize = 100
+	maxBulkChunkSize     = 1000
+	// maxBulkLineBytes bounds a single NDJSON line; the upload itself is not limited
+	maxBulkLineBytes = 1 << 20
+)
+
+// ndjsonContentTypes are the media types detected as NDJSON uploads
+var ndjsonContentTypes = []string{"application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines"}
+
+// executionEnqueuer is the part of the queue client used to enqueue streamed inputs
+type executionEnqueuer interface {
+	EnqueueExecution(payload *queue.ExecutionTaskPayload, opts ...asynq.Option) (*asynq.TaskInfo, error)
+	EnqueueExecutionGroup(payloads []*queue.ExecutionTaskPayload, groupID string, opts ...asynq.Option) ([]*asynq.TaskInfo, error)
+}
+
+// bulkStreamOptions are the query parameters of a streaming bulk upload
+type bulkStreamOptions struct {
+	Format       string
+	NamePrefix   string
+	ChunkSize    int
+	GroupEnqueue bool
+	Mapping      map[string]string // CSV header -> input field path
+	Types        map[string]string // input field path -> type hint
+	Tags         map[string]string
+}
+
+// parseBulkStreamOptions reads the options of a streaming bulk upload from the query string
+func parseBulkStreamOptions(c *gin.Context, errs *fieldErrors) *bulkStreamOptions {
+	options := &bulkStreamOptions{
+		Format:     queryOneOf(c, errs, "format", bulkFormatNDJSON, bulkFormatCSV),
+		NamePrefix: c.Query("namePrefix"),
+		ChunkSize:  queryInt(c, errs, "chunkSize", defaultBulkChunkSize, 1, maxBulkChunkSize),
+	}
+	if raw := c.Query("groupEnqueue"); raw != "" {
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			errs.add("groupEnqueue", "must be a boolean")
+		}
+		options.GroupEnqueue = value
+	}
+	if options.NamePrefix == "" {
+		options.NamePrefix = fmt.Sprintf("bulk-%d", time.Now().Unix())
+	}
+
+	// Headers may contain ':', field paths may not
+	for _, pair := range c.QueryArray("map") {
+		i := strings.LastIndexByte(pair, ':')
+		if i <= 0 || i == len(pair)-1 {
+			errs.add("map", "must be in the form header:field")
+			continue
+		}
+		if options.Mapping == nil {
+			options.Mapping = make(map[string]string)
+		}
+		options.Mapping[pair[:i]] = pair[i+1:]
+	}
+	for _, pair := range c.QueryArray("type") {
+		field, kind, ok := strings.Cut(pair, ":")
+		if !ok || field == "" {
+			errs.add("type", "must be in the form field:type")
+			continue
+		}
+		if oneOf(errs, "type", kind, csvTypes...) == "" {
+			continue
+		}
+		if options.Types == nil {
+			options.Types = make(map[string]string)
+		}
+		options.Types[field] = kind
+	}
+
+	for _, pair := range c.QueryArray("tag") {
+		key, value, ok := strings.Cut(pair, ":")
+		if !ok || key == "" {
+			errs.add("tag", "must be in the form key:value")
+			continue
+		}
+		if options.Tags == nil {
+			options.Tags = make(map[string]string)
+		}
+		options.Tags[key] = value
+	}
+	validateTags(errs, "tag", options.Tags)
+	return options
+}
+
+// bulkItem is one input of a streamed upload and the line it starts on. Items with an
+// error could not be parsed; the stream continues after them.
+type bulkItem struct {
+	line  int
+	input interface{}
+	err   error
+}
+
+// bulkItemReader reads the items of a streamed upload. Next returns io.EOF at the end of
+// the upload and other errors when it cannot be read any further.
+type bulkItemReader interface {
+	Next() (bulkItem, error)
+	LinesRead() int
+}
+
+// ndjsonReader reads one JSON value per line, skipping blank lines
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newNDJSONReader(r io.Reader) *ndjsonReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBulkLineBytes)
+	return &ndjsonReader{scanner: scanner}
+}
+
+func (r *ndjsonReader) Next() (bulkItem, error) {
+	for r.scanner.Scan() {
+		r.line++
+		data := strings.TrimSpace(r.scanner.Text())
+		if data == "" {
+			continue
+		}
+		item := bulkItem{line: r.line}
+		if err := json.Unmarshal([]byte(data), &item.input); err != nil {
+			item.err = fmt.Errorf("invalid JSON: %v", err)
+		}
+		return item, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return bulkItem{}, fmt.Errorf("line %d is longer than %d bytes", r.line+1, maxBulkLineBytes)
+		}
+		return bulkItem{}, err
+	}
+	return bulkItem{}, io.EOF
+}
+
+func (r *ndjsonReader) LinesRead() int {
+	return r.line
+}
+
+// csvColumn maps a CSV column onto an input field
+type csvColumn struct {
+	path []string
+	kind string
+}
+
+// csvReader reads one input object per CSV record. The first record is the header; each
+// column becomes the field named by the mapping, or by its header, and dotted field
+// paths create nested objects.
+type csvReader struct {
+	reader  *csv.Reader
+	columns []*csvColumn
+	line    int
+}
+
+// newCSVReader reads the header of a CSV upload
+func newCSVReader(r io.Reader, mapping, types map[string]string) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("the CSV upload has no header")
+		}
+		return nil, fmt.Errorf("invalid CSV header: %v", err)
+	}
+
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+	for name := range mapping {
+		if !containsString(header, name) {
+			return nil, fmt.Errorf("mapped header %q is not a column of the CSV upload", name)
+		}
+	}
+
+	columns := make([]*csvColumn, len(header))
+	fields := make([]string, 0, len(header))
+	for i, name := range header {
+		field := name
+		if mapped, ok := mapping[name]; ok {
+			field = mapped
+		}
+		if field == "" {
+			continue
+		}
+		path := strings.Split(field, ".")
+		if containsString(path, "") {
+			return nil, fmt.Errorf("invalid field %q for column %q", field, name)
+		}
+		kind := types[field]
+		if kind == "" {
+			kind = "string"
+		}
+		columns[i] = &csvColumn{path: path, kind: kind}
+		fields = append(fields, field)
+	}
+	for field := range types {
+		if !containsString(fields, field) {
+			return nil, fmt.Errorf("typed field %q is not a column of the CSV upload", field)
+		}
+	}
+	return &csvReader{reader: reader, columns: columns, line: 1}, nil
+}
+
+func (r *csvReader) Next() (bulkItem, error) {
+	record, err := r.reader.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			r.line = parseErr.Line
+			return bulkItem{line: parseErr.StartLine, err: parseErr.Err}, nil
+		}
+		return bulkItem{}, err
+	}
+	line, _ := r.reader.FieldPos(0)
+	r.line = line
+
+	item := bulkItem{line: line}
+	input := make(map[string]interface{}, len(record))
+	for i, cell := range record {
+		column := r.columns[i]
+		if column == nil || (cell == "" && column.kind != "string") {
+			continue
+		}
+		value, err := csvValue(cell, column.kind)
+		if err != nil {
+			item.err = fmt.Errorf("column %d (%s): %v", i+1, strings.Join(column.path, "."), err)
+			return item, nil
+		}
+		if err := setField(input, column.path, value); err != nil {
+			item.err = err
+			return item, nil
+		}
+	}
+	item.input = input
+	return item, nil
+}
+
+func (r *csvReader) LinesRead() int {
+	return r.line
+}
+
+// csvValue converts a CSV cell according to its type hint
+func csvValue(cell, kind string) (interface{}, error) {
+	switch kind {
+	case "number":
+		value, err := strconv.ParseFloat(strings.TrimSpace(cell), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", cell)
+		}
+		return value, nil
+	case "integer":
+		value, err := strconv.ParseInt(strings.TrimSpace(cell), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", cell)
+		}
+		return value, nil
+	case "boolean":
+		value, err := strconv.ParseBool(strings.TrimSpace(cell))
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", cell)
+		}
+		return value, nil
+	case "json":
+		var value interface{}
+		if err := json.Unmarshal([]byte(cell), &value); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+		return value, nil
+	}
+	return cell, nil
+}
+
+// setField sets a value at a dotted path, creating intermediate objects
+func setField(object map[string]interface{}, path []string, value interface{}) error {
+	for i, key := range path[:len(path)-1] {
+		child, exists := object[key]
+		if !exists {
+			next := make(map[string]interface{})
+			object[key] = next
+			object = next
+			continue
+		}
+		next, ok := child.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("field %s is both a value and an object", strings.Join(path[:i+1], "."))
+		}
+		object = next
+	}
+	key := path[len(path)-1]
+	if _, exists := object[key]; exists {
+		return fmt.Errorf("field %s is set by more than one column", strings.Join(path, "."))
+	}
+	object[key] = value
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// bulkEventWriter writes the NDJSON progress stream. When the connection cannot read the
+// request while writing the response, events are held back until the upload was read and
+// only errors and the summary are reported.
+type bulkEventWriter struct {
+	c       *gin.Context
+	encoder *json.Encoder
+	live    bool
+	pending []models.BulkStreamEvent
+}
+
+func newBulkEventWriter(c *gin.Context) *bulkEventWriter {
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Status(http.StatusOK)
+
+	// HTTP/1 servers otherwise discard the unread upload when the response starts
+	live := c.Request.ProtoMajor >= 2 || http.NewResponseController(c.Writer).EnableFullDuplex() == nil
+	w := &bulkEventWriter{c: c, encoder: json.NewEncoder(c.Writer), live: live}
+	if live {
+		c.Writer.WriteHeaderNow()
+		c.Writer.Flush()
+	}
+	return w
+}
+
+func (w *bulkEventWriter) emit(event models.BulkStreamEvent) {
+	if !w.live {
+		if event.Type != models.BulkStreamEventProgress {
+			w.pending = append(w.pending, event)
+		}
+		return
+	}
+	_ = w.encoder.Encode(event)
+	w.c.Writer.Flush()
+}
+
+func (w *bulkEventWriter) close(summary models.BulkStreamEvent) {
+	for _, event := range w.pending {
+		_ = w.encoder.Encode(event)
+	}
+	w.pending = nil
+	_ = w.encoder.Encode(summary)
+	w.c.Writer.Flush()
+}
+
+// bulkStream enqueues the items of an upload in micro-batches while it is read
+type bulkStream struct {
+	stateMachineID string
+	options        *bulkStreamOptions
+	schemas        *executionSchemas
+	queue          executionEnqueuer
+	opts           []asynq.Option // Options of every task; the queue defaults when empty
+	emit           func(models.BulkStreamEvent)
+
+	counts   models.BulkStreamEvent
+	chunk    []*queue.ExecutionTaskPayload
+	lines    []int
+	chunks   int
+	index    int
+	lastLine int
+}
+
+// run reads the upload to the end and returns the summary event
+func (s *bulkStream) run(ctx context.Context, reader bulkItemReader) models.BulkStreamEvent {
+	for {
+		if err := ctx.Err(); err != nil {
+			return s.summary(reader, err)
+		}
+		item, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			s.flush()
+			summary := s.summary(reader, nil)
+			summary.Completed = true
+			return summary
+		}
+		if err != nil {
+			s.flush()
+			return s.summary(reader, err)
+		}
+		s.add(item)
+	}
+}
+
+// add validates an item and adds it to the current micro-batch
+func (s *bulkStream) add(item bulkItem) {
+	if item.err != nil {
+		s.counts.Rejected++
+		s.event(models.BulkStreamEvent{Type: models.BulkStreamEventError, Line: item.line, Error: item.err.Error()})
+		return
+	}
+	if errs := s.schemas.validateInput("input", item.input); len(errs) > 0 {
+		s.counts.Rejected++
+		s.event(models.BulkStreamEvent{Type: models.BulkStreamEventError, Line: item.line, Error: "input does not match the input schema", Errors: errs})
+		return
+	}
+
+	s.enqueue(item.line, item.input)
+}
+
+// enqueue adds a valid input to the current micro-batch, enqueueing the micro-batch when
+// it is full
+func (s *bulkStream) enqueue(line int, input interface{}) {
+	payload := &queue.ExecutionTaskPayload{
+		StateMachineID: s.stateMachineID,
+		ExecutionName:  fmt.Sprintf("%s-%d", s.options.NamePrefix, s.index),
+		ExecutionIndex: s.index,
+		Input:          input,
+	}
+	if len(s.options.Tags) > 0 {
+		payload.Options = map[string]interface{}{middleware.ExecutionTagsOption: s.options.Tags}
+	}
+	s.index++
+	s.chunk = append(s.chunk, payload)
+	s.lines = append(s.lines, line)
+	s.lastLine = line
+	if len(s.chunk) >= s.options.ChunkSize {
+		s.flush()
+	}
+}
+
+// flush enqueues the current micro-batch and reports progress
+func (s *bulkStream) flush() {
+	if len(s.chunk) == 0 {
+		return
+	}
+
+	if s.options.GroupEnqueue {
+		groupID := fmt.Sprintf("%s-mb%d", s.options.NamePrefix, s.chunks)
+		infos, err := s.queue.EnqueueExecutionGroup(s.chunk, groupID, s.opts...)
+		s.counts.Enqueued += len(infos)
+		for i := len(infos); i < len(s.chunk) && err != nil; i++ {
+			s.failed(s.lines[i], err)
+		}
+	} else {
+		for i, payload := range s.chunk {
+			if _, err := s.queue.EnqueueExecution(payload, executionTaskOptions(payload, s.opts)...); err != nil {
+				s.failed(s.lines[i], err)
+				continue
+			}
+			s.counts.Enqueued++
+		}
+	}
+
+	s.chunks++
+	s.chunk = s.chunk[:0]
+	s.lines = s.lines[:0]
+
+	progress := s.counts
+	progress.Type = models.BulkStreamEventProgress
+	progress.LinesRead = s.lastLine
+	s.event(progress)
+}
+
+func (s *bulkStream) failed(line int, err error) {
+	s.counts.Failed++
+	s.event(models.BulkStreamEvent{Type: models.BulkStreamEventError, Line: line, Error: err.Error()})
+}
+
+// event reports an event with the current counters
+func (s *bulkStream) event(event models.BulkStreamEvent) {
+	event.Enqueued, event.Rejected, event.Failed = s.counts.Enqueued, s.counts.Rejected, s.counts.Failed
+	if event.LinesRead == 0 {
+		event.LinesRead = event.Line
+	}
+	s.emit(event)
+}
+
+func (s *bulkStream) summary(reader bulkItemReader, err error) models.BulkStreamEvent {
+	summary := s.counts
+	summary.Type = models.BulkStreamEventSummary
+	summary.BatchID = s.options.NamePrefix
+	summary.LinesRead = reader.LinesRead()
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	return summary
+}
+
+// bulkUpload returns the uploaded inputs and their content type. Multipart uploads are
+// read part by part, so the "inputs" file is streamed rather than buffered.
+func bulkUpload(c *gin.Context) (io.Reader, string, string, error) {
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return c.Request.Body, mediaType, "", nil
+	}
+
+	parts, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, "", "", err
+	}
+	for {
+		part, err := parts.NextPart()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, "", "", errors.New("missing inputs file")
+			}
+			return nil, "", "", err
+		}
+		if part.FormName() == "inputs" {
+			partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			return part, partType, part.FileName(), nil
+		}
+	}
+}
+
+// detectBulkFormat infers the upload format from its media type or file name
+func detectBulkFormat(mediaType, fileName string) string {
+	switch {
+	case containsString(ndjsonContentTypes, mediaType):
+		return bulkFormatNDJSON
+	case mediaType == "text/csv":
+		return bulkFormatCSV
+	}
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".ndjson", ".jsonl":
+		return bulkFormatNDJSON
+	case ".csv":
+		return bulkFormatCSV
+	}
+	return ""
+}
+
+// ExecuteBulkStream enqueues executions from an NDJSON or CSV upload while it is being read.
+// The upload is the request body or the "inputs" file of a multipart form. Items are
+// enqueued in micro-batches of chunkSize, and the response is an NDJSON stream of
+// BulkStreamEvent: an error event per line that could not be parsed, validated or
+// enqueued, a progress event per micro-batch and a final summary.
+//
+// Query parameters:
+// - format: "ndjson" or "csv" (optional, detected from the content type or file name)
+// - namePrefix: Prefix for execution names (optional, default: "bulk-{timestamp}")
+// - chunkSize: Items enqueued per micro-batch, 1-1000 (optional, default: 100)
+// - groupEnqueue: Enqueue each micro-batch as a task group (optional, default: false)
+// - map: CSV header to input field, as header:field; dotted fields nest (repeatable)
+// - type: Type of a CSV field, as field:string|number|integer|boolean|json (repeatable)
+// - tag: Tag applied to every execution, as key:value (repeatable)
+func ExecuteBulkStream(c *gin.Context) {
+	repoManager, ok := middleware.GetRepositoryManager(c)
+	if !ok {
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
+		return
+	}
+	queueClient, ok := middleware.GetQueueClient(c)
+	if !ok {
+		respondNotConfigured(c, models.CodeQueueNotConfigured, "Queue client not configured")
+		return
+	}
+	streamBulkUpload(c, repoManager, queueClient)
+}
+
+// streamBulkUpload validates the options and runs a streaming bulk upload
+func streamBulkUpload(c *gin.Context, repoManager *repository.Manager, enqueuer executionEnqueuer) {
+	stateMachineID := c.Param("stateMachineId")
+
+	var errs fieldErrors
+	options := parseBulkStreamOptions(c, &errs)
+	if errs.respond(c) {
+		return
+	}
+
+	schemas, ok := executionSchemasFor(c, repoManager, stateMachineID)
+	if !ok {
+		return
+	}
+
+	upload, mediaType, fileName, err := bulkUpload(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, models.CodeInvalidRequest, "Invalid upload", err.Error())
+		return
+	}
+	if options.Format == "" {
+		options.Format = detectBulkFormat(mediaType, fileName)
+	}
+
+	var reader bulkItemReader
+	switch options.Format {
+	case bulkFormatNDJSON:
+		if len(options.Mapping) > 0 || len(options.Types) > 0 {
+			errs.add("map", "map and type only apply to CSV uploads")
+		}
+		reader = newNDJSONReader(upload)
+	case bulkFormatCSV:
+		reader, err = newCSVReader(upload, options.Mapping, options.Types)
+		if err != nil {
+			errs.add("inputs", "%v", err)
+		}
+	default:
+		errs.add("format", "must be ndjson or csv when it cannot be detected from the content type or file name")
+	}
+	if errs.respond(c) {
+		return
+	}
+	if redisClient, ok := middleware.GetRedisClient(c); ok {
+		run := &batchRun{Kind: runKindBulk, NamePrefix: options.NamePrefix, StateMachineID: stateMachineID, GroupEnqueue: options.GroupEnqueue, Concurrency: 10, Tags: options.Tags}
+		if !registerBatchRun(c, redisClient, options.NamePrefix, run) {
+			return
+		}
+	}
+
+	events := newBulkEventWriter(c)
+	stream := &bulkStream{
+		stateMachineID: stateMachineID,
+		options:        options,
+		schemas:        schemas,
+		queue:          enqueuer,
+		emit:           events.emit,
+	}
+	events.close(stream.run(c.Request.Context(), reader))
+}
@@ -0,0 +1,628 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hibiken/asynq"
+	"github.com/hussainpithawala/state-machine-amz-gin/datasets"
+	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
+	"github.com/hussainpithawala/state-machine-amz-gin/models"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+)
+
+const (
+	defaultDatasetTTL = 7 * 24 * time.Hour
+	maxDatasetTTL     = 90 * 24 * time.Hour
+	// maxDatasetChunkBytes bounds a single chunk upload; datasets have up to datasets.MaxChunks chunks
+	maxDatasetChunkBytes = 64 << 20
+	// maxDatasetErrors bounds the row errors reported when an upload is completed
+	maxDatasetErrors   = 20
+	defaultPreviewRows = 10
+	maxPreviewRows     = 100
+)
+
+// datasetStore returns the configured dataset store or writes a 500
+func datasetStore(c *gin.Context) (*datasets.Store, bool) {
+	store, ok := middleware.GetDatasetStore(c)
+	if !ok {
+		respondNotConfigured(c, models.CodeDatasetStoreNotConfigured, "Dataset store not configured")
+	}
+	return store, ok
+}
+
+// respondDatasetError reports a missing dataset as 404 and a failed store as 503
+func respondDatasetError(c *gin.Context, err error, title string) {
+	if errors.Is(err, datasets.ErrNotFound) {
+		respondError(c, http.StatusNotFound, models.CodeDatasetNotFound, "Dataset not found", "")
+		return
+	}
+	respondError(c, http.StatusServiceUnavailable, models.CodeDatasetStoreUnavailable, title, err.Error())
+}
+
+// loadDataset looks up the dataset named by the datasetId path parameter
+func loadDataset(c *gin.Context, store *datasets.Store) (*datasets.Dataset, bool) {
+	dataset, err := store.Get(c.Request.Context(), c.Param("datasetId"))
+	if err != nil {
+		respondDatasetError(c, err, "Failed to read dataset")
+		return nil, false
+	}
+	return dataset, true
+}
+
+// requireReadyDataset writes a 409 unless the upload of the dataset is complete
+func requireReadyDataset(c *gin.Context, dataset *datasets.Dataset) bool {
+	if dataset.Status != datasets.StatusReady {
+		respondError(c, http.StatusConflict, models.CodeDatasetNotReady, "Dataset upload is not complete",
+			fmt.Sprintf("Dataset %s is %s; complete the upload first", dataset.ID, dataset.Status))
+		return false
+	}
+	return true
+}
+
+func datasetResponse(dataset *datasets.Dataset, chunks []datasets.Chunk) *models.DatasetResponse {
+	response := &models.DatasetResponse{
+		ID:          dataset.ID,
+		Name:        dataset.Name,
+		Format:      dataset.Format,
+		Mapping:     dataset.Mapping,
+		Types:       dataset.Types,
+		Status:      dataset.Status,
+		Chunks:      dataset.Chunks,
+		Size:        dataset.Size,
+		RowCount:    dataset.RowCount,
+		CreatedAt:   dataset.CreatedAt,
+		CompletedAt: dataset.CompletedAt,
+		ExpiresAt:   dataset.ExpiresAt,
+	}
+	if dataset.Status == datasets.StatusUploading {
+		response.Chunks = len(chunks)
+		response.UploadedChunks = make([]models.DatasetChunk, len(chunks))
+		for i, chunk := range chunks {
+			response.Size += chunk.Size
+			response.UploadedChunks[i] = models.DatasetChunk{Index: chunk.Index, Size: chunk.Size}
+		}
+	}
+	return response
+}
+
+// datasetContentError reports dataset content that cannot be read as rows, as opposed to
+// a failure of the store
+type datasetContentError struct {
+	err error
+}
+
+func (e *datasetContentError) Error() string {
+	return e.err.Error()
+}
+
+// storeReader remembers the error of the store a dataset is read from
+type storeReader struct {
+	io.Reader
+	err error
+}
+
+func (r *storeReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		r.err = err
+	}
+	return n, err
+}
+
+// readDatasetRows calls fn with every row of a dataset until fn returns false. The
+// error is the one that stopped reading before the end of the dataset; content that
+// cannot be read is reported as a *datasetContentError.
+func readDatasetRows(ctx context.Context, store *datasets.Store, dataset *datasets.Dataset, chunks int, fn func(index int, item bulkItem) bool) error {
+	content := store.Open(ctx, dataset.ID, chunks)
+	defer func() {
+		_ = content.Close()
+	}()
+	source := &storeReader{Reader: content}
+
+	failed := func(err error) error {
+		if source.err != nil {
+			return source.err
+		}
+		return &datasetContentError{err: err}
+	}
+
+	var reader bulkItemReader
+	if dataset.Format == bulkFormatCSV {
+		csvRows, err := newCSVReader(source, dataset.Mapping, dataset.Types)
+		if err != nil {
+			return failed(err)
+		}
+		reader = csvRows
+	} else {
+		reader = newNDJSONReader(source)
+	}
+
+	for index := 0; ; index++ {
+		item, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return failed(err)
+		}
+		if !fn(index, item) {
+			return nil
+		}
+	}
+}
+
+// selectDatasetInput applies the selector to a row
+func selectDatasetInput(selector jsonPathExpr, item bulkItem) (interface{}, error) {
+	if item.err != nil {
+		return nil, item.err
+	}
+	if selector == nil {
+		return item.input, nil
+	}
+	input, ok := selector.lookup(item.input)
+	if !ok {
+		return nil, fmt.Errorf("selector %s matched nothing", selector)
+	}
+	return input, nil
+}
+
+// parseSelector parses an optional JSONPath selector
+func parseSelector(errs *fieldErrors, field, selector string) jsonPathExpr {
+	if selector == "" {
+		return nil
+	}
+	path, err := parseJSONPath(selector)
+	if err != nil {
+		errs.add(field, "%v", err)
+		return nil
+	}
+	return path
+}
+
+// maxReportedRejections bounds the rejected rows a dataset bulk reports; the rest are only
+// counted
+const maxReportedRejections = 100
+
+// datasetBulk is a bulk execution over the rows of a ready dataset
+type datasetBulk struct {
+	store    *datasets.Store
+	dataset  *datasets.Dataset
+	selector jsonPathExpr
+	schemas  *executionSchemas
+}
+
+// openDatasetBulk checks the selector and loads a ready dataset and the schemas its rows
+// are validated against. It writes the error response when ok is false.
+func openDatasetBulk(c *gin.Context, repoManager *repository.Manager, stateMachineID, datasetID, selector string) (bulk *datasetBulk, ok bool) {
+	var errs fieldErrors
+	path := parseSelector(&errs, "selector", selector)
+	if errs.respond(c) {
+		return nil, false
+	}
+
+	store, ok := datasetStore(c)
+	if !ok {
+		return nil, false
+	}
+	dataset, err := store.Get(c.Request.Context(), datasetID)
+	if err != nil {
+		respondDatasetError(c, err, "Failed to read dataset")
+		return nil, false
+	}
+	if !requireReadyDataset(c, dataset) {
+		return nil, false
+	}
+
+	schemas, ok := executionSchemasFor(c, repoManager, stateMachineID)
+	if !ok {
+		return nil, false
+	}
+	return &datasetBulk{store: store, dataset: dataset, selector: path, schemas: schemas}, true
+}
+
+// run streams the rows of the dataset into stream, which enqueues them in micro-batches,
+// so the dataset is never held in memory. Rows the selector matches nothing in or that
+// fail the input schema are rejected by row index; the first maxReportedRejections are
+// returned with the number of rejected rows.
+func (d *datasetBulk) run(ctx context.Context, stream *bulkStream) (rejected []models.RejectedItem, total int, err error) {
+	reject := func(item models.RejectedItem) {
+		total++
+		if len(rejected) < maxReportedRejections {
+			rejected = append(rejected, item)
+		}
+	}
+	err = readDatasetRows(ctx, d.store, d.dataset, d.dataset.Chunks, func(index int, item bulkItem) bool {
+		field := fmt.Sprintf("rows[%d]", index)
+		input, err := selectDatasetInput(d.selector, item)
+		if err != nil {
+			reject(models.RejectedItem{Index: index, Errors: []models.FieldError{{Field: field, Message: err.Error()}}})
+			return true
+		}
+		if errs := d.schemas.validateInput(field, input); len(errs) > 0 {
+			reject(models.RejectedItem{Index: index, Errors: errs})
+			return true
+		}
+		stream.enqueue(index, input)
+		return ctx.Err() == nil
+	})
+	stream.flush()
+	if err == nil {
+		err = ctx.Err()
+	}
+	return rejected, total, err
+}
+
+// executeDatasetBulk queues an execution for every valid row of a dataset and reports how
+// many were queued. A dataset without a valid row is a validation failure.
+func executeDatasetBulk(c *gin.Context, bulk *datasetBulk, enqueuer executionEnqueuer, stateMachineID, batchID string, req *models.ExecuteBulkRequest, startAt time.Time, opts []asynq.Option) {
+	stream := &bulkStream{
+		stateMachineID: stateMachineID,
+		options: &bulkStreamOptions{
+			NamePrefix:   req.NamePrefix,
+			ChunkSize:    defaultBulkChunkSize,
+			GroupEnqueue: req.GroupEnqueue,
+			Tags:         req.Tags,
+		},
+		queue: enqueuer,
+		opts:  opts,
+		emit:  func(models.BulkStreamEvent) {},
+	}
+	rejected, totalRejected, err := bulk.run(c.Request.Context(), stream)
+	if err != nil {
+		if stream.counts.Enqueued > 0 {
+			err = fmt.Errorf("%w; %d executions of %s were queued before reading stopped", err, stream.counts.Enqueued, batchID)
+		}
+		respondDatasetError(c, err, "Failed to read dataset")
+		return
+	}
+	if stream.counts.Enqueued == 0 && stream.counts.Failed == 0 {
+		respondRejected(c, rejected)
+		return
+	}
+
+	response := models.BulkExecutionResponse{
+		OrchestratorID: batchID,
+		BatchID:        batchID,
+		Status:         "Accepted",
+		TotalEnqueued:  stream.counts.Enqueued,
+		TotalFailed:    stream.counts.Failed,
+		Mode:           req.Mode,
+		TotalRejected:  totalRejected,
+		Rejected:       rejected,
+	}
+	if !startAt.IsZero() {
+		response.Status = "Scheduled"
+		response.ScheduledAt = &startAt
+	}
+	c.JSON(http.StatusAccepted, response)
+}
+
+// CreateDataset starts the upload of a dataset. The content is then uploaded in chunks
+// with UploadDatasetChunk and the upload finished with CompleteDataset.
+func CreateDataset(c *gin.Context) {
+	store, ok := datasetStore(c)
+	if !ok {
+		return
+	}
+
+	var req models.CreateDatasetRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	var errs fieldErrors
+	if req.Format != bulkFormatCSV && (len(req.Mapping) > 0 || len(req.Types) > 0) {
+		errs.add("mapping", "mapping and types only apply to CSV datasets")
+	}
+	for _, header := range sortedStringKeys(req.Mapping) {
+		if req.Mapping[header] == "" || containsString(strings.Split(req.Mapping[header], "."), "") {
+			errs.add("mapping."+header, "must be a field name; dotted names nest")
+		}
+	}
+	for _, field := range sortedStringKeys(req.Types) {
+		oneOf(&errs, "types."+field, req.Types[field], csvTypes...)
+	}
+	ttl := defaultDatasetTTL
+	if req.ExpiresInSeconds > 0 {
+		ttl = time.Duration(req.ExpiresInSeconds) * time.Second
+		if ttl > maxDatasetTTL {
+			errs.add("expiresInSeconds", "must be at most %d", int(maxDatasetTTL/time.Second))
+		}
+	}
+	if errs.respond(c) {
+		return
+	}
+
+	dataset := &datasets.Dataset{
+		Name:      req.Name,
+		Format:    req.Format,
+		Mapping:   req.Mapping,
+		Types:     req.Types,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	}
+	if err := store.Create(c.Request.Context(), dataset); err != nil {
+		respondDatasetError(c, err, "Failed to create dataset")
+		return
+	}
+
+	c.JSON(http.StatusCreated, datasetResponse(dataset, nil))
+}
+
+// UploadDatasetChunk stores one chunk of a dataset from the raw request body. Chunks are
+// numbered from 0 and concatenated in order; uploading an index again replaces the chunk,
+// so an interrupted upload is resumed by sending the chunks GetDataset does not list.
+// An optional X-Checksum-SHA256 header (hex) is checked against the stored chunk.
+func UploadDatasetChunk(c *gin.Context) {
+	store, ok := datasetStore(c)
+	if !ok {
+		return
+	}
+
+	var errs fieldErrors
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil || index < 0 || index >= datasets.MaxChunks {
+		errs.add("index", "must be an integer between 0 and %d", datasets.MaxChunks-1)
+	}
+	expected := strings.ToLower(c.GetHeader("X-Checksum-SHA256"))
+	if expected != "" && len(expected) != sha256.Size*2 {
+		errs.add("X-Checksum-SHA256", "must be a hex SHA-256 digest")
+	}
+	if errs.respond(c) {
+		return
+	}
+
+	dataset, ok := loadDataset(c, store)
+	if !ok {
+		return
+	}
+	if dataset.Status != datasets.StatusUploading {
+		respondError(c, http.StatusConflict, models.CodeDatasetCompleted, "Dataset upload is already complete",
+			fmt.Sprintf("Dataset %s is %s and no longer accepts chunks", dataset.ID, dataset.Status))
+		return
+	}
+
+	hash := sha256.New()
+	body := io.TeeReader(http.MaxBytesReader(c.Writer, c.Request.Body, maxDatasetChunkBytes), hash)
+	size, err := store.PutChunk(c.Request.Context(), dataset.ID, index, body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(c, http.StatusRequestEntityTooLarge, models.CodeInvalidRequest, "Chunk too large",
+				fmt.Sprintf("Chunks must not be larger than %d bytes", maxDatasetChunkBytes))
+			return
+		}
+		respondDatasetError(c, err, "Failed to store chunk")
+		return
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if expected != "" && expected != checksum {
+		_ = store.DeleteChunk(c.Request.Context(), dataset.ID, index)
+		respondError(c, http.StatusBadRequest, models.CodeInvalidRequest, "Chunk checksum mismatch",
+			fmt.Sprintf("X-Checksum-SHA256 is %s but the received chunk hashes to %s; upload the chunk again", expected, checksum))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.DatasetChunkResponse{
+		DatasetID: dataset.ID,
+		Index:     index,
+		Size:      size,
+		SHA256:    checksum,
+	})
+}
+
+// CompleteDataset finishes the upload of a dataset. The chunks must be numbered 0 to n-1
+// without gaps; every row is parsed and counted, and the dataset stays open for uploads
+// when a row cannot be read. Completing a ready dataset again returns it unchanged.
+func CompleteDataset(c *gin.Context) {
+	store, ok := datasetStore(c)
+	if !ok {
+		return
+	}
+
+	var req models.CompleteDatasetRequest
+	if c.Request.ContentLength != 0 && !bindJSON(c, &req) {
+		return
+	}
+
+	dataset, ok := loadDataset(c, store)
+	if !ok {
+		return
+	}
+	if dataset.Status == datasets.StatusReady {
+		c.JSON(http.StatusOK, datasetResponse(dataset, nil))
+		return
+	}
+
+	ctx := c.Request.Context()
+	chunks, err := store.Chunks(ctx, dataset.ID)
+	if err != nil {
+		respondDatasetError(c, err, "Failed to list chunks")
+		return
+	}
+
+	var errs fieldErrors
+	var size int64
+	for i, chunk := range chunks {
+		if chunk.Index != i {
+			errs.add("chunks", "chunk %d is missing", i)
+			break
+		}
+		size += chunk.Size
+	}
+	switch {
+	case len(chunks) == 0:
+		errs.add("chunks", "no chunks were uploaded")
+	case req.Chunks > 0 && req.Chunks != len(chunks):
+		errs.add("chunks", "expected %d chunks but %d were uploaded", req.Chunks, len(chunks))
+	}
+	if errs.respond(c) {
+		return
+	}
+
+	rows := 0
+	err = readDatasetRows(ctx, store, dataset, len(chunks), func(index int, item bulkItem) bool {
+		rows++
+		if item.err != nil {
+			errs.add(fmt.Sprintf("rows[%d]", index), "line %d: %v", item.line, item.err)
+		}
+		return len(errs) < maxDatasetErrors
+	})
+	var contentErr *datasetContentError
+	if errors.As(err, &contentErr) {
+		errs.add("dataset", "%v", err)
+	} else if err != nil {
+		respondDatasetError(c, err, "Failed to read dataset")
+		return
+	}
+	if len(errs) == 0 && rows == 0 {
+		errs.add("dataset", "the dataset has no rows")
+	}
+	if errs.respond(c) {
+		return
+	}
+
+	if err := store.Complete(ctx, dataset, len(chunks), size, rows); err != nil {
+		respondDatasetError(c, err, "Failed to complete dataset")
+		return
+	}
+	c.JSON(http.StatusOK, datasetResponse(dataset, nil))
+}
+
+// GetDataset returns a dataset. While it is uploading the response lists the chunks
+// received so far.
+func GetDataset(c *gin.Context) {
+	store, ok := datasetStore(c)
+	if !ok {
+		return
+	}
+	dataset, ok := loadDataset(c, store)
+	if !ok {
+		return
+	}
+
+	var chunks []datasets.Chunk
+	if dataset.Status == datasets.StatusUploading {
+		var err error
+		if chunks, err = store.Chunks(c.Request.Context(), dataset.ID); err != nil {
+			respondDatasetError(c, err, "Failed to list chunks")
+			return
+		}
+	}
+	c.JSON(http.StatusOK, datasetResponse(dataset, chunks))
+}
+
+// ListDatasets lists the datasets that have not expired, newest first
+// Query parameters:
+// - status: "UPLOADING" or "READY" (optional)
+// - limit: Page size, 1-1000 (optional, default: 100)
+// - offset: Datasets to skip (optional, default: 0)
+func ListDatasets(c *gin.Context) {
+	store, ok := datasetStore(c)
+	if !ok {
+		return
+	}
+
+	var errs fieldErrors
+	status := queryOneOf(c, &errs, "status", datasets.StatusUploading, datasets.StatusReady)
+	limit := queryInt(c, &errs, "limit", 100, 1, 1000)
+	offset := queryInt(c, &errs, "offset", 0, 0, math.MaxInt32)
+	if errs.respond(c) {
+		return
+	}
+
+	all, err := store.List(c.Request.Context())
+	if err != nil {
+		respondDatasetError(c, err, "Failed to list datasets")
+		return
+	}
+
+	matching := make([]*models.DatasetResponse, 0, len(all))
+	for _, dataset := range all {
+		if status == "" || dataset.Status == status {
+			matching = append(matching, datasetResponse(dataset, nil))
+		}
+	}
+	page := matching[min(offset, len(matching)):min(offset+limit, len(matching))]
+
+	c.JSON(http.StatusOK, models.ListDatasetsResponse{
+		Datasets: page,
+		Total:    len(matching),
+		Limit:    limit,
+		Offset:   offset,
+	})
+}
+
+// PreviewDataset returns the first rows of a ready dataset as bulk executions would
+// read them
+// Query parameters:
+// - limit: Rows to return, 1-100 (optional, default: 10)
+// - selector: JSONPath applied to every row, as in ExecuteBulk (optional)
+func PreviewDataset(c *gin.Context) {
+	store, ok := datasetStore(c)
+	if !ok {
+		return
+	}
+
+	var errs fieldErrors
+	limit := queryInt(c, &errs, "limit", defaultPreviewRows, 1, maxPreviewRows)
+	selector := parseSelector(&errs, "selector", c.Query("selector"))
+	if errs.respond(c) {
+		return
+	}
+
+	dataset, ok := loadDataset(c, store)
+	if !ok || !requireReadyDataset(c, dataset) {
+		return
+	}
+
+	rows := make([]models.DatasetRow, 0, min(limit, dataset.RowCount))
+	err := readDatasetRows(c.Request.Context(), store, dataset, dataset.Chunks, func(index int, item bulkItem) bool {
+		row := models.DatasetRow{Index: index, Line: item.line}
+		if input, err := selectDatasetInput(selector, item); err != nil {
+			row.Error = err.Error()
+		} else {
+			row.Input = input
+		}
+		rows = append(rows, row)
+		return len(rows) < limit
+	})
+	if err != nil {
+		respondDatasetError(c, err, "Failed to read dataset")
+		return
+	}
+
+	c.JSON(http.StatusOK, models.DatasetPreviewResponse{
+		DatasetID: dataset.ID,
+		RowCount:  dataset.RowCount,
+		Rows:      rows,
+	})
+}
+
+// DeleteDataset removes a dataset and its chunks
+func DeleteDataset(c *gin.Context) {
+	store, ok := datasetStore(c)
+	if !ok {
+		return
+	}
+	dataset, ok := loadDataset(c, store)
+	if !ok {
+		return
+	}
+	if err := store.Delete(c.Request.Context(), dataset.ID); err != nil {
+		respondDatasetError(c, err, "Failed to delete dataset")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
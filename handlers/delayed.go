@@ -0,0 +1,381 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hibiken/asynq"
+	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
+	"github.com/hussainpithawala/state-machine-amz-gin/models"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
+)
+
+// maxStartDelay bounds how far ahead an execution can be scheduled
+const maxStartDelay = 366 * 24 * time.Hour
+
+// uniqueForOption records in the options of an execution task how long the task was
+// queued as unique, so a rescheduled task stays unique
+const uniqueForOption = "uniqueForSeconds"
+
+// scheduledTaskID is the ID of the task of a delayed execution, by which the execution
+// is looked up, cancelled and rescheduled
+func scheduledTaskID(stateMachineID, executionName string) string {
+	return stateMachineID + ":" + executionName
+}
+
+// executionTaskOptions are the options of the task of an execution: opts, and the ID of
+// the task when opts delay it
+func executionTaskOptions(payload *queue.ExecutionTaskPayload, opts []asynq.Option) []asynq.Option {
+	for _, opt := range opts {
+		if opt.Type() == asynq.ProcessAtOpt || opt.Type() == asynq.ProcessInOpt {
+			return append(slices.Clip(opts), asynq.TaskID(scheduledTaskID(payload.StateMachineID, payload.ExecutionName)))
+		}
+	}
+	return opts
+}
+
+// delayedStart returns when an execution asked to start at startAt or after
+// delaySeconds should start. The zero time means it starts right away.
+func delayedStart(errs *fieldErrors, startAt *time.Time, delaySeconds int) time.Time {
+	now := time.Now()
+	switch {
+	case startAt != nil && delaySeconds > 0:
+		errs.add("startAt", "provide either startAt or delaySeconds, not both")
+	case startAt != nil:
+		if !startAt.After(now) {
+			errs.add("startAt", "must be in the future")
+		} else if startAt.Sub(now) > maxStartDelay {
+			errs.add("startAt", "must be within %d days", int(maxStartDelay.Hours()/24))
+		}
+		return startAt.UTC()
+	case delaySeconds > 0:
+		delay := time.Duration(delaySeconds) * time.Second
+		if delay > maxStartDelay {
+			errs.add("delaySeconds", "must be at most %d", int(maxStartDelay.Seconds()))
+		}
+		return now.Add(delay).UTC()
+	}
+	return time.Time{}
+}
+
+// validateDelayedBulk rejects the bulk options that do not apply to a delayed bulk,
+// whose executions are queued one by one
+func validateDelayedBulk(errs *fieldErrors, startAt time.Time, doMicroBatch, groupEnqueue bool) {
+	if startAt.IsZero() {
+		return
+	}
+	if doMicroBatch {
+		errs.add("doMicroBatch", "cannot be combined with startAt or delaySeconds")
+	}
+	if groupEnqueue {
+		errs.add("groupEnqueue", "cannot be combined with startAt or delaySeconds")
+	}
+}
+
+// enqueueBulk queues an execution named <namePrefix>-<i> for every input, carrying the
+// tags of the bulk in its options. It returns how many inputs could not be queued.
+func enqueueBulk(enqueuer executionEnqueuer, stateMachineID, namePrefix string, inputs []interface{}, tags map[string]string, stopOnError bool, opts []asynq.Option) int {
+	failed := 0
+	for i, input := range inputs {
+		payload := &queue.ExecutionTaskPayload{
+			StateMachineID: stateMachineID,
+			ExecutionName:  fmt.Sprintf("%s-%d", namePrefix, i),
+			ExecutionIndex: i,
+			Input:          input,
+		}
+		if len(tags) > 0 {
+			payload.Options = map[string]interface{}{middleware.ExecutionTagsOption: tags}
+		}
+		if _, err := enqueuer.EnqueueExecution(payload, executionTaskOptions(payload, opts)...); err != nil {
+			failed++
+			if stopOnError {
+				return failed + len(inputs) - i - 1
+			}
+		}
+	}
+	return failed
+}
+
+// respondDelayedBulk reports the executions of a delayed bulk as scheduled
+func respondDelayedBulk(c *gin.Context, batchID, mode string, startAt time.Time, total, failed int, rejected []models.RejectedItem) {
+	c.JSON(http.StatusAccepted, models.BulkExecutionResponse{
+		OrchestratorID: batchID,
+		BatchID:        batchID,
+		Status:         "Scheduled",
+		TotalEnqueued:  total - failed,
+		TotalFailed:    failed,
+		Mode:           mode,
+		TotalRejected:  len(rejected),
+		Rejected:       rejected,
+		ScheduledAt:    &startAt,
+	})
+}
+
+// queueInspector returns the inspector of the queue, or writes a 500
+func queueInspector(c *gin.Context) (*asynq.Inspector, bool) {
+	inspector, ok := middleware.GetQueueInspector(c)
+	if !ok || inspector == nil {
+		respondNotConfigured(c, models.CodeQueueNotConfigured, "Queue inspector not configured")
+		return nil, false
+	}
+	return inspector, true
+}
+
+// scheduledExecutionResponse describes a queued execution task; ok is false for other tasks
+func scheduledExecutionResponse(info *asynq.TaskInfo) (*models.ScheduledExecutionResponse, bool) {
+	if info.Type != queue.TypeExecutionTask {
+		return nil, false
+	}
+	var payload queue.ExecutionTaskPayload
+	if err := json.Unmarshal(info.Payload, &payload); err != nil {
+		return nil, false
+	}
+	status := StatusScheduled
+	if info.State != asynq.TaskStateScheduled {
+		status = StatusPending
+	}
+	response := &models.ScheduledExecutionResponse{
+		ExecutionName:     payload.ExecutionName,
+		StateMachineID:    payload.StateMachineID,
+		Status:            status,
+		StartAt:           info.NextProcessAt.UTC(),
+		Input:             payload.Input,
+		SourceExecutionID: payload.SourceExecutionID,
+		SourceStateName:   payload.SourceStateName,
+	}
+	if tags, ok := payload.Options[middleware.ExecutionTagsOption].(map[string]interface{}); ok {
+		response.Tags = make(map[string]string, len(tags))
+		for key, value := range tags {
+			response.Tags[key] = fmt.Sprint(value)
+		}
+	}
+	return response, true
+}
+
+// scheduledQueue is the queue scheduled executions are looked up in: the queue query
+// parameter, or the queue of the state machine
+func scheduledQueue(c *gin.Context) string {
+	return c.DefaultQuery("queue", c.Param("stateMachineId"))
+}
+
+// scheduledTask returns the task of a scheduled execution, or writes a 404 when there is
+// none and a 409 when it has started
+func scheduledTask(c *gin.Context, inspector *asynq.Inspector) (*asynq.TaskInfo, bool) {
+	info, err := inspector.GetTaskInfo(scheduledQueue(c), scheduledTaskID(c.Param("stateMachineId"), c.Param("executionName")))
+	if err == nil {
+		if execution, ok := scheduledExecutionResponse(info); !ok || execution.StateMachineID != c.Param("stateMachineId") {
+			err = asynq.ErrTaskNotFound
+		}
+	}
+	if errors.Is(err, asynq.ErrQueueNotFound) || errors.Is(err, asynq.ErrTaskNotFound) {
+		respondError(c, http.StatusNotFound, models.CodeScheduledExecutionNotFound, "Scheduled execution not found", "")
+		return nil, false
+	}
+	if err != nil {
+		respondError(c, http.StatusServiceUnavailable, models.CodeQueueUnavailable, "Failed to read scheduled execution", err.Error())
+		return nil, false
+	}
+	if info.State != asynq.TaskStateScheduled {
+		respondError(c, http.StatusConflict, models.CodeExecutionNotScheduled, "Execution is not scheduled",
+			fmt.Sprintf("The execution task is %s", info.State))
+		return nil, false
+	}
+	return info, true
+}
+
+// ListScheduledExecutions lists the delayed executions of a state machine that have not
+// started, soonest first
+// Query parameters:
+// - queue: Queue the executions were enqueued on (optional, default: the state machine ID)
+// - page: Page number, from 1 (optional, default: 1)
+// - pageSize: Page size, 1-1000 (optional, default: 100)
+func ListScheduledExecutions(c *gin.Context) {
+	inspector, ok := queueInspector(c)
+	if !ok {
+		return
+	}
+
+	var errs fieldErrors
+	stateMachineID := c.Param("stateMachineId")
+	page := queryInt(c, &errs, "page", 1, 1, 1<<20)
+	pageSize := queryInt(c, &errs, "pageSize", 100, 1, 1000)
+	if errs.respond(c) {
+		return
+	}
+
+	response := models.ListScheduledExecutionsResponse{
+		Executions: []*models.ScheduledExecutionResponse{},
+		Page:       page,
+		PageSize:   pageSize,
+	}
+	executions, err := scheduledExecutions(inspector, scheduledQueue(c), stateMachineID)
+	if err != nil {
+		respondError(c, http.StatusServiceUnavailable, models.CodeQueueUnavailable, "Failed to list scheduled executions", err.Error())
+		return
+	}
+
+	response.Total = len(executions)
+	if start := (page - 1) * pageSize; start < len(executions) {
+		response.Executions = executions[start:min(start+pageSize, len(executions))]
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// scheduledExecutions returns the scheduled executions of a state machine in a queue.
+// Other state machines may share the queue, so every scheduled task of the queue is read.
+func scheduledExecutions(inspector *asynq.Inspector, queueName, stateMachineID string) ([]*models.ScheduledExecutionResponse, error) {
+	const pageSize = 1000
+	executions := []*models.ScheduledExecutionResponse{}
+	for page := 1; ; page++ {
+		tasks, err := inspector.ListScheduledTasks(queueName, asynq.Page(page), asynq.PageSize(pageSize))
+		if errors.Is(err, asynq.ErrQueueNotFound) {
+			return executions, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, task := range tasks {
+			if execution, ok := scheduledExecutionResponse(task); ok && execution.StateMachineID == stateMachineID {
+				executions = append(executions, execution)
+			}
+		}
+		if len(tasks) < pageSize {
+			return executions, nil
+		}
+	}
+}
+
+// GetScheduledExecution returns a delayed execution that has not started
+func GetScheduledExecution(c *gin.Context) {
+	inspector, ok := queueInspector(c)
+	if !ok {
+		return
+	}
+	info, ok := scheduledTask(c, inspector)
+	if !ok {
+		return
+	}
+	execution, _ := scheduledExecutionResponse(info)
+	c.JSON(http.StatusOK, execution)
+}
+
+// CancelScheduledExecution removes a delayed execution from the queue before it starts
+func CancelScheduledExecution(c *gin.Context) {
+	inspector, ok := queueInspector(c)
+	if !ok {
+		return
+	}
+	info, ok := scheduledTask(c, inspector)
+	if !ok {
+		return
+	}
+	if err := inspector.DeleteTask(info.Queue, info.ID); err != nil {
+		respondError(c, http.StatusServiceUnavailable, models.CodeQueueUnavailable, "Failed to cancel scheduled execution", err.Error())
+		return
+	}
+	execution, _ := scheduledExecutionResponse(info)
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Message: "Scheduled execution cancelled",
+		Data:    gin.H{"executionName": execution.ExecutionName, "stateMachineId": execution.StateMachineID, "queue": info.Queue},
+	})
+}
+
+// RescheduleExecution moves the start of a delayed execution. A start time that has
+// passed, or a delay of zero, starts it now.
+func RescheduleExecution(c *gin.Context) {
+	inspector, ok := queueInspector(c)
+	if !ok {
+		return
+	}
+	queueClient, ok := middleware.GetQueueClient(c)
+	if !ok || queueClient == nil {
+		respondNotConfigured(c, models.CodeQueueNotConfigured, "Queue client not configured")
+		return
+	}
+
+	var req models.RescheduleExecutionRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	var errs fieldErrors
+	var startAt time.Time
+	switch {
+	case req.StartAt != nil && req.DelaySeconds != nil:
+		errs.add("startAt", "provide either startAt or delaySeconds, not both")
+	case req.StartAt != nil:
+		startAt = req.StartAt.UTC()
+	case req.DelaySeconds != nil:
+		startAt = time.Now().Add(time.Duration(*req.DelaySeconds) * time.Second).UTC()
+	default:
+		errs.add("startAt", "provide startAt or delaySeconds")
+	}
+	if time.Until(startAt) > maxStartDelay {
+		errs.add("startAt", "must be within %d days", int(maxStartDelay.Hours()/24))
+	}
+	if errs.respond(c) {
+		return
+	}
+
+	info, ok := scheduledTask(c, inspector)
+	if !ok {
+		return
+	}
+
+	// An execution due now is moved to the pending tasks of its queue
+	if !startAt.After(time.Now()) {
+		if err := inspector.RunTask(info.Queue, info.ID); err != nil {
+			respondError(c, http.StatusServiceUnavailable, models.CodeQueueUnavailable, "Failed to start scheduled execution", err.Error())
+			return
+		}
+		info.State = asynq.TaskStatePending
+		info.NextProcessAt = time.Now()
+		execution, _ := scheduledExecutionResponse(info)
+		c.JSON(http.StatusOK, execution)
+		return
+	}
+
+	// A task keeps its processing time, so it is queued again under the same ID and with
+	// the same options
+	var payload queue.ExecutionTaskPayload
+	if err := json.Unmarshal(info.Payload, &payload); err != nil {
+		respondError(c, http.StatusInternalServerError, models.CodeInternalError, "Invalid scheduled execution", err.Error())
+		return
+	}
+	if err := inspector.DeleteTask(info.Queue, info.ID); err != nil {
+		respondError(c, http.StatusServiceUnavailable, models.CodeQueueUnavailable, "Failed to reschedule execution", err.Error())
+		return
+	}
+	rescheduled, err := queueClient.EnqueueExecution(&payload, rescheduleOptions(info, &payload, startAt)...)
+	if err != nil {
+		respondError(c, http.StatusServiceUnavailable, models.CodeQueueUnavailable, "Failed to reschedule execution",
+			fmt.Sprintf("The execution was removed from the queue and could not be queued again: %v", err))
+		return
+	}
+	execution, _ := scheduledExecutionResponse(rescheduled)
+	c.JSON(http.StatusOK, execution)
+}
+
+// rescheduleOptions are the options a scheduled task is queued again with to start at
+// startAt
+func rescheduleOptions(info *asynq.TaskInfo, payload *queue.ExecutionTaskPayload, startAt time.Time) []asynq.Option {
+	opts := []asynq.Option{asynq.Queue(info.Queue), asynq.TaskID(info.ID), asynq.ProcessAt(startAt), asynq.MaxRetry(info.MaxRetry)}
+	if info.Timeout > 0 {
+		opts = append(opts, asynq.Timeout(info.Timeout))
+	}
+	if !info.Deadline.IsZero() {
+		opts = append(opts, asynq.Deadline(info.Deadline))
+	}
+	if info.Retention > 0 {
+		opts = append(opts, asynq.Retention(info.Retention))
+	}
+	if seconds, ok := payload.Options[uniqueForOption].(float64); ok && seconds > 0 {
+		opts = append(opts, asynq.Unique(time.Duration(seconds)*time.Second))
+	}
+	return opts
+}
@@ -2,15 +2,14 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
 	"github.com/hussainpithawala/state-machine-amz-gin/models"
-	"github.com/hussainpithawala/state-machine-amz-go/pkg/executor"
-	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
 	"github.com/hussainpithawala/state-machine-amz-go/pkg/statemachine"
 	"github.com/hussainpithawala/state-machine-amz-go/pkg/statemachine/persistent"
 	"github.com/hussainpithawala/state-machine-amz-go/pkg/types"
@@ -22,46 +21,53 @@ func StartExecution(c *gin.Context) {
 	baseExecutor, ok := middleware.GetBaseExecutor(c)
 
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Repository manager not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 
 	queueClient, ok := middleware.GetQueueClient(c)
 	if !ok || queueClient == nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Queue client not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeQueueNotConfigured, "Queue client not configured")
 		return
 	}
 
 	stateMachineID := c.Param("stateMachineId")
 
 	var req models.StartExecutionRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Invalid request",
-			Message: err.Error(),
-			Code:    http.StatusBadRequest,
-		})
+	if !bindJSON(c, &req) {
 		return
 	}
 
+	var errs fieldErrors
+	validateTags(&errs, "tags", req.Tags)
+	startAt := delayedStart(&errs, req.StartAt, req.DelaySeconds)
+	if errs.respond(c) {
+		return
+	}
+
+	// Executions started with tags are saved through a manager that stores them
+	ctx, taggedManager := taggedRepositoryManager(c.Request.Context(), repoManager, req.Tags)
+
 	// Load state machine to validate it exists
-	sm, err := persistent.NewFromDefnId(c.Request.Context(), stateMachineID, repoManager)
+	sm, err := persistent.NewFromDefnId(ctx, stateMachineID, taggedManager)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error:   "State machine not found",
-			Message: err.Error(),
-			Code:    http.StatusNotFound,
-		})
+		respondStateMachineLoadError(c, err, "State machine not found")
 		return
 	}
 
-	ctx := context.WithValue(c.Request.Context(), types.ExecutionContextKey, executor.NewExecutionContextAdapter(baseExecutor))
+	// Validate the input before the execution record is created. Inputs derived
+	// from a source execution are only known once the execution starts.
+	if req.Input != nil || req.SourceExecutionID == "" {
+		schemas, ok := executionSchemasFor(c, repoManager, stateMachineID)
+		if !ok {
+			return
+		}
+		if schemas.validateInput("input", req.Input).respond(c) {
+			return
+		}
+	}
+
+	ctx = context.WithValue(ctx, types.ExecutionContextKey, executionContext(c, baseExecutor))
 
 	// Build execution options
 	var execOpts []statemachine.ExecutionOption
@@ -71,17 +77,45 @@ func StartExecution(c *gin.Context) {
 	if sourceInputTransformer != "" {
 		transformerRegistry, ok := middleware.GetTransformerRegistry(c)
 		if !ok {
-			c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-				Error:   "Failed to get transformer function from registry",
-				Message: "Failed to get transformer function registry",
-				Code:    http.StatusInternalServerError,
-			})
+			respondNotConfigured(c, models.CodeTransformerNotConfigured, "Failed to get transformer function from registry")
 			return
 		}
 		transformerFunc := transformerRegistry[sourceInputTransformer]
 		execOpts = append(execOpts, statemachine.WithInputTransformer(transformerFunc))
 	}
 
+	// A delayed execution is queued and started by a worker at its start time
+	if !startAt.IsZero() {
+		payload := &queue.ExecutionTaskPayload{
+			StateMachineID:       stateMachineID,
+			ExecutionName:        req.Name,
+			Input:                req.Input,
+			SourceExecutionID:    req.SourceExecutionID,
+			SourceStateName:      req.SourceStateName,
+			InputTransformerName: sourceInputTransformer,
+		}
+		if len(req.Tags) > 0 {
+			payload.Options = map[string]interface{}{middleware.ExecutionTagsOption: req.Tags}
+		}
+		info, err := queueClient.EnqueueExecution(payload, executionTaskOptions(payload, taskOptions(c, stateMachineID, startAt))...)
+		if err != nil {
+			respondEnqueueError(c, err, "Failed to schedule execution")
+			return
+		}
+		scheduledAt := info.NextProcessAt.UTC()
+		c.JSON(http.StatusAccepted, models.StartExecutionResponse{
+			StateMachineID: stateMachineID,
+			Name:           req.Name,
+			Status:         StatusScheduled,
+			StartTime:      scheduledAt,
+			Input:          req.Input,
+			ScheduledAt:    &scheduledAt,
+			TaskID:         info.ID,
+			Queue:          info.Queue,
+		})
+		return
+	}
+
 	if req.Input != nil {
 		exec, err := sm.Execute(
 			ctx,
@@ -89,11 +123,7 @@ func StartExecution(c *gin.Context) {
 			statemachine.WithExecutionName(req.Name),
 		)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-				Error:   "Failed to execute state machine",
-				Message: err.Error(),
-				Code:    http.StatusInternalServerError,
-			})
+			respondError(c, http.StatusInternalServerError, models.CodeExecutionFailed, "Failed to execute state machine", err.Error())
 			return
 		}
 
@@ -117,11 +147,7 @@ func StartExecution(c *gin.Context) {
 		fmt.Printf("executing from source-execution-id %s\n", req.SourceExecutionID)
 		executionResult, err := sm.Execute(ctx, req.Input, execOpts...)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-				Error:   "Failed to execute using source execution",
-				Message: err.Error(),
-				Code:    http.StatusInternalServerError,
-			})
+			respondError(c, http.StatusInternalServerError, models.CodeExecutionFailed, "Failed to execute using source execution", err.Error())
 			return
 		}
 
@@ -140,21 +166,14 @@ func StartExecution(c *gin.Context) {
 func GetExecution(c *gin.Context) {
 	repoManager, ok := middleware.GetRepositoryManager(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Repository manager not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 
 	executionID := c.Param("executionId")
 	record, err := repoManager.GetExecution(c.Request.Context(), executionID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error:   "Execution not found",
-			Message: err.Error(),
-			Code:    http.StatusNotFound,
-		})
+		respondLookupError(c, err, models.CodeExecutionNotFound, "Execution not found")
 		return
 	}
 
@@ -170,77 +189,62 @@ func GetExecution(c *gin.Context) {
 		EndTime:        record.EndTime,
 		Error:          record.Error,
 		Metadata:       record.Metadata,
+		Tags:           middleware.ExecutionTagsFrom(record.Metadata),
 	})
 }
 
-// ListExecutions lists executions for a state machine with filtering
+// ListExecutions lists executions for a state machine with filtering and cursor pagination
 func ListExecutions(c *gin.Context) {
+	listExecutions(c, c.Param("stateMachineId"))
+}
+
+// SearchExecutions lists executions across all state machines. The optional
+// stateMachineId query parameter narrows the search to one state machine.
+func SearchExecutions(c *gin.Context) {
+	listExecutions(c, c.Query("stateMachineId"))
+}
+
+func listExecutions(c *gin.Context, stateMachineID string) {
 	repoManager, ok := middleware.GetRepositoryManager(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Repository manager not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 
-	stateMachineID := c.Param("stateMachineId")
-
 	// Parse query parameters
-	status := c.Query("status")
-	limitStr := c.DefaultQuery("limit", "50")
-	offsetStr := c.DefaultQuery("offset", "0")
-
-	limit, _ := strconv.Atoi(limitStr)
-	offset, _ := strconv.Atoi(offsetStr)
-
-	filter := &repository.ExecutionFilter{
-		StateMachineID: stateMachineID,
-		Status:         status,
-		Limit:          limit,
-		Offset:         offset,
+	var errs fieldErrors
+	search := parseExecutionSearch(c, &errs, true)
+	if errs.respond(c) {
+		return
+	}
+	search.StateMachineID = stateMachineID
+	if !search.resolveAttributes(c, repoManager) {
+		return
 	}
 
 	// Get executions
-	records, err := repoManager.ListExecutions(c.Request.Context(), filter)
+	records, nextCursor, err := searchExecutions(c, repoManager, search)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to list executions",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondSearchError(c, "Failed to list executions", err)
 		return
 	}
 
-	// Get total count
-	total, err := repoManager.CountExecutions(c.Request.Context(), filter)
+	// Get total count. A count the in-memory fallback cannot finish would be too low.
+	total, err := countExecutions(c, repoManager, search)
+	if errors.Is(err, errScanLimitExceeded) {
+		respondSearchError(c, "Failed to count executions", err)
+		return
+	}
 	if err != nil {
 		total = int64(len(records))
 	}
 
-	// Convert to response
-	executions := make([]*models.ExecutionResponse, len(records))
-	for i, record := range records {
-		executions[i] = &models.ExecutionResponse{
-			ExecutionID:    record.ExecutionID,
-			StateMachineID: record.StateMachineID,
-			Name:           record.Name,
-			Status:         record.Status,
-			CurrentState:   record.CurrentState,
-			Input:          record.Input,
-			Output:         record.Output,
-			StartTime:      record.StartTime,
-			EndTime:        record.EndTime,
-			Error:          record.Error,
-			Metadata:       record.Metadata,
-		}
-	}
-
 	c.JSON(http.StatusOK, models.ListExecutionsResponse{
-		Executions: executions,
+		Executions: executionResponses(records),
 		Total:      total,
-		Limit:      limit,
-		Offset:     offset,
+		Limit:      search.Limit,
+		Offset:     search.Offset,
+		NextCursor: nextCursor,
 	})
 }
 
@@ -248,10 +252,7 @@ func ListExecutions(c *gin.Context) {
 func GetExecutionHistory(c *gin.Context) {
 	repoManager, ok := middleware.GetRepositoryManager(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Repository manager not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 
@@ -259,11 +260,7 @@ func GetExecutionHistory(c *gin.Context) {
 
 	records, err := repoManager.GetStateHistory(c.Request.Context(), executionID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to retrieve execution history",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondRepositoryError(c, "Failed to retrieve execution history", err)
 		return
 	}
 
@@ -294,28 +291,23 @@ func GetExecutionHistory(c *gin.Context) {
 func CountExecutions(c *gin.Context) {
 	repoManager, ok := middleware.GetRepositoryManager(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Repository manager not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 
-	stateMachineID := c.Param("stateMachineId")
-	status := c.Query("status")
-
-	filter := &repository.ExecutionFilter{
-		StateMachineID: stateMachineID,
-		Status:         status,
+	var errs fieldErrors
+	search := parseExecutionSearch(c, &errs, false)
+	if errs.respond(c) {
+		return
+	}
+	search.StateMachineID = c.Param("stateMachineId")
+	if !search.resolveAttributes(c, repoManager) {
+		return
 	}
 
-	count, err := repoManager.CountExecutions(c.Request.Context(), filter)
+	count, err := countExecutions(c, repoManager, search)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to count executions",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondSearchError(c, "Failed to count executions", err)
 		return
 	}
 
@@ -328,10 +320,7 @@ func CountExecutions(c *gin.Context) {
 func StopExecution(c *gin.Context) {
 	repoManager, ok := middleware.GetRepositoryManager(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Repository manager not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 
@@ -340,21 +329,13 @@ func StopExecution(c *gin.Context) {
 	// Get execution
 	record, err := repoManager.GetExecution(c.Request.Context(), executionID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error:   "Execution not found",
-			Message: err.Error(),
-			Code:    http.StatusNotFound,
-		})
+		respondLookupError(c, err, models.CodeExecutionNotFound, "Execution not found")
 		return
 	}
 
 	// Check if execution is already stopped
 	if record.Status == "SUCCEEDED" || record.Status == "FAILED" || record.Status == "CANCELLED" {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Execution already stopped",
-			Message: fmt.Sprintf("Execution is in %s state", record.Status),
-			Code:    http.StatusBadRequest,
-		})
+		respondError(c, http.StatusConflict, models.CodeExecutionNotRunning, "Execution already stopped", fmt.Sprintf("Execution is in %s state", record.Status))
 		return
 	}
 
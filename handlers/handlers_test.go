@@ -2,14 +2,39 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/gin-gonic/gin"
+	"github.com/hibiken/asynq"
+	"github.com/hussainpithawala/state-machine-amz-gin/activities"
+	"github.com/hussainpithawala/state-machine-amz-gin/datasets"
+	"github.com/hussainpithawala/state-machine-amz-gin/httptask"
+	"github.com/hussainpithawala/state-machine-amz-gin/inbox"
+	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
 	"github.com/hussainpithawala/state-machine-amz-gin/models"
+	"github.com/hussainpithawala/state-machine-amz-gin/recovery"
+	"github.com/hussainpithawala/state-machine-amz-gin/schedules"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/executor"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/statemachine"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/types"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
 )
 
 // Helper functions for tests
@@ -340,8 +365,23 @@ func TestGetOpenAPISpec_Success(t *testing.T) {
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
-	// Should return 200 if openapi.json exists in the project
 	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+	assert.True(t, json.Valid(w.Body.Bytes()))
+}
+
+func TestGetAPIDocs_Success(t *testing.T) {
+	router := setupTestRouter()
+	router.GET("/api/v1/docs", GetAPIDocs)
+
+	req := httptest.NewRequest("GET", "/api/v1/docs", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, w.Body.String(), `openapi.json`)
+	assert.Contains(t, w.Body.String(), "/api/v1/docs/assets/swagger-ui-bundle.js")
 }
 
 func TestListTransformers_NoRegistry(t *testing.T) {
@@ -359,3 +399,2934 @@ func TestListTransformers_NoRegistry(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Empty(t, response.Transformers)
 }
+
+func TestLivenessCheck(t *testing.T) {
+	router := setupTestRouter()
+	router.GET("/health/live", LivenessCheck)
+
+	req := httptest.NewRequest("GET", "/health/live", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp models.LivenessResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "alive", resp.Status)
+}
+
+func TestReadinessCheck_NoDependencies(t *testing.T) {
+	router := setupTestRouter()
+	router.GET("/health/ready", ReadinessCheck)
+
+	req := httptest.NewRequest("GET", "/health/ready", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp models.ReadinessResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "healthy", resp.Status)
+	for _, name := range []string{"database", "redis", "queue", "worker", "orchestrators"} {
+		assert.Equal(t, "not_configured", resp.Components[name].Status, name)
+	}
+}
+
+func TestReadinessCheck_WorkerNotRunning(t *testing.T) {
+	router := setupTestRouter()
+	router.Use(middleware.StateMachineMiddleware(&middleware.Config{
+		Worker: &middleware.Worker{},
+	}))
+	router.GET("/health/ready", ReadinessCheck)
+
+	req := httptest.NewRequest("GET", "/health/ready", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	var resp models.ReadinessResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "unhealthy", resp.Status)
+	assert.Equal(t, "down", resp.Components["worker"].Status)
+	assert.False(t, resp.Cached)
+
+	// A second probe within the cache window reuses the previous result
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/health/ready", nil))
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, resp.Cached)
+}
+
+func TestBindJSON_ReportsEveryFieldProblem(t *testing.T) {
+	router := setupTestRouter()
+	router.POST("/batch", func(c *gin.Context) {
+		var req models.ExecuteBatchRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+		c.JSON(http.StatusOK, req)
+	})
+
+	body := map[string]interface{}{
+		"concurrency":    -1,
+		"microBatchSize": -5,
+		"mode":           "parallel",
+		"unexpected":     true,
+		"filter": map[string]interface{}{
+			"limit":  "ten",
+			"states": "Done",
+		},
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest("POST", "/batch", body))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var response models.ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, []models.FieldError{
+		{Field: "filter.limit", Message: "must be an integer"},
+		{Field: "filter.states", Message: "must be an array"},
+		{Field: "unexpected", Message: "unknown field"},
+	}, response.Details)
+
+	delete(body, "unexpected")
+	delete(body, "filter")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest("POST", "/batch", body))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, []models.FieldError{
+		{Field: "concurrency", Message: "must be greater than or equal to 0"},
+		{Field: "mode", Message: "must be one of: distributed, concurrent, sequential"},
+		{Field: "microBatchSize", Message: "must be greater than or equal to 0"},
+	}, response.Details)
+}
+
+func TestBindJSON_ValidRequest(t *testing.T) {
+	router := setupTestRouter()
+	router.POST("/enqueue", func(c *gin.Context) {
+		var req models.EnqueueExecutionRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+		c.JSON(http.StatusOK, req)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest("POST", "/enqueue", map[string]interface{}{
+		"stateMachineId": "sm-1",
+		"executionName":  "exec-1",
+		"input":          map[string]interface{}{"orderId": 42},
+	}))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest("POST", "/enqueue", map[string]interface{}{"input": 1}))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"field":"executionName","message":"is required"`)
+}
+
+func TestQueryInt_RejectsInvalidPagination(t *testing.T) {
+	router := setupTestRouter()
+	router.GET("/executions", func(c *gin.Context) {
+		var errs fieldErrors
+		limit := queryInt(c, &errs, "limit", defaultListLimit, 1, maxListLimit)
+		offset := queryInt(c, &errs, "offset", 0, 0, 1000)
+		if errs.respond(c) {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"limit": limit, "offset": offset})
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/executions?limit=abc&offset=-1", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var response models.ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, []models.FieldError{
+		{Field: "limit", Message: "must be an integer"},
+		{Field: "offset", Message: "must be between 0 and 1000"},
+	}, response.Details)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/executions", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"limit":50,"offset":0}`, w.Body.String())
+}
+
+func TestExecutionSchemas_ValidateInput(t *testing.T) {
+	schemas, err := compileExecutionSchemas(map[string]interface{}{
+		"inputSchema": map[string]interface{}{
+			"type":     "object",
+			"required": []interface{}{"orderId"},
+			"properties": map[string]interface{}{
+				"orderId": map[string]interface{}{"type": "string"},
+				"items": map[string]interface{}{
+					"type":  "array",
+					"items": map[string]interface{}{"type": "integer"},
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	assert.Empty(t, schemas.validateInput("input", map[string]interface{}{"orderId": "o-1", "items": []interface{}{1.0, 2.0}}))
+	assert.Empty(t, schemas.validateOutput("output", "anything goes without an output schema"))
+
+	errs := schemas.validateInput("input", map[string]interface{}{"items": []interface{}{1.0, "two"}})
+	assert.Len(t, errs, 2)
+	fields := []string{errs[0].Field, errs[1].Field}
+	assert.ElementsMatch(t, []string{"input", "input.items[1]"}, fields)
+}
+
+func TestExecutionSchemas_InvalidSchema(t *testing.T) {
+	_, err := compileExecutionSchemas(map[string]interface{}{
+		"outputSchema": map[string]interface{}{"type": 12},
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid outputSchema")
+}
+
+func TestSplitBulkInputs_ReportsRejectedIndexes(t *testing.T) {
+	schemas, err := compileExecutionSchemas(map[string]interface{}{
+		"inputSchema": map[string]interface{}{"type": "object", "required": []interface{}{"id"}},
+	})
+	assert.NoError(t, err)
+
+	inputs := []interface{}{
+		map[string]interface{}{"id": 1},
+		map[string]interface{}{"name": "missing id"},
+		map[string]interface{}{"id": 3},
+		"not an object",
+	}
+	valid, rejected := splitBulkInputs(schemas, "inputs", inputs)
+
+	assert.Equal(t, []interface{}{inputs[0], inputs[2]}, valid)
+	assert.Len(t, rejected, 2)
+	assert.Equal(t, 1, rejected[0].Index)
+	assert.Equal(t, "inputs[1]", rejected[0].Errors[0].Field)
+	assert.Equal(t, 3, rejected[1].Index)
+	assert.Equal(t, "inputs[3]", rejected[1].Errors[0].Field)
+}
+
+// ==================== Problem Details Tests ====================
+
+func TestRespondError_ProblemDetails(t *testing.T) {
+	router := setupTestRouter()
+	router.Use(middleware.RequestID())
+	router.GET("/executions/:executionId", GetExecution)
+
+	req := httptest.NewRequest("GET", "/executions/test-exec", nil)
+	req.Header.Set(middleware.RequestIDHeader, "req-123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, models.ProblemContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, "req-123", w.Header().Get(middleware.RequestIDHeader))
+
+	var response models.ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, models.CodeRepositoryNotConfigured, response.Code)
+	assert.Equal(t, models.ProblemTypePrefix+models.CodeRepositoryNotConfigured, response.Type)
+	assert.Equal(t, http.StatusInternalServerError, response.Status)
+	assert.Equal(t, "/executions/test-exec", response.Instance)
+	assert.Equal(t, "req-123", response.RequestID)
+}
+
+func TestRespondLookupError_ClassifiesRepositoryErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"not found", errors.New("execution not found: exec-1"), http.StatusNotFound, models.CodeExecutionNotFound},
+		{"outage", errors.New("dial tcp 127.0.0.1:5432: connect: connection refused"), http.StatusServiceUnavailable, models.CodeRepositoryUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := setupTestRouter()
+			router.GET("/lookup", func(c *gin.Context) {
+				respondLookupError(c, tt.err, models.CodeExecutionNotFound, "Execution not found")
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/lookup", nil))
+
+			var response models.ErrorResponse
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.code, response.Code)
+		})
+	}
+}
+
+func TestRespondStateMachineLoadError_InvalidDefinition(t *testing.T) {
+	router := setupTestRouter()
+	router.GET("/load", func(c *gin.Context) {
+		respondStateMachineLoadError(c, errors.New("failed to create state machine: StartAt is required"), "State machine not found")
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/load", nil))
+
+	var response models.ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, models.CodeInvalidDefinition, response.Code)
+}
+
+func TestErrorHandler_HidesPanicValue(t *testing.T) {
+	router := setupTestRouter()
+	router.Use(middleware.RequestID(), middleware.ErrorHandler())
+	router.GET("/panic", func(c *gin.Context) {
+		panic("secret connection string")
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, models.ProblemContentType, w.Header().Get("Content-Type"))
+	assert.NotContains(t, w.Body.String(), "secret")
+
+	var response models.ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, models.CodeInternalError, response.Code)
+	assert.NotEmpty(t, response.RequestID)
+	assert.Equal(t, response.RequestID, w.Header().Get(middleware.RequestIDHeader))
+}
+
+// ==================== Execution Search Tests ====================
+
+func dryRunDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	assert.NoError(t, err)
+	return db
+}
+
+func TestParseExecutionSearch_Filters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/executions?status=FAILED,TIMED_OUT&status=ABORTED&namePrefix=order_&metadata=region:eu&order=asc&limit=10&startedAfter=2026-01-01T00:00:00Z", nil)
+
+	var errs fieldErrors
+	search := parseExecutionSearch(c, &errs, true)
+
+	assert.Empty(t, errs)
+	assert.Equal(t, []string{"FAILED", "TIMED_OUT", "ABORTED"}, search.Statuses)
+	assert.Equal(t, map[string]string{"region": "eu"}, search.Metadata)
+	assert.Equal(t, orderAsc, search.Order)
+	assert.Equal(t, 10, search.Limit)
+	assert.Equal(t, 2026, search.StartedAfter.Year())
+
+	_, ok := search.legacyFilter()
+	assert.False(t, ok)
+}
+
+func TestParseExecutionSearch_RejectsInvalidParameters(t *testing.T) {
+	router := setupTestRouter()
+	router.Use(func(c *gin.Context) {
+		c.Set("repositoryManager", repository.NewManagerWithRepository(nil))
+	})
+	router.GET("/executions", SearchExecutions)
+
+	cursor := encodeCursor(executionCursor{StartTime: time.Now(), ExecutionID: "exec-1", Order: orderDesc})
+	req := httptest.NewRequest("GET", "/executions?status=DONE&metadata=nocolon&startedAfter=yesterday&order=asc&cursor="+cursor, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var response models.ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	fields := make([]string, len(response.Details))
+	for i, detail := range response.Details {
+		fields[i] = detail.Field
+	}
+	assert.ElementsMatch(t, []string{"status", "metadata", "startedAfter", "cursor"}, fields)
+}
+
+func TestExecutionCursor_RoundTrip(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 123456000, time.UTC)
+	encoded := encodeCursor(executionCursor{StartTime: start, ExecutionID: "exec-42", Order: orderDesc})
+
+	cursor, err := decodeCursor(encoded)
+	assert.NoError(t, err)
+	assert.True(t, start.Equal(cursor.StartTime))
+	assert.Equal(t, "exec-42", cursor.ExecutionID)
+
+	_, err = decodeCursor("not-a-cursor")
+	assert.Error(t, err)
+}
+
+func TestExecutionSearch_BuildsKeysetQuery(t *testing.T) {
+	search := &executionSearch{
+		StateMachineID: "order-flow",
+		Statuses:       []string{"FAILED", "TIMED_OUT"},
+		NamePattern:    "order-*-2026",
+		ErrorContains:  "50%",
+		Metadata:       map[string]string{"region": "eu"},
+		Order:          orderDesc,
+		Limit:          25,
+		Cursor:         &executionCursor{StartTime: time.Now(), ExecutionID: "exec-9", Order: orderDesc},
+	}
+
+	var rows []repository.ExecutionModel
+	stmt := search.page(search.where(dryRunDB(t).Model(&repository.ExecutionModel{}))).Find(&rows).Statement
+	sql := stmt.SQL.String()
+
+	assert.Contains(t, sql, "state_machine_id = $1")
+	assert.Contains(t, sql, "status IN ($2,$3)")
+	assert.Contains(t, sql, "(start_time, execution_id) < ($")
+	assert.Contains(t, sql, "ORDER BY start_time DESC,execution_id DESC LIMIT $")
+	assert.Contains(t, stmt.Vars, "order-%-2026")
+	assert.Contains(t, stmt.Vars, `%50\%%`)
+	assert.Contains(t, stmt.Vars, 26)
+}
+
+// ==================== JSON Search Tests ====================
+
+func TestParseJSONPredicate(t *testing.T) {
+	tests := []struct {
+		predicate string
+		jsonPath  string
+		wantErr   bool
+	}{
+		{`$.orderId == "12345"`, `strict $."orderId" == "12345"`, false},
+		{`$.items[0]['unit price']>=9.5`, `strict $."items"[0]."unit price" >= 9.5`, false},
+		{`$.customer.vip != true`, `strict $."customer"."vip" != true`, false},
+		{`$.cancelledAt`, `strict $."cancelledAt"`, false},
+		{`orderId == "1"`, "", true},
+		{`$.total ~ 5`, "", true},
+		{`$.flag > true`, "", true},
+		{`$.items == [1]`, "", true},
+		{`$.items[x] == 1`, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.predicate, func(t *testing.T) {
+			predicate, err := parseJSONPredicate(tt.predicate)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.jsonPath, predicate.jsonPath())
+		})
+	}
+}
+
+func TestJSONPredicate_Match(t *testing.T) {
+	var input interface{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"orderId":"12345","total":99.5,"customer":{"vip":true},"items":[{"sku":"A-1"}],"note":null}`), &input))
+
+	tests := []struct {
+		predicate string
+		want      bool
+	}{
+		{`$.orderId == "12345"`, true},
+		{`$.orderId == 12345`, false},
+		{`$.total > 50`, true},
+		{`$.total <= 50`, false},
+		{`$.customer.vip == true`, true},
+		{`$.items[0].sku == "A-1"`, true},
+		{`$.items[1].sku == "A-1"`, false},
+		{`$.note == null`, true},
+		{`$.note`, true},
+		{`$.missing != "x"`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.predicate, func(t *testing.T) {
+			predicate, err := parseJSONPredicate(tt.predicate)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, predicate.match(input))
+		})
+	}
+}
+
+func TestExecutionSearch_PushesJSONFiltersDown(t *testing.T) {
+	inputPredicate, err := parseJSONPredicate(`$.orderId == "12345"`)
+	assert.NoError(t, err)
+	outputPredicate, err := parseJSONPredicate(`$.shipment`)
+	assert.NoError(t, err)
+	attribute, err := newSearchAttribute(models.SearchAttribute{Name: "customer", Path: "$.customer['id']"})
+	assert.NoError(t, err)
+
+	search := &executionSearch{
+		Input:      []*jsonPredicate{inputPredicate},
+		Output:     []*jsonPredicate{outputPredicate},
+		attributes: []attributeFilter{{attribute: attribute, value: "c-7"}},
+	}
+
+	var rows []repository.ExecutionModel
+	stmt := search.where(dryRunDB(t).Model(&repository.ExecutionModel{})).Find(&rows).Statement
+	sql := stmt.SQL.String()
+
+	assert.Contains(t, sql, "input @@ CAST($1 AS jsonpath)")
+	assert.Contains(t, sql, "jsonb_path_exists(output, CAST($2 AS jsonpath), '{}', true)")
+	assert.Contains(t, sql, `(input #>> '{"customer","id"}') = $3`)
+	assert.Equal(t, []interface{}{`strict $."orderId" == "12345"`, `strict $."shipment"`, "c-7"}, stmt.Vars)
+}
+
+func TestSearchAttribute_MatchesTextValue(t *testing.T) {
+	attribute, err := newSearchAttribute(models.SearchAttribute{Name: "order", Source: "output", Path: "$.order.id"})
+	assert.NoError(t, err)
+	assert.Regexp(t, `^idx_exec_attr_[0-9a-f]{16}$`, attribute.indexName())
+
+	record := &repository.ExecutionRecord{Output: map[string]interface{}{"order": map[string]interface{}{"id": float64(12345)}}}
+	assert.True(t, attributeFilter{attribute: attribute, value: "12345"}.match(record))
+	assert.False(t, attributeFilter{attribute: attribute, value: "1234"}.match(record))
+
+	_, err = newSearchAttribute(models.SearchAttribute{Name: "bad:name", Path: "$.x"})
+	assert.Error(t, err)
+	_, err = newSearchAttribute(models.SearchAttribute{Name: "root", Path: "$"})
+	assert.Error(t, err)
+}
+
+// ==================== Execution Tag Tests ====================
+
+func TestExecutionSearch_FiltersByTags(t *testing.T) {
+	search := &executionSearch{Tags: map[string]string{"customer": "acme", "region": "eu"}}
+
+	var rows []repository.ExecutionModel
+	stmt := search.where(dryRunDB(t).Model(&repository.ExecutionModel{})).Find(&rows).Statement
+	assert.Contains(t, stmt.SQL.String(), "metadata -> 'tags' ->> $1 = $2")
+	assert.Equal(t, []interface{}{"customer", "acme", "region", "eu"}, stmt.Vars)
+
+	tagged := &repository.ExecutionRecord{Metadata: map[string]interface{}{
+		"tags": map[string]interface{}{"customer": "acme", "region": "eu", "campaign": "spring"},
+	}}
+	assert.True(t, search.hasJSONFilters())
+	assert.True(t, search.matchJSON(tagged))
+	assert.False(t, search.matchJSON(&repository.ExecutionRecord{}))
+}
+
+func TestExecutionSearch_InMemoryScanLimit(t *testing.T) {
+	executions := make([]*repository.ExecutionRecord, maxScannedExecutions+1)
+	for i := range executions {
+		executions[i] = &repository.ExecutionRecord{
+			ExecutionID: fmt.Sprintf("id-%d", i),
+			Name:        fmt.Sprintf("order-%d", i),
+			Input:       map[string]interface{}{"amount": float64(i)},
+		}
+	}
+	repo := &listingRepository{executions: executions[:maxScannedExecutions]}
+	router := setupTestRouter()
+	router.Use(func(c *gin.Context) {
+		c.Set("repositoryManager", repository.NewManagerWithRepository(repo))
+	})
+	router.GET("/state-machines/:stateMachineId/executions", ListExecutions)
+	router.GET("/state-machines/:stateMachineId/executions/count", CountExecutions)
+	query := "?input=" + url.QueryEscape("$.amount >= 10")
+
+	// Up to the limit the count is exact
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/state-machines/orders/executions/count"+query, nil))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, fmt.Sprintf(`{"count": %d}`, maxScannedExecutions-10), w.Body.String())
+
+	// Beyond it the search is refused rather than answered with a partial result
+	repo.executions = executions
+	for _, path := range []string{"/state-machines/orders/executions/count", "/state-machines/orders/executions"} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path+query, nil))
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code, path)
+		assert.Contains(t, w.Body.String(), models.CodeSearchScanLimitExceeded)
+	}
+}
+
+func TestUpdateExecutionTags_RejectsInvalidTags(t *testing.T) {
+	router := setupTestRouter()
+	router.Use(func(c *gin.Context) {
+		c.Set("repositoryManager", repository.NewManagerWithRepository(nil))
+	})
+	router.PATCH("/executions/:executionId/tags", UpdateExecutionTags)
+
+	req := createRequest("PATCH", "/executions/exec-1/tags", map[string]interface{}{
+		"set":    map[string]string{"customer:id": "acme", "region": "eu"},
+		"remove": []string{"region", ""},
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var response models.ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	fields := make([]string, len(response.Details))
+	for i, detail := range response.Details {
+		fields[i] = detail.Field
+	}
+	assert.ElementsMatch(t, []string{"set.customer:id", "remove", "remove"}, fields)
+}
+
+// recordingRepository captures the executions saved through it and serves state machines
+type recordingRepository struct {
+	repository.Repository
+	saved         []*repository.ExecutionRecord
+	stateMachines map[string]*repository.StateMachineRecord
+}
+
+func (r *recordingRepository) GetStateMachine(_ context.Context, stateMachineID string) (*repository.StateMachineRecord, error) {
+	if record, ok := r.stateMachines[stateMachineID]; ok {
+		return record, nil
+	}
+	return nil, errors.New("state machine not found")
+}
+
+func (r *recordingRepository) SaveExecution(_ context.Context, record *repository.ExecutionRecord) error {
+	r.saved = append(r.saved, record)
+	return nil
+}
+
+func (r *recordingRepository) GetExecution(_ context.Context, executionID string) (*repository.ExecutionRecord, error) {
+	for i := len(r.saved) - 1; i >= 0; i-- {
+		if r.saved[i].ExecutionID == executionID {
+			return r.saved[i], nil
+		}
+	}
+	return nil, errors.New("execution not found")
+}
+
+func (r *recordingRepository) SaveStateMachine(_ context.Context, record *repository.StateMachineRecord) error {
+	r.stateMachines[record.ID] = record
+	return nil
+}
+
+func TestTaggingRepositoryManager_SavesContextTags(t *testing.T) {
+	recorder := &recordingRepository{}
+	ctx, manager := taggedRepositoryManager(context.Background(), repository.NewManagerWithRepository(recorder), map[string]string{"customer": "acme"})
+
+	record := &repository.ExecutionRecord{ExecutionID: "exec-1", Metadata: map[string]interface{}{"source": "api"}}
+	assert.NoError(t, manager.GetRepository().SaveExecution(ctx, record))
+	assert.NoError(t, manager.GetRepository().SaveExecution(context.Background(), &repository.ExecutionRecord{ExecutionID: "exec-2"}))
+
+	assert.Len(t, recorder.saved, 2)
+	assert.Equal(t, "api", recorder.saved[0].Metadata["source"])
+	assert.Equal(t, map[string]string{"customer": "acme"}, middleware.ExecutionTagsFrom(recorder.saved[0].Metadata))
+	assert.Nil(t, recorder.saved[1].Metadata)
+
+	// Wrapping twice keeps a single tagging layer
+	assert.Same(t, manager, middleware.NewTaggingRepositoryManager(manager))
+}
+
+// ==================== Streaming Bulk Tests ====================
+
+// recordingQueue captures enqueued tasks and fails the execution names in fail
+type recordingQueue struct {
+	enqueued []*queue.ExecutionTaskPayload
+	timeouts []*queue.TimeoutTaskPayload
+	fail     map[string]bool
+}
+
+func (q *recordingQueue) ScheduleTimeout(payload *queue.TimeoutTaskPayload, _ time.Duration) (*asynq.TaskInfo, error) {
+	q.timeouts = append(q.timeouts, payload)
+	return &asynq.TaskInfo{ID: "timeout-" + payload.CorrelationID}, nil
+}
+
+func (q *recordingQueue) EnqueueExecution(payload *queue.ExecutionTaskPayload, _ ...asynq.Option) (*asynq.TaskInfo, error) {
+	if q.fail[payload.ExecutionName] {
+		return nil, asynq.ErrTaskIDConflict
+	}
+	q.enqueued = append(q.enqueued, payload)
+	return &asynq.TaskInfo{ID: payload.ExecutionName}, nil
+}
+
+func (q *recordingQueue) EnqueueExecutionGroup(payloads []*queue.ExecutionTaskPayload, _ string, _ ...asynq.Option) ([]*asynq.TaskInfo, error) {
+	infos := make([]*asynq.TaskInfo, 0, len(payloads))
+	for _, payload := range payloads {
+		info, err := q.EnqueueExecution(payload)
+		if err != nil {
+			return infos, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func bulkStreamRouter(q *recordingQueue) *gin.Engine {
+	repo := &recordingRepository{stateMachines: map[string]*repository.StateMachineRecord{
+		"orders": {ID: "orders", Metadata: map[string]interface{}{
+			"inputSchema": map[string]interface{}{"type": "object", "required": []interface{}{"orderId"}},
+		}},
+	}}
+	manager := repository.NewManagerWithRepository(repo)
+
+	router := setupTestRouter()
+	router.POST("/state-machines/:stateMachineId/executions/bulk-stream", func(c *gin.Context) {
+		streamBulkUpload(c, manager, q)
+	})
+	return router
+}
+
+func decodeBulkEvents(t *testing.T, body []byte) []models.BulkStreamEvent {
+	var events []models.BulkStreamEvent
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	for decoder.More() {
+		var event models.BulkStreamEvent
+		assert.NoError(t, decoder.Decode(&event))
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestExecuteBulkStream_NDJSON(t *testing.T) {
+	q := &recordingQueue{fail: map[string]bool{"orders-run-2": true}}
+	router := bulkStreamRouter(q)
+
+	body := strings.Join([]string{
+		`{"orderId": "A-1"}`,
+		``,
+		`{"orderId": `,
+		`{"sku": "no-order"}`,
+		`{"orderId": "A-2"}`,
+		`{"orderId": "A-3"}`,
+	}, "\n")
+	req := httptest.NewRequest("POST", "/state-machines/orders/executions/bulk-stream?namePrefix=orders-run&chunkSize=2&tag=customer:acme", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+	events := decodeBulkEvents(t, w.Body.Bytes())
+	if !assert.Len(t, events, 4) {
+		return
+	}
+	assert.Equal(t, models.BulkStreamEventError, events[0].Type)
+	assert.Equal(t, 3, events[0].Line)
+	assert.Contains(t, events[0].Error, "invalid JSON")
+	assert.Equal(t, 4, events[1].Line)
+	assert.Equal(t, "input", events[1].Errors[0].Field)
+	assert.Equal(t, 6, events[2].Line)
+	assert.Equal(t, 1, events[2].Failed)
+
+	summary := events[3]
+	assert.Equal(t, models.BulkStreamEventSummary, summary.Type)
+	assert.True(t, summary.Completed)
+	assert.Equal(t, "orders-run", summary.BatchID)
+	assert.Equal(t, 6, summary.LinesRead)
+	assert.Equal(t, 2, summary.Enqueued)
+	assert.Equal(t, 2, summary.Rejected)
+	assert.Equal(t, 1, summary.Failed)
+
+	if assert.Len(t, q.enqueued, 2) {
+		assert.Equal(t, "orders-run-0", q.enqueued[0].ExecutionName)
+		assert.Equal(t, "orders-run-1", q.enqueued[1].ExecutionName)
+		assert.Equal(t, map[string]string{"customer": "acme"}, q.enqueued[0].Options[middleware.ExecutionTagsOption])
+	}
+}
+
+func TestExecuteBulkStream_CSVMultipart(t *testing.T) {
+	q := &recordingQueue{}
+	router := bulkStreamRouter(q)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("inputs", "orders.csv")
+	assert.NoError(t, err)
+	_, _ = part.Write([]byte("Order ID,amount,express,customer.id\nA-1,10.5,true,c-1\nA-2,ten,false,c-2\n"))
+	assert.NoError(t, writer.Close())
+
+	req := httptest.NewRequest("POST", "/state-machines/orders/executions/bulk-stream?map=Order%20ID:orderId&type=amount:number&type=express:boolean", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	events := decodeBulkEvents(t, w.Body.Bytes())
+	if !assert.Len(t, events, 2) {
+		return
+	}
+	assert.Equal(t, 3, events[0].Line)
+	assert.Contains(t, events[0].Error, `"ten" is not a number`)
+	assert.Equal(t, 1, events[1].Enqueued)
+
+	if assert.Len(t, q.enqueued, 1) {
+		assert.Equal(t, map[string]interface{}{
+			"orderId":  "A-1",
+			"amount":   10.5,
+			"express":  true,
+			"customer": map[string]interface{}{"id": "c-1"},
+		}, q.enqueued[0].Input)
+	}
+}
+
+func TestExecuteBulkStream_RejectsInvalidOptions(t *testing.T) {
+	router := bulkStreamRouter(&recordingQueue{})
+
+	req := httptest.NewRequest("POST", "/state-machines/orders/executions/bulk-stream?chunkSize=0&type=amount:decimal", strings.NewReader("a,b\n"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// The format cannot be detected from a plain text upload
+	req = httptest.NewRequest("POST", "/state-machines/orders/executions/bulk-stream", strings.NewReader("a,b\n"))
+	req.Header.Set("Content-Type", "text/plain")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Mapped headers must exist
+	req = httptest.NewRequest("POST", "/state-machines/orders/executions/bulk-stream?format=csv&map=Missing:orderId", strings.NewReader("a,b\n"))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// ==================== Dataset Tests ====================
+
+func datasetRouter(t *testing.T) (*gin.Engine, *datasets.Store) {
+	blobs, err := datasets.NewLocalStore(t.TempDir())
+	assert.NoError(t, err)
+	store := datasets.NewStore(blobs)
+
+	router := setupTestRouter()
+	router.Use(middleware.StateMachineMiddleware(&middleware.Config{DatasetStore: store}))
+	router.POST("/datasets", CreateDataset)
+	router.GET("/datasets", ListDatasets)
+	router.GET("/datasets/:datasetId", GetDataset)
+	router.DELETE("/datasets/:datasetId", DeleteDataset)
+	router.PUT("/datasets/:datasetId/chunks/:index", UploadDatasetChunk)
+	router.POST("/datasets/:datasetId/complete", CompleteDataset)
+	router.GET("/datasets/:datasetId/preview", PreviewDataset)
+	return router, store
+}
+
+func createDataset(t *testing.T, router *gin.Engine, body map[string]interface{}) models.DatasetResponse {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/datasets", body))
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var dataset models.DatasetResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &dataset))
+	return dataset
+}
+
+func uploadChunk(router *gin.Engine, datasetID string, index int, content, checksum string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/datasets/%s/chunks/%d", datasetID, index), strings.NewReader(content))
+	if checksum != "" {
+		req.Header.Set("X-Checksum-SHA256", checksum)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestDataset_ChunkedCSVUpload(t *testing.T) {
+	router, _ := datasetRouter(t)
+	dataset := createDataset(t, router, map[string]interface{}{
+		"name":    "orders",
+		"format":  "csv",
+		"mapping": map[string]string{"id": "order.id"},
+		"types":   map[string]string{"order.id": "integer"},
+	})
+	assert.Equal(t, datasets.StatusUploading, dataset.Status)
+
+	// Chunks may arrive out of order and split a record
+	w := uploadChunk(router, dataset.ID, 1, "lice\n2,bob\n3,carol\n", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = uploadChunk(router, dataset.ID, 0, "id,name\n1,a", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodGet, "/datasets/"+dataset.ID, nil))
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &dataset))
+	assert.Equal(t, []models.DatasetChunk{{Index: 0, Size: 11}, {Index: 1, Size: 19}}, dataset.UploadedChunks)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/datasets/"+dataset.ID+"/complete", map[string]int{"chunks": 2}))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var completed models.DatasetResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &completed))
+	assert.Equal(t, datasets.StatusReady, completed.Status)
+	assert.Equal(t, 3, completed.RowCount)
+	assert.Equal(t, 2, completed.Chunks)
+	assert.Equal(t, int64(30), completed.Size)
+	assert.Empty(t, completed.UploadedChunks)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodGet, "/datasets/"+dataset.ID+"/preview?limit=2&selector=$.order", nil))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var preview models.DatasetPreviewResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &preview))
+	assert.Equal(t, 3, preview.RowCount)
+	assert.Equal(t, []models.DatasetRow{
+		{Index: 0, Line: 2, Input: map[string]interface{}{"id": float64(1)}},
+		{Index: 1, Line: 3, Input: map[string]interface{}{"id": float64(2)}},
+	}, preview.Rows)
+
+	// A ready dataset no longer accepts chunks
+	w = uploadChunk(router, dataset.ID, 2, "4,dave\n", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), models.CodeDatasetCompleted)
+}
+
+func TestDataset_RejectsIncompleteUploads(t *testing.T) {
+	router, _ := datasetRouter(t)
+	dataset := createDataset(t, router, map[string]interface{}{"format": "ndjson"})
+
+	w := uploadChunk(router, dataset.ID, 0, "{\"orderId\": 1}\n", strings.Repeat("0", 64))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "checksum mismatch")
+
+	w = uploadChunk(router, dataset.ID, 0, "{\"orderId\": 1}\n{oops}\n", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = uploadChunk(router, dataset.ID, 2, "{\"orderId\": 3}\n", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/datasets/"+dataset.ID+"/complete", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "chunk 1 is missing")
+
+	w = uploadChunk(router, dataset.ID, 1, "{\"orderId\": 2}\n", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/datasets/"+dataset.ID+"/complete", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var problem models.ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Len(t, problem.Details, 1)
+	assert.Equal(t, "rows[1]", problem.Details[0].Field)
+	assert.Contains(t, problem.Details[0].Message, "line 2: invalid JSON")
+
+	// The dataset stays open, so the broken chunk can be replaced
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodGet, "/datasets/"+dataset.ID+"/preview", nil))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), models.CodeDatasetNotReady)
+}
+
+func TestDatasetBulk_StreamsValidRowsIntoTheQueue(t *testing.T) {
+	router, store := datasetRouter(t)
+	ready := func(content string) string {
+		dataset := createDataset(t, router, map[string]interface{}{"format": "ndjson"})
+		assert.Equal(t, http.StatusOK, uploadChunk(router, dataset.ID, 0, content, "").Code)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, createRequest(http.MethodPost, "/datasets/"+dataset.ID+"/complete", nil))
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		return dataset.ID
+	}
+
+	manager := repository.NewManagerWithRepository(&recordingRepository{stateMachines: map[string]*repository.StateMachineRecord{
+		"orders": {ID: "orders", Metadata: map[string]interface{}{
+			"inputSchema": map[string]interface{}{"type": "object", "required": []interface{}{"orderId"}},
+		}},
+	}})
+	var q *recordingQueue
+	bulk := setupTestRouter()
+	bulk.Use(middleware.StateMachineMiddleware(&middleware.Config{DatasetStore: store}))
+	bulk.POST("/bulk/:datasetId", func(c *gin.Context) {
+		dataset, ok := openDatasetBulk(c, manager, "orders", c.Param("datasetId"), c.Query("selector"))
+		if !ok {
+			return
+		}
+		req := &models.ExecuteBulkRequest{NamePrefix: "orders-run", Tags: map[string]string{"customer": "acme"}}
+		executeDatasetBulk(c, dataset, q, "orders", "orders-run-1", req, time.Time{}, nil)
+	})
+	execute := func(path string) (*httptest.ResponseRecorder, models.BulkExecutionResponse) {
+		q = &recordingQueue{}
+		w := httptest.NewRecorder()
+		bulk.ServeHTTP(w, createRequest(http.MethodPost, path, nil))
+		var response models.BulkExecutionResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+
+	datasetID := ready("{\"payload\": {\"orderId\": 1}}\n{\"payload\": {}}\n{\"other\": 1}\n{\"payload\": {\"orderId\": 4}}\n")
+	w, response := execute("/bulk/" + datasetID + "?selector=$.payload")
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.Equal(t, "orders-run-1", response.BatchID)
+	assert.Equal(t, 2, response.TotalEnqueued)
+	assert.Equal(t, 2, response.TotalRejected)
+	if assert.Len(t, response.Rejected, 2) {
+		assert.Equal(t, 1, response.Rejected[0].Index)
+		assert.Equal(t, 2, response.Rejected[1].Index)
+		assert.Contains(t, response.Rejected[1].Errors[0].Message, "matched nothing")
+	}
+	if assert.Len(t, q.enqueued, 2) {
+		assert.Equal(t, "orders-run-0", q.enqueued[0].ExecutionName)
+		assert.Equal(t, map[string]interface{}{"orderId": float64(1)}, q.enqueued[0].Input)
+		assert.Equal(t, "orders-run-1", q.enqueued[1].ExecutionName)
+		assert.Equal(t, map[string]string{"customer": "acme"}, q.enqueued[1].Options[middleware.ExecutionTagsOption])
+	}
+
+	// No valid row is a validation failure
+	w, _ = execute("/bulk/" + datasetID + "?selector=$.missing")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "rows[0]")
+	assert.Empty(t, q.enqueued)
+
+	// Only the first rejected rows are reported; the rest are counted
+	content := strings.Repeat("{}\n", maxReportedRejections+50) + "{\"orderId\": 1}\n"
+	w, response = execute("/bulk/" + ready(content))
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.Equal(t, 1, response.TotalEnqueued)
+	assert.Equal(t, maxReportedRejections+50, response.TotalRejected)
+	assert.Len(t, response.Rejected, maxReportedRejections)
+}
+
+func TestListDatasets_DropsExpiredDatasets(t *testing.T) {
+	router, store := datasetRouter(t)
+	live := createDataset(t, router, map[string]interface{}{"format": "ndjson", "name": "live"})
+	expired := createDataset(t, router, map[string]interface{}{"format": "ndjson", "name": "expired"})
+
+	record, err := store.Get(context.Background(), expired.ID)
+	assert.NoError(t, err)
+	record.ExpiresAt = time.Now().Add(-time.Minute)
+	assert.NoError(t, store.Save(context.Background(), record))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodGet, "/datasets?status=UPLOADING", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list models.ListDatasetsResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, 1, list.Total)
+	assert.Equal(t, live.ID, list.Datasets[0].ID)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodGet, "/datasets/"+expired.ID, nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), models.CodeDatasetNotFound)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodDelete, "/datasets/"+live.ID, nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestCreateDataset_RejectsInvalidOptions(t *testing.T) {
+	router, _ := datasetRouter(t)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/datasets", map[string]interface{}{
+		"format":           "ndjson",
+		"types":            map[string]string{"amount": "decimal"},
+		"expiresInSeconds": 365 * 24 * 3600,
+	}))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var problem models.ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	fields := make([]string, len(problem.Details))
+	for i, detail := range problem.Details {
+		fields[i] = detail.Field
+	}
+	assert.ElementsMatch(t, []string{"mapping", "types.amount", "expiresInSeconds"}, fields)
+
+	notConfigured := setupTestRouter()
+	notConfigured.POST("/datasets", CreateDataset)
+	w = httptest.NewRecorder()
+	notConfigured.ServeHTTP(w, createRequest(http.MethodPost, "/datasets", map[string]interface{}{"format": "csv"}))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), models.CodeDatasetStoreNotConfigured)
+}
+
+// ==================== Batch Results Tests ====================
+
+// listingRepository serves executions with the substring name filter of the repositories
+type listingRepository struct {
+	repository.Repository
+	executions []*repository.ExecutionRecord
+	links      []*repository.LinkedExecutionRecord
+}
+
+func (r *listingRepository) ListLinkedExecutions(_ context.Context, filter *repository.LinkedExecutionFilter) ([]*repository.LinkedExecutionRecord, error) {
+	var matches []*repository.LinkedExecutionRecord
+	for _, link := range r.links {
+		if link.TargetExecutionID == filter.TargetExecutionID {
+			matches = append(matches, link)
+		}
+	}
+	return matches, nil
+}
+
+func (r *listingRepository) ListExecutions(_ context.Context, filter *repository.ExecutionFilter) ([]*repository.ExecutionRecord, error) {
+	var matches []*repository.ExecutionRecord
+	for _, record := range r.executions {
+		if strings.Contains(record.Name, filter.Name) && (filter.Status == "" || record.Status == filter.Status) {
+			matches = append(matches, record)
+		}
+	}
+	matches = matches[min(filter.Offset, len(matches)):]
+	return matches[:min(filter.Limit, len(matches))], nil
+}
+
+func resultsRouter() *gin.Engine {
+	failed := func(name, err string) *repository.ExecutionRecord {
+		return &repository.ExecutionRecord{ExecutionID: "id-" + name, Name: name, Status: "FAILED", Error: err}
+	}
+	succeeded := func(name string, output map[string]interface{}) *repository.ExecutionRecord {
+		return &repository.ExecutionRecord{ExecutionID: "id-" + name, Name: name, Status: "SUCCEEDED", Output: output}
+	}
+	manager := repository.NewManagerWithRepository(&listingRepository{executions: []*repository.ExecutionRecord{
+		succeeded("orders-10", map[string]interface{}{"total": 10.5, "invoice": map[string]interface{}{"id": "INV-10"}}),
+		failed("orders-2", "payment declined"),
+		succeeded("orders-0", map[string]interface{}{"total": 3.0}),
+		{ExecutionID: "id-running", Name: "orders-1", Status: "RUNNING"},
+		failed("orders-mb1-0", "not a child"),
+		failed("preorders-3", "not a child"),
+	}})
+
+	router := setupTestRouter()
+	router.GET("/batch/:batchId/results", func(c *gin.Context) {
+		streamBatchResults(c, manager, c.Param("batchId"), c.Param("batchId"))
+	})
+	return router
+}
+
+func TestBatchResults_NDJSONInInputOrder(t *testing.T) {
+	w := httptest.NewRecorder()
+	resultsRouter().ServeHTTP(w, createRequest(http.MethodGet, "/batch/orders/results?status=SUCCEEDED,FAILED&field=total:$.total&field=$.invoice.id", nil))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+	var results []models.ExecutionResult
+	decoder := json.NewDecoder(w.Body)
+	for decoder.More() {
+		var result models.ExecutionResult
+		assert.NoError(t, decoder.Decode(&result))
+		results = append(results, result)
+	}
+	if assert.Len(t, results, 3) {
+		assert.Equal(t, []int{0, 2, 10}, []int{results[0].Index, results[1].Index, results[2].Index})
+		assert.Equal(t, map[string]interface{}{"total": 3.0}, results[0].Fields)
+		assert.Equal(t, "payment declined", results[1].Error)
+		assert.Empty(t, results[1].Fields)
+		assert.Equal(t, map[string]interface{}{"total": 10.5, "$.invoice.id": "INV-10"}, results[2].Fields)
+	}
+}
+
+func TestBatchResults_CSVAndJSON(t *testing.T) {
+	router := resultsRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodGet, "/batch/orders/results?format=csv&field=invoice:$.invoice&status=SUCCEEDED", nil))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Header().Get("Content-Disposition"), `filename="orders-results.csv"`)
+	assert.Equal(t, "index,executionId,name,status,error,startTime,endTime,invoice\n"+
+		"0,id-orders-0,orders-0,SUCCEEDED,,,,\n"+
+		`10,id-orders-10,orders-10,SUCCEEDED,,,,"{""id"":""INV-10""}"`+"\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodGet, "/batch/orders/results?format=json&status=FAILED", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response models.ExecutionResultsResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "orders", response.BatchID)
+	assert.Equal(t, 1, response.Total)
+	assert.Equal(t, 2, response.Results[0].Index)
+
+	// An empty export is still a valid document
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodGet, "/batch/unknown/results?format=json", nil))
+	assert.JSONEq(t, `{"batchId":"unknown","results":[],"total":0}`, w.Body.String())
+}
+
+func TestBatchResults_RejectsInvalidParameters(t *testing.T) {
+	w := httptest.NewRecorder()
+	resultsRouter().ServeHTTP(w, createRequest(http.MethodGet, "/batch/orders/results?format=xml&status=DONE&field=total&field=status:$.status", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var problem models.ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	fields := make([]string, len(problem.Details))
+	for i, detail := range problem.Details {
+		fields[i] = detail.Field
+	}
+	assert.ElementsMatch(t, []string{"format", "status", "field", "field"}, fields)
+}
+
+func TestBatchResults_BuildsKeysetQuery(t *testing.T) {
+	results := &batchResults{prefix: "orders_2026", statuses: []string{"FAILED"}}
+
+	var rows []repository.ExecutionModel
+	stmt := results.page(dryRunDB(t), "orders_2026-499").Find(&rows).Statement
+	sql := stmt.SQL.String()
+
+	assert.Contains(t, sql, "name LIKE $1")
+	assert.Contains(t, sql, "status IN ($2)")
+	assert.Contains(t, sql, "(LENGTH(name), name) > ($3, $4)")
+	assert.Contains(t, sql, "ORDER BY LENGTH(name) ASC,name ASC LIMIT $5")
+	assert.NotContains(t, sql, "output")
+	assert.Equal(t, []interface{}{`orders\_2026-%`, "FAILED", 15, "orders_2026-499", resultsPageSize}, stmt.Vars)
+}
+
+// ==================== Retry Failed Tests ====================
+
+func retryContext() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/bulk/orders/retry-failed", nil)
+	return c
+}
+
+// runsRepository serves a state machine, the IDs of batch sources and the children of runs
+type runsRepository struct {
+	listingRepository
+	sources []string
+}
+
+func (r *runsRepository) GetStateMachine(_ context.Context, stateMachineID string) (*repository.StateMachineRecord, error) {
+	return &repository.StateMachineRecord{ID: stateMachineID, Definition: `{"StartAt":"Done","States":{"Done":{"Type":"Succeed"}}}`}, nil
+}
+
+func (r *runsRepository) ListExecutionIDs(_ context.Context, _ *repository.ExecutionFilter) ([]string, error) {
+	return r.sources, nil
+}
+
+// queueRouter serves the handlers over a queue in miniredis
+func queueRouter(t *testing.T, repo *runsRepository) (*gin.Engine, *asynq.Inspector, *miniredis.Miniredis) {
+	redisServer := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+	config := queue.DefaultConfig()
+	config.RedisClientOpt = &asynq.RedisClientOpt{Addr: redisServer.Addr()}
+	queueClient, err := queue.NewClient(config)
+	assert.NoError(t, err)
+	inspector := asynq.NewInspector(asynq.RedisClientOpt{Addr: redisServer.Addr()})
+	t.Cleanup(func() {
+		_ = queueClient.Close()
+		_ = inspector.Close()
+	})
+
+	router := setupTestRouter()
+	router.Use(middleware.StateMachineMiddleware(&middleware.Config{
+		RepositoryManager: repository.NewManagerWithRepository(repo),
+		RedisClient:       redisClient,
+		QueueClient:       queueClient,
+		QueueConfig:       config,
+		BaseExecutor:      executor.NewBaseExecutor(),
+	}))
+	return router, inspector, redisServer
+}
+
+// runsRouter starts batch and bulk runs on a queue in miniredis and serves their results
+func runsRouter(t *testing.T, repo *runsRepository) (*gin.Engine, *asynq.Inspector) {
+	router, inspector, _ := queueRouter(t, repo)
+	router.POST("/state-machines/:stateMachineId/batch", ExecuteBatch)
+	router.POST("/state-machines/:stateMachineId/bulk", ExecuteBulk)
+	router.GET("/batch/:batchId/results", GetBatchResults)
+	router.GET("/bulk/:orchestratorId/results", GetBulkResults)
+	router.POST("/batch/:batchId/retry-failed", RetryFailedBatch)
+	router.POST("/bulk/:orchestratorId/retry-failed", RetryFailedBulk)
+	return router, inspector
+}
+
+// queuedNames waits for n tasks on a queue and returns their execution names
+func queuedNames(t *testing.T, inspector *asynq.Inspector, queueName string, n int) []string {
+	var names []string
+	assert.Eventually(t, func() bool {
+		tasks, err := inspector.ListPendingTasks(queueName)
+		if err != nil || len(tasks) < n {
+			return false
+		}
+		names = names[:0]
+		for _, task := range tasks {
+			var payload queue.ExecutionTaskPayload
+			assert.NoError(t, json.Unmarshal(task.Payload, &payload))
+			names = append(names, payload.ExecutionName)
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	return names
+}
+
+func resultIndexes(t *testing.T, w *httptest.ResponseRecorder) []int {
+	var indexes []int
+	decoder := json.NewDecoder(w.Body)
+	for decoder.More() {
+		var result models.ExecutionResult
+		assert.NoError(t, decoder.Decode(&result))
+		indexes = append(indexes, result.Index)
+	}
+	return indexes
+}
+
+func TestBatchResults_ByReturnedRunID(t *testing.T) {
+	repo := &runsRepository{sources: []string{"src-0", "src-1"}}
+	repo.executions = []*repository.ExecutionRecord{
+		{ExecutionID: "b0", Name: "reprocess-0", Status: "SUCCEEDED"},
+		{ExecutionID: "b1", Name: "reprocess-1", Status: "FAILED"},
+		{ExecutionID: "u0", Name: "upload-0", Status: "SUCCEEDED"},
+	}
+	router, inspector := runsRouter(t, repo)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/state-machines/billing/batch", map[string]interface{}{
+		"namePrefix": "reprocess", "tags": map[string]string{"team": "billing"},
+	}))
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var batch models.BatchExecutionResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &batch))
+	assert.NotEqual(t, "reprocess", batch.BatchID)
+	assert.ElementsMatch(t, []string{"reprocess-0", "reprocess-1"}, queuedNames(t, inspector, "billing", 2))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodGet, "/batch/"+batch.BatchID+"/results", nil))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []int{0, 1}, resultIndexes(t, w))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/state-machines/orders/bulk", map[string]interface{}{
+		"namePrefix": "upload", "inputs": []interface{}{map[string]interface{}{"n": 0}},
+	}))
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var bulk models.BulkExecutionResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &bulk))
+	assert.Equal(t, []string{"upload-0"}, queuedNames(t, inspector, "orders", 1))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodGet, "/bulk/"+bulk.OrchestratorID+"/results?format=json", nil))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var export models.ExecutionResultsResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &export))
+	assert.Equal(t, bulk.OrchestratorID, export.BatchID)
+	if assert.Len(t, export.Results, 1) {
+		assert.Equal(t, "u0", export.Results[0].ExecutionID)
+	}
+
+	// Runs that were not recorded are exported by name prefix
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodGet, "/batch/reprocess/results", nil))
+	assert.Equal(t, []int{0, 1}, resultIndexes(t, w))
+}
+
+func TestRetryFailedBatch_ByReturnedRunID(t *testing.T) {
+	repo := &runsRepository{sources: []string{"src-0", "src-1", "src-2"}}
+	router, inspector := runsRouter(t, repo)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/state-machines/billing/batch", map[string]interface{}{
+		"namePrefix": "reprocess", "tags": map[string]string{"team": "billing"},
+	}))
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var batch models.BatchExecutionResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &batch))
+	queuedNames(t, inspector, "billing", 3)
+
+	repo.executions = []*repository.ExecutionRecord{
+		{ExecutionID: "b0", StateMachineID: "billing", Name: "reprocess-0", Status: "SUCCEEDED"},
+		{ExecutionID: "b1", StateMachineID: "billing", Name: "reprocess-1", Status: "FAILED"},
+		{ExecutionID: "b2", StateMachineID: "billing", Name: "reprocess-2", Status: "FAILED"},
+	}
+	repo.links = []*repository.LinkedExecutionRecord{
+		{SourceExecutionID: "src-1", SourceStateName: "Ship", TargetExecutionID: "b1"},
+		{SourceExecutionID: "src-2", SourceStateName: "Ship", TargetExecutionID: "b2"},
+	}
+
+	// The ID of a batch does not name a bulk
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/bulk/"+batch.BatchID+"/retry-failed", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), models.CodeNotFound)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/batch/"+batch.BatchID+"/retry-failed", nil))
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var retry models.RetryFailedResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &retry))
+	assert.Equal(t, batch.BatchID+"-retry1", retry.BatchID)
+	assert.Equal(t, batch.BatchID, retry.ParentID)
+	assert.Equal(t, 2, retry.Attempt)
+	assert.Equal(t, 2, retry.TotalRetried)
+
+	names := queuedNames(t, inspector, "billing", 5)
+	assert.Subset(t, names, []string{retry.BatchID + "-0", retry.BatchID + "-1"})
+}
+
+func TestPlanRetry_BulkReusesInputs(t *testing.T) {
+	manager := repository.NewManagerWithRepository(&listingRepository{executions: []*repository.ExecutionRecord{
+		{ExecutionID: "e7", StateMachineID: "sm-1", Name: "orders-7", Status: "FAILED", Input: map[string]interface{}{"id": 7}},
+		{ExecutionID: "e2", StateMachineID: "sm-1", Name: "orders-2", Status: "FAILED", Input: map[string]interface{}{"id": 2}},
+		{ExecutionID: "e3", StateMachineID: "sm-1", Name: "orders-3", Status: "SUCCEEDED", Input: map[string]interface{}{"id": 3}},
+		{ExecutionID: "r0", StateMachineID: "sm-1", Name: "orders-retry1-0", Status: "FAILED", Input: map[string]interface{}{"id": 2}},
+	}})
+
+	run := &batchRun{Kind: runKindBulk}
+	plan, err := planRetry(retryContext(), manager, "orders", run)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 7}, plan.indexes)
+	assert.Equal(t, []interface{}{map[string]interface{}{"id": 2}, map[string]interface{}{"id": 7}}, plan.inputs)
+	assert.Empty(t, plan.sources)
+	assert.Equal(t, "sm-1", run.StateMachineID)
+}
+
+func TestPlanRetry_BatchUsesSourceLinks(t *testing.T) {
+	manager := repository.NewManagerWithRepository(&listingRepository{
+		executions: []*repository.ExecutionRecord{
+			{ExecutionID: "e0", StateMachineID: "sm-2", Name: "orders-0", Status: "FAILED"},
+			{ExecutionID: "e1", StateMachineID: "sm-2", Name: "orders-1", Status: "FAILED"},
+		},
+		links: []*repository.LinkedExecutionRecord{
+			{SourceExecutionID: "src-0", SourceStateName: "Ship", TargetExecutionID: "e0"},
+		},
+	})
+
+	run := &batchRun{Kind: runKindBatch}
+	plan, err := planRetry(retryContext(), manager, "orders", run)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0}, plan.indexes)
+	assert.Equal(t, []string{"src-0"}, plan.sources)
+	assert.Equal(t, "Ship", run.SourceStateName)
+	if assert.Len(t, plan.skipped, 1) {
+		assert.Equal(t, models.SkippedRetry{Index: 1, ExecutionID: "e1", Reason: "execution is not linked to a source execution"}, plan.skipped[0])
+	}
+}
+
+func TestStartChainedBatch_EnqueuesChainedExecutions(t *testing.T) {
+	q := &recordingQueue{fail: map[string]bool{"orders-retry1-1": true}}
+	run := &batchRun{StateMachineID: "sm-2", SourceStateName: "Ship", Tags: map[string]string{"team": "billing"}}
+
+	failed := startChainedBatch(context.Background(), nil, q, "orders-retry1", run, []string{"src-0", "src-4", "src-9"}, nil)
+
+	assert.Equal(t, 1, failed)
+	if assert.Len(t, q.enqueued, 2) {
+		assert.Equal(t, "orders-retry1-0", q.enqueued[0].ExecutionName)
+		assert.Equal(t, "src-0", q.enqueued[0].SourceExecutionID)
+		assert.Equal(t, "Ship", q.enqueued[0].SourceStateName)
+		assert.Equal(t, map[string]interface{}{middleware.ExecutionTagsOption: run.Tags}, q.enqueued[0].Options)
+		assert.Equal(t, "orders-retry1-2", q.enqueued[1].ExecutionName)
+		assert.Equal(t, 2, q.enqueued[1].ExecutionIndex)
+	}
+
+	// With stopOnError the executions after a failure are not enqueued
+	q = &recordingQueue{fail: map[string]bool{"orders-retry2-0": true}}
+	run.StopOnError = true
+	assert.Equal(t, 3, startChainedBatch(context.Background(), nil, q, "orders-retry2", run, []string{"src-0", "src-4", "src-9"}, nil))
+	assert.Empty(t, q.enqueued)
+}
+
+func TestEnqueueBulk_TagsEveryChild(t *testing.T) {
+	q := &recordingQueue{fail: map[string]bool{"orders-1": true}}
+	tags := map[string]string{"customer": "acme"}
+
+	failed := enqueueBulk(q, "orders", "orders", []interface{}{"a", "b", "c"}, tags, false, nil)
+
+	assert.Equal(t, 1, failed)
+	if assert.Len(t, q.enqueued, 2) {
+		for i, payload := range q.enqueued {
+			assert.Equal(t, []string{"orders-0", "orders-2"}[i], payload.ExecutionName)
+			assert.Equal(t, map[string]interface{}{middleware.ExecutionTagsOption: tags}, payload.Options)
+		}
+	}
+
+	// Untagged bulks queue their children without options
+	q = &recordingQueue{}
+	assert.Zero(t, enqueueBulk(q, "orders", "orders", []interface{}{"a"}, nil, false, nil))
+	assert.Nil(t, q.enqueued[0].Options)
+}
+
+func TestRetryFailed_MissingConfiguration(t *testing.T) {
+	router := setupTestRouter()
+	router.POST("/batch/:batchId/retry-failed", RetryFailedBatch)
+	router.POST("/bulk/:orchestratorId/retry-failed", func(c *gin.Context) {
+		c.Set("repositoryManager", repository.NewManagerWithRepository(&listingRepository{}))
+		RetryFailedBulk(c)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/batch/orders/retry-failed", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), models.CodeRepositoryNotConfigured)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/bulk/orders/retry-failed", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), models.CodeRedisNotConfigured)
+}
+
+// ==================== Batch Dry Run Tests ====================
+
+// sourceRepository lists source executions and their outputs through the repository interface
+type sourceRepository struct {
+	repository.Repository
+	ids     []string
+	linked  map[string]bool
+	outputs map[string]interface{}
+}
+
+func (r *sourceRepository) ListExecutionIDs(_ context.Context, _ *repository.ExecutionFilter) ([]string, error) {
+	return r.ids, nil
+}
+
+func (r *sourceRepository) ListNonLinkedExecutions(_ context.Context, _ *repository.ExecutionFilter, _ *repository.LinkedExecutionFilter) ([]*repository.ExecutionRecord, error) {
+	var records []*repository.ExecutionRecord
+	for _, id := range r.ids {
+		if !r.linked[id] {
+			records = append(records, &repository.ExecutionRecord{ExecutionID: id})
+		}
+	}
+	return records, nil
+}
+
+func (r *sourceRepository) GetExecutionOutput(_ context.Context, executionID, _ string) (interface{}, error) {
+	output, ok := r.outputs[executionID]
+	if !ok {
+		return nil, errors.New("execution output not found")
+	}
+	return output, nil
+}
+
+func dryRunRouter(repo repository.Repository) *gin.Engine {
+	manager := repository.NewManagerWithRepository(repo)
+	registry := middleware.TransformerRegistry{
+		"wrap": func(input interface{}) (interface{}, error) {
+			return map[string]interface{}{"order": input}, nil
+		},
+	}
+
+	router := setupTestRouter()
+	router.POST("/batch/preview", func(c *gin.Context) {
+		c.Set("transformerRegistry", registry)
+		var req models.ExecuteBatchRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+		previewBatch(c, manager, &req, newBatchSelection(&req))
+	})
+	return router
+}
+
+func TestPreviewBatch_SamplesTransformedInputs(t *testing.T) {
+	router := dryRunRouter(&sourceRepository{
+		ids:     []string{"a", "b", "c", "d"},
+		linked:  map[string]bool{"b": true},
+		outputs: map[string]interface{}{"a": map[string]interface{}{"id": "A"}, "c": map[string]interface{}{"id": "C"}},
+	})
+
+	body := `{"namePrefix":"reprocess","dryRun":true,"sampleSize":2,"doMicroBatch":true,"microBatchSize":2,
+		"filter":{"applyUnique":true,"sourceInputTransformer":"wrap"}}`
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/batch/preview", json.RawMessage(body)))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response models.BatchDryRunResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.True(t, response.DryRun)
+	assert.Equal(t, 3, response.MatchCount)
+	assert.Equal(t, 1, response.ExcludedByUnique)
+	assert.Equal(t, 2, response.MicroBatchCount)
+	assert.Empty(t, response.Warnings)
+	assert.Equal(t, []models.BatchDryRunItem{
+		{SourceExecutionID: "a", ExecutionName: "reprocess-0", Input: map[string]interface{}{"order": map[string]interface{}{"id": "A"}}},
+		{SourceExecutionID: "c", ExecutionName: "reprocess-1", Input: map[string]interface{}{"order": map[string]interface{}{"id": "C"}}},
+	}, response.Sample)
+
+	// Unknown transformers are ignored by the batch, which the preview points out
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/batch/preview", json.RawMessage(`{"namePrefix":"p","dryRun":true,"filter":{"sourceInputTransformer":"missing"}}`)))
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 4, response.MatchCount)
+	assert.Len(t, response.Warnings, 1)
+	assert.Equal(t, "execution output not found", response.Sample[1].Error)
+}
+
+func TestPreviewBatch_ExtendedFiltersNeedDatabase(t *testing.T) {
+	w := httptest.NewRecorder()
+	dryRunRouter(&sourceRepository{}).ServeHTTP(w, createRequest(http.MethodPost, "/batch/preview",
+		json.RawMessage(`{"dryRun":true,"filter":{"states":["Ship","Bill"]}}`)))
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+}
+
+func TestBatchSelection_BuildsQuery(t *testing.T) {
+	req := &models.ExecuteBatchRequest{
+		ExecutionNameList: []string{"order-1", "order-2"},
+		Filter: &models.BatchExecutionFilterRequest{
+			SourceStateMachineId: "orders",
+			Status:               "SUCCEEDED",
+			States:               []string{"Ship", "Bill"},
+			NamePattern:          "order-*",
+			ApplyUnique:          true,
+			SourceStateName:      "Ship",
+			Offset:               10,
+			Limit:                50,
+		},
+	}
+	selection := newBatchSelection(req)
+
+	var ids []string
+	stmt := selection.where(dryRunDB(t)).Order("execution_id").Pluck("execution_id", &ids).Statement
+	sql := stmt.SQL.String()
+
+	assert.Contains(t, sql, "state_machine_id = $1 AND status = $2 AND name IN ($3,$4) AND current_state IN ($5,$6) AND name LIKE $7")
+	assert.Contains(t, sql, "execution_id NOT IN (SELECT DISTINCT source_execution_id FROM \"linked_executions\" WHERE source_execution_id IS NOT NULL AND source_state_machine_id = $8 AND source_state_name = $9)")
+	assert.Equal(t, "order-%", stmt.Vars[6])
+
+	assert.Equal(t, 40, selection.window(50))
+	assert.Equal(t, 0, selection.window(5))
+	assert.Equal(t, 50, selection.window(500))
+}
+
+func TestBatchSelection_SourceStateNameDoesNotSelect(t *testing.T) {
+	selection := newBatchSelection(&models.ExecuteBatchRequest{Filter: &models.BatchExecutionFilterRequest{
+		SourceStateMachineId: "orders",
+		CurrentState:         "Bill",
+		SourceStateName:      "Ship",
+		StartTimeFrom:        1700000000,
+	}})
+	assert.Equal(t, "Bill", selection.filter.CurrentState)
+	assert.Equal(t, time.Unix(1700000000, 0), selection.filter.StartAfter)
+	assert.False(t, selection.extended())
+
+	selection = newBatchSelection(&models.ExecuteBatchRequest{Filter: &models.BatchExecutionFilterRequest{SourceStateName: "Ship"}})
+	assert.Empty(t, selection.filter.CurrentState)
+	assert.Equal(t, repository.ExecutionFilter{}, newBatchSelection(&models.ExecuteBatchRequest{}).filter)
+}
+
+func TestBatchSelection_RegexNamePattern(t *testing.T) {
+	selection := newBatchSelection(&models.ExecuteBatchRequest{Filter: &models.BatchExecutionFilterRequest{NamePattern: "/^order-[0-9]+$/"}})
+
+	var ids []string
+	stmt := selection.where(dryRunDB(t)).Pluck("execution_id", &ids).Statement
+	assert.Contains(t, stmt.SQL.String(), "name ~ $1")
+	assert.Equal(t, "^order-[0-9]+$", stmt.Vars[0])
+}
+
+func TestValidateBatchSelection(t *testing.T) {
+	var errs fieldErrors
+	validateBatchSelection(&errs, &models.ExecuteBatchRequest{
+		DoMicroBatch:      true,
+		ExecutionNameList: []string{"order-1"},
+		Filter: &models.BatchExecutionFilterRequest{
+			CurrentState: "Ship",
+			States:       []string{"Bill"},
+			NamePattern:  "/order-(/",
+		},
+	})
+	fields := make([]string, 0, len(errs))
+	for _, err := range errs {
+		fields = append(fields, err.Field)
+	}
+	assert.ElementsMatch(t, []string{"filter.states", "filter.namePattern", "doMicroBatch"}, fields)
+
+	errs = nil
+	validateBatchSelection(&errs, &models.ExecuteBatchRequest{
+		ExecutionNameList: []string{"order-1"},
+		Filter:            &models.BatchExecutionFilterRequest{States: []string{"Ship", "Bill"}, NamePattern: "order-*"},
+	})
+	assert.Empty(t, errs)
+}
+
+func TestSchedule_NextRunInTimeZone(t *testing.T) {
+	schedule := &schedules.Schedule{Cron: "0 2 * * *", TimeZone: "Europe/Berlin"}
+	next, err := schedule.Next(time.Date(2026, 3, 27, 12, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 3, 28, 1, 0, 0, 0, time.UTC), next) // 02:00 CET
+	// 02:00 does not exist on the day clocks move forward
+	next, err = schedule.Next(next)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 3, 30, 0, 0, 0, 0, time.UTC), next) // 02:00 CEST
+
+	schedule = &schedules.Schedule{IntervalSeconds: 90}
+	next, err = schedule.Next(time.Date(2026, 3, 28, 12, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 3, 28, 12, 1, 30, 0, time.UTC), next)
+}
+
+func TestSchedule_RendersInputTemplate(t *testing.T) {
+	schedule := &schedules.Schedule{
+		ID:       "nightly",
+		TimeZone: "America/New_York",
+		Input: map[string]interface{}{
+			"date":  "{{scheduledDate}}",
+			"runs":  []interface{}{"{{executionName}}", 3.0},
+			"label": "reconcile {{scheduleId}} at {{scheduledTime}}",
+		},
+	}
+	scheduledAt := time.Date(2026, 7, 1, 2, 0, 0, 0, time.UTC)
+	name := schedule.ExecutionName(scheduledAt, false)
+	assert.Equal(t, "nightly-1782871200", name)
+	assert.Equal(t, map[string]interface{}{
+		"date":  "2026-06-30",
+		"runs":  []interface{}{"nightly-1782871200", 3.0},
+		"label": "reconcile nightly at 2026-06-30T22:00:00-04:00",
+	}, schedule.Render(scheduledAt, name))
+}
+
+func TestValidateSchedule(t *testing.T) {
+	cases := map[string]*schedules.Schedule{
+		"cron":            {Cron: "61 * * * *"},
+		"timeZone":        {Cron: "@daily", TimeZone: "Mars/Olympus"},
+		"intervalSeconds": {},
+	}
+	for field, schedule := range cases {
+		var errs fieldErrors
+		validateSchedule(&errs, schedule)
+		if assert.Len(t, errs, 1, field) {
+			assert.Equal(t, field, errs[0].Field)
+		}
+	}
+
+	var errs fieldErrors
+	validateSchedule(&errs, &schedules.Schedule{Cron: "*/5 * * * *", IntervalSeconds: 60})
+	assert.Len(t, errs, 1)
+	errs = nil
+	validateSchedule(&errs, &schedules.Schedule{Cron: "@every 1h30m", TimeZone: "Asia/Kolkata"})
+	assert.Empty(t, errs)
+}
+
+func TestCreateSchedule_Validation(t *testing.T) {
+	router := setupTestRouter()
+	router.POST("/schedules", func(c *gin.Context) {
+		c.Set("repositoryManager", repository.NewManagerWithRepository(&listingRepository{}))
+		c.Set("redisClient", redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"}))
+		CreateSchedule(c)
+	})
+	router.POST("/schedules/:scheduleId/trigger", TriggerSchedule)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/schedules",
+		json.RawMessage(`{"stateMachineId":"orders","cron":"0 2 * * *","overlapPolicy":"queue"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "overlapPolicy")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/schedules",
+		json.RawMessage(`{"stateMachineId":"orders","cron":"0 25 * * *"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"cron"`)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/schedules/nightly/trigger", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), models.CodeRepositoryNotConfigured)
+}
+
+func TestDelayedStart(t *testing.T) {
+	var errs fieldErrors
+	assert.True(t, delayedStart(&errs, nil, 0).IsZero())
+	assert.Empty(t, errs)
+
+	at := delayedStart(&errs, nil, 90)
+	assert.Empty(t, errs)
+	assert.WithinDuration(t, time.Now().Add(90*time.Second), at, 5*time.Second)
+
+	future := time.Now().Add(time.Hour)
+	assert.True(t, future.Equal(delayedStart(&errs, &future, 0)))
+	assert.Empty(t, errs)
+
+	past := time.Now().Add(-time.Minute)
+	delayedStart(&errs, &past, 0)
+	delayedStart(&errs, &future, 60)
+	delayedStart(&errs, nil, int(maxStartDelay.Seconds())+1)
+	if assert.Len(t, errs, 3) {
+		assert.Equal(t, "startAt", errs[0].Field)
+		assert.Equal(t, "startAt", errs[1].Field)
+		assert.Equal(t, "delaySeconds", errs[2].Field)
+	}
+
+	errs = nil
+	validateDelayedBulk(&errs, time.Time{}, true, true)
+	assert.Empty(t, errs)
+	validateDelayedBulk(&errs, future, true, true)
+	assert.Len(t, errs, 2)
+}
+
+func TestScheduledExecutions_ScheduleGetRescheduleCancel(t *testing.T) {
+	router, inspector, redisServer := queueRouter(t, &runsRepository{})
+	router.POST("/state-machines/:stateMachineId/executions", StartExecution)
+	router.POST("/queue/enqueue", EnqueueExecution)
+	router.GET("/state-machines/:stateMachineId/scheduled-executions/:executionName", GetScheduledExecution)
+	router.DELETE("/state-machines/:stateMachineId/scheduled-executions/:executionName", CancelScheduledExecution)
+	router.POST("/state-machines/:stateMachineId/scheduled-executions/:executionName/reschedule", RescheduleExecution)
+	scheduled := "/state-machines/orders/scheduled-executions/"
+
+	start := map[string]interface{}{"name": "order-1", "input": map[string]interface{}{"id": 1}, "delaySeconds": 3600, "tags": map[string]string{"team": "billing"}}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/state-machines/orders/executions", start))
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var started models.StartExecutionResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &started))
+	assert.Equal(t, "orders:order-1", started.TaskID)
+	assert.Equal(t, "orders", started.Queue)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *started.ScheduledAt, 5*time.Second)
+
+	// The name identifies the delayed execution, so it cannot be scheduled twice
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/state-machines/orders/executions", start))
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodGet, scheduled+"order-1", nil))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var execution models.ScheduledExecutionResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &execution))
+	assert.Equal(t, StatusScheduled, execution.Status)
+	assert.Equal(t, map[string]string{"team": "billing"}, execution.Tags)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, scheduled+"order-1/reschedule", map[string]interface{}{"delaySeconds": 7200}))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	info, err := inspector.GetTaskInfo("orders", "orders:order-1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, asynq.TaskStateScheduled, info.State)
+		assert.WithinDuration(t, time.Now().Add(2*time.Hour), info.NextProcessAt, 5*time.Second)
+		assert.Equal(t, queue.DefaultConfig().RetryPolicy.MaxRetry, info.MaxRetry)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodDelete, scheduled+"order-1", nil))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var cancelled struct {
+		Data map[string]string `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &cancelled))
+	assert.Equal(t, map[string]string{"executionName": "order-1", "stateMachineId": "orders", "queue": "orders"}, cancelled.Data)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodGet, scheduled+"order-1", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// A unique task is still unique once rescheduled
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/queue/enqueue", map[string]interface{}{
+		"stateMachineId": "orders", "executionName": "order-2", "delaySeconds": 3600, "uniqueForSeconds": 600, "maxRetry": 1,
+	}))
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, scheduled+"order-2/reschedule", map[string]interface{}{"delaySeconds": 60}))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	info, err = inspector.GetTaskInfo("orders", "orders:order-2")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, info.MaxRetry)
+	}
+	uniqueKeys := 0
+	for _, key := range redisServer.Keys() {
+		if strings.HasPrefix(key, "asynq:{orders}:unique:") {
+			uniqueKeys++
+			assert.Greater(t, redisServer.TTL(key), 9*time.Minute)
+		}
+	}
+	assert.Equal(t, 1, uniqueKeys)
+}
+
+func TestTaskOptions_RetryPolicyWithoutQueueConfig(t *testing.T) {
+	redisServer := miniredis.RunT(t)
+	config := queue.DefaultConfig()
+	config.RedisClientOpt = &asynq.RedisClientOpt{Addr: redisServer.Addr()}
+	queueClient, err := queue.NewClient(config)
+	assert.NoError(t, err)
+	inspector := asynq.NewInspector(asynq.RedisClientOpt{Addr: redisServer.Addr()})
+	t.Cleanup(func() {
+		_ = queueClient.Close()
+		_ = inspector.Close()
+	})
+
+	// Without a queue configuration the tasks still get the default retry policy rather
+	// than the asynq one
+	router := setupTestRouter()
+	router.Use(middleware.StateMachineMiddleware(&middleware.Config{
+		RepositoryManager: repository.NewManagerWithRepository(&runsRepository{}),
+		QueueClient:       queueClient,
+		BaseExecutor:      executor.NewBaseExecutor(),
+	}))
+	router.POST("/state-machines/:stateMachineId/executions", StartExecution)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/state-machines/orders/executions", map[string]interface{}{"name": "order-1", "delaySeconds": 3600}))
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	info, err := inspector.GetTaskInfo("orders", "orders:order-1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, config.RetryPolicy.MaxRetry, info.MaxRetry)
+		assert.Equal(t, config.RetryPolicy.Timeout, info.Timeout)
+	}
+}
+
+func TestListScheduledExecutions_PagesOverTheStateMachine(t *testing.T) {
+	router, _, _ := queueRouter(t, &runsRepository{})
+	router.POST("/queue/enqueue", EnqueueExecution)
+	router.GET("/state-machines/:stateMachineId/scheduled-executions", ListScheduledExecutions)
+
+	// Two state machines share the default queue
+	for _, execution := range []struct{ stateMachineID, name string }{
+		{"orders", "order-1"}, {"invoices", "invoice-1"}, {"orders", "order-2"}, {"invoices", "invoice-2"}, {"orders", "order-3"},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, createRequest(http.MethodPost, "/queue/enqueue", map[string]interface{}{
+			"stateMachineId": execution.stateMachineID, "executionName": execution.name, "queue": "default", "delaySeconds": 3600,
+		}))
+		assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	list := func(page int) models.ListScheduledExecutionsResponse {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, createRequest(http.MethodGet, fmt.Sprintf("/state-machines/orders/scheduled-executions?queue=default&pageSize=2&page=%d", page), nil))
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var response models.ListScheduledExecutionsResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+	names := []string{}
+	for page := 1; page <= 3; page++ {
+		response := list(page)
+		assert.Equal(t, 3, response.Total)
+		for _, execution := range response.Executions {
+			assert.Equal(t, "orders", execution.StateMachineID)
+			names = append(names, execution.ExecutionName)
+		}
+		if page < 3 {
+			assert.NotEmpty(t, response.Executions)
+		} else {
+			assert.Empty(t, response.Executions)
+		}
+	}
+	assert.ElementsMatch(t, []string{"order-1", "order-2", "order-3"}, names)
+}
+
+func TestScheduledExecutions_QueueNotConfigured(t *testing.T) {
+	router := setupTestRouter()
+	router.GET("/state-machines/:stateMachineId/scheduled-executions", ListScheduledExecutions)
+	router.DELETE("/state-machines/:stateMachineId/scheduled-executions/:executionName", CancelScheduledExecution)
+
+	for _, req := range []*http.Request{
+		createRequest(http.MethodGet, "/state-machines/orders/scheduled-executions", nil),
+		createRequest(http.MethodDelete, "/state-machines/orders/scheduled-executions/order-1", nil),
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Contains(t, w.Body.String(), models.CodeQueueNotConfigured)
+	}
+}
+
+func TestEnqueueExecution_DelayValidation(t *testing.T) {
+	config := queue.DefaultConfig()
+	config.RedisClientOpt = &asynq.RedisClientOpt{Addr: "127.0.0.1:1"}
+	queueClient, err := queue.NewClient(config)
+	assert.NoError(t, err)
+	router := setupTestRouter()
+	router.POST("/queue/enqueue", func(c *gin.Context) {
+		c.Set("queueClient", queueClient)
+		EnqueueExecution(c)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/queue/enqueue", json.RawMessage(
+		`{"stateMachineId":"orders","executionName":"order-1","startAt":"2099-01-01T00:00:00Z","delaySeconds":60}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "startAt")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/queue/enqueue", json.RawMessage(
+		`{"stateMachineId":"orders","executionName":"order-1","delaySeconds":-5}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPriorityQueue(t *testing.T) {
+	queues := queuesByWeight(map[string]int{"critical": 6, "timeout": 5, "default": 3, "low": 1})
+	assert.Equal(t, []string{"critical", "default", "low"}, queues)
+	assert.Equal(t, "critical", priorityQueue(queues, "high"))
+	assert.Equal(t, "default", priorityQueue(queues, "normal"))
+	assert.Equal(t, "low", priorityQueue(queues, "low"))
+
+	queues = queuesByWeight(map[string]int{"payments": 5, "reports": 1, "emails": 2})
+	assert.Equal(t, []string{"payments", "emails", "reports"}, queues)
+	assert.Equal(t, "emails", priorityQueue(queues, "normal"))
+}
+
+func TestEnqueueExecution_QueueSelection(t *testing.T) {
+	config := queue.DefaultConfig()
+	config.RedisClientOpt = &asynq.RedisClientOpt{Addr: "127.0.0.1:1"}
+	config.Queues = map[string]int{"critical": 6, "default": 3, "low": 1}
+	queueClient, err := queue.NewClient(config)
+	assert.NoError(t, err)
+
+	router := setupTestRouter()
+	router.POST("/queue/enqueue", func(c *gin.Context) {
+		c.Set("queueClient", queueClient)
+		c.Set("queueConfig", config)
+		EnqueueExecution(c)
+	})
+	router.POST("/unconfigured/enqueue", func(c *gin.Context) {
+		c.Set("queueClient", queueClient)
+		EnqueueExecution(c)
+	})
+
+	cases := map[string]struct {
+		path, body, field string
+	}{
+		"unknown queue":           {"/queue/enqueue", `{"stateMachineId":"orders","executionName":"o-1","queue":"urgent"}`, `unknown queue \"urgent\"`},
+		"queue and priority":      {"/queue/enqueue", `{"stateMachineId":"orders","executionName":"o-1","queue":"low","priority":"high"}`, "priority"},
+		"timeout queue":           {"/queue/enqueue", `{"stateMachineId":"orders","executionName":"o-1","queue":"timeout"}`, "reserved"},
+		"unknown priority":        {"/queue/enqueue", `{"stateMachineId":"orders","executionName":"o-1","priority":"urgent"}`, "priority"},
+		"negative maxRetry":       {"/queue/enqueue", `{"stateMachineId":"orders","executionName":"o-1","maxRetry":-1}`, "maxRetry"},
+		"priority without queues": {"/unconfigured/enqueue", `{"stateMachineId":"orders","executionName":"o-1","priority":"low"}`, "priority"},
+	}
+	for name, tc := range cases {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, createRequest(http.MethodPost, tc.path, json.RawMessage(tc.body)))
+		assert.Equal(t, http.StatusBadRequest, w.Code, name)
+		assert.Contains(t, w.Body.String(), tc.field, name)
+	}
+}
+
+func TestActivities_ExecutionContext(t *testing.T) {
+	baseExecutor := executor.NewBaseExecutor()
+	baseExecutor.RegisterGoFunction("charge", func(_ context.Context, input interface{}) (interface{}, error) {
+		return input, nil
+	})
+	execCtx := activities.NewExecutionContext(executor.NewExecutionContextAdapter(baseExecutor), activities.NewStore(nil))
+
+	_, ok := execCtx.GetTaskHandler("charge")
+	assert.True(t, ok)
+	_, ok = execCtx.GetTaskHandler(activities.Resource("ship-order"))
+	assert.True(t, ok)
+	_, ok = execCtx.GetTaskHandler(activities.ResourcePrefix)
+	assert.False(t, ok)
+	_, ok = execCtx.GetTaskHandler("refund")
+	assert.False(t, ok)
+
+	assert.Equal(t, "States.HeartbeatTimeout", (&activities.TaskError{Name: activities.ErrorHeartbeatTimeout, Cause: "no heartbeat"}).Error())
+}
+
+func TestActivities_Validation(t *testing.T) {
+	router := setupTestRouter()
+	router.POST("/activities", func(c *gin.Context) {
+		c.Set("redisClient", redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"}))
+		CreateActivity(c)
+	})
+	router.GET("/activities/:name/poll", func(c *gin.Context) {
+		c.Set("redisClient", redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"}))
+		PollActivityTask(c)
+	})
+	router.POST("/activities/tasks/:token/heartbeat", SendActivityTaskHeartbeat)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/activities", json.RawMessage(`{"name":"ship order","heartbeatSeconds":30}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"name"`)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/activities", json.RawMessage(`{"name":"ship-order","heartbeatSeconds":-1}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "heartbeatSeconds")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodGet, "/activities/ship-order/poll?waitSeconds=120", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "waitSeconds")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/activities/tasks/abc/heartbeat", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), models.CodeRedisNotConfigured)
+}
+
+// runCallbackMachine runs a ".waitForTaskToken" Task in the background. The handler of
+// "notify" hands the task tokens it receives to tokens.
+func runCallbackMachine(t *testing.T, redisClient *redis.Client, task string) (tokens chan string, done chan interface{}) {
+	t.Helper()
+	sm, err := statemachine.New([]byte(`{"StartAt": "Notify", "States": {"Notify": `+task+`}}`), true)
+	if err != nil {
+		t.Fatalf("invalid state machine: %v", err)
+	}
+	baseExecutor := executor.NewBaseExecutor()
+	tokens = make(chan string, 1)
+	baseExecutor.RegisterGoFunction("notify", func(_ context.Context, input interface{}) (interface{}, error) {
+		params := input.(map[string]interface{})
+		if _, ok := params[activities.TaskTokenTimeoutField]; ok {
+			return nil, errors.New("timeout passed on")
+		}
+		tokens <- params[activities.TaskTokenField].(string)
+		return nil, nil
+	})
+	execCtx := activities.NewExecutionContext(executor.NewExecutionContextAdapter(baseExecutor), activities.NewStore(redisClient))
+
+	done = make(chan interface{}, 1)
+	go func() {
+		exec, err := sm.Execute(context.WithValue(context.Background(), types.ExecutionContextKey, execCtx), map[string]interface{}{"orderId": "12345"})
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- exec.Output
+	}()
+	return tokens, done
+}
+
+func TestTaskToken_Callback(t *testing.T) {
+	redisServer := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+	router := setupTestRouter()
+	router.Use(func(c *gin.Context) {
+		c.Set("redisClient", redisClient)
+	})
+	router.POST("/tasks/:token/success", SendTaskSuccess)
+	router.POST("/tasks/:token/failure", SendTaskFailure)
+
+	// Success resumes the execution with the output, once
+	tokens, done := runCallbackMachine(t, redisClient, `{"Type": "Task", "Resource": "notify.waitForTaskToken", "Parameters": {"orderId": "$.orderId"}, "End": true}`)
+	token := <-tokens
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/tasks/"+token+"/success", json.RawMessage(`{"output":{"approved":true}}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, map[string]interface{}{"approved": true}, <-done)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/tasks/"+token+"/success", json.RawMessage(`{"output":{}}`)))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), models.CodeTaskNotRunning)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/tasks/unknown/failure", json.RawMessage(`{}`)))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), models.CodeTaskNotFound)
+
+	// Failure fails the Task state under the reported error name
+	tokens, done = runCallbackMachine(t, redisClient, `{"Type": "Task", "Resource": "notify.waitForTaskToken", "Parameters": {}, "End": true}`)
+	token = <-tokens
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/tasks/"+token+"/failure", json.RawMessage(`{"error":"Rejected","cause":"out of stock"}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.EqualError(t, (<-done).(error), "Rejected")
+
+	// Without a callback in time, the Task state fails with States.Timeout
+	tokens, done = runCallbackMachine(t, redisClient, `{"Type": "Task", "Resource": "notify.waitForTaskToken", "Parameters": {"TaskTokenTimeoutSeconds": 1}, "End": true}`)
+	token = <-tokens
+	assert.EqualError(t, (<-done).(error), activities.ErrorTimeout)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/tasks/"+token+"/success", json.RawMessage(`{"output":{}}`)))
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestTaskToken_ResumesParkedExecution(t *testing.T) {
+	router, inspector, redisServer := queueRouter(t, &runsRepository{})
+	router.POST("/tasks/:token/success", SendTaskSuccess)
+	store := activities.NewStore(redis.NewClient(&redis.Options{Addr: redisServer.Addr()}))
+
+	// A worker parks the execution on the token of its Task
+	ctx, parking, cancel := activities.WithParking(context.Background())
+	defer cancel()
+	var token string
+	_, err := store.Callback(ctx, "notify.waitForTaskToken", func(_ context.Context, input interface{}) (interface{}, error) {
+		token = input.(map[string]interface{})[activities.TaskTokenField].(string)
+		return nil, nil
+	}, map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Equal(t, token, parking.Parked().Token)
+	reported, err := store.Park(context.Background(), []string{token}, &activities.ParkedExecution{ExecutionID: "exec-1", StateMachineID: "orders", ExecutionName: "order-1"})
+	assert.NoError(t, err)
+	assert.False(t, reported)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/tasks/"+token+"/success", json.RawMessage(`{"output":{"approved":true}}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	info, err := inspector.GetTaskInfo("orders", middleware.ResumeTaskIDPrefix+"exec-1-"+token)
+	if assert.NoError(t, err) {
+		var payload queue.ExecutionTaskPayload
+		assert.NoError(t, json.Unmarshal(info.Payload, &payload))
+		assert.True(t, middleware.IsResumeTask(&payload))
+		assert.Equal(t, token, payload.Options[middleware.TaskTokenOption])
+		assert.Equal(t, "exec-1", payload.ExecutionID)
+	}
+}
+
+func TestTaskToken_ExecutionContext(t *testing.T) {
+	baseExecutor := executor.NewBaseExecutor()
+	baseExecutor.RegisterGoFunction("notify", func(_ context.Context, input interface{}) (interface{}, error) {
+		return input, nil
+	})
+	execCtx := activities.NewExecutionContext(executor.NewExecutionContextAdapter(baseExecutor), activities.NewStore(nil))
+
+	handler, ok := execCtx.GetTaskHandler("notify" + activities.WaitForTaskTokenSuffix)
+	assert.True(t, ok)
+	_, ok = execCtx.GetTaskHandler("missing" + activities.WaitForTaskTokenSuffix)
+	assert.False(t, ok)
+	_, ok = execCtx.GetTaskHandler(activities.WaitForTaskTokenSuffix)
+	assert.False(t, ok)
+
+	_, err := handler(context.Background(), "not an object")
+	assert.EqualError(t, err, activities.ErrorRuntime)
+	_, err = handler(context.Background(), map[string]interface{}{activities.TaskTokenTimeoutField: -1.0})
+	assert.EqualError(t, err, activities.ErrorRuntime)
+}
+
+func TestTaskToken_HTTPInvoke(t *testing.T) {
+	redisServer := miniredis.RunT(t)
+	store := activities.NewStore(redis.NewClient(&redis.Options{Addr: redisServer.Addr()}))
+	requests := make(chan *http.Request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sm, err := statemachine.New([]byte(`{"StartAt": "Approve", "States": {"Approve": {
+		"Type": "Task",
+		"Resource": "http:invoke.waitForTaskToken",
+		"Parameters": {
+			"Input": "$",
+			"Method": "POST",
+			"Url": "`+server.URL+`/approvals/{$.orderId}",
+			"Headers": {"X-Callback-Token": "{$$.Task.Token}"},
+			"TaskTokenTimeoutSeconds": 5
+		},
+		"End": true
+	}}}`), true)
+	assert.NoError(t, err)
+	baseExecutor := executor.NewBaseExecutor()
+	httptask.Register(baseExecutor)
+	execCtx := activities.NewExecutionContext(executor.NewExecutionContextAdapter(baseExecutor), store)
+
+	done := make(chan interface{}, 1)
+	go func() {
+		exec, err := sm.Execute(context.WithValue(context.Background(), types.ExecutionContextKey, execCtx), map[string]interface{}{"orderId": "12345"})
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- exec.Output
+	}()
+
+	var token string
+	select {
+	case r := <-requests:
+		assert.Equal(t, "/approvals/12345", r.URL.Path)
+		token = r.Header.Get("X-Callback-Token")
+	case result := <-done:
+		t.Fatalf("execution ended before calling the endpoint: %v", result)
+	}
+	_, err = store.Succeed(context.Background(), token, map[string]interface{}{"approved": true})
+	assert.NoError(t, err)
+	select {
+	case result := <-done:
+		assert.Equal(t, map[string]interface{}{"approved": true}, result)
+	case <-time.After(5 * time.Second):
+		t.Fatal("execution did not resume")
+	}
+}
+
+// ==================== Queued Resume Tests ====================
+
+// pausedRepository serves the executions of a state machine with an output schema
+type pausedRepository struct {
+	recordingRepository
+	executions map[string]*repository.ExecutionRecord
+}
+
+func (r *pausedRepository) GetExecution(_ context.Context, executionID string) (*repository.ExecutionRecord, error) {
+	if record, ok := r.executions[executionID]; ok {
+		return record, nil
+	}
+	return nil, errors.New("execution not found")
+}
+
+func resumeRouter(q *recordingQueue) *gin.Engine {
+	started := time.Now()
+	manager := repository.NewManagerWithRepository(&pausedRepository{
+		recordingRepository: recordingRepository{stateMachines: map[string]*repository.StateMachineRecord{
+			"orders": {ID: "orders", Metadata: map[string]interface{}{
+				"outputSchema": map[string]interface{}{"type": "object", "required": []interface{}{"approved"}},
+			}},
+		}},
+		executions: map[string]*repository.ExecutionRecord{
+			"exec-1": {ExecutionID: "exec-1", StateMachineID: "orders", Name: "order-1", Status: "PAUSED", CurrentState: "WaitForApproval", StartTime: &started},
+			"exec-2": {ExecutionID: "exec-2", StateMachineID: "orders", Name: "order-2", Status: "SUCCEEDED", StartTime: &started},
+		},
+	})
+
+	router := setupTestRouter()
+	router.POST("/executions/:executionId/resume", func(c *gin.Context) {
+		resumeExecution(c, manager, q)
+	})
+	return router
+}
+
+func TestResumeExecution_Queued(t *testing.T) {
+	q := &recordingQueue{}
+	router := resumeRouter(q)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/executions/exec-1/resume", map[string]interface{}{
+		"output": map[string]interface{}{"approved": true},
+	}))
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	var response models.ResumeQueuedResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "exec-1", response.ExecutionID)
+	assert.Equal(t, "orders", response.StateMachineID)
+	assert.Equal(t, "order-1", response.TaskID)
+
+	if assert.Len(t, q.enqueued, 1) {
+		payload := q.enqueued[0]
+		assert.True(t, middleware.IsResumeTask(payload))
+		assert.Equal(t, "exec-1", payload.ExecutionID)
+		assert.Equal(t, "orders", payload.StateMachineID)
+		assert.Equal(t, map[string]interface{}{"approved": true}, payload.Input)
+	}
+	assert.False(t, middleware.IsResumeTask(&queue.ExecutionTaskPayload{ExecutionName: "order-1"}))
+}
+
+func TestResumeExecution_QueuedErrors(t *testing.T) {
+	q := &recordingQueue{fail: map[string]bool{"order-1": true}}
+	router := resumeRouter(q)
+
+	tests := []struct {
+		name   string
+		path   string
+		body   interface{}
+		status int
+		code   string
+	}{
+		{"resume already queued", "/executions/exec-1/resume", map[string]interface{}{"output": map[string]interface{}{"approved": true}}, http.StatusConflict, models.CodeDuplicateTask},
+		{"not paused", "/executions/exec-2/resume", map[string]interface{}{"output": map[string]interface{}{"approved": true}}, http.StatusConflict, models.CodeExecutionNotPaused},
+		{"unknown execution", "/executions/exec-3/resume", map[string]interface{}{}, http.StatusNotFound, models.CodeExecutionNotFound},
+		{"output schema", "/executions/exec-1/resume", map[string]interface{}{"output": map[string]interface{}{}}, http.StatusBadRequest, models.CodeValidationFailed},
+		{"invalid wait", "/executions/exec-1/resume?wait=soon", map[string]interface{}{}, http.StatusBadRequest, models.CodeValidationFailed},
+		{"invalid timeout", "/executions/exec-1/resume?wait=true&timeoutSeconds=0", map[string]interface{}{}, http.StatusBadRequest, models.CodeValidationFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, createRequest(http.MethodPost, tt.path, tt.body))
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+			assert.Contains(t, w.Body.String(), tt.code)
+		})
+	}
+	assert.Empty(t, q.enqueued)
+}
+
+func TestResumeExecution_WaitSkipsQueue(t *testing.T) {
+	q := &recordingQueue{}
+	router := resumeRouter(q)
+
+	// The stored state machine has no definition, so the resume in the request fails to load it
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/executions/exec-1/resume?wait=true&timeoutSeconds=5", map[string]interface{}{
+		"output": map[string]interface{}{"approved": true},
+	}))
+	assert.NotEqual(t, http.StatusAccepted, w.Code)
+	assert.Empty(t, q.enqueued)
+}
+
+func TestResumeExecution_ReplacesArchivedResume(t *testing.T) {
+	redisServer := miniredis.RunT(t)
+	config := queue.DefaultConfig()
+	config.RedisClientOpt = &asynq.RedisClientOpt{Addr: redisServer.Addr()}
+	queueClient, err := queue.NewClient(config)
+	assert.NoError(t, err)
+	inspector := asynq.NewInspector(asynq.RedisClientOpt{Addr: redisServer.Addr()})
+	t.Cleanup(func() {
+		_ = queueClient.Close()
+		_ = inspector.Close()
+	})
+
+	started := time.Now()
+	router := setupTestRouter()
+	router.Use(middleware.StateMachineMiddleware(&middleware.Config{
+		RepositoryManager: repository.NewManagerWithRepository(&pausedRepository{
+			recordingRepository: recordingRepository{stateMachines: map[string]*repository.StateMachineRecord{"orders": {ID: "orders"}}},
+			executions: map[string]*repository.ExecutionRecord{
+				"exec-1": {ExecutionID: "exec-1", StateMachineID: "orders", Name: "order-1", Status: "PAUSED", CurrentState: "WaitForApproval", StartTime: &started},
+			},
+		}),
+		QueueClient:  queueClient,
+		QueueConfig:  config,
+		BaseExecutor: executor.NewBaseExecutor(),
+	}))
+	router.POST("/executions/:executionId/resume", ResumeExecution)
+	resume := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, createRequest(http.MethodPost, "/executions/exec-1/resume", map[string]interface{}{
+			"output": map[string]interface{}{"approved": true},
+		}))
+		return w
+	}
+
+	taskID := middleware.ResumeTaskIDPrefix + "exec-1-WaitForApproval"
+	assert.Equal(t, http.StatusAccepted, resume().Code)
+
+	// A resume still pending is not queued twice
+	w := resume()
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), models.CodeDuplicateTask)
+
+	// A resume archived after its retries ran out is replaced
+	assert.NoError(t, inspector.ArchiveTask("orders", taskID))
+	w = resume()
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	info, err := inspector.GetTaskInfo("orders", taskID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, asynq.TaskStatePending, info.State)
+	}
+}
+
+// ==================== Resume By Correlation Tests ====================
+
+// correlatedRepository serves paused executions and the correlations they wait on
+type correlatedRepository struct {
+	pausedRepository
+	repository.MessageRepository
+	correlations []*repository.MessageCorrelationRecord
+}
+
+func (r *correlatedRepository) FindWaitingCorrelations(_ context.Context, filter *repository.MessageCorrelationFilter) ([]*repository.MessageCorrelationRecord, error) {
+	var found []*repository.MessageCorrelationRecord
+	for _, correlation := range r.correlations {
+		if correlation.CorrelationKey == filter.CorrelationKey && reflect.DeepEqual(correlation.CorrelationValue, filter.CorrelationValue) &&
+			correlation.StateMachineID == filter.StateMachineID && correlation.Status == filter.Status {
+			found = append(found, correlation)
+		}
+	}
+	if filter.Limit > 0 && len(found) > filter.Limit {
+		found = found[:filter.Limit]
+	}
+	return found, nil
+}
+
+func (r *correlatedRepository) GetMessageCorrelation(_ context.Context, id string) (*repository.MessageCorrelationRecord, error) {
+	for _, correlation := range r.correlations {
+		if correlation.ID == id {
+			return correlation, nil
+		}
+	}
+	return nil, errors.New("correlation not found")
+}
+
+func (r *correlatedRepository) SaveMessageCorrelation(_ context.Context, record *repository.MessageCorrelationRecord) error {
+	for i, correlation := range r.correlations {
+		if correlation.ID == record.ID {
+			r.correlations[i] = record
+			return nil
+		}
+	}
+	r.correlations = append(r.correlations, record)
+	return nil
+}
+
+func (r *correlatedRepository) ListTimedOutCorrelations(_ context.Context, timestamp int64) ([]*repository.MessageCorrelationRecord, error) {
+	var found []*repository.MessageCorrelationRecord
+	for _, correlation := range r.correlations {
+		if correlation.Status == "WAITING" && correlation.TimeoutAt != nil && *correlation.TimeoutAt <= timestamp {
+			found = append(found, correlation)
+		}
+	}
+	return found, nil
+}
+
+func correlationRouter(q *recordingQueue) *gin.Engine {
+	manager := repository.NewManagerWithRepository(correlatedExecutions())
+
+	router := setupTestRouter()
+	router.POST("/state-machines/:stateMachineId/resume-by-correlation", func(c *gin.Context) {
+		resumeByCorrelation(c, manager, q, nil)
+	})
+	return router
+}
+
+// correlatedExecutions returns executions of two state machines waiting on orderId 1001
+func correlatedExecutions() *correlatedRepository {
+	started := time.Now()
+	execution := func(id, stateMachineID, status string) *repository.ExecutionRecord {
+		return &repository.ExecutionRecord{ExecutionID: id, StateMachineID: stateMachineID, Name: "order-" + id, Status: status, CurrentState: "WaitForPayment", StartTime: &started}
+	}
+	correlation := func(executionID, stateMachineID string, value interface{}) *repository.MessageCorrelationRecord {
+		return &repository.MessageCorrelationRecord{ExecutionID: executionID, StateMachineID: stateMachineID, CorrelationKey: "orderId", CorrelationValue: value, Status: "WAITING"}
+	}
+	return &correlatedRepository{
+		pausedRepository: pausedRepository{
+			recordingRepository: recordingRepository{stateMachines: map[string]*repository.StateMachineRecord{
+				"orders":  {ID: "orders"},
+				"refunds": {ID: "refunds"},
+			}},
+			executions: map[string]*repository.ExecutionRecord{
+				"exec-1": execution("exec-1", "orders", "PAUSED"),
+				"exec-2": execution("exec-2", "orders", "PAUSED"),
+				"exec-3": execution("exec-3", "refunds", "PAUSED"),
+				"exec-4": execution("exec-4", "orders", "RUNNING"),
+			},
+		},
+		correlations: []*repository.MessageCorrelationRecord{
+			correlation("exec-1", "orders", json.Number("1001")),
+			correlation("exec-2", "orders", "1001"),
+			correlation("exec-3", "refunds", json.Number("1001")),
+			correlation("exec-4", "orders", json.Number("1001")),
+		},
+	}
+}
+
+func resumeByCorrelationRequest(t *testing.T, router *gin.Engine, body map[string]interface{}) (int, models.ResumeByCorrelationResponse) {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/state-machines/orders/resume-by-correlation", body))
+	var response models.ResumeByCorrelationResponse
+	if w.Code < http.StatusBadRequest {
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	}
+	return w.Code, response
+}
+
+func TestResumeByCorrelation_PerExecutionResults(t *testing.T) {
+	q := &recordingQueue{}
+	router := correlationRouter(q)
+
+	// The number matches the executions of this state machine waiting on 1001, not "1001"
+	status, response := resumeByCorrelationRequest(t, router, map[string]interface{}{
+		"correlationKey": "orderId", "correlationValue": 1001, "output": map[string]interface{}{"paid": true},
+	})
+	assert.Equal(t, http.StatusAccepted, status)
+	assert.True(t, response.Queued)
+	assert.Equal(t, 2, response.MatchCount)
+	assert.Equal(t, 1, response.ResumedCount)
+	assert.Equal(t, 1, response.FailedCount)
+	assert.Equal(t, []string{"exec-1"}, response.ExecutionIDs)
+	if assert.Len(t, response.Results, 2) {
+		assert.Equal(t, models.ResumeResult{ExecutionID: "exec-1", Name: "order-exec-1", Result: ResumeResultQueued}, response.Results[0])
+		assert.Equal(t, ResumeResultFailed, response.Results[1].Result)
+		assert.Equal(t, models.CodeExecutionNotPaused, response.Results[1].Code)
+		assert.Equal(t, "execution is RUNNING", response.Results[1].Error)
+	}
+	if assert.Len(t, q.enqueued, 1) {
+		assert.Equal(t, "exec-1", q.enqueued[0].ExecutionID)
+	}
+
+	// Strings only match strings; a resume already queued is reported per execution
+	q.fail = map[string]bool{"order-exec-2": true}
+	status, response = resumeByCorrelationRequest(t, router, map[string]interface{}{
+		"correlationKey": "orderId", "correlationValue": "1001",
+	})
+	assert.Equal(t, http.StatusAccepted, status)
+	assert.Equal(t, 1, response.MatchCount)
+	assert.Equal(t, 1, response.FailedCount)
+	assert.Empty(t, response.ExecutionIDs)
+	if assert.Len(t, response.Results, 1) {
+		assert.Equal(t, "exec-2", response.Results[0].ExecutionID)
+		assert.Equal(t, models.CodeDuplicateTask, response.Results[0].Code)
+	}
+}
+
+func TestResumeByCorrelation_MaxMatchesAndDryRun(t *testing.T) {
+	q := &recordingQueue{}
+	router := correlationRouter(q)
+
+	status, response := resumeByCorrelationRequest(t, router, map[string]interface{}{
+		"correlationKey": "orderId", "correlationValue": 1001, "dryRun": true, "maxMatches": 1,
+	})
+	assert.Equal(t, http.StatusOK, status)
+	assert.True(t, response.DryRun)
+	assert.True(t, response.MoreMatches)
+	assert.Equal(t, 0, response.ResumedCount)
+	if assert.Len(t, response.Results, 1) {
+		assert.Equal(t, ResumeResultMatched, response.Results[0].Result)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/state-machines/orders/resume-by-correlation", map[string]interface{}{
+		"correlationKey": "orderId", "correlationValue": 1001, "maxMatches": 1,
+	}))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), models.CodeTooManyMatches)
+	assert.Empty(t, q.enqueued)
+}
+
+func TestResumeByCorrelation_InvalidCorrelationValue(t *testing.T) {
+	router := correlationRouter(&recordingQueue{})
+
+	tests := []struct {
+		name string
+		body map[string]interface{}
+		want string
+	}{
+		{"missing", map[string]interface{}{"correlationKey": "orderId"}, "correlationValue"},
+		{"null", map[string]interface{}{"correlationKey": "orderId", "correlationValue": nil}, "correlationValue"},
+		{"object", map[string]interface{}{"correlationKey": "orderId", "correlationValue": map[string]interface{}{"id": 1}}, "string, number or boolean"},
+		{"concurrency", map[string]interface{}{"correlationKey": "orderId", "correlationValue": true, "concurrency": 0.5}, "concurrency"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, createRequest(http.MethodPost, "/state-machines/orders/resume-by-correlation", tt.body))
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), tt.want)
+		})
+	}
+}
+
+func TestParseCorrelationValue(t *testing.T) {
+	value, err := models.ParseCorrelationValue("1001", models.CorrelationValueNumber)
+	assert.NoError(t, err)
+	assert.Equal(t, json.Number("1001"), value.Value())
+
+	value, err = models.ParseCorrelationValue("1001", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "1001", value.Value())
+
+	value, err = models.ParseCorrelationValue("true", models.CorrelationValueBoolean)
+	assert.NoError(t, err)
+	assert.Equal(t, true, value.Value())
+
+	for _, raw := range []string{"NaN", "0x10", "12abc", ""} {
+		_, err = models.ParseCorrelationValue(raw, models.CorrelationValueNumber)
+		assert.Error(t, err, raw)
+	}
+	_, err = models.ParseCorrelationValue("yes please", models.CorrelationValueBoolean)
+	assert.Error(t, err)
+}
+
+// ==================== Message Inbox Tests ====================
+
+// inboxRouter serves the message endpoints over an inbox in miniredis, queueing resumes
+// on q
+func inboxRouter(t *testing.T, q *recordingQueue) (*gin.Engine, *correlatedRepository, *inbox.Store) {
+	redisServer := miniredis.RunT(t)
+	store := inbox.NewStore(redis.NewClient(&redis.Options{Addr: redisServer.Addr()}))
+	repo := correlatedExecutions()
+	manager := repository.NewManagerWithRepository(repo)
+
+	router := setupTestRouter()
+	router.Use(func(c *gin.Context) {
+		c.Set("messageInbox", store)
+		c.Next()
+	})
+	router.POST("/state-machines/:stateMachineId/resume-by-correlation", func(c *gin.Context) {
+		resumeByCorrelation(c, manager, q, store)
+	})
+	router.GET("/state-machines/:stateMachineId/messages", ListMessages)
+	router.GET("/state-machines/:stateMachineId/messages/:messageId", GetMessage)
+	router.DELETE("/state-machines/:stateMachineId/messages/:messageId", DeleteMessage)
+	router.POST("/state-machines/:stateMachineId/messages/:messageId/redeliver", func(c *gin.Context) {
+		redeliverMessage(c, manager, store, q)
+	})
+	return router, repo, store
+}
+
+func TestResumeByCorrelation_BuffersUnmatchedMessage(t *testing.T) {
+	q := &recordingQueue{}
+	router, repo, store := inboxRouter(t, q)
+
+	status, response := resumeByCorrelationRequest(t, router, map[string]interface{}{
+		"correlationKey": "orderId", "correlationValue": 2002, "output": map[string]interface{}{"paid": true}, "messageTtlSeconds": 600,
+	})
+	assert.Equal(t, http.StatusAccepted, status)
+	assert.True(t, response.Buffered)
+	assert.NotEmpty(t, response.MessageID)
+	assert.Empty(t, q.enqueued)
+
+	// Dry runs never buffer
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPost, "/state-machines/orders/resume-by-correlation", map[string]interface{}{
+		"correlationKey": "orderId", "correlationValue": 2002, "dryRun": true,
+	}))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// The execution reaching its Message state gets the message; the Message state records
+	// numbers as float64
+	started := time.Now()
+	record := &repository.ExecutionRecord{ExecutionID: "exec-5", StateMachineID: "orders", Name: "order-exec-5", Status: "PAUSED", CurrentState: "WaitForPayment", StartTime: &started}
+	repo.correlations = append(repo.correlations, &repository.MessageCorrelationRecord{
+		ID: "corr-exec-5-WaitForPayment", ExecutionID: "exec-5", StateMachineID: "orders", CorrelationKey: "orderId", CorrelationValue: float64(2002), Status: "WAITING",
+	})
+	manager := middleware.NewInboxRepositoryManager(repository.NewManagerWithRepository(repo), store, q, nil)
+	assert.Same(t, manager, middleware.NewInboxRepositoryManager(manager, store, q, nil))
+	assert.NoError(t, manager.GetRepository().SaveExecution(context.Background(), record))
+
+	if assert.Len(t, q.enqueued, 1) {
+		assert.True(t, middleware.IsResumeTask(q.enqueued[0]))
+		assert.Equal(t, "exec-5", q.enqueued[0].ExecutionID)
+		assert.Equal(t, map[string]interface{}{"paid": true}, q.enqueued[0].Input)
+	}
+	message, err := store.Get(context.Background(), response.MessageID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, inbox.StatusDelivered, message.Status)
+		assert.Equal(t, "exec-5", message.ExecutionID)
+		assert.Equal(t, 1, message.Deliveries)
+		assert.WithinDuration(t, message.CreatedAt.Add(10*time.Minute), message.ExpiresAt, time.Second)
+	}
+
+	// A delivered message is not delivered again when the execution pauses later on
+	assert.NoError(t, manager.GetRepository().SaveExecution(context.Background(), record))
+	assert.Len(t, q.enqueued, 1)
+}
+
+func TestMessages_ListGetDeleteAndRedeliver(t *testing.T) {
+	q := &recordingQueue{}
+	router, repo, _ := inboxRouter(t, q)
+
+	_, first := resumeByCorrelationRequest(t, router, map[string]interface{}{"correlationKey": "orderId", "correlationValue": 3003})
+	_, second := resumeByCorrelationRequest(t, router, map[string]interface{}{"correlationKey": "customerId", "correlationValue": "c-1"})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodGet, "/state-machines/orders/messages?correlationKey=orderId", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list models.ListMessagesResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, 1, list.Total)
+	if assert.Len(t, list.Messages, 1) {
+		assert.Equal(t, first.MessageID, list.Messages[0].ID)
+		assert.Equal(t, float64(3003), list.Messages[0].CorrelationValue)
+		assert.Equal(t, inbox.StatusPending, list.Messages[0].Status)
+	}
+
+	// Messages belong to their state machine
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodGet, "/state-machines/refunds/messages/"+first.MessageID, nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), models.CodeMessageNotFound)
+
+	// Without a waiting execution the message stays pending
+	redeliver := func(id string) (int, models.RedeliverMessageResponse) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, createRequest(http.MethodPost, "/state-machines/orders/messages/"+id+"/redeliver", nil))
+		var response models.RedeliverMessageResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return w.Code, response
+	}
+	status, redelivered := redeliver(first.MessageID)
+	assert.Equal(t, http.StatusOK, status)
+	assert.False(t, redelivered.Delivered)
+	assert.Equal(t, inbox.StatusPending, redelivered.Message.Status)
+
+	// An execution waiting without having been handed the message gets it
+	repo.correlations = append(repo.correlations, &repository.MessageCorrelationRecord{
+		ExecutionID: "exec-1", StateMachineID: "orders", CorrelationKey: "orderId", CorrelationValue: json.Number("3003"), Status: "WAITING",
+	})
+	status, redelivered = redeliver(first.MessageID)
+	assert.Equal(t, http.StatusAccepted, status)
+	assert.True(t, redelivered.Delivered)
+	assert.Equal(t, inbox.StatusDelivered, redelivered.Message.Status)
+	assert.Equal(t, "exec-1", redelivered.Message.ExecutionID)
+	assert.Equal(t, ResumeResultQueued, redelivered.Result.Result)
+	if assert.Len(t, q.enqueued, 1) {
+		assert.Equal(t, "exec-1", q.enqueued[0].ExecutionID)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodGet, "/state-machines/orders/messages?status=DELIVERED", nil))
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, 1, list.Total)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodDelete, "/state-machines/orders/messages/"+second.MessageID, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodGet, "/state-machines/orders/messages/"+second.MessageID, nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestWaitTimeouts_ScheduledWhenExecutionsPauseAndListedBeforeExpiry(t *testing.T) {
+	q := &recordingQueue{}
+	repo := correlatedExecutions()
+	repo.stateMachines["orders"].Definition = `{"StartAt":"WaitForPayment","States":{"WaitForPayment":{"Type":"Message","CorrelationKey":"orderId","Next":"Done"},"Done":{"Type":"Succeed"}}}`
+	manager := repository.NewManagerWithRepository(repo)
+
+	router := setupTestRouter()
+	router.Use(func(c *gin.Context) {
+		c.Set("repositoryManager", manager)
+		c.Next()
+	})
+	router.PUT("/state-machines/:stateMachineId/wait-timeouts", UpdateWaitTimeouts)
+	router.GET("/state-machines/:stateMachineId/waiting/expiring", ListExpiringExecutions)
+
+	// Only Message states can have a timeout
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPut, "/state-machines/orders/wait-timeouts", map[string]interface{}{
+		"states": map[string]interface{}{"Done": 60},
+	}))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "states.Done")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodPut, "/state-machines/orders/wait-timeouts", map[string]interface{}{
+		"defaultSeconds": 86400, "states": map[string]interface{}{"WaitForPayment": 60},
+	}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var updated models.WaitTimeoutsResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+	assert.Equal(t, map[string]int{"WaitForPayment": 60}, updated.States)
+
+	// The execution pausing in the Message state gets its timeout scheduled once
+	started := time.Now()
+	record := &repository.ExecutionRecord{ExecutionID: "exec-5", StateMachineID: "orders", Name: "order-exec-5", Status: "PAUSED", CurrentState: "WaitForPayment", StartTime: &started}
+	repo.correlations = append(repo.correlations, &repository.MessageCorrelationRecord{
+		ID: "corr-exec-5-WaitForPayment", ExecutionID: "exec-5", StateMachineID: "orders", StateName: "WaitForPayment",
+		CorrelationKey: "orderId", CorrelationValue: float64(5005), CreatedAt: started.Unix(), Status: "WAITING",
+	})
+	timeoutManager := middleware.NewWaitTimeoutRepositoryManager(manager, q)
+	assert.Same(t, timeoutManager, middleware.NewWaitTimeoutRepositoryManager(timeoutManager, q))
+	assert.NoError(t, timeoutManager.GetRepository().SaveExecution(context.Background(), record))
+	assert.NoError(t, timeoutManager.GetRepository().SaveExecution(context.Background(), record))
+
+	if assert.Len(t, q.timeouts, 1) {
+		assert.Equal(t, "corr-exec-5-WaitForPayment", q.timeouts[0].CorrelationID)
+		assert.Equal(t, "WaitForPayment", q.timeouts[0].StateName)
+		assert.Equal(t, 60, q.timeouts[0].TimeoutSeconds)
+	}
+
+	expiring := func(query string) models.ListExpiringExecutionsResponse {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, createRequest(http.MethodGet, "/state-machines/orders/waiting/expiring"+query, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response models.ListExpiringExecutionsResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+	assert.Equal(t, 0, expiring("?withinSeconds=30").Total)
+	list := expiring("")
+	if assert.Len(t, list.Executions, 1) {
+		assert.Equal(t, "exec-5", list.Executions[0].ExecutionID)
+		assert.Equal(t, "WaitForPayment", list.Executions[0].StateName)
+		assert.InDelta(t, 60, list.Executions[0].ExpiresIn, 2)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, createRequest(http.MethodGet, "/state-machines/missing/waiting/expiring", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// ==================== Recovery Tests ====================
+
+// orphanedRepository serves executions to the recovery scanner
+type orphanedRepository struct {
+	pausedRepository
+}
+
+func (r *orphanedRepository) SaveExecution(_ context.Context, record *repository.ExecutionRecord) error {
+	r.executions[record.ExecutionID] = record
+	return nil
+}
+
+func (r *orphanedRepository) FindOrphanedExecutions(_ context.Context, stateMachineID string, threshold time.Duration) ([]*repository.ExecutionRecord, error) {
+	var found []*repository.ExecutionRecord
+	for _, record := range r.executions {
+		if record.StateMachineID == stateMachineID && record.Status == "RUNNING" && record.StartTime.Before(time.Now().Add(-threshold)) {
+			found = append(found, record)
+		}
+	}
+	return found, nil
+}
+
+func (r *orphanedRepository) GetStateHistory(_ context.Context, _ string) ([]*repository.StateHistoryRecord, error) {
+	return nil, nil
+}
+
+func (r *orphanedRepository) ListStateMachines(_ context.Context, _ *repository.DefinitionFilter) ([]*repository.StateMachineRecord, error) {
+	records := make([]*repository.StateMachineRecord, 0, len(r.stateMachines))
+	for _, record := range r.stateMachines {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func TestRecovery_ConfigureListScanAndAttempts(t *testing.T) {
+	crashed := time.Now().Add(-2 * time.Hour)
+	started := time.Now()
+	repo := &orphanedRepository{pausedRepository{
+		recordingRepository: recordingRepository{stateMachines: map[string]*repository.StateMachineRecord{"orders": {ID: "orders"}}},
+		executions: map[string]*repository.ExecutionRecord{
+			"exec-1": {ExecutionID: "exec-1", StateMachineID: "orders", Name: "order-1", Status: "RUNNING", CurrentState: "Charge", StartTime: &crashed},
+			"exec-2": {ExecutionID: "exec-2", StateMachineID: "orders", Name: "order-2", Status: "RUNNING", CurrentState: "Charge", StartTime: &started},
+			"exec-3": {ExecutionID: "exec-3", StateMachineID: "orders", Name: "order-3", Status: "SUCCEEDED", StartTime: &crashed},
+		},
+	}}
+	manager := repository.NewManagerWithRepository(repo)
+	redisServer := miniredis.RunT(t)
+	scanner := recovery.NewScanner(redis.NewClient(&redis.Options{Addr: redisServer.Addr()}), manager, nil)
+	scanner.Defaults.Strategy = recovery.StrategyFail
+
+	router := setupTestRouter()
+	router.Use(func(c *gin.Context) {
+		c.Set("repositoryManager", manager)
+		c.Set("recoveryScanner", scanner)
+		c.Next()
+	})
+	router.GET("/state-machines/:stateMachineId/recovery", GetRecoveryConfig)
+	router.PUT("/state-machines/:stateMachineId/recovery", UpdateRecoveryConfig)
+	router.GET("/state-machines/:stateMachineId/recovery/orphans", ListOrphanedExecutions)
+	router.POST("/state-machines/:stateMachineId/recovery/scan", TriggerRecoveryScan)
+	router.GET("/state-machines/:stateMachineId/recovery/attempts", ListRecoveryAttempts)
+
+	serve := func(method, path string, body interface{}, out interface{}) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, createRequest(method, path, body))
+		if out != nil {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), out))
+		}
+		return w.Code
+	}
+
+	var config models.RecoveryConfigResponse
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/state-machines/orders/recovery", nil, &config))
+	assert.True(t, config.Enabled)
+	assert.Equal(t, recovery.StrategyFail, config.Strategy)
+	assert.Equal(t, int64(300), config.OrphanedThresholdSeconds)
+	assert.Nil(t, config.LastScan)
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPut, "/state-machines/orders/recovery", map[string]interface{}{"strategy": "RESTART"}, nil))
+	assert.Equal(t, http.StatusOK, serve(http.MethodPut, "/state-machines/orders/recovery", map[string]interface{}{
+		"enabled": false, "strategy": "PAUSE", "orphanedThresholdSeconds": 60,
+	}, &config))
+	assert.False(t, config.Enabled)
+	assert.Equal(t, recovery.StrategyPause, config.Strategy)
+	assert.Equal(t, int64(60), config.OrphanedThresholdSeconds)
+	assert.Equal(t, recovery.DefaultMaxRecoveryAttempts, config.MaxRecoveryAttempts)
+	assert.Equal(t, "PAUSE", config.Overrides.Strategy)
+
+	// Only executions RUNNING longer than the threshold are orphaned
+	var orphans models.ListOrphanedExecutionsResponse
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/state-machines/orders/recovery/orphans", nil, &orphans))
+	if assert.Len(t, orphans.Executions, 1) {
+		assert.Equal(t, "exec-1", orphans.Executions[0].ExecutionID)
+		assert.GreaterOrEqual(t, orphans.Executions[0].RunningSeconds, int64(7200))
+	}
+
+	// Triggered scans run while automatic recovery is disabled
+	var scan models.TriggerRecoveryScanResponse
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/state-machines/orders/recovery/scan", nil, &scan))
+	assert.Equal(t, 1, scan.Scan.Orphans)
+	assert.True(t, scan.Scan.Manual)
+	if assert.Len(t, scan.Attempts, 1) {
+		assert.Equal(t, recovery.OutcomePaused, scan.Attempts[0].Outcome)
+		assert.Equal(t, "PAUSED", scan.Attempts[0].Status)
+		assert.Equal(t, 1, scan.Attempts[0].Attempt)
+		assert.Equal(t, []string{scan.Attempts[0].ID}, scan.Scan.AttemptIDs)
+	}
+	assert.Equal(t, "PAUSED", repo.executions["exec-1"].Status)
+
+	// Automatic scans leave disabled state machines alone and cover new ones
+	repo.stateMachines["refunds"] = &repository.StateMachineRecord{ID: "refunds"}
+	repo.executions["exec-4"] = &repository.ExecutionRecord{ExecutionID: "exec-4", StateMachineID: "refunds", Name: "refund-4", Status: "RUNNING", CurrentState: "Refund", StartTime: &crashed}
+	repo.executions["exec-5"] = &repository.ExecutionRecord{ExecutionID: "exec-5", StateMachineID: "orders", Name: "order-5", Status: "RUNNING", CurrentState: "Charge", StartTime: &crashed}
+	assert.NoError(t, scanner.ScanDue(context.Background()))
+	assert.Equal(t, "FAILED", repo.executions["exec-4"].Status)
+	assert.Equal(t, "RUNNING", repo.executions["exec-5"].Status)
+
+	var attempts models.ListRecoveryAttemptsResponse
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/state-machines/refunds/recovery/attempts", nil, &attempts))
+	if assert.Len(t, attempts.Attempts, 1) {
+		assert.Equal(t, recovery.OutcomeAbandoned, attempts.Attempts[0].Outcome)
+		assert.False(t, attempts.Attempts[0].Manual)
+	}
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/state-machines/orders/recovery/attempts?outcome=ABANDONED", nil, &attempts))
+	assert.Equal(t, 0, attempts.Total)
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/state-machines/orders/recovery/attempts?executionId=exec-1", nil, &attempts))
+	assert.Equal(t, 1, attempts.Total)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/state-machines/orders/recovery/attempts?outcome=LOST", nil, nil))
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/state-machines/orders/recovery", nil, &config))
+	if assert.NotNil(t, config.LastScan) {
+		assert.True(t, config.LastScan.Manual)
+	}
+
+	// An empty body restores the defaults
+	assert.Equal(t, http.StatusOK, serve(http.MethodPut, "/state-machines/orders/recovery", map[string]interface{}{}, &config))
+	assert.True(t, config.Enabled)
+	assert.NotContains(t, repo.stateMachines["orders"].Metadata, recovery.SettingsKey)
+
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodPost, "/state-machines/missing/recovery/scan", nil, nil))
+	unconfigured := setupTestRouter()
+	unconfigured.GET("/state-machines/:stateMachineId/recovery", GetRecoveryConfig)
+	w := httptest.NewRecorder()
+	unconfigured.ServeHTTP(w, createRequest(http.MethodGet, "/state-machines/orders/recovery", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), models.CodeRecoveryNotConfigured)
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hussainpithawala/state-machine-amz-gin/inbox"
+	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
+	"github.com/hussainpithawala/state-machine-amz-gin/models"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+)
+
+// messageInbox returns the inbox of buffered messages, or writes a 500. Messages are only
+// buffered when they can be delivered, which takes Redis and a queue client.
+func messageInbox(c *gin.Context) (*inbox.Store, bool) {
+	store, ok := middleware.GetMessageInbox(c)
+	if !ok {
+		respondNotConfigured(c, models.CodeRedisNotConfigured, "Message inbox not configured (needs Redis and a queue client)")
+		return nil, false
+	}
+	return store, true
+}
+
+// respondInboxError reports a missing message as 404, a message being delivered as 409 and
+// a failed Redis call as 503
+func respondInboxError(c *gin.Context, err error, title string) {
+	switch {
+	case errors.Is(err, inbox.ErrNotFound):
+		respondError(c, http.StatusNotFound, models.CodeMessageNotFound, "Message not found", "")
+	case errors.Is(err, inbox.ErrClaimed):
+		respondError(c, http.StatusConflict, models.CodeMessageBeingDelivered, "Message is being delivered", "The message is being delivered to an execution that just paused")
+	default:
+		respondError(c, http.StatusServiceUnavailable, models.CodeRedisUnavailable, title, err.Error())
+	}
+}
+
+func messageResponse(message *inbox.Message) *models.MessageResponse {
+	return &models.MessageResponse{
+		ID:               message.ID,
+		StateMachineID:   message.StateMachineID,
+		CorrelationKey:   message.CorrelationKey,
+		CorrelationValue: message.CorrelationValue,
+		Output:           message.Output,
+		Status:           message.Status,
+		CreatedAt:        message.CreatedAt,
+		ExpiresAt:        message.ExpiresAt,
+		DeliveredAt:      message.DeliveredAt,
+		ExecutionID:      message.ExecutionID,
+		Deliveries:       message.Deliveries,
+	}
+}
+
+// stateMachineMessage returns a message of the state machine in the path, or writes a 404
+func stateMachineMessage(c *gin.Context, store *inbox.Store) (*inbox.Message, bool) {
+	message, err := store.Get(c.Request.Context(), c.Param("messageId"))
+	if err == nil && message.StateMachineID != c.Param("stateMachineId") {
+		err = inbox.ErrNotFound
+	}
+	if err != nil {
+		respondInboxError(c, err, "Failed to read message")
+		return nil, false
+	}
+	return message, true
+}
+
+// bufferMessage keeps a message no execution waits for in the inbox. An execution that
+// paused after the lookup may have missed it; the lookup is repeated, and when an
+// execution is found the message is taken back and the records returned to resume.
+func bufferMessage(c *gin.Context, repoManager *repository.Manager, store *inbox.Store, stateMachineID string, req *models.ResumeByCorrelationRequest, limit int) ([]*repository.ExecutionRecord, *inbox.Message, error) {
+	ctx := c.Request.Context()
+	message := &inbox.Message{
+		StateMachineID:   stateMachineID,
+		CorrelationKey:   req.CorrelationKey,
+		CorrelationValue: req.CorrelationValue.Value(),
+		Output:           req.Output,
+	}
+	if err := store.Put(ctx, message, time.Duration(req.MessageTTLSeconds)*time.Second); err != nil {
+		return nil, nil, err
+	}
+
+	records, err := waitingExecutions(ctx, repoManager, stateMachineID, req.CorrelationKey, req.CorrelationValue, limit)
+	if err != nil || len(records) == 0 || !unbuffer(ctx, store, message.ID) {
+		return nil, message, nil
+	}
+	return records, nil, nil
+}
+
+// unbuffer removes a message nothing has delivered yet, reporting whether it did
+func unbuffer(ctx context.Context, store *inbox.Store, id string) bool {
+	message, err := store.Take(ctx, id)
+	if err != nil || message.Status != inbox.StatusPending {
+		return false
+	}
+	return store.Delete(ctx, id) == nil
+}
+
+// ListMessages lists the messages buffered for a state machine, oldest first
+//
+// Query parameters:
+// - status: PENDING or DELIVERED (optional)
+// - correlationKey: Only messages for this key (optional)
+// - limit: Page size, 1-1000 (optional, default: 100)
+// - offset: Messages to skip (optional, default: 0)
+func ListMessages(c *gin.Context) {
+	store, ok := messageInbox(c)
+	if !ok {
+		return
+	}
+
+	var errs fieldErrors
+	status := queryOneOf(c, &errs, "status", inbox.StatusPending, inbox.StatusDelivered)
+	correlationKey := c.Query("correlationKey")
+	limit := queryInt(c, &errs, "limit", 100, 1, 1000)
+	offset := queryInt(c, &errs, "offset", 0, 0, math.MaxInt32)
+	if errs.respond(c) {
+		return
+	}
+
+	all, err := store.List(c.Request.Context(), c.Param("stateMachineId"))
+	if err != nil {
+		respondInboxError(c, err, "Failed to list messages")
+		return
+	}
+
+	matching := make([]*models.MessageResponse, 0, len(all))
+	for _, message := range all {
+		if (status == "" || message.Status == status) && (correlationKey == "" || message.CorrelationKey == correlationKey) {
+			matching = append(matching, messageResponse(message))
+		}
+	}
+	page := matching[min(offset, len(matching)):min(offset+limit, len(matching))]
+
+	c.JSON(http.StatusOK, models.ListMessagesResponse{
+		Messages: page,
+		Total:    len(matching),
+		Limit:    limit,
+		Offset:   offset,
+	})
+}
+
+// GetMessage returns a buffered message
+func GetMessage(c *gin.Context) {
+	store, ok := messageInbox(c)
+	if !ok {
+		return
+	}
+	message, ok := stateMachineMessage(c, store)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, messageResponse(message))
+}
+
+// DeleteMessage removes a buffered message, so it is never delivered
+func DeleteMessage(c *gin.Context) {
+	store, ok := messageInbox(c)
+	if !ok {
+		return
+	}
+	message, ok := stateMachineMessage(c, store)
+	if !ok {
+		return
+	}
+	if err := store.Delete(c.Request.Context(), message.ID); err != nil {
+		respondInboxError(c, err, "Failed to delete message")
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Message: "Message deleted",
+		Data:    gin.H{"messageId": message.ID},
+	})
+}
+
+// RedeliverMessage delivers a buffered message to the oldest execution waiting for its
+// correlation, whether or not it was delivered before. When no execution waits or the
+// delivery fails, the message is pending again. The delivery is queued for a worker and
+// answered with 202 when a queue client is configured; the query parameters are those of
+// ResumeExecution.
+func RedeliverMessage(c *gin.Context) {
+	repoManager, ok := middleware.GetRepositoryManager(c)
+	if !ok {
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
+		return
+	}
+	store, ok := messageInbox(c)
+	if !ok {
+		return
+	}
+
+	redeliverMessage(c, repoManager, store, queueEnqueuer(c))
+}
+
+// redeliverMessage delivers a message, queued on enqueuer unless it is nil or the request
+// asks to wait
+func redeliverMessage(c *gin.Context, repoManager *repository.Manager, store *inbox.Store, enqueuer executionEnqueuer) {
+	var errs fieldErrors
+	options := parseResumeOptions(c, &errs)
+	if errs.respond(c) {
+		return
+	}
+	if options.wait {
+		enqueuer = nil
+	}
+	if _, ok := stateMachineMessage(c, store); !ok {
+		return
+	}
+	message, err := store.Take(c.Request.Context(), c.Param("messageId"))
+	if err != nil {
+		respondInboxError(c, err, "Failed to take message")
+		return
+	}
+
+	ctx, cancel := resumeContext(c, options)
+	defer cancel()
+
+	correlationValue := models.NewCorrelationValue(message.CorrelationValue)
+	records, err := waitingExecutions(ctx, repoManager, message.StateMachineID, message.CorrelationKey, correlationValue, 1)
+	if err != nil || len(records) == 0 {
+		releaseMessage(c, store, message)
+		if err != nil {
+			respondRepositoryError(c, "Failed to find waiting executions", err)
+			return
+		}
+		c.JSON(http.StatusOK, models.RedeliverMessageResponse{Message: messageResponse(message)})
+		return
+	}
+
+	record := records[0]
+	result, paused := newResumeResult(record)
+	status := http.StatusOK
+	switch {
+	case !paused:
+	case enqueuer != nil:
+		if _, err := enqueueResume(c, enqueuer, record, message.Output); err != nil {
+			failResume(&result, enqueueErrorCode(err), err)
+		} else {
+			result.Result = ResumeResultQueued
+			status = http.StatusAccepted
+		}
+	default:
+		sm, ok := resumeStateMachine(ctx, c, repoManager, record.StateMachineID)
+		if !ok {
+			releaseMessage(c, store, message)
+			return
+		}
+		resumeOne(ctx, sm, record, message.Output, &result)
+	}
+
+	if result.Result == ResumeResultFailed {
+		releaseMessage(c, store, message)
+		c.JSON(status, models.RedeliverMessageResponse{Message: messageResponse(message), Result: &result})
+		return
+	}
+	if err := store.Delivered(c.Request.Context(), message, record.ExecutionID); err != nil {
+		respondInboxError(c, err, "Failed to record the delivery")
+		return
+	}
+	c.JSON(status, models.RedeliverMessageResponse{Message: messageResponse(message), Delivered: true, Result: &result})
+}
+
+// releaseMessage makes a message that was not delivered pending again
+func releaseMessage(c *gin.Context, store *inbox.Store, message *inbox.Message) {
+	if err := store.Release(c.Request.Context(), message); err != nil {
+		log.Printf("Failed to release message %s: %v", message.ID, err)
+	}
+}
@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPathStep is one member or array index of a JSONPath
+type jsonPathStep struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// jsonPathExpr is a JSONPath made of member and index steps only, such as $.items[0].sku.
+// It is the subset that can be evaluated in memory and translated to Postgres jsonpath.
+type jsonPathExpr []jsonPathStep
+
+// parseJSONPath parses $, $.name, $['name'] and $[0] steps
+func parseJSONPath(path string) (jsonPathExpr, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, errors.New("path must start with $")
+	}
+
+	var expr jsonPathExpr
+	rest := path[1:]
+	for rest != "" {
+		switch {
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			key := rest[1 : end+1]
+			if key == "" {
+				return nil, fmt.Errorf("empty member name in %q", path)
+			}
+			expr = append(expr, jsonPathStep{key: key})
+			rest = rest[end+1:]
+		case strings.HasPrefix(rest, "['") || strings.HasPrefix(rest, `["`):
+			quote := rest[1]
+			end := strings.IndexByte(rest[2:], quote)
+			if end < 0 || !strings.HasPrefix(rest[2+end+1:], "]") {
+				return nil, fmt.Errorf("unterminated member name in %q", path)
+			}
+			expr = append(expr, jsonPathStep{key: rest[2 : 2+end]})
+			rest = rest[2+end+2:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated index in %q", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid array index %q in %q", rest[1:end], path)
+			}
+			expr = append(expr, jsonPathStep{index: index, isIndex: true})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected %q in %q", rest[:1], path)
+		}
+	}
+	return expr, nil
+}
+
+// String renders the path as a Postgres jsonpath with quoted member names
+func (p jsonPathExpr) String() string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, step := range p {
+		if step.isIndex {
+			fmt.Fprintf(&b, "[%d]", step.index)
+			continue
+		}
+		b.WriteString(".")
+		b.WriteString(jsonString(step.key))
+	}
+	return b.String()
+}
+
+// textArray renders the path as a Postgres text[] literal for the #>> operator
+func (p jsonPathExpr) textArray() string {
+	parts := make([]string, len(p))
+	for i, step := range p {
+		if step.isIndex {
+			parts[i] = strconv.Itoa(step.index)
+			continue
+		}
+		parts[i] = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(step.key) + `"`
+	}
+	return "'{" + strings.ReplaceAll(strings.Join(parts, ","), "'", "''") + "}'"
+}
+
+// lookup returns the value at the path and whether it exists
+func (p jsonPathExpr) lookup(document interface{}) (interface{}, bool) {
+	value := document
+	for _, step := range p {
+		if step.isIndex {
+			items, ok := value.([]interface{})
+			if !ok || step.index >= len(items) {
+				return nil, false
+			}
+			value = items[step.index]
+			continue
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[step.key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// jsonPredicateOperators are the comparison operators of a predicate, longest first
+var jsonPredicateOperators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// jsonPredicate is a JSONPath comparison such as $.orderId == "12345". A predicate
+// without an operator matches documents in which the path exists.
+type jsonPredicate struct {
+	Path     jsonPathExpr
+	Operator string
+	Value    interface{}
+}
+
+// parseJSONPredicate parses "<path> [<operator> <JSON literal>]"
+func parseJSONPredicate(predicate string) (*jsonPredicate, error) {
+	predicate = strings.TrimSpace(predicate)
+	end := jsonPathEnd(predicate)
+	path, err := parseJSONPath(predicate[:end])
+	if err != nil {
+		return nil, err
+	}
+	result := &jsonPredicate{Path: path}
+
+	rest := strings.TrimSpace(predicate[end:])
+	if rest == "" {
+		return result, nil
+	}
+	for _, operator := range jsonPredicateOperators {
+		if strings.HasPrefix(rest, operator) {
+			result.Operator = operator
+			rest = strings.TrimSpace(rest[len(operator):])
+			break
+		}
+	}
+	if result.Operator == "" {
+		return nil, fmt.Errorf("expected one of %s after the path", strings.Join(jsonPredicateOperators, " "))
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(rest)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&result.Value); err != nil || decoder.More() {
+		return nil, fmt.Errorf("value %q must be a JSON string, number, boolean or null", rest)
+	}
+	switch result.Value.(type) {
+	case map[string]interface{}, []interface{}:
+		return nil, fmt.Errorf("value %q must be a JSON string, number, boolean or null", rest)
+	}
+	if number, ok := result.Value.(json.Number); ok {
+		if result.Value, err = number.Float64(); err != nil {
+			return nil, fmt.Errorf("invalid number %q", rest)
+		}
+	}
+	if _, ok := result.Value.(bool); ok && result.Operator != "==" && result.Operator != "!=" {
+		return nil, errors.New("booleans can only be compared with == or !=")
+	}
+	if result.Value == nil && result.Operator != "==" && result.Operator != "!=" {
+		return nil, errors.New("null can only be compared with == or !=")
+	}
+	return result, nil
+}
+
+// jsonPathEnd returns the length of the path at the start of a predicate. Spaces and
+// operator characters inside quoted member names belong to the path.
+func jsonPathEnd(predicate string) int {
+	var quote byte
+	for i := 0; i < len(predicate); i++ {
+		switch ch := predicate[i]; {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == ' ' || strings.IndexByte("=!<>", ch) >= 0:
+			return i
+		}
+	}
+	return len(predicate)
+}
+
+// jsonPath renders the predicate as a strict Postgres jsonpath. Strict mode keeps the
+// semantics of match: missing members and type mismatches never match.
+func (p *jsonPredicate) jsonPath() string {
+	if p.Operator == "" {
+		return "strict " + p.Path.String()
+	}
+	value, _ := json.Marshal(p.Value)
+	return "strict " + p.Path.String() + " " + p.Operator + " " + string(value)
+}
+
+// sql returns the condition matching the predicate against a JSONB column and its argument
+func (p *jsonPredicate) sql(column string) (string, interface{}) {
+	if p.Operator == "" {
+		return "jsonb_path_exists(" + column + ", CAST(? AS jsonpath), '{}', true)", p.jsonPath()
+	}
+	return column + " @@ CAST(? AS jsonpath)", p.jsonPath()
+}
+
+// match evaluates the predicate against a decoded JSON document
+func (p *jsonPredicate) match(document interface{}) bool {
+	value, ok := p.Path.lookup(document)
+	if !ok {
+		return false
+	}
+	if p.Operator == "" {
+		return true
+	}
+
+	switch expected := p.Value.(type) {
+	case nil:
+		return (value == nil) == (p.Operator == "==")
+	case bool:
+		actual, ok := value.(bool)
+		return ok && (actual == expected) == (p.Operator == "==")
+	case float64:
+		actual, ok := toFloat(value)
+		return ok && compareOrdered(actual, expected, p.Operator)
+	case string:
+		actual, ok := value.(string)
+		return ok && compareOrdered(actual, expected, p.Operator)
+	}
+	return false
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func compareOrdered[T float64 | string](actual, expected T, operator string) bool {
+	switch operator {
+	case "==":
+		return actual == expected
+	case "!=":
+		return actual != expected
+	case "<":
+		return actual < expected
+	case "<=":
+		return actual <= expected
+	case ">":
+		return actual > expected
+	case ">=":
+		return actual >= expected
+	}
+	return false
+}
+
+// jsonString quotes a member name the way both JSON and Postgres jsonpath accept
+func jsonString(value string) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
@@ -2,93 +2,206 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/hibiken/asynq"
+	"github.com/hussainpithawala/state-machine-amz-gin/inbox"
 	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
 	"github.com/hussainpithawala/state-machine-amz-gin/models"
 	"github.com/hussainpithawala/state-machine-amz-go/pkg/batch"
-	"github.com/hussainpithawala/state-machine-amz-go/pkg/execution"
 	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
 	"github.com/hussainpithawala/state-machine-amz-go/pkg/statemachine/persistent"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/types"
 )
 
-// ResumeExecution resumes a paused execution (Message state)
+// Timeouts of resumes that run in the request
+const (
+	defaultResumeWaitSeconds = 60
+	maxResumeWaitSeconds     = 3600
+)
+
+// resumeOptions are the query parameters of the resume endpoints
+type resumeOptions struct {
+	wait    bool
+	timeout time.Duration
+}
+
+// parseResumeOptions reads the wait and timeoutSeconds query parameters
+func parseResumeOptions(c *gin.Context, errs *fieldErrors) resumeOptions {
+	var options resumeOptions
+	if raw := c.Query("wait"); raw != "" {
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			errs.add("wait", "must be a boolean")
+		}
+		options.wait = value
+	}
+	seconds := queryInt(c, errs, "timeoutSeconds", defaultResumeWaitSeconds, 1, maxResumeWaitSeconds)
+	options.timeout = time.Duration(seconds) * time.Second
+	return options
+}
+
+// queueEnqueuer returns the queue client, or nil when none is configured
+func queueEnqueuer(c *gin.Context) executionEnqueuer {
+	if queueClient, ok := middleware.GetQueueClient(c); ok && queueClient != nil {
+		return queueClient
+	}
+	return nil
+}
+
+// enqueueResume queues the resume of a paused execution for a worker. An execution has a
+// single resume queued at a time for the state it waits in. A resume task left behind,
+// archived after its retries ran out or kept after it completed, is replaced.
+func enqueueResume(c *gin.Context, enqueuer executionEnqueuer, record *repository.ExecutionRecord, output interface{}) (*asynq.TaskInfo, error) {
+	taskID := middleware.ResumeTaskIDPrefix + record.ExecutionID + "-" + record.CurrentState
+	opts := taskOptions(c, record.StateMachineID, time.Time{})
+	opts = append(opts, asynq.TaskID(taskID))
+	payload := middleware.NewResumeTaskPayload(record, output)
+	info, err := enqueuer.EnqueueExecution(payload, opts...)
+	if errors.Is(err, asynq.ErrTaskIDConflict) && deleteFinishedTask(c, record.StateMachineID, taskID) {
+		return enqueuer.EnqueueExecution(payload, opts...)
+	}
+	return info, err
+}
+
+// deleteFinishedTask deletes a task that is archived or completed, so its ID can be
+// reused. It reports whether the task was deleted.
+func deleteFinishedTask(c *gin.Context, queueName, taskID string) bool {
+	inspector, ok := middleware.GetQueueInspector(c)
+	if !ok || inspector == nil {
+		return false
+	}
+	info, err := inspector.GetTaskInfo(queueName, taskID)
+	if err != nil || (info.State != asynq.TaskStateArchived && info.State != asynq.TaskStateCompleted) {
+		return false
+	}
+	return inspector.DeleteTask(queueName, taskID) == nil
+}
+
+// resumeContext is the context of resumes that run in the request. It is bounded by the
+// timeout of options rather than by the client connection, so a client that disconnects
+// does not cancel a resume half way through the flow.
+func resumeContext(c *gin.Context, options resumeOptions) (context.Context, context.CancelFunc) {
+	ctx := context.WithoutCancel(c.Request.Context())
+	if baseExecutor, ok := middleware.GetBaseExecutor(c); ok {
+		ctx = context.WithValue(ctx, types.ExecutionContextKey, executionContext(c, baseExecutor))
+	}
+	return context.WithTimeout(ctx, options.timeout)
+}
+
+// resumeStateMachine loads the state machine of resumes that run in the request
+func resumeStateMachine(ctx context.Context, c *gin.Context, repoManager *repository.Manager, stateMachineID string) (*persistent.StateMachine, bool) {
+	sm, err := persistent.NewFromDefnId(ctx, stateMachineID, middleware.NewTaggingRepositoryManager(repoManager))
+	if err != nil {
+		respondStateMachineLoadError(c, err, "State machine not found")
+		return nil, false
+	}
+	if queueClient, ok := middleware.GetQueueClient(c); ok && queueClient != nil {
+		sm.SetQueueClient(queueClient)
+	}
+	return sm, true
+}
+
+// respondResumeError reports a resume that failed in the request, as 504 when it did not
+// finish within its timeout
+func respondResumeError(c *gin.Context, err error, options resumeOptions) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		respondError(c, http.StatusGatewayTimeout, models.CodeResumeTimedOut, "Resume did not finish in time",
+			fmt.Sprintf("The resume was cancelled after %s; check the execution status", options.timeout))
+		return
+	}
+	respondError(c, http.StatusInternalServerError, models.CodeExecutionFailed, "Failed to resume execution", err.Error())
+}
+
+// ResumeExecution resumes a paused execution (Message state). The resume is queued for a
+// worker and answered with 202 when a queue client is configured.
+//
+// Query parameters:
+// - wait: Resume in the request and respond with the execution (optional, default: false)
+// - timeoutSeconds: Time a resume in the request may take, 1-3600 (optional, default: 60)
 func ResumeExecution(c *gin.Context) {
 	repoManager, ok := middleware.GetRepositoryManager(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Repository manager not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 
+	resumeExecution(c, repoManager, queueEnqueuer(c))
+}
+
+// resumeExecution resumes a paused execution, queued on enqueuer unless it is nil or the
+// request asks to wait
+func resumeExecution(c *gin.Context, repoManager *repository.Manager, enqueuer executionEnqueuer) {
 	executionID := c.Param("executionId")
 
 	var req models.ResumeExecutionRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Invalid request",
-			Message: err.Error(),
-			Code:    http.StatusBadRequest,
-		})
+	if !bindJSON(c, &req) {
 		return
 	}
+	var errs fieldErrors
+	options := parseResumeOptions(c, &errs)
+	if errs.respond(c) {
+		return
+	}
+	if options.wait {
+		enqueuer = nil
+	}
 
 	// Get execution
 	record, err := repoManager.GetExecution(c.Request.Context(), executionID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error:   "Execution not found",
-			Message: err.Error(),
-			Code:    http.StatusNotFound,
-		})
+		respondLookupError(c, err, models.CodeExecutionNotFound, "Execution not found")
 		return
 	}
 
 	// Check if execution is paused
 	if record.Status != "PAUSED" {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Execution is not paused",
-			Message: "Only paused executions can be resumed",
-			Code:    http.StatusBadRequest,
-		})
+		respondError(c, http.StatusConflict, models.CodeExecutionNotPaused, "Execution is not paused", "Only paused executions can be resumed")
 		return
 	}
 
-	// Load state machine
-	sm, err := persistent.NewFromDefnId(c.Request.Context(), record.StateMachineID, repoManager)
-	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error:   "State machine not found",
-			Message: err.Error(),
-			Code:    http.StatusNotFound,
+	schemas, ok := executionSchemasFor(c, repoManager, record.StateMachineID)
+	if !ok {
+		return
+	}
+	if schemas.validateOutput("output", req.Output).respond(c) {
+		return
+	}
+
+	if enqueuer != nil {
+		info, err := enqueueResume(c, enqueuer, record, req.Output)
+		if err != nil {
+			respondEnqueueError(c, err, "Failed to queue resume")
+			return
+		}
+		c.JSON(http.StatusAccepted, models.ResumeQueuedResponse{
+			ExecutionID:    record.ExecutionID,
+			StateMachineID: record.StateMachineID,
+			TaskID:         info.ID,
+			Queue:          info.Queue,
 		})
 		return
 	}
 
-	// Create execution context from record
-	execCtx := &execution.Execution{
-		ID:             record.ExecutionID,
-		StateMachineID: record.StateMachineID,
-		Name:           record.Name,
-		Status:         record.Status,
-		CurrentState:   record.CurrentState,
-		Input:          record.Input,
-		Output:         req.Output, // Use the output from the resume request
-		StartTime:      *record.StartTime,
+	ctx, cancel := resumeContext(c, options)
+	defer cancel()
+
+	// Load state machine
+	sm, ok := resumeStateMachine(ctx, c, repoManager, record.StateMachineID)
+	if !ok {
+		return
 	}
 
-	// Resume execution
-	result, err := sm.ResumeExecution(c.Request.Context(), execCtx)
+	// Resume execution with the output from the resume request
+	result, err := middleware.ResumePausedExecution(ctx, sm, record, req.Output)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to resume execution",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondResumeError(c, err, options)
 		return
 	}
 
@@ -106,137 +219,332 @@ func ResumeExecution(c *gin.Context) {
 	})
 }
 
-// ResumeByCorrelation resumes executions waiting on a correlation key/value
+// Limits of resumes by correlation
+const (
+	defaultResumeMaxMatches  = 100
+	defaultResumeConcurrency = 10
+)
+
+// Outcomes of the executions matched by a resume by correlation
+const (
+	ResumeResultResumed = "RESUMED"
+	ResumeResultQueued  = "QUEUED"
+	ResumeResultFailed  = "FAILED"
+	ResumeResultMatched = "MATCHED" // Found by a dry run
+)
+
+// ResumeByCorrelation resumes the executions of a state machine waiting on a correlation
+// key/value and reports the outcome per execution. Nothing is resumed when more than
+// maxMatches executions wait, or with dryRun set. The resumes are queued for a worker and
+// answered with 202 when a queue client is configured; the query parameters are those of
+// ResumeExecution. When no execution waits and the message inbox is configured, the
+// message is buffered for messageTtlSeconds and delivered to the first execution that
+// pauses waiting for it.
 func ResumeByCorrelation(c *gin.Context) {
 	repoManager, ok := middleware.GetRepositoryManager(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Repository manager not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 
+	messages, _ := middleware.GetMessageInbox(c)
+	resumeByCorrelation(c, repoManager, queueEnqueuer(c), messages)
+}
+
+// resumeByCorrelation resumes the waiting executions, queued on enqueuer unless it is nil
+// or the request asks to wait. Messages no execution waits for are buffered in messages
+// unless it is nil.
+func resumeByCorrelation(c *gin.Context, repoManager *repository.Manager, enqueuer executionEnqueuer, messages *inbox.Store) {
 	stateMachineID := c.Param("stateMachineId")
 
 	var req models.ResumeByCorrelationRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Invalid request",
-			Message: err.Error(),
-			Code:    http.StatusBadRequest,
-		})
+	if !bindJSON(c, &req) {
+		return
+	}
+	var errs fieldErrors
+	if req.CorrelationValue.Value() == nil {
+		errs.add("correlationValue", "is required")
+	}
+	options := parseResumeOptions(c, &errs)
+	if errs.respond(c) {
 		return
 	}
+	if options.wait {
+		enqueuer = nil
+	}
+	maxMatches, concurrency := resumeLimits(&req)
 
-	// Load state machine
-	sm, err := persistent.NewFromDefnId(c.Request.Context(), stateMachineID, repoManager)
-	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error:   "State machine not found",
-			Message: err.Error(),
-			Code:    http.StatusNotFound,
-		})
+	schemas, ok := executionSchemasFor(c, repoManager, stateMachineID)
+	if !ok {
+		return
+	}
+	if schemas.validateOutput("output", req.Output).respond(c) {
 		return
 	}
 
-	// Find waiting executions
-	waitingExecutions, err := sm.FindWaitingExecutionsByCorrelation(
-		c.Request.Context(),
-		req.CorrelationKey,
-		req.CorrelationValue,
-	)
+	// One execution more than maxMatches is looked up to tell whether there are too many
+	records, err := waitingExecutions(c.Request.Context(), repoManager, stateMachineID, req.CorrelationKey, req.CorrelationValue, maxMatches+1)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to find waiting executions",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondRepositoryError(c, "Failed to find waiting executions", err)
 		return
 	}
+	if len(records) == 0 {
+		if records, ok = bufferUnmatched(c, repoManager, messages, stateMachineID, &req, maxMatches+1); !ok {
+			return
+		}
+	}
 
-	if len(waitingExecutions) == 0 {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error:   "No waiting executions found",
-			Message: "No executions are waiting for this correlation",
-			Code:    http.StatusNotFound,
-		})
+	moreMatches := len(records) > maxMatches
+	if req.DryRun {
+		response := resumeByCorrelationResponse(matchedResults(records[:min(len(records), maxMatches)]))
+		response.DryRun = true
+		response.MoreMatches = moreMatches
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	if moreMatches {
+		respondError(c, http.StatusConflict, models.CodeTooManyMatches, "Too many waiting executions",
+			fmt.Sprintf("More than %d executions are waiting for this correlation and none was resumed; raise maxMatches or preview them with dryRun", maxMatches))
 		return
 	}
 
-	// Resume each execution
-	resumedIDs := make([]string, 0, len(waitingExecutions))
-	for _, record := range waitingExecutions {
-		execCtx := &execution.Execution{
-			ID:             record.ExecutionID,
-			StateMachineID: record.StateMachineID,
-			Name:           record.Name,
-			Status:         record.Status,
-			CurrentState:   record.CurrentState,
-			Input:          record.Input,
-			Output:         req.Output,
-			StartTime:      *record.StartTime,
+	if enqueuer != nil {
+		response := resumeByCorrelationResponse(queueResumes(c, enqueuer, records, req.Output))
+		response.Queued = true
+		c.JSON(http.StatusAccepted, response)
+		return
+	}
+
+	ctx, cancel := resumeContext(c, options)
+	defer cancel()
+
+	// Load state machine
+	sm, ok := resumeStateMachine(ctx, c, repoManager, stateMachineID)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, resumeByCorrelationResponse(resumeAll(ctx, sm, records, req.Output, concurrency)))
+}
+
+// bufferUnmatched buffers a message no execution waits for in messages and answers with
+// 202, or with 404 when there is no inbox or the request is a dry run. The executions that
+// paused while the message was buffered are returned instead, with true.
+func bufferUnmatched(c *gin.Context, repoManager *repository.Manager, messages *inbox.Store, stateMachineID string, req *models.ResumeByCorrelationRequest, limit int) ([]*repository.ExecutionRecord, bool) {
+	if messages == nil || req.DryRun {
+		respondError(c, http.StatusNotFound, models.CodeNoWaitingExecutions, "No waiting executions found", "No executions are waiting for this correlation")
+		return nil, false
+	}
+	records, message, err := bufferMessage(c, repoManager, messages, stateMachineID, req, limit)
+	if err != nil {
+		respondInboxError(c, err, "Failed to buffer message")
+		return nil, false
+	}
+	if message != nil {
+		c.JSON(http.StatusAccepted, models.ResumeByCorrelationResponse{
+			ExecutionIDs: []string{},
+			Results:      []models.ResumeResult{},
+			Buffered:     true,
+			MessageID:    message.ID,
+		})
+		return nil, false
+	}
+	return records, true
+}
+
+// resumeLimits returns the maxMatches and concurrency of a request, or their defaults
+func resumeLimits(req *models.ResumeByCorrelationRequest) (maxMatches, concurrency int) {
+	maxMatches, concurrency = req.MaxMatches, req.Concurrency
+	if maxMatches == 0 {
+		maxMatches = defaultResumeMaxMatches
+	}
+	if concurrency == 0 {
+		concurrency = defaultResumeConcurrency
+	}
+	return maxMatches, concurrency
+}
+
+// waitingExecutions returns up to limit executions of a state machine waiting for a
+// correlation, oldest first. Zero means no limit.
+func waitingExecutions(ctx context.Context, repoManager *repository.Manager, stateMachineID, correlationKey string, correlationValue models.CorrelationValue, limit int) ([]*repository.ExecutionRecord, error) {
+	messages, ok := repoManager.GetRepository().(repository.MessageRepository)
+	if !ok {
+		return nil, errors.New("repository does not support message correlation")
+	}
+	correlations, err := messages.FindWaitingCorrelations(ctx, &repository.MessageCorrelationFilter{
+		CorrelationKey:   correlationKey,
+		CorrelationValue: correlationValue.Value(),
+		Status:           "WAITING",
+		StateMachineID:   stateMachineID,
+		Limit:            limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find correlations: %w", err)
+	}
+
+	records := make([]*repository.ExecutionRecord, 0, len(correlations))
+	for _, correlation := range correlations {
+		record, err := repoManager.GetExecution(ctx, correlation.ExecutionID)
+		if err != nil {
+			// The execution was deleted after it paused
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// newResumeResult starts the result of resuming an execution. Executions that are no
+// longer paused fail straight away.
+func newResumeResult(record *repository.ExecutionRecord) (models.ResumeResult, bool) {
+	result := models.ResumeResult{ExecutionID: record.ExecutionID, Name: record.Name}
+	if record.Status != StatusPaused {
+		result.Result = ResumeResultFailed
+		result.Code = models.CodeExecutionNotPaused
+		result.Error = fmt.Sprintf("execution is %s", record.Status)
+		return result, false
+	}
+	return result, true
+}
+
+func failResume(result *models.ResumeResult, code string, err error) {
+	result.Result = ResumeResultFailed
+	result.Code = code
+	result.Error = err.Error()
+}
+
+// matchedResults lists the executions a dry run would resume
+func matchedResults(records []*repository.ExecutionRecord) []models.ResumeResult {
+	results := make([]models.ResumeResult, len(records))
+	for i, record := range records {
+		result, ok := newResumeResult(record)
+		if ok {
+			result.Result = ResumeResultMatched
 		}
+		results[i] = result
+	}
+	return results
+}
 
-		_, err := sm.ResumeExecution(c.Request.Context(), execCtx)
-		if err == nil {
-			resumedIDs = append(resumedIDs, record.ExecutionID)
+// queueResumes queues the resume of every waiting execution. Executions that already have
+// a resume queued fail with DUPLICATE_TASK.
+func queueResumes(c *gin.Context, enqueuer executionEnqueuer, records []*repository.ExecutionRecord, output interface{}) []models.ResumeResult {
+	results := make([]models.ResumeResult, len(records))
+	for i, record := range records {
+		result, ok := newResumeResult(record)
+		if ok {
+			if _, err := enqueueResume(c, enqueuer, record, output); err != nil {
+				failResume(&result, enqueueErrorCode(err), err)
+			} else {
+				result.Result = ResumeResultQueued
+			}
 		}
+		results[i] = result
 	}
+	return results
+}
 
-	c.JSON(http.StatusOK, models.ResumeByCorrelationResponse{
-		ResumedCount: len(resumedIDs),
-		ExecutionIDs: resumedIDs,
-	})
+// resumeAll resumes the waiting executions in the request, concurrency at a time
+func resumeAll(ctx context.Context, sm *persistent.StateMachine, records []*repository.ExecutionRecord, output interface{}, concurrency int) []models.ResumeResult {
+	results := make([]models.ResumeResult, len(records))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, record := range records {
+		result, ok := newResumeResult(record)
+		results[i] = result
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			resumeOne(ctx, sm, record, output, &results[i])
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// resumeOne resumes an execution and records the outcome in result
+func resumeOne(ctx context.Context, sm *persistent.StateMachine, record *repository.ExecutionRecord, output interface{}, result *models.ResumeResult) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			failResume(result, models.CodeInternalError, fmt.Errorf("resume panicked: %v", recovered))
+		}
+	}()
+
+	resumed, err := middleware.ResumePausedExecution(ctx, sm, record, output)
+	if resumed != nil {
+		result.ExecutionStatus = resumed.Status
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		failResume(result, models.CodeResumeTimedOut, err)
+	case err != nil:
+		failResume(result, models.CodeExecutionFailed, err)
+	default:
+		result.Result = ResumeResultResumed
+	}
+}
+
+// resumeByCorrelationResponse counts the outcomes of a resume by correlation
+func resumeByCorrelationResponse(results []models.ResumeResult) models.ResumeByCorrelationResponse {
+	response := models.ResumeByCorrelationResponse{
+		MatchCount:   len(results),
+		ExecutionIDs: make([]string, 0, len(results)),
+		Results:      results,
+	}
+	for _, result := range results {
+		switch result.Result {
+		case ResumeResultResumed, ResumeResultQueued:
+			response.ResumedCount++
+			response.ExecutionIDs = append(response.ExecutionIDs, result.ExecutionID)
+		case ResumeResultFailed:
+			response.FailedCount++
+		}
+	}
+	return response
 }
 
 // FindWaitingExecutions finds executions waiting on a correlation
 func FindWaitingExecutions(c *gin.Context) {
 	repoManager, ok := middleware.GetRepositoryManager(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Repository manager not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 
 	stateMachineID := c.Param("stateMachineId")
 	correlationKey := c.Query("correlationKey")
-	correlationValue := c.Query("correlationValue")
+	rawValue := c.Query("correlationValue")
 
-	if correlationKey == "" || correlationValue == "" {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Missing parameters",
-			Message: "correlationKey and correlationValue are required",
-			Code:    http.StatusBadRequest,
-		})
+	if correlationKey == "" || rawValue == "" {
+		respondError(c, http.StatusBadRequest, models.CodeInvalidRequest, "Missing parameters", "correlationKey and correlationValue are required")
 		return
 	}
-
-	// Load state machine
-	sm, err := persistent.NewFromDefnId(c.Request.Context(), stateMachineID, repoManager)
+	var errs fieldErrors
+	valueType := queryOneOf(c, &errs, "correlationValueType", models.CorrelationValueString, models.CorrelationValueNumber, models.CorrelationValueBoolean)
+	correlationValue, err := models.ParseCorrelationValue(rawValue, valueType)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error:   "State machine not found",
-			Message: err.Error(),
-			Code:    http.StatusNotFound,
-		})
+		errs.add("correlationValue", "%v", err)
+	}
+	if errs.respond(c) {
+		return
+	}
+
+	// Check the state machine exists
+	if _, err := persistent.NewFromDefnId(c.Request.Context(), stateMachineID, repoManager); err != nil {
+		respondStateMachineLoadError(c, err, "State machine not found")
 		return
 	}
 
 	// Find waiting executions
-	records, err := sm.FindWaitingExecutionsByCorrelation(
-		c.Request.Context(),
-		correlationKey,
-		correlationValue,
-	)
+	records, err := waitingExecutions(c.Request.Context(), repoManager, stateMachineID, correlationKey, correlationValue, 0)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to find waiting executions",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondRepositoryError(c, "Failed to find waiting executions", err)
 		return
 	}
 
@@ -268,59 +576,37 @@ func FindWaitingExecutions(c *gin.Context) {
 func ResumeOrchestrator(c *gin.Context) {
 	repoManager, ok := middleware.GetRepositoryManager(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Repository manager not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 
 	queueClient, ok := middleware.GetQueueClient(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Queue client not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeQueueNotConfigured, "Queue client not configured")
 		return
 	}
 
 	redisClient, ok := middleware.GetRedisClient(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Redis client not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRedisNotConfigured, "Redis client not configured")
 		return
 	}
 
 	var req models.ResumeOrchestratorRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Invalid request",
-			Message: err.Error(),
-			Code:    http.StatusBadRequest,
-		})
+	if !bindJSON(c, &req) {
 		return
 	}
 
 	// Validate orchestrator SM ID
 	if req.OrchestratorSMID != batch.OrchestratorStateMachineID && req.OrchestratorSMID != batch.BulkOrchestratorStateMachineID {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Invalid orchestratorSmId",
-			Message: fmt.Sprintf("Must be either '%s' or '%s'", batch.OrchestratorStateMachineID, batch.BulkOrchestratorStateMachineID),
-			Code:    http.StatusBadRequest,
-		})
+		respondError(c, http.StatusBadRequest, models.CodeInvalidRequest, "Invalid orchestratorSmId", fmt.Sprintf("Must be either '%s' or '%s'", batch.OrchestratorStateMachineID, batch.BulkOrchestratorStateMachineID))
 		return
 	}
 
 	// Create orchestrator instance
 	parentSM, err := persistent.New(batch.OrchestratorDefinitionJSON(), true, batch.OrchestratorStateMachineID, repoManager)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to create parent state machine",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondError(c, http.StatusInternalServerError, models.CodeOrchestratorFailed, "Failed to create parent state machine", err.Error())
 		return
 	}
 	parentSM.SetQueueClient(queueClient)
@@ -335,11 +621,7 @@ func ResumeOrchestrator(c *gin.Context) {
 
 	orchestrator, err := batch.NewOrchestrator(c.Request.Context(), redisClient, parentSM, smFactory, smCreator)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to create orchestrator",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondError(c, http.StatusInternalServerError, models.CodeOrchestratorFailed, "Failed to create orchestrator", err.Error())
 		return
 	}
 
@@ -353,11 +635,7 @@ func ResumeOrchestrator(c *gin.Context) {
 	// Resume the orchestrator
 	err = orchestrator.ResumeOrchestrator(c.Request.Context(), mbMeta)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to resume orchestrator",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondError(c, http.StatusInternalServerError, models.CodeOrchestratorFailed, "Failed to resume orchestrator", err.Error())
 		return
 	}
 
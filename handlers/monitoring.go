@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
@@ -10,51 +11,80 @@ import (
 
 // HealthCheck performs a health check
 func HealthCheck(c *gin.Context) {
-	repoManager, hasRepo := middleware.GetRepositoryManager(c)
-	_, hasQueue := middleware.GetQueueClient(c)
+	report := readinessChecker(c).Ready(c.Request.Context())
 
-	services := make(map[string]string)
-
-	// Check repository
-	if hasRepo {
-		// Try a simple ping operation
-		_, err := repoManager.ListStateMachines(c.Request.Context(), nil)
-		if err != nil {
-			services["database"] = "down"
-		} else {
-			services["database"] = "up"
-		}
-	} else {
-		services["database"] = "not_configured"
+	services := make(map[string]string, len(report.Components))
+	for name, component := range report.Components {
+		services[name] = component.Status
 	}
 
-	// Check queue
-	if hasQueue {
-		services["queue"] = "up"
-	} else {
-		services["queue"] = "not_configured"
+	statusCode := http.StatusOK
+	if report.Status == middleware.ReadinessUnhealthy {
+		statusCode = http.StatusServiceUnavailable
 	}
 
-	// Determine overall status
-	status := "healthy"
-	for _, serviceStatus := range services {
-		if serviceStatus == "down" {
-			status = "unhealthy"
-			break
+	c.JSON(statusCode, models.HealthResponse{
+		Status:   report.Status,
+		Services: services,
+	})
+}
+
+// LivenessCheck reports that the process is up and able to serve requests.
+// It does not touch any dependency so that a slow database never restarts the pod.
+func LivenessCheck(c *gin.Context) {
+	c.JSON(http.StatusOK, models.LivenessResponse{
+		Status: "alive",
+		Time:   time.Now().UTC(),
+	})
+}
+
+// ReadinessCheck reports whether every dependency needed to serve traffic is available.
+// Each component is checked with a timeout and the result is cached briefly.
+func ReadinessCheck(c *gin.Context) {
+	report := readinessChecker(c).Ready(c.Request.Context())
+
+	components := make(map[string]models.ComponentHealthResponse, len(report.Components))
+	for name, component := range report.Components {
+		components[name] = models.ComponentHealthResponse{
+			Status:    component.Status,
+			LatencyMs: float64(component.Latency.Microseconds()) / 1000,
+			Message:   component.Message,
 		}
 	}
 
 	statusCode := http.StatusOK
-	if status == "unhealthy" {
+	if report.Status == middleware.ReadinessUnhealthy {
 		statusCode = http.StatusServiceUnavailable
 	}
 
-	c.JSON(statusCode, models.HealthResponse{
-		Status:   status,
-		Services: services,
+	c.JSON(statusCode, models.ReadinessResponse{
+		Status:     report.Status,
+		Components: components,
+		CheckedAt:  report.CheckedAt,
+		Cached:     report.Cached,
 	})
 }
 
+// readinessChecker returns the shared health checker, or builds one from
+// whatever dependencies are present when the middleware is not installed
+func readinessChecker(c *gin.Context) *middleware.HealthChecker {
+	if checker, ok := middleware.GetHealthChecker(c); ok && checker != nil {
+		return checker
+	}
+
+	config := &middleware.Config{}
+	if repoManager, ok := middleware.GetRepositoryManager(c); ok {
+		config.RepositoryManager = repoManager
+	}
+	if queueClient, ok := middleware.GetQueueClient(c); ok {
+		config.QueueClient = queueClient
+	}
+	if redisClient, ok := middleware.GetRedisClient(c); ok {
+		config.RedisClient = redisClient
+	}
+	return middleware.NewHealthChecker(config)
+}
+
 // GetQueueStats retrieves queue statistics
 // func GetQueueStats(c *gin.Context) {
 // 	queueClient, ok := middleware.GetQueueClient(c)
@@ -1,51 +1,37 @@
 package handlers
 
 import (
-	"encoding/json"
+	"bytes"
+	"html/template"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/hussainpithawala/state-machine-amz-gin/models"
+	"github.com/hussainpithawala/state-machine-amz-gin/openapi"
 )
 
-// GetOpenAPISpec serves the OpenAPI specification
-func GetOpenAPISpec(c *gin.Context) {
-	// Try to read from the file system
-	// First check current directory, then parent directory
-	paths := []string{
-		"openapi.json",
-		"../openapi.json",
-		filepath.Join(".", "openapi.json"),
-	}
+var docsTemplate = template.Must(template.New("docs").Parse(string(openapi.DocsPage())))
 
-	var specData []byte
-	var err error
+// GetOpenAPISpec serves the OpenAPI specification embedded in the binary
+func GetOpenAPISpec(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", openapi.Spec())
+}
 
-	for _, path := range paths {
-		specData, err = os.ReadFile(path)
-		if err == nil {
-			break
-		}
-	}
+// GetAPIDocs serves the bundled Swagger-UI page for the embedded specification.
+// All assets are served from the binary so the page works offline.
+func GetAPIDocs(c *gin.Context) {
+	basePath := strings.TrimSuffix(c.FullPath(), "/docs")
 
+	var page bytes.Buffer
+	err := docsTemplate.Execute(&page, map[string]string{
+		"SpecURL":   basePath + "/openapi.json",
+		"AssetsURL": basePath + "/docs/assets",
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Failed to load OpenAPI specification",
-			"message": "openapi.json file not found",
-		})
-		return
-	}
-
-	// Parse to validate it's valid JSON
-	var spec map[string]interface{}
-	if err := json.Unmarshal(specData, &spec); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Failed to parse OpenAPI specification",
-			"message": err.Error(),
-		})
+		respondError(c, http.StatusInternalServerError, models.CodeInternalError, "Failed to render API documentation", err.Error())
 		return
 	}
 
-	c.JSON(http.StatusOK, spec)
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page.Bytes())
 }
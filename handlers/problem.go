@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
+	"github.com/hussainpithawala/state-machine-amz-gin/models"
+)
+
+// respondError writes an RFC 7807 problem document with a stable error code
+func respondError(c *gin.Context, status int, code, title, detail string) {
+	writeProblem(c, newProblem(c, status, code, title, detail))
+}
+
+func newProblem(c *gin.Context, status int, code, title, detail string) models.ErrorResponse {
+	requestID, _ := middleware.GetRequestID(c)
+	return models.ErrorResponse{
+		Type:      models.ProblemTypePrefix + code,
+		Title:     title,
+		Status:    status,
+		Detail:    detail,
+		Instance:  c.Request.URL.Path,
+		Code:      code,
+		RequestID: requestID,
+	}
+}
+
+func writeProblem(c *gin.Context, problem models.ErrorResponse) {
+	c.Header("Content-Type", models.ProblemContentType)
+	c.JSON(problem.Status, problem)
+}
+
+// respondNotConfigured reports a dependency missing from the middleware configuration
+func respondNotConfigured(c *gin.Context, code, title string) {
+	respondError(c, http.StatusInternalServerError, code, title, "")
+}
+
+// isNotFoundError reports whether a repository error means the record does not exist.
+// The repositories report missing records with "... not found" errors.
+func isNotFoundError(err error) bool {
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "not found")
+}
+
+// respondLookupError reports a failed repository lookup as 404 when the record does not
+// exist and as 503 REPOSITORY_UNAVAILABLE when the repository itself failed
+func respondLookupError(c *gin.Context, err error, notFoundCode, notFoundTitle string) {
+	if isNotFoundError(err) {
+		respondError(c, http.StatusNotFound, notFoundCode, notFoundTitle, err.Error())
+		return
+	}
+	respondRepositoryError(c, "Repository unavailable", err)
+}
+
+// respondRepositoryError reports a failed repository call as 503 REPOSITORY_UNAVAILABLE
+func respondRepositoryError(c *gin.Context, title string, err error) {
+	respondError(c, http.StatusServiceUnavailable, models.CodeRepositoryUnavailable, title, err.Error())
+}
+
+// respondStateMachineLoadError classifies an error from persistent.NewFromDefnId. Fetch failures
+// are lookup errors; anything else means the stored definition could not be built.
+func respondStateMachineLoadError(c *gin.Context, err error, notFoundTitle string) {
+	if isNotFoundError(err) || strings.HasPrefix(err.Error(), "failed to fetch state machine definition") {
+		respondLookupError(c, err, models.CodeStateMachineNotFound, notFoundTitle)
+		return
+	}
+	respondError(c, http.StatusInternalServerError, models.CodeInvalidDefinition, "Stored state machine definition is invalid", err.Error())
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hibiken/asynq"
+	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
+	"github.com/hussainpithawala/state-machine-amz-gin/models"
+)
+
+// taskOptions are the options of an execution task queued on queueName, processed at
+// the given time unless it is zero. Passing options replaces the defaults of the queue
+// client, so the retry policy of the queue is applied here.
+func taskOptions(c *gin.Context, queueName string, at time.Time) []asynq.Option {
+	policy := middleware.GetRetryPolicy(c)
+	opts := []asynq.Option{asynq.Queue(queueName), asynq.MaxRetry(policy.MaxRetry), asynq.Timeout(policy.Timeout)}
+	if !at.IsZero() {
+		opts = append(opts, asynq.ProcessAt(at))
+	}
+	return opts
+}
+
+// timeoutQueue is the queue the queue client reserves for timeout events
+const timeoutQueue = "timeout"
+
+// queuesByWeight returns the configured queues executions can be enqueued on, heaviest
+// first
+func queuesByWeight(queues map[string]int) []string {
+	names := make([]string, 0, len(queues))
+	for name := range queues {
+		if name != timeoutQueue {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if queues[names[i]] != queues[names[j]] {
+			return queues[names[i]] > queues[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// priorityQueue maps a priority onto queues sorted heaviest first: high is the heaviest
+// queue, low the lightest and normal the "default" queue, or the middle one without it
+func priorityQueue(queues []string, priority string) string {
+	switch priority {
+	case "high":
+		return queues[0]
+	case "low":
+		return queues[len(queues)-1]
+	}
+	if slices.Contains(queues, "default") {
+		return "default"
+	}
+	return queues[len(queues)/2]
+}
+
+// enqueueQueue returns the queue an enqueue request asks for, or "" for the default
+// queue of the state machine. Queues are checked against the queue configuration when
+// the middleware has one.
+func enqueueQueue(c *gin.Context, errs *fieldErrors, req *models.EnqueueExecutionRequest) string {
+	var queues []string
+	if config, ok := middleware.GetQueueConfig(c); ok {
+		queues = queuesByWeight(config.Queues)
+	}
+	switch {
+	case req.Queue != "" && req.Priority != "":
+		errs.add("priority", "provide either queue or priority, not both")
+	case req.Priority != "":
+		if len(queues) == 0 {
+			errs.add("priority", "needs queues in middleware.Config.QueueConfig")
+			return ""
+		}
+		return priorityQueue(queues, req.Priority)
+	case req.Queue == timeoutQueue:
+		errs.add("queue", "%q is reserved for timeout events", timeoutQueue)
+	case req.Queue != "":
+		if len(queues) > 0 && !slices.Contains(queues, req.Queue) {
+			errs.add("queue", "unknown queue %q, configured queues are %s", req.Queue, strings.Join(queues, ", "))
+		}
+		return req.Queue
+	}
+	return ""
+}
+
+// enqueueOptions are the task options of an enqueue request. They are nil when the
+// request sets none, so the defaults of the queue client apply.
+func enqueueOptions(c *gin.Context, req *models.EnqueueExecutionRequest, queueName string, startAt time.Time) []asynq.Option {
+	if queueName == "" && startAt.IsZero() && req.MaxRetry == nil && req.TimeoutSeconds == 0 && req.UniqueForSeconds == 0 {
+		return nil
+	}
+	if queueName == "" {
+		queueName = req.StateMachineID
+	}
+	opts := taskOptions(c, queueName, startAt)
+	if req.MaxRetry != nil {
+		opts = append(opts, asynq.MaxRetry(*req.MaxRetry))
+	}
+	if req.TimeoutSeconds > 0 {
+		opts = append(opts, asynq.Timeout(time.Duration(req.TimeoutSeconds)*time.Second))
+	}
+	if req.UniqueForSeconds > 0 {
+		opts = append(opts, asynq.Unique(time.Duration(req.UniqueForSeconds)*time.Second))
+	}
+	return opts
+}
+
+// respondEnqueueError reports a task that is already queued as 409 and a failed queue
+// call as 503
+func respondEnqueueError(c *gin.Context, err error, title string) {
+	if enqueueErrorCode(err) == models.CodeDuplicateTask {
+		respondError(c, http.StatusConflict, models.CodeDuplicateTask, "Execution task already queued", err.Error())
+		return
+	}
+	respondError(c, http.StatusServiceUnavailable, models.CodeQueueUnavailable, title, err.Error())
+}
+
+// enqueueErrorCode is the error code of a task that could not be queued
+func enqueueErrorCode(err error) string {
+	if errors.Is(err, asynq.ErrDuplicateTask) || errors.Is(err, asynq.ErrTaskIDConflict) {
+		return models.CodeDuplicateTask
+	}
+	return models.CodeQueueUnavailable
+}
@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
+	"github.com/hussainpithawala/state-machine-amz-gin/models"
+	"github.com/hussainpithawala/state-machine-amz-gin/recovery"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+)
+
+// recoveryScanner returns the configured recovery scanner, responding 500 when there is none
+func recoveryScanner(c *gin.Context) (*recovery.Scanner, bool) {
+	scanner, ok := middleware.GetRecoveryScanner(c)
+	if !ok {
+		respondNotConfigured(c, models.CodeRecoveryNotConfigured, "Recovery scanner not configured")
+		return nil, false
+	}
+	return scanner, true
+}
+
+// recoveryStateMachine loads the state machine in the path, responding 404 when it does
+// not exist
+func recoveryStateMachine(c *gin.Context) (*repository.StateMachineRecord, bool) {
+	repoManager, ok := middleware.GetRepositoryManager(c)
+	if !ok {
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
+		return nil, false
+	}
+	record, err := repoManager.GetStateMachine(c.Request.Context(), c.Param("stateMachineId"))
+	if err != nil {
+		respondLookupError(c, err, models.CodeStateMachineNotFound, "State machine not found")
+		return nil, false
+	}
+	return record, true
+}
+
+// recoveryPolicy returns the recovery settings of a state machine and the policy they make,
+// responding 500 when the stored settings are invalid
+func recoveryPolicy(c *gin.Context, scanner *recovery.Scanner, record *repository.StateMachineRecord) (recovery.Settings, recovery.Policy, bool) {
+	settings, policy, err := scanner.Policy(record)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, models.CodeInternalError, "Stored recovery settings are invalid", err.Error())
+		return settings, policy, false
+	}
+	return settings, policy, true
+}
+
+// GetRecoveryConfig returns the recovery policy of a state machine, the settings it
+// overrides the scanner defaults with, and its last scan
+func GetRecoveryConfig(c *gin.Context) {
+	scanner, ok := recoveryScanner(c)
+	if !ok {
+		return
+	}
+	record, ok := recoveryStateMachine(c)
+	if !ok {
+		return
+	}
+	settings, policy, ok := recoveryPolicy(c, scanner, record)
+	if !ok {
+		return
+	}
+	respondRecoveryConfig(c, scanner, record.ID, settings, policy)
+}
+
+// UpdateRecoveryConfig replaces the recovery settings of a state machine. Omitted fields
+// keep the scanner defaults; an empty body restores them all.
+func UpdateRecoveryConfig(c *gin.Context) {
+	scanner, ok := recoveryScanner(c)
+	if !ok {
+		return
+	}
+
+	var req models.RecoverySettings
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	repoManager, ok := middleware.GetRepositoryManager(c)
+	if !ok {
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
+		return
+	}
+	record, ok := recoveryStateMachine(c)
+	if !ok {
+		return
+	}
+
+	settings := recovery.Settings{
+		Enabled:                  req.Enabled,
+		OrphanedThresholdSeconds: req.OrphanedThresholdSeconds,
+		Strategy:                 req.Strategy,
+		MaxRecoveryAttempts:      req.MaxRecoveryAttempts,
+	}
+	var value interface{}
+	if !settings.IsZero() {
+		value = settings
+	}
+	if _, err := updateStateMachineMetadata(c.Request.Context(), repoManager, record, map[string]interface{}{recovery.SettingsKey: value}); err != nil {
+		respondRepositoryError(c, "Failed to save state machine metadata", err)
+		return
+	}
+
+	respondRecoveryConfig(c, scanner, record.ID, settings, scanner.Defaults.Apply(settings))
+}
+
+func respondRecoveryConfig(c *gin.Context, scanner *recovery.Scanner, stateMachineID string, settings recovery.Settings, policy recovery.Policy) {
+	last, err := scanner.Store().LastScan(c.Request.Context(), stateMachineID)
+	if err != nil {
+		respondError(c, http.StatusServiceUnavailable, models.CodeRedisUnavailable, "Failed to load the last recovery scan", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, models.RecoveryConfigResponse{
+		StateMachineID:           stateMachineID,
+		Enabled:                  policy.Enabled,
+		OrphanedThresholdSeconds: int64(policy.OrphanedThreshold / time.Second),
+		Strategy:                 policy.Strategy,
+		MaxRecoveryAttempts:      policy.MaxRecoveryAttempts,
+		ScanIntervalSeconds:      int64(scanner.ScanInterval / time.Second),
+		Overrides: models.RecoverySettings{
+			Enabled:                  settings.Enabled,
+			OrphanedThresholdSeconds: settings.OrphanedThresholdSeconds,
+			Strategy:                 settings.Strategy,
+			MaxRecoveryAttempts:      settings.MaxRecoveryAttempts,
+		},
+		LastScan: recoveryScanResponse(last),
+	})
+}
+
+// ListOrphanedExecutions lists the executions of a state machine its recovery policy
+// considers orphaned, oldest first. They are listed whether or not recovery is enabled.
+//
+// Query parameters:
+// - limit: Page size, 1-1000 (optional, default: 100)
+// - offset: Executions to skip (optional, default: 0)
+func ListOrphanedExecutions(c *gin.Context) {
+	scanner, ok := recoveryScanner(c)
+	if !ok {
+		return
+	}
+
+	var errs fieldErrors
+	limit := queryInt(c, &errs, "limit", 100, 1, 1000)
+	offset := queryInt(c, &errs, "offset", 0, 0, math.MaxInt32)
+	if errs.respond(c) {
+		return
+	}
+
+	record, ok := recoveryStateMachine(c)
+	if !ok {
+		return
+	}
+	if _, _, ok := recoveryPolicy(c, scanner, record); !ok {
+		return
+	}
+	orphans, err := scanner.Orphans(c.Request.Context(), record)
+	if err != nil {
+		respondRepositoryError(c, "Failed to find orphaned executions", err)
+		return
+	}
+
+	sort.Slice(orphans, func(i, j int) bool {
+		if !orphans[i].StartTime.Equal(orphans[j].StartTime) {
+			return orphans[i].StartTime.Before(orphans[j].StartTime)
+		}
+		return orphans[i].ExecutionID < orphans[j].ExecutionID
+	})
+	page := orphans[min(offset, len(orphans)):min(offset+limit, len(orphans))]
+
+	now := time.Now()
+	executions := make([]*models.OrphanedExecutionResponse, len(page))
+	for i, orphan := range page {
+		executions[i] = &models.OrphanedExecutionResponse{
+			ExecutionID:    orphan.ExecutionID,
+			StateMachineID: orphan.StateMachineID,
+			CurrentState:   orphan.CurrentState,
+			Status:         orphan.Status,
+			StartTime:      orphan.StartTime,
+			LastUpdateTime: orphan.LastUpdateTime,
+			RunningSeconds: int64(now.Sub(orphan.StartTime).Seconds()),
+		}
+		if orphan.RecoveryMetadata != nil {
+			executions[i].RecoveryAttempts = orphan.RecoveryMetadata.RecoveryAttemptCount
+		}
+	}
+
+	c.JSON(http.StatusOK, models.ListOrphanedExecutionsResponse{
+		Executions: executions,
+		Total:      len(orphans),
+		Limit:      limit,
+		Offset:     offset,
+	})
+}
+
+// TriggerRecoveryScan scans a state machine for orphaned executions now and recovers them
+// with its policy, even when its recovery is disabled. Executions run again are queued for
+// a worker. It responds 409 while another scan of the state machine runs.
+func TriggerRecoveryScan(c *gin.Context) {
+	scanner, ok := recoveryScanner(c)
+	if !ok {
+		return
+	}
+	record, ok := recoveryStateMachine(c)
+	if !ok {
+		return
+	}
+	if _, _, ok := recoveryPolicy(c, scanner, record); !ok {
+		return
+	}
+
+	// A client that disconnects does not cut the scan short; ScanTimeout bounds it
+	scan, attempts, err := scanner.Scan(context.WithoutCancel(c.Request.Context()), record)
+	switch {
+	case errors.Is(err, recovery.ErrScanInProgress):
+		respondError(c, http.StatusConflict, models.CodeRecoveryScanInProgress, "Recovery scan in progress", "The state machine is being scanned already; retry when the scan finishes")
+		return
+	case err != nil && scan == nil:
+		respondError(c, http.StatusServiceUnavailable, models.CodeRedisUnavailable, "Failed to start the recovery scan", err.Error())
+		return
+	case err != nil && scan.Error != "":
+		respondRepositoryError(c, "Failed to find orphaned executions", err)
+		return
+	case err != nil:
+		log.Printf("Warning: failed to record the recovery scan of %s: %v", record.ID, err)
+	}
+
+	response := models.TriggerRecoveryScanResponse{
+		Scan:     recoveryScanResponse(scan),
+		Attempts: make([]*models.RecoveryAttemptResponse, len(attempts)),
+	}
+	for i, attempt := range attempts {
+		response.Attempts[i] = recoveryAttemptResponse(attempt)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ListRecoveryAttempts lists the recovery attempts made on the executions of a state
+// machine, newest first
+//
+// Query parameters:
+// - executionId: Only attempts on this execution (optional)
+// - outcome: RECOVERED, PAUSED, ABANDONED or ERROR (optional)
+// - limit: Page size, 1-1000 (optional, default: 100)
+// - offset: Attempts to skip (optional, default: 0)
+func ListRecoveryAttempts(c *gin.Context) {
+	scanner, ok := recoveryScanner(c)
+	if !ok {
+		return
+	}
+
+	var errs fieldErrors
+	executionID := c.Query("executionId")
+	outcome := queryOneOf(c, &errs, "outcome", recovery.OutcomeRecovered, recovery.OutcomePaused, recovery.OutcomeAbandoned, recovery.OutcomeError)
+	limit := queryInt(c, &errs, "limit", 100, 1, 1000)
+	offset := queryInt(c, &errs, "offset", 0, 0, math.MaxInt32)
+	if errs.respond(c) {
+		return
+	}
+
+	record, ok := recoveryStateMachine(c)
+	if !ok {
+		return
+	}
+	all, err := scanner.Store().Attempts(c.Request.Context(), record.ID)
+	if err != nil {
+		respondError(c, http.StatusServiceUnavailable, models.CodeRedisUnavailable, "Failed to list recovery attempts", err.Error())
+		return
+	}
+
+	matching := make([]*models.RecoveryAttemptResponse, 0, len(all))
+	for _, attempt := range all {
+		if (executionID == "" || attempt.ExecutionID == executionID) && (outcome == "" || attempt.Outcome == outcome) {
+			matching = append(matching, recoveryAttemptResponse(attempt))
+		}
+	}
+	page := matching[min(offset, len(matching)):min(offset+limit, len(matching))]
+
+	c.JSON(http.StatusOK, models.ListRecoveryAttemptsResponse{
+		Attempts: page,
+		Total:    len(matching),
+		Limit:    limit,
+		Offset:   offset,
+	})
+}
+
+func recoveryScanResponse(scan *recovery.Scan) *models.RecoveryScanResponse {
+	if scan == nil {
+		return nil
+	}
+	attemptIDs := scan.Attempts
+	if attemptIDs == nil {
+		attemptIDs = []string{}
+	}
+	return &models.RecoveryScanResponse{
+		StateMachineID: scan.StateMachineID,
+		StartedAt:      scan.StartedAt,
+		FinishedAt:     scan.FinishedAt,
+		Orphans:        scan.Orphans,
+		AttemptIDs:     attemptIDs,
+		Error:          scan.Error,
+		Manual:         scan.Manual,
+	}
+}
+
+func recoveryAttemptResponse(attempt *recovery.Attempt) *models.RecoveryAttemptResponse {
+	return &models.RecoveryAttemptResponse{
+		ID:             attempt.ID,
+		ExecutionID:    attempt.ExecutionID,
+		StateMachineID: attempt.StateMachineID,
+		CurrentState:   attempt.CurrentState,
+		Strategy:       attempt.Strategy,
+		Attempt:        attempt.Attempt,
+		StartedAt:      attempt.StartedAt,
+		FinishedAt:     attempt.FinishedAt,
+		Outcome:        attempt.Outcome,
+		Status:         attempt.Status,
+		Error:          attempt.Error,
+		Manual:         attempt.Manual,
+	}
+}
@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
+	"github.com/hussainpithawala/state-machine-amz-gin/models"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"gorm.io/gorm"
+)
+
+// Export formats of batch and bulk results
+const (
+	resultsFormatNDJSON = "ndjson"
+	resultsFormatCSV    = "csv"
+	resultsFormatJSON   = "json"
+)
+
+// resultsPageSize is the number of executions read from the database per page
+const resultsPageSize = 500
+
+// resultColumns are the CSV columns preceding the selected output fields
+var resultColumns = []string{"index", "executionId", "name", "status", "error", "startTime", "endTime"}
+
+// resultField is an output field selected with field=name:$.path
+type resultField struct {
+	name string
+	path jsonPathExpr
+}
+
+// batchResults selects the children of a batch or bulk. Children are named
+// <prefix>-<index>, where index is the position of their input.
+type batchResults struct {
+	prefix   string
+	statuses []string
+	fields   []resultField
+	inputs   bool // Read the inputs of the children as well
+}
+
+// childExecution is a child of a batch or bulk and its input index
+type childExecution struct {
+	index  int
+	record *repository.ExecutionRecord
+}
+
+// parseBatchResults reads the status and field filters of a results export
+func parseBatchResults(c *gin.Context, errs *fieldErrors, prefix string) *batchResults {
+	results := &batchResults{prefix: prefix}
+	for _, status := range queryList(c, "status") {
+		if oneOf(errs, "status", status, executionStatuses...) != "" {
+			results.statuses = append(results.statuses, status)
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, raw := range c.QueryArray("field") {
+		name, path := raw, raw
+		if !strings.HasPrefix(raw, "$") {
+			var ok bool
+			if name, path, ok = strings.Cut(raw, ":"); !ok || name == "" {
+				errs.add("field", "must be a JSONPath or name:JSONPath")
+				continue
+			}
+		}
+		expr, err := parseJSONPath(path)
+		if err != nil {
+			errs.add("field", "%v", err)
+			continue
+		}
+		if names[name] || containsString(resultColumns, name) {
+			errs.add("field", "field name %q is used more than once", name)
+			continue
+		}
+		names[name] = true
+		results.fields = append(results.fields, resultField{name: name, path: expr})
+	}
+	return results
+}
+
+// childIndex returns the input index of a child named <prefix>-<index>
+func childIndex(prefix, name string) (int, bool) {
+	suffix, ok := strings.CutPrefix(name, prefix+"-")
+	if !ok || suffix == "" {
+		return 0, false
+	}
+	for _, r := range suffix {
+		if r < '0' || r > '9' {
+			return 0, false
+		}
+	}
+	index, err := strconv.Atoi(suffix)
+	return index, err == nil
+}
+
+// result converts a child execution into its export row
+func (r *batchResults) result(child childExecution) models.ExecutionResult {
+	record := child.record
+	result := models.ExecutionResult{
+		Index:       child.index,
+		ExecutionID: record.ExecutionID,
+		Name:        record.Name,
+		Status:      record.Status,
+		Error:       record.Error,
+		StartTime:   record.StartTime,
+		EndTime:     record.EndTime,
+	}
+	if len(r.fields) > 0 {
+		result.Fields = make(map[string]interface{}, len(r.fields))
+		for _, field := range r.fields {
+			if value, ok := field.path.lookup(record.Output); ok {
+				result.Fields[field.name] = value
+			}
+		}
+	}
+	return result
+}
+
+// each calls fn with the children in index order, one page at a time
+func (r *batchResults) each(c *gin.Context, repoManager *repository.Manager, fn func([]childExecution) error) error {
+	db, ok := executionsDB(repoManager)
+	if !ok {
+		return r.scan(c, repoManager, fn)
+	}
+
+	lastName := ""
+	for {
+		var rows []repository.ExecutionModel
+		if err := r.page(db.WithContext(c.Request.Context()), lastName).Find(&rows).Error; err != nil {
+			return err
+		}
+
+		page := make([]childExecution, 0, len(rows))
+		for i := range rows {
+			if index, ok := childIndex(r.prefix, rows[i].Name); ok {
+				page = append(page, childExecution{index: index, record: executionRecord(&rows[i])})
+			}
+		}
+		if len(page) > 0 {
+			if err := fn(page); err != nil {
+				return err
+			}
+		}
+		if len(rows) < resultsPageSize {
+			return nil
+		}
+		lastName = rows[len(rows)-1].Name
+	}
+}
+
+// page queries the page of children after the child named lastName. Children share the
+// prefix and differ in their decimal index, so ordering by name length and then name is
+// ordering by index.
+func (r *batchResults) page(db *gorm.DB, lastName string) *gorm.DB {
+	columns := []string{"execution_id", "state_machine_id", "name", "status", "error", "start_time", "end_time"}
+	if len(r.fields) > 0 {
+		columns = append(columns, "output")
+	}
+	if r.inputs {
+		columns = append(columns, "input")
+	}
+	query := db.Model(&repository.ExecutionModel{}).
+		Select(columns).
+		Where("name LIKE ?", escapeLike(r.prefix+"-")+"%")
+	if len(r.statuses) > 0 {
+		query = query.Where("status IN ?", r.statuses)
+	}
+	if lastName != "" {
+		query = query.Where("(LENGTH(name), name) > (?, ?)", utf8.RuneCountInString(lastName), lastName)
+	}
+	return query.Order("LENGTH(name) ASC").Order("name ASC").Limit(resultsPageSize)
+}
+
+// scan is the fallback for repositories without database access. The repository name
+// filter matches substrings, so at most maxScannedExecutions candidates are inspected and
+// the children are sorted in memory.
+func (r *batchResults) scan(c *gin.Context, repoManager *repository.Manager, fn func([]childExecution) error) error {
+	filter := &repository.ExecutionFilter{Name: r.prefix, Limit: maxListLimit}
+	if len(r.statuses) == 1 {
+		filter.Status = r.statuses[0]
+	}
+
+	var children []childExecution
+	for filter.Offset < maxScannedExecutions {
+		records, err := repoManager.ListExecutions(c.Request.Context(), filter)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			index, ok := childIndex(r.prefix, record.Name)
+			if ok && (len(r.statuses) == 0 || containsString(r.statuses, record.Status)) {
+				children = append(children, childExecution{index: index, record: record})
+			}
+		}
+		if len(records) < filter.Limit {
+			break
+		}
+		filter.Offset += filter.Limit
+	}
+
+	sort.Slice(children, func(i, j int) bool { return children[i].index < children[j].index })
+	if len(children) == 0 {
+		return nil
+	}
+	return fn(children)
+}
+
+// resultsWriter writes results in one of the export formats. The response starts with
+// the first page, so failures before it are still reported as problems.
+type resultsWriter struct {
+	c       *gin.Context
+	format  string
+	batchID string
+	results *batchResults
+	csv     *csv.Writer
+	started bool
+	total   int
+}
+
+func (w *resultsWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+
+	contentType := map[string]string{
+		resultsFormatNDJSON: "application/x-ndjson",
+		resultsFormatCSV:    "text/csv; charset=utf-8",
+		resultsFormatJSON:   "application/json; charset=utf-8",
+	}[w.format]
+	w.c.Header("Content-Type", contentType)
+	w.c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", w.batchID+"-results."+w.format))
+	w.c.Status(http.StatusOK)
+
+	switch w.format {
+	case resultsFormatCSV:
+		w.csv = csv.NewWriter(w.c.Writer)
+		header := append([]string(nil), resultColumns...)
+		for _, field := range w.results.fields {
+			header = append(header, field.name)
+		}
+		_ = w.csv.Write(header)
+	case resultsFormatJSON:
+		batchID, _ := json.Marshal(w.batchID)
+		_, _ = fmt.Fprintf(w.c.Writer, `{"batchId":%s,"results":[`, batchID)
+	}
+}
+
+func (w *resultsWriter) write(page []childExecution) error {
+	w.start()
+	for _, child := range page {
+		result := w.results.result(child)
+		var err error
+		switch w.format {
+		case resultsFormatCSV:
+			err = w.csv.Write(w.csvRecord(result))
+		case resultsFormatJSON:
+			if w.total > 0 {
+				_, _ = w.c.Writer.WriteString(",")
+			}
+			err = w.encode(result)
+		default:
+			if err = w.encode(result); err == nil {
+				_, err = w.c.Writer.WriteString("\n")
+			}
+		}
+		if err != nil {
+			return err
+		}
+		w.total++
+	}
+
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	}
+	w.c.Writer.Flush()
+	return nil
+}
+
+func (w *resultsWriter) encode(result models.ExecutionResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = w.c.Writer.Write(data)
+	return err
+}
+
+func (w *resultsWriter) close() {
+	w.start()
+	if w.format == resultsFormatJSON {
+		_, _ = fmt.Fprintf(w.c.Writer, `],"total":%d}`, w.total)
+	}
+	if w.csv != nil {
+		w.csv.Flush()
+	}
+	w.c.Writer.Flush()
+}
+
+// csvRecord renders a result as CSV cells. Output fields that are not strings are
+// written as JSON.
+func (w *resultsWriter) csvRecord(result models.ExecutionResult) []string {
+	record := []string{
+		strconv.Itoa(result.Index),
+		result.ExecutionID,
+		result.Name,
+		result.Status,
+		result.Error,
+		formatResultTime(result.StartTime),
+		formatResultTime(result.EndTime),
+	}
+	for _, field := range w.results.fields {
+		value, ok := result.Fields[field.name]
+		switch {
+		case !ok || value == nil:
+			record = append(record, "")
+		case isString(value):
+			record = append(record, value.(string))
+		default:
+			data, _ := json.Marshal(value)
+			record = append(record, string(data))
+		}
+	}
+	return record
+}
+
+func isString(value interface{}) bool {
+	_, ok := value.(string)
+	return ok
+}
+
+func formatResultTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// exportBatchResults streams the children of the batch or bulk a start request returned
+// runID for
+func exportBatchResults(c *gin.Context, runID string) {
+	repoManager, ok := middleware.GetRepositoryManager(c)
+	if !ok {
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
+		return
+	}
+	redisClient, _ := middleware.GetRedisClient(c)
+	namePrefix, _, err := resolveRun(c.Request.Context(), redisClient, runID)
+	if err != nil {
+		respondError(c, http.StatusServiceUnavailable, models.CodeRedisUnavailable, "Failed to load batch run", err.Error())
+		return
+	}
+	streamBatchResults(c, repoManager, runID, namePrefix)
+}
+
+func streamBatchResults(c *gin.Context, repoManager *repository.Manager, runID, namePrefix string) {
+	var errs fieldErrors
+	format := queryOneOf(c, &errs, "format", resultsFormatNDJSON, resultsFormatCSV, resultsFormatJSON)
+	if format == "" {
+		format = resultsFormatNDJSON
+	}
+	results := parseBatchResults(c, &errs, namePrefix)
+	if errs.respond(c) {
+		return
+	}
+
+	writer := &resultsWriter{c: c, format: format, batchID: runID, results: results}
+	if err := results.each(c, repoManager, writer.write); err != nil {
+		if !writer.started {
+			respondRepositoryError(c, "Failed to read results", err)
+			return
+		}
+		// The status line was sent; the truncated body is all that can signal the failure
+		_ = c.Error(err)
+		return
+	}
+	writer.close()
+}
+
+// GetBatchResults exports the outcome of every execution of a batch, in input order.
+// batchId is the ID ExecuteBatch returned; the name prefix of a batch that was not
+// recorded, or has expired, is accepted as well.
+// Query parameters:
+// - format: "ndjson" (default), "csv" or "json"
+// - status: Only executions with these statuses, comma-separated or repeated (optional)
+// - field: Output field to include, as name:$.path or $.path (repeatable)
+func GetBatchResults(c *gin.Context) {
+	exportBatchResults(c, c.Param("batchId"))
+}
+
+// GetBulkResults exports the outcome of every execution of a bulk, in input order.
+// orchestratorId is the ID ExecuteBulk or ExecuteBulkStream returned, or the name prefix
+// of the bulk. Query parameters are those of GetBatchResults.
+func GetBulkResults(c *gin.Context) {
+	exportBatchResults(c, c.Param("orchestratorId"))
+}
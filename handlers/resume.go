@@ -17,20 +17,12 @@ import (
 func SignalResume(c *gin.Context) {
 	redisClient, ok := middleware.GetRedisClient(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Redis client not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRedisNotConfigured, "Redis client not configured")
 		return
 	}
 
 	var req models.SignalResumeRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Invalid request",
-			Message: err.Error(),
-			Code:    http.StatusBadRequest,
-		})
+	if !bindJSON(c, &req) {
 		return
 	}
 
@@ -38,11 +30,7 @@ func SignalResume(c *gin.Context) {
 	controller := batch.NewResumeController(redisClient)
 	err := controller.Signal(c.Request.Context(), req.BatchID, req.Operator, req.Notes)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to signal resume",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondError(c, http.StatusServiceUnavailable, models.CodeRedisUnavailable, "Failed to signal resume", err.Error())
 		return
 	}
 
@@ -61,20 +49,12 @@ func SignalResume(c *gin.Context) {
 func RevokeResume(c *gin.Context) {
 	redisClient, ok := middleware.GetRedisClient(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Redis client not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRedisNotConfigured, "Redis client not configured")
 		return
 	}
 
 	var req models.RevokeResumeRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Invalid request",
-			Message: err.Error(),
-			Code:    http.StatusBadRequest,
-		})
+	if !bindJSON(c, &req) {
 		return
 	}
 
@@ -82,11 +62,7 @@ func RevokeResume(c *gin.Context) {
 	controller := batch.NewResumeController(redisClient)
 	err := controller.Revoke(c.Request.Context(), req.BatchID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to revoke resume signal",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondError(c, http.StatusServiceUnavailable, models.CodeRedisUnavailable, "Failed to revoke resume signal", err.Error())
 		return
 	}
 
@@ -103,20 +79,12 @@ func RevokeResume(c *gin.Context) {
 func CheckResume(c *gin.Context) {
 	redisClient, ok := middleware.GetRedisClient(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Redis client not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRedisNotConfigured, "Redis client not configured")
 		return
 	}
 
 	var req models.CheckResumeRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Invalid request",
-			Message: err.Error(),
-			Code:    http.StatusBadRequest,
-		})
+	if !bindJSON(c, &req) {
 		return
 	}
 
@@ -124,11 +92,7 @@ func CheckResume(c *gin.Context) {
 	controller := batch.NewResumeController(redisClient)
 	result, err := controller.Check(c.Request.Context(), req.BatchID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to check resume signal",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondError(c, http.StatusServiceUnavailable, models.CodeRedisUnavailable, "Failed to check resume signal", err.Error())
 		return
 	}
 
@@ -153,10 +117,7 @@ func CheckResume(c *gin.Context) {
 func SignalResumeParam(c *gin.Context) {
 	redisClient, ok := middleware.GetRedisClient(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Redis client not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRedisNotConfigured, "Redis client not configured")
 		return
 	}
 
@@ -172,11 +133,7 @@ func SignalResumeParam(c *gin.Context) {
 	controller := batch.NewResumeController(redisClient)
 	err := controller.Signal(c.Request.Context(), batchID, operator, notes)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to signal resume",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondError(c, http.StatusServiceUnavailable, models.CodeRedisUnavailable, "Failed to signal resume", err.Error())
 		return
 	}
 
@@ -193,10 +150,7 @@ func SignalResumeParam(c *gin.Context) {
 func RevokeResumeParam(c *gin.Context) {
 	redisClient, ok := middleware.GetRedisClient(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Redis client not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRedisNotConfigured, "Redis client not configured")
 		return
 	}
 
@@ -206,11 +160,7 @@ func RevokeResumeParam(c *gin.Context) {
 	controller := batch.NewResumeController(redisClient)
 	err := controller.Revoke(c.Request.Context(), batchID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to revoke resume signal",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondError(c, http.StatusServiceUnavailable, models.CodeRedisUnavailable, "Failed to revoke resume signal", err.Error())
 		return
 	}
 
@@ -225,10 +175,7 @@ func RevokeResumeParam(c *gin.Context) {
 func CheckResumeParam(c *gin.Context) {
 	redisClient, ok := middleware.GetRedisClient(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Redis client not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRedisNotConfigured, "Redis client not configured")
 		return
 	}
 
@@ -238,11 +185,7 @@ func CheckResumeParam(c *gin.Context) {
 	controller := batch.NewResumeController(redisClient)
 	result, err := controller.Check(c.Request.Context(), batchID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to check resume signal",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondError(c, http.StatusServiceUnavailable, models.CodeRedisUnavailable, "Failed to check resume signal", err.Error())
 		return
 	}
 
@@ -0,0 +1,434 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
+	"github.com/hussainpithawala/state-machine-amz-gin/models"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/statemachine"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/statemachine/persistent"
+	"github.com/redis/go-redis/v9"
+)
+
+// Kinds of batch runs. Batch children chain from source executions, bulk children are
+// started with their own input.
+const (
+	runKindBatch = "batch"
+	runKindBulk  = "bulk"
+)
+
+// batchRunKeyPrefix prefixes the Redis keys holding batch runs by run ID
+const batchRunKeyPrefix = "state-machine:batch-runs:"
+
+// batchRunTTL is how long batch runs are kept, and so how long their failed executions
+// can be retried with the options they were started with
+const batchRunTTL = 7 * 24 * time.Hour
+
+// batchRun records how a batch or bulk was started, so its failed executions can be
+// retried with the same options. Runs are recorded under the ID the start request
+// returned; their children are named <NamePrefix>-<index>.
+type batchRun struct {
+	Kind             string            `json:"kind"`
+	NamePrefix       string            `json:"namePrefix,omitempty"`
+	StateMachineID   string            `json:"stateMachineId"`
+	SourceStateName  string            `json:"sourceStateName,omitempty"`
+	InputTransformer string            `json:"inputTransformer,omitempty"`
+	GroupEnqueue     bool              `json:"groupEnqueue,omitempty"`
+	Concurrency      int               `json:"concurrency"`
+	StopOnError      bool              `json:"stopOnError,omitempty"`
+	DoMicroBatch     bool              `json:"doMicroBatch,omitempty"`
+	MicroBatchSize   int               `json:"microBatchSize,omitempty"`
+	Mode             string            `json:"mode,omitempty"`
+	Tags             map[string]string `json:"tags,omitempty"`
+	ParentID         string            `json:"parentId,omitempty"` // ID of the run this run retries
+	Attempt          int               `json:"attempt"`            // 1 for the original run
+	Indexes          []int             `json:"indexes,omitempty"`  // Index in the parent of every retried child
+	CreatedAt        time.Time         `json:"createdAt"`
+}
+
+func batchRunKey(runID string) string {
+	return batchRunKeyPrefix + runID
+}
+
+// retriesKey counts the retries of a run
+func retriesKey(runID string) string {
+	return batchRunKeyPrefix + runID + ":retries"
+}
+
+// retryPrefix names the n-th retry of a run. The suffix is not a number, so the
+// children of a retry are never mistaken for children of its parent.
+func retryPrefix(parentID string, n int) string {
+	return fmt.Sprintf("%s-retry%d", parentID, n)
+}
+
+func saveBatchRun(ctx context.Context, client *redis.Client, runID string, run *batchRun) error {
+	if client == nil {
+		return errors.New("redis client not configured")
+	}
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	return client.Set(ctx, batchRunKey(runID), data, batchRunTTL).Err()
+}
+
+// loadBatchRun returns the run registered under an ID, or nil for runs that were not
+// registered or have expired
+func loadBatchRun(ctx context.Context, client *redis.Client, runID string) (*batchRun, error) {
+	data, err := client.Get(ctx, batchRunKey(runID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var run batchRun
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, fmt.Errorf("invalid batch run %s: %w", runID, err)
+	}
+	return &run, nil
+}
+
+// resolveRun returns the run registered under an ID and the name prefix of its children.
+// Runs that were not registered, or were registered before their name prefix was
+// recorded, are named by their ID.
+func resolveRun(ctx context.Context, client *redis.Client, runID string) (string, *batchRun, error) {
+	if client == nil {
+		return runID, nil, nil
+	}
+	run, err := loadBatchRun(ctx, client, runID)
+	if err != nil || run == nil || run.NamePrefix == "" {
+		return runID, run, err
+	}
+	return run.NamePrefix, run, nil
+}
+
+// registerBatchRun records a run under the ID its start request returns, before its
+// children are started
+func registerBatchRun(c *gin.Context, client *redis.Client, runID string, run *batchRun) bool {
+	if run.Attempt == 0 {
+		run.Attempt = 1
+	}
+	run.CreatedAt = time.Now().UTC()
+	if err := saveBatchRun(c.Request.Context(), client, runID, run); err != nil {
+		respondError(c, http.StatusServiceUnavailable, models.CodeRedisUnavailable, "Failed to register batch run", err.Error())
+		return false
+	}
+	return true
+}
+
+// nextRetry reserves the name prefix of the next retry of a run
+func nextRetry(ctx context.Context, client *redis.Client, parentID string) (string, error) {
+	var n *redis.IntCmd
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		n = pipe.Incr(ctx, retriesKey(parentID))
+		pipe.Expire(ctx, retriesKey(parentID), batchRunTTL)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return retryPrefix(parentID, int(n.Val())), nil
+}
+
+// describeRunAttempts adds the parent and the retries of a run to a status response.
+// Status views still answer when Redis is unavailable, without the attempts.
+func describeRunAttempts(c *gin.Context, runID string, status *models.BulkStatusResponse) {
+	client, ok := middleware.GetRedisClient(c)
+	if !ok || client == nil {
+		return
+	}
+	ctx := c.Request.Context()
+	if run, err := loadBatchRun(ctx, client, runID); err == nil && run != nil {
+		status.ParentID = run.ParentID
+		status.Attempt = run.Attempt
+	}
+	if n, err := client.Get(ctx, retriesKey(runID)).Int(); err == nil {
+		for i := 1; i <= n; i++ {
+			status.Retries = append(status.Retries, retryPrefix(runID, i))
+		}
+	}
+}
+
+// retryPlan is what a retry starts: an input for every failed bulk child, or the source
+// execution of every failed batch child
+type retryPlan struct {
+	indexes []int
+	inputs  []interface{}
+	sources []string
+	skipped []models.SkippedRetry
+}
+
+func (p *retryPlan) size() int {
+	return len(p.indexes)
+}
+
+// planRetry collects the FAILED children of a run, named <namePrefix>-<index>. Batch
+// children are chained again from the source execution they are linked to; children
+// without a link are skipped.
+func planRetry(c *gin.Context, repoManager *repository.Manager, namePrefix string, run *batchRun) (*retryPlan, error) {
+	results := &batchResults{prefix: namePrefix, statuses: []string{StatusFailed}, inputs: run.Kind == runKindBulk}
+	plan := &retryPlan{}
+	err := results.each(c, repoManager, func(page []childExecution) error {
+		for _, child := range page {
+			if run.StateMachineID == "" {
+				run.StateMachineID = child.record.StateMachineID
+			}
+			if run.Kind == runKindBulk {
+				plan.indexes = append(plan.indexes, child.index)
+				plan.inputs = append(plan.inputs, child.record.Input)
+				continue
+			}
+
+			links, err := repoManager.ListLinkedExecutions(c.Request.Context(), &repository.LinkedExecutionFilter{
+				TargetExecutionID: child.record.ExecutionID,
+				Limit:             1,
+			})
+			if err != nil {
+				return err
+			}
+			if len(links) == 0 {
+				plan.skipped = append(plan.skipped, models.SkippedRetry{
+					Index:       child.index,
+					ExecutionID: child.record.ExecutionID,
+					Reason:      "execution is not linked to a source execution",
+				})
+				continue
+			}
+			// Runs registered before their options were recorded take them from the link
+			if run.SourceStateName == "" {
+				run.SourceStateName = links[0].SourceStateName
+			}
+			if run.InputTransformer == "" {
+				run.InputTransformer = links[0].InputTransformerName
+			}
+			plan.indexes = append(plan.indexes, child.index)
+			plan.sources = append(plan.sources, links[0].SourceExecutionID)
+		}
+		return nil
+	})
+	return plan, err
+}
+
+// retryExecutionOptions resolves the input transformer of a batch run
+func retryExecutionOptions(c *gin.Context, run *batchRun) []statemachine.ExecutionOption {
+	if run.InputTransformer == "" {
+		return nil
+	}
+	registry, ok := middleware.GetTransformerRegistry(c)
+	if !ok || registry == nil || registry[run.InputTransformer] == nil {
+		return nil
+	}
+	return []statemachine.ExecutionOption{
+		statemachine.WithInputTransformerName(run.InputTransformer),
+		statemachine.WithInputTransformer(registry[run.InputTransformer]),
+	}
+}
+
+// startChainedBatch chains an execution named <namePrefix>-<i> from every source
+// execution, with the options of run. The executions are queued when a queue is
+// configured and run locally otherwise. It returns how many could not be started.
+func startChainedBatch(ctx context.Context, sm *persistent.StateMachine, enqueuer executionEnqueuer, namePrefix string, run *batchRun, sources []string, execOpts []statemachine.ExecutionOption) int {
+	if enqueuer != nil {
+		payloads := make([]*queue.ExecutionTaskPayload, len(sources))
+		for i, source := range sources {
+			payloads[i] = &queue.ExecutionTaskPayload{
+				StateMachineID:       run.StateMachineID,
+				SourceExecutionID:    source,
+				SourceStateName:      run.SourceStateName,
+				InputTransformerName: run.InputTransformer,
+				ExecutionName:        fmt.Sprintf("%s-%d", namePrefix, i),
+				ExecutionIndex:       i,
+			}
+			if len(run.Tags) > 0 {
+				payloads[i].Options = map[string]interface{}{middleware.ExecutionTagsOption: run.Tags}
+			}
+		}
+		if run.GroupEnqueue {
+			infos, _ := enqueuer.EnqueueExecutionGroup(payloads, namePrefix)
+			return len(payloads) - len(infos)
+		}
+		failed := 0
+		for i, payload := range payloads {
+			if _, err := enqueuer.EnqueueExecution(payload); err != nil {
+				failed++
+				if run.StopOnError {
+					return failed + len(payloads) - i - 1
+				}
+			}
+		}
+		return failed
+	}
+
+	// Run locally, a fixed pool of run.Concurrency workers executes the sources in turn
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var failed atomic.Int32
+	for range min(max(run.Concurrency, 1), len(sources)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				opts := append(append([]statemachine.ExecutionOption(nil), execOpts...),
+					statemachine.WithExecutionName(fmt.Sprintf("%s-%d", namePrefix, index)),
+					statemachine.WithSourceExecution(sources[index], run.SourceStateName),
+				)
+				if _, err := sm.Execute(ctx, nil, opts...); err != nil {
+					failed.Add(1)
+				}
+			}
+		}()
+	}
+	for i := range sources {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return int(failed.Load())
+}
+
+// RetryFailedBatch starts a new batch from the FAILED executions of a batch. batchId is
+// the ID returned when the batch was started; its options are reused and the new batch,
+// named <batchId>-retry<n>, is linked to it.
+func RetryFailedBatch(c *gin.Context) {
+	retryFailed(c, runKindBatch, c.Param("batchId"))
+}
+
+// RetryFailedBulk starts a new bulk from the inputs of the FAILED executions of a bulk.
+// orchestratorId is the ID returned when the bulk was started; its options are reused
+// and the new bulk, named <orchestratorId>-retry<n>, is linked to it.
+func RetryFailedBulk(c *gin.Context) {
+	retryFailed(c, runKindBulk, c.Param("orchestratorId"))
+}
+
+func retryFailed(c *gin.Context, kind, parentID string) {
+	repoManager, ok := middleware.GetRepositoryManager(c)
+	if !ok {
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
+		return
+	}
+	redisClient, ok := middleware.GetRedisClient(c)
+	if !ok {
+		respondNotConfigured(c, models.CodeRedisNotConfigured, "Redis client not configured")
+		return
+	}
+	queueClient, hasQueue := middleware.GetQueueClient(c)
+
+	ctx := c.Request.Context()
+	parentPrefix, parent, err := resolveRun(ctx, redisClient, parentID)
+	if err != nil {
+		respondError(c, http.StatusServiceUnavailable, models.CodeRedisUnavailable, "Failed to load batch run", err.Error())
+		return
+	}
+	if parent == nil {
+		// Runs started before runs were recorded are retried with the default options
+		parent = &batchRun{Kind: kind, Concurrency: 10, Attempt: 1}
+	}
+	if parent.Kind != kind {
+		respondError(c, http.StatusNotFound, models.CodeNotFound, fmt.Sprintf("No %s %s", kind, parentID),
+			fmt.Sprintf("%s is a %s; retry it with POST /%s/%s/retry-failed", parentID, parent.Kind, parent.Kind, parentID))
+		return
+	}
+	if parent.Kind == runKindBulk && !hasQueue {
+		respondNotConfigured(c, models.CodeQueueNotConfigured, "Queue client not configured")
+		return
+	}
+
+	run := *parent
+	plan, err := planRetry(c, repoManager, parentPrefix, &run)
+	if err != nil {
+		respondRepositoryError(c, "Failed to read failed executions", err)
+		return
+	}
+	if plan.size() == 0 {
+		detail := fmt.Sprintf("%s has no failed executions", parentID)
+		if len(plan.skipped) > 0 {
+			detail = fmt.Sprintf("none of the %d failed executions of %s can be retried", len(plan.skipped), parentID)
+		}
+		respondError(c, http.StatusConflict, models.CodeNoFailedExecutions, "Nothing to retry", detail)
+		return
+	}
+
+	if _, err := persistent.NewFromDefnId(ctx, run.StateMachineID, repoManager); err != nil {
+		respondStateMachineLoadError(c, err, "State machine not found")
+		return
+	}
+
+	namePrefix, err := nextRetry(ctx, redisClient, parentID)
+	if err != nil {
+		respondError(c, http.StatusServiceUnavailable, models.CodeRedisUnavailable, "Failed to register retry", err.Error())
+		return
+	}
+	run.NamePrefix = namePrefix
+	run.ParentID = parentID
+	run.Attempt = parent.Attempt + 1
+	run.Indexes = plan.indexes
+	if !registerBatchRun(c, redisClient, namePrefix, &run) {
+		return
+	}
+	execOpts := retryExecutionOptions(c, &run)
+	opts := taskOptions(c, run.StateMachineID, time.Time{})
+
+	go func() {
+		// Bulk children are queued with the tags of the run in their options
+		if run.Kind == runKindBulk && !run.DoMicroBatch {
+			failed := enqueueBulk(queueClient, run.StateMachineID, namePrefix, plan.inputs, run.Tags, run.StopOnError, opts)
+			log.Printf("Retry %s started: %d executions, %d failed to start", namePrefix, len(plan.inputs)-failed, failed)
+			return
+		}
+
+		bgCtx, taggedManager := taggedRepositoryManager(context.Background(), repoManager, run.Tags)
+
+		sm, err := persistent.NewFromDefnId(bgCtx, run.StateMachineID, taggedManager)
+		if err != nil {
+			log.Printf("Failed to load state machine for retry %s: %v", namePrefix, err)
+			return
+		}
+
+		if run.Kind == runKindBulk {
+			sm.SetQueueClient(queueClient)
+			_, err := sm.ExecuteBulk(bgCtx, plan.inputs, &statemachine.BulkExecutionOptions{
+				NamePrefix:        namePrefix,
+				UseGroupEnqueue:   run.GroupEnqueue,
+				ConcurrentBatches: run.Concurrency,
+				GroupConcurrency:  run.Concurrency,
+				StopOnError:       run.StopOnError,
+				DoMicroBatch:      run.DoMicroBatch,
+				MicroBatchSize:    run.MicroBatchSize,
+				RedisClient:       redisClient,
+			}, execOpts...)
+			if err != nil {
+				log.Printf("Retry %s failed: %v", namePrefix, err)
+			}
+			return
+		}
+
+		var enqueuer executionEnqueuer
+		if hasQueue {
+			sm.SetQueueClient(queueClient)
+			enqueuer = queueClient
+		}
+		failed := startChainedBatch(bgCtx, sm, enqueuer, namePrefix, &run, plan.sources, execOpts)
+		log.Printf("Retry %s started: %d executions, %d failed to start", namePrefix, len(plan.sources)-failed, failed)
+	}()
+
+	c.JSON(http.StatusAccepted, models.RetryFailedResponse{
+		BatchID:      namePrefix,
+		ParentID:     parentID,
+		Attempt:      run.Attempt,
+		TotalRetried: plan.size(),
+		Skipped:      plan.skipped,
+		Mode:         run.Mode,
+	})
+}
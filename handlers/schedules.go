@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
+	"github.com/hussainpithawala/state-machine-amz-gin/models"
+	"github.com/hussainpithawala/state-machine-amz-gin/schedules"
+)
+
+// scheduleStore returns the store of schedules, kept in Redis, or writes a 500
+func scheduleStore(c *gin.Context) (*schedules.Store, bool) {
+	redisClient, ok := middleware.GetRedisClient(c)
+	if !ok {
+		respondNotConfigured(c, models.CodeRedisNotConfigured, "Redis client not configured")
+		return nil, false
+	}
+	return schedules.NewStore(redisClient), true
+}
+
+// respondScheduleError reports a missing schedule as 404 and a failed Redis call as 503
+func respondScheduleError(c *gin.Context, err error, title string) {
+	if errors.Is(err, schedules.ErrNotFound) {
+		respondError(c, http.StatusNotFound, models.CodeScheduleNotFound, "Schedule not found", "")
+		return
+	}
+	respondError(c, http.StatusServiceUnavailable, models.CodeRedisUnavailable, title, err.Error())
+}
+
+// validateSchedule checks the timing of a schedule
+func validateSchedule(errs *fieldErrors, schedule *schedules.Schedule) {
+	if _, err := schedule.Location(); err != nil {
+		errs.add("timeZone", "unknown time zone %q", schedule.TimeZone)
+		return
+	}
+	if _, err := schedule.Spec(); err != nil {
+		field := "cron"
+		if schedule.Cron == "" {
+			field = "intervalSeconds"
+		}
+		errs.add(field, "%v", err)
+		return
+	}
+	if _, err := schedule.Next(time.Now()); err != nil {
+		errs.add("cron", "%v", err)
+	}
+}
+
+func scheduleRunResponse(run *schedules.Run) *models.ScheduleRun {
+	if run == nil {
+		return nil
+	}
+	return &models.ScheduleRun{
+		ScheduledAt:   run.ScheduledAt,
+		FiredAt:       run.FiredAt,
+		Outcome:       run.Outcome,
+		ExecutionName: run.ExecutionName,
+		Manual:        run.Manual,
+		Reason:        run.Reason,
+	}
+}
+
+func scheduleResponse(schedule *schedules.Schedule) *models.ScheduleResponse {
+	timeZone := schedule.TimeZone
+	if timeZone == "" {
+		timeZone = "UTC"
+	}
+	return &models.ScheduleResponse{
+		ID:              schedule.ID,
+		Name:            schedule.Name,
+		StateMachineID:  schedule.StateMachineID,
+		Cron:            schedule.Cron,
+		IntervalSeconds: schedule.IntervalSeconds,
+		TimeZone:        timeZone,
+		Input:           schedule.Input,
+		OverlapPolicy:   schedule.OverlapPolicy,
+		Paused:          schedule.Paused,
+		CreatedAt:       schedule.CreatedAt,
+		UpdatedAt:       schedule.UpdatedAt,
+		NextRunAt:       schedule.NextRunAt,
+		LastRun:         scheduleRunResponse(schedule.LastRun),
+	}
+}
+
+// CreateSchedule creates a schedule that starts executions of a state machine on a cron
+// expression or a fixed interval. Schedules are fired by workers started with
+// WorkerConfig.EnableScheduler.
+func CreateSchedule(c *gin.Context) {
+	repoManager, ok := middleware.GetRepositoryManager(c)
+	if !ok {
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
+		return
+	}
+	store, ok := scheduleStore(c)
+	if !ok {
+		return
+	}
+
+	var req models.CreateScheduleRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	schedule := &schedules.Schedule{
+		Name:            req.Name,
+		StateMachineID:  req.StateMachineID,
+		Cron:            req.Cron,
+		IntervalSeconds: req.IntervalSeconds,
+		TimeZone:        req.TimeZone,
+		Input:           req.Input,
+		OverlapPolicy:   req.OverlapPolicy,
+		Paused:          req.Paused,
+	}
+	if schedule.OverlapPolicy == "" {
+		schedule.OverlapPolicy = schedules.OverlapSkip
+	}
+
+	var errs fieldErrors
+	validateSchedule(&errs, schedule)
+	if errs.respond(c) {
+		return
+	}
+
+	if _, err := repoManager.GetStateMachine(c.Request.Context(), req.StateMachineID); err != nil {
+		respondLookupError(c, err, models.CodeStateMachineNotFound, "State machine not found")
+		return
+	}
+
+	if err := store.Create(c.Request.Context(), schedule); err != nil {
+		respondScheduleError(c, err, "Failed to create schedule")
+		return
+	}
+	c.JSON(http.StatusCreated, scheduleResponse(schedule))
+}
+
+// ListSchedules lists schedules, oldest first
+// Query parameters:
+// - stateMachineId: Only schedules of this state machine (optional)
+// - limit: Page size, 1-1000 (optional, default: 100)
+// - offset: Schedules to skip (optional, default: 0)
+func ListSchedules(c *gin.Context) {
+	store, ok := scheduleStore(c)
+	if !ok {
+		return
+	}
+
+	var errs fieldErrors
+	stateMachineID := c.Query("stateMachineId")
+	limit := queryInt(c, &errs, "limit", 100, 1, 1000)
+	offset := queryInt(c, &errs, "offset", 0, 0, math.MaxInt32)
+	if errs.respond(c) {
+		return
+	}
+
+	all, err := store.List(c.Request.Context())
+	if err != nil {
+		respondScheduleError(c, err, "Failed to list schedules")
+		return
+	}
+
+	matching := make([]*models.ScheduleResponse, 0, len(all))
+	for _, schedule := range all {
+		if stateMachineID == "" || schedule.StateMachineID == stateMachineID {
+			matching = append(matching, scheduleResponse(schedule))
+		}
+	}
+	page := matching[min(offset, len(matching)):min(offset+limit, len(matching))]
+
+	c.JSON(http.StatusOK, models.ListSchedulesResponse{
+		Schedules: page,
+		Total:     len(matching),
+		Limit:     limit,
+		Offset:    offset,
+	})
+}
+
+// GetSchedule returns a schedule with its last and next run
+func GetSchedule(c *gin.Context) {
+	store, ok := scheduleStore(c)
+	if !ok {
+		return
+	}
+	schedule, err := store.Get(c.Request.Context(), c.Param("scheduleId"))
+	if err != nil {
+		respondScheduleError(c, err, "Failed to read schedule")
+		return
+	}
+	c.JSON(http.StatusOK, scheduleResponse(schedule))
+}
+
+// DeleteSchedule deletes a schedule. Executions it started are not affected.
+func DeleteSchedule(c *gin.Context) {
+	store, ok := scheduleStore(c)
+	if !ok {
+		return
+	}
+	scheduleID := c.Param("scheduleId")
+	if err := store.Delete(c.Request.Context(), scheduleID); err != nil {
+		respondScheduleError(c, err, "Failed to delete schedule")
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Message: "Schedule deleted",
+		Data:    gin.H{"scheduleId": scheduleID},
+	})
+}
+
+// PauseSchedule stops a schedule from firing until it is resumed
+func PauseSchedule(c *gin.Context) {
+	store, ok := scheduleStore(c)
+	if !ok {
+		return
+	}
+	schedule, err := store.Pause(c.Request.Context(), c.Param("scheduleId"))
+	if err != nil {
+		respondScheduleError(c, err, "Failed to pause schedule")
+		return
+	}
+	c.JSON(http.StatusOK, scheduleResponse(schedule))
+}
+
+// ResumeSchedule lets a paused schedule fire again from its next run. Runs missed while
+// it was paused are not made up.
+func ResumeSchedule(c *gin.Context) {
+	store, ok := scheduleStore(c)
+	if !ok {
+		return
+	}
+	schedule, err := store.Resume(c.Request.Context(), c.Param("scheduleId"))
+	if err != nil {
+		respondScheduleError(c, err, "Failed to resume schedule")
+		return
+	}
+	c.JSON(http.StatusOK, scheduleResponse(schedule))
+}
+
+// TriggerSchedule fires a schedule now, applying its overlap policy, even while it is
+// paused. Its next scheduled run is not affected. The response is 202 when an execution
+// was enqueued and 200 when the overlap policy skipped the run.
+func TriggerSchedule(c *gin.Context) {
+	repoManager, ok := middleware.GetRepositoryManager(c)
+	if !ok {
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
+		return
+	}
+	redisClient, ok := middleware.GetRedisClient(c)
+	if !ok {
+		respondNotConfigured(c, models.CodeRedisNotConfigured, "Redis client not configured")
+		return
+	}
+	queueClient, ok := middleware.GetQueueClient(c)
+	if !ok || queueClient == nil {
+		respondNotConfigured(c, models.CodeQueueNotConfigured, "Queue client not configured")
+		return
+	}
+
+	scheduleID := c.Param("scheduleId")
+	scheduler := schedules.NewScheduler(redisClient, repoManager, queueClient)
+	scheduler.RetryPolicy = middleware.GetRetryPolicy(c)
+	run, err := scheduler.Trigger(c.Request.Context(), scheduleID)
+	if run == nil {
+		respondScheduleError(c, err, "Failed to trigger schedule")
+		return
+	}
+	if err != nil {
+		// The run was started but could not be recorded as the last run
+		_ = c.Error(err)
+	}
+
+	switch run.Outcome {
+	case schedules.OutcomeFailed:
+		respondError(c, http.StatusInternalServerError, models.CodeExecutionFailed, "Failed to start the scheduled execution", run.Reason)
+	case schedules.OutcomeSkipped:
+		c.JSON(http.StatusOK, models.TriggerScheduleResponse{ScheduleID: scheduleID, Run: *scheduleRunResponse(run)})
+	default:
+		c.JSON(http.StatusAccepted, models.TriggerScheduleResponse{ScheduleID: scheduleID, Run: *scheduleRunResponse(run)})
+	}
+}
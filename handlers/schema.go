@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hussainpithawala/state-machine-amz-gin/models"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// Metadata keys holding the JSON Schemas of a state machine
+const (
+	inputSchemaKey  = "inputSchema"
+	outputSchemaKey = "outputSchema"
+)
+
+// executionSchemas holds the compiled input/output schemas of a state machine.
+// A nil schema accepts any value.
+type executionSchemas struct {
+	input  *jsonschema.Schema
+	output *jsonschema.Schema
+}
+
+// executionSchemasFor loads the schemas of a state machine, writing an error response if it fails
+func executionSchemasFor(c *gin.Context, repoManager *repository.Manager, stateMachineID string) (*executionSchemas, bool) {
+	record, err := repoManager.GetStateMachine(c.Request.Context(), stateMachineID)
+	if err != nil {
+		respondLookupError(c, err, models.CodeStateMachineNotFound, "State machine not found")
+		return nil, false
+	}
+
+	schemas, err := compileExecutionSchemas(record.Metadata)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, models.CodeInvalidSchema, "Invalid state machine schema", err.Error())
+		return nil, false
+	}
+	return schemas, true
+}
+
+// compileExecutionSchemas compiles the inputSchema and outputSchema entries of metadata
+func compileExecutionSchemas(metadata map[string]interface{}) (*executionSchemas, error) {
+	schemas := &executionSchemas{}
+	var err error
+	if schemas.input, err = compileSchema(inputSchemaKey, metadata[inputSchemaKey]); err != nil {
+		return nil, err
+	}
+	if schemas.output, err = compileSchema(outputSchemaKey, metadata[outputSchemaKey]); err != nil {
+		return nil, err
+	}
+	return schemas, nil
+}
+
+// compileSchema compiles a JSON Schema document. A nil document yields a nil schema.
+func compileSchema(name string, document interface{}) (*jsonschema.Schema, error) {
+	if document == nil {
+		return nil, nil
+	}
+
+	// Round-trip through JSON so numbers are decoded the way the compiler expects
+	data, err := json.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+
+	url := name + ".json"
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(url, doc); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	schema, err := compiler.Compile(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return schema, nil
+}
+
+// validateInput checks an execution input. Problems are reported under the given field path.
+func (s *executionSchemas) validateInput(field string, input interface{}) fieldErrors {
+	return validateAgainst(s.input, field, input)
+}
+
+// validateOutput checks an output supplied when resuming an execution
+func (s *executionSchemas) validateOutput(field string, output interface{}) fieldErrors {
+	return validateAgainst(s.output, field, output)
+}
+
+func validateAgainst(schema *jsonschema.Schema, field string, value interface{}) fieldErrors {
+	var errs fieldErrors
+	if schema == nil {
+		return errs
+	}
+
+	// Normalize Go values (structs, typed maps, float64) into the JSON model of the validator
+	data, err := json.Marshal(value)
+	if err != nil {
+		errs.add(field, "%v", err)
+		return errs
+	}
+	instance, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		errs.add(field, "%v", err)
+		return errs
+	}
+
+	err = schema.Validate(instance)
+	if err == nil {
+		return errs
+	}
+
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		errs.add(field, "%v", err)
+		return errs
+	}
+
+	for _, unit := range validationErr.BasicOutput().Errors {
+		if unit.Error == nil {
+			continue
+		}
+		errs.add(instancePath(field, unit.InstanceLocation), "%s", unit.Error.String())
+	}
+	if len(errs) == 0 {
+		errs.add(field, "%v", validationErr)
+	}
+	return errs
+}
+
+// instancePath converts a JSON pointer such as "/items/0/sku" into "input.items[0].sku"
+func instancePath(field, pointer string) string {
+	path := field
+	if pointer == "" {
+		return path
+	}
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		if _, err := strconv.Atoi(token); err == nil {
+			path += "[" + token + "]"
+			continue
+		}
+		path = joinPath(path, token)
+	}
+	return path
+}
+
+// schemaMetadata merges the schemas of a create request into its metadata
+func schemaMetadata(metadata map[string]interface{}, inputSchema, outputSchema interface{}) map[string]interface{} {
+	if inputSchema == nil && outputSchema == nil {
+		return metadata
+	}
+	merged := make(map[string]interface{}, len(metadata)+2)
+	for key, value := range metadata {
+		merged[key] = value
+	}
+	if inputSchema != nil {
+		merged[inputSchemaKey] = inputSchema
+	}
+	if outputSchema != nil {
+		merged[outputSchemaKey] = outputSchema
+	}
+	return merged
+}
+
+// splitBulkInputs separates bulk inputs that match the input schema from rejected ones
+func splitBulkInputs(schemas *executionSchemas, field string, inputs []interface{}) (valid []interface{}, rejected []models.RejectedItem) {
+	for i, input := range inputs {
+		if errs := schemas.validateInput(fmt.Sprintf("%s[%d]", field, i), input); len(errs) > 0 {
+			rejected = append(rejected, models.RejectedItem{Index: i, Errors: errs})
+			continue
+		}
+		valid = append(valid, input)
+	}
+	return valid, rejected
+}
+
+// validateBulkInputs drops inputs rejected by the input schema. If no input is valid
+// a 400 listing every problem is written and ok is false.
+func validateBulkInputs(c *gin.Context, repoManager *repository.Manager, stateMachineID string, inputs []interface{}) (valid []interface{}, rejected []models.RejectedItem, ok bool) {
+	schemas, ok := executionSchemasFor(c, repoManager, stateMachineID)
+	if !ok {
+		return nil, nil, false
+	}
+
+	valid, rejected = splitBulkInputs(schemas, "inputs", inputs)
+	if len(valid) == 0 {
+		respondRejected(c, rejected)
+		return nil, rejected, false
+	}
+	return valid, rejected, true
+}
+
+// respondRejected writes a 400 listing the problems of every rejected bulk input
+func respondRejected(c *gin.Context, rejected []models.RejectedItem) {
+	var errs fieldErrors
+	for _, item := range rejected {
+		errs = append(errs, item.Errors...)
+	}
+	errs.respond(c)
+}
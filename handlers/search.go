@@ -0,0 +1,581 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
+	"github.com/hussainpithawala/state-machine-amz-gin/models"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"gorm.io/gorm"
+)
+
+// Sort orders accepted by the "order" query parameter. Executions are sorted by start
+// time, with the execution ID breaking ties so the order is stable across pages.
+const (
+	orderDesc = "desc"
+	orderAsc  = "asc"
+)
+
+// maxScannedExecutions bounds how many executions the in-memory fallback inspects
+// when the repository cannot evaluate JSON predicates itself
+const maxScannedExecutions = 1000
+
+// errSearchNotSupported is returned when a search needs filters the configured repository cannot run
+var errSearchNotSupported = errors.New("execution search requires the gorm-postgres repository")
+
+// errScanLimitExceeded is returned when the in-memory fallback would have to inspect more
+// than maxScannedExecutions executions to answer a search
+var errScanLimitExceeded = fmt.Errorf("more than %d executions match the other filters; the filter requires the gorm-postgres repository", maxScannedExecutions)
+
+// gormRepository is implemented by repositories that expose their database handle
+type gormRepository interface {
+	GetDB() *gorm.DB
+}
+
+// executionSearch holds the filters and page position of an execution search
+type executionSearch struct {
+	StateMachineID string
+	Statuses       []string
+	CurrentState   string
+	NamePrefix     string
+	NamePattern    string
+	ErrorContains  string
+	Metadata       map[string]string
+	Tags           map[string]string
+	Input          []*jsonPredicate
+	Output         []*jsonPredicate
+	Attributes     map[string]string
+	attributes     []attributeFilter
+	StartedAfter   time.Time
+	StartedBefore  time.Time
+	EndedAfter     time.Time
+	EndedBefore    time.Time
+	Order          string
+	Limit          int
+	Offset         int
+	Cursor         *executionCursor
+}
+
+// executionCursor is the position after the last execution of a page
+type executionCursor struct {
+	StartTime   time.Time `json:"t"`
+	ExecutionID string    `json:"id"`
+	Order       string    `json:"o"`
+}
+
+// encodeCursor returns the opaque form of a cursor
+func encodeCursor(cursor executionCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor produced by encodeCursor
+func decodeCursor(value string) (*executionCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor executionCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.ExecutionID == "" || cursor.StartTime.IsZero() {
+		return nil, errors.New("incomplete cursor")
+	}
+	return &cursor, nil
+}
+
+// parseExecutionSearch reads the search filters from the query string. Problems are
+// recorded in errs. Paging parameters are only read when withPaging is set.
+func parseExecutionSearch(c *gin.Context, errs *fieldErrors, withPaging bool) *executionSearch {
+	search := &executionSearch{
+		CurrentState:  c.Query("currentState"),
+		NamePrefix:    c.Query("namePrefix"),
+		NamePattern:   c.Query("namePattern"),
+		ErrorContains: c.Query("errorContains"),
+		StartedAfter:  queryTime(c, errs, "startedAfter"),
+		StartedBefore: queryTime(c, errs, "startedBefore"),
+		EndedAfter:    queryTime(c, errs, "endedAfter"),
+		EndedBefore:   queryTime(c, errs, "endedBefore"),
+	}
+
+	for _, status := range queryList(c, "status") {
+		if oneOf(errs, "status", status, executionStatuses...) != "" {
+			search.Statuses = append(search.Statuses, status)
+		}
+	}
+
+	for _, pair := range c.QueryArray("metadata") {
+		key, value, ok := strings.Cut(pair, ":")
+		if !ok || key == "" {
+			errs.add("metadata", "must be in the form key:value")
+			continue
+		}
+		if search.Metadata == nil {
+			search.Metadata = make(map[string]string)
+		}
+		search.Metadata[key] = value
+	}
+
+	for _, pair := range c.QueryArray("tag") {
+		key, value, ok := strings.Cut(pair, ":")
+		if !ok || key == "" {
+			errs.add("tag", "must be in the form key:value")
+			continue
+		}
+		if search.Tags == nil {
+			search.Tags = make(map[string]string)
+		}
+		search.Tags[key] = value
+	}
+
+	search.Input = queryPredicates(c, errs, "input")
+	search.Output = queryPredicates(c, errs, "output")
+
+	for _, pair := range c.QueryArray("attr") {
+		name, value, ok := strings.Cut(pair, ":")
+		if !ok || name == "" {
+			errs.add("attr", "must be in the form name:value")
+			continue
+		}
+		if search.Attributes == nil {
+			search.Attributes = make(map[string]string)
+		}
+		search.Attributes[name] = value
+	}
+
+	if !search.StartedAfter.IsZero() && !search.StartedBefore.IsZero() && !search.StartedBefore.After(search.StartedAfter) {
+		errs.add("startedBefore", "must be after startedAfter")
+	}
+	if !search.EndedAfter.IsZero() && !search.EndedBefore.IsZero() && !search.EndedBefore.After(search.EndedAfter) {
+		errs.add("endedBefore", "must be after endedAfter")
+	}
+
+	if !withPaging {
+		return search
+	}
+
+	search.Order = queryOneOf(c, errs, "order", orderDesc, orderAsc)
+	if search.Order == "" {
+		search.Order = orderDesc
+	}
+	search.Limit = queryInt(c, errs, "limit", defaultListLimit, 1, maxListLimit)
+	search.Offset = queryInt(c, errs, "offset", 0, 0, math.MaxInt32)
+
+	if raw := c.Query("cursor"); raw != "" {
+		cursor, err := decodeCursor(raw)
+		switch {
+		case err != nil:
+			errs.add("cursor", "is not a valid cursor")
+		case cursor.Order != search.Order:
+			errs.add("cursor", "was issued for order=%s", cursor.Order)
+		case search.Offset > 0:
+			errs.add("offset", "cannot be combined with cursor")
+		default:
+			search.Cursor = cursor
+		}
+	}
+	return search
+}
+
+// queryList returns a repeatable query parameter, also splitting comma-separated values
+func queryList(c *gin.Context, name string) []string {
+	var values []string
+	for _, raw := range c.QueryArray(name) {
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// queryPredicates parses a repeatable JSONPath predicate query parameter
+func queryPredicates(c *gin.Context, errs *fieldErrors, name string) []*jsonPredicate {
+	var predicates []*jsonPredicate
+	for _, raw := range c.QueryArray(name) {
+		predicate, err := parseJSONPredicate(raw)
+		if err != nil {
+			errs.add(name, "invalid predicate %q: %v", raw, err)
+			continue
+		}
+		predicates = append(predicates, predicate)
+	}
+	return predicates
+}
+
+// queryTime parses an optional RFC3339 timestamp query parameter
+func queryTime(c *gin.Context, errs *fieldErrors, name string) time.Time {
+	raw := c.Query(name)
+	if raw == "" {
+		return time.Time{}
+	}
+	value, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		errs.add(name, "must be an RFC3339 timestamp")
+		return time.Time{}
+	}
+	return value
+}
+
+// resolveAttributes looks up the search attributes used by the search in the state machine
+// metadata. It writes an error response and returns false if they cannot be resolved.
+func (s *executionSearch) resolveAttributes(c *gin.Context, repoManager *repository.Manager) bool {
+	if len(s.Attributes) == 0 {
+		return true
+	}
+
+	var errs fieldErrors
+	if s.StateMachineID == "" {
+		errs.add("attr", "requires stateMachineId")
+		return !errs.respond(c)
+	}
+
+	record, err := repoManager.GetStateMachine(c.Request.Context(), s.StateMachineID)
+	if err != nil {
+		respondLookupError(c, err, models.CodeStateMachineNotFound, "State machine not found")
+		return false
+	}
+	declared, err := searchAttributesFrom(record.Metadata)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, models.CodeInvalidDefinition, "Stored search attributes are invalid", err.Error())
+		return false
+	}
+
+	for _, name := range sortedStringKeys(s.Attributes) {
+		attribute, ok := declared[name]
+		if !ok {
+			errs.add("attr", "unknown search attribute %q", name)
+			continue
+		}
+		s.attributes = append(s.attributes, attributeFilter{attribute: attribute, value: s.Attributes[name]})
+	}
+	return !errs.respond(c)
+}
+
+// hasJSONFilters reports whether the search filters on execution input, output or tags
+func (s *executionSearch) hasJSONFilters() bool {
+	return len(s.Input) > 0 || len(s.Output) > 0 || len(s.attributes) > 0 || len(s.Tags) > 0
+}
+
+// matchJSON evaluates the input, output, search attribute and tag filters in memory
+func (s *executionSearch) matchJSON(record *repository.ExecutionRecord) bool {
+	if len(s.Tags) > 0 {
+		tags := middleware.ExecutionTagsFrom(record.Metadata)
+		for key, value := range s.Tags {
+			if actual, ok := tags[key]; !ok || actual != value {
+				return false
+			}
+		}
+	}
+	for _, predicate := range s.Input {
+		if !predicate.match(record.Input) {
+			return false
+		}
+	}
+	for _, predicate := range s.Output {
+		if !predicate.match(record.Output) {
+			return false
+		}
+	}
+	for _, filter := range s.attributes {
+		if !filter.match(record) {
+			return false
+		}
+	}
+	return true
+}
+
+// legacyFilter converts the search into a repository filter when it only uses filters the
+// repository interface supports. Repositories without database access fall back to it;
+// JSON filters are then evaluated in memory.
+func (s *executionSearch) legacyFilter() (*repository.ExecutionFilter, bool) {
+	if len(s.Statuses) > 1 || s.CurrentState != "" || s.NamePrefix != "" || s.NamePattern != "" ||
+		s.ErrorContains != "" || len(s.Metadata) > 0 || !s.EndedAfter.IsZero() || !s.EndedBefore.IsZero() ||
+		s.Cursor != nil || s.Order == orderAsc {
+		return nil, false
+	}
+
+	filter := &repository.ExecutionFilter{
+		StateMachineID: s.StateMachineID,
+		StartAfter:     s.StartedAfter,
+		StartBefore:    s.StartedBefore,
+		Limit:          s.Limit,
+		Offset:         s.Offset,
+	}
+	if len(s.Statuses) == 1 {
+		filter.Status = s.Statuses[0]
+	}
+	return filter, true
+}
+
+// where applies the filters of the search to a query on the executions table
+func (s *executionSearch) where(query *gorm.DB) *gorm.DB {
+	if s.StateMachineID != "" {
+		query = query.Where("state_machine_id = ?", s.StateMachineID)
+	}
+	if len(s.Statuses) > 0 {
+		query = query.Where("status IN ?", s.Statuses)
+	}
+	if s.CurrentState != "" {
+		query = query.Where("current_state = ?", s.CurrentState)
+	}
+	if s.NamePrefix != "" {
+		query = query.Where("name LIKE ?", escapeLike(s.NamePrefix)+"%")
+	}
+	if s.NamePattern != "" {
+		query = query.Where("name LIKE ?", globToLike(s.NamePattern))
+	}
+	if s.ErrorContains != "" {
+		query = query.Where("error ILIKE ?", "%"+escapeLike(s.ErrorContains)+"%")
+	}
+	for _, key := range sortedStringKeys(s.Metadata) {
+		query = query.Where("metadata ->> ? = ?", key, s.Metadata[key])
+	}
+	for _, key := range sortedStringKeys(s.Tags) {
+		query = query.Where("metadata -> '"+middleware.ExecutionTagsMetadataKey+"' ->> ? = ?", key, s.Tags[key])
+	}
+	for _, predicate := range s.Input {
+		condition, arg := predicate.sql("input")
+		query = query.Where(condition, arg)
+	}
+	for _, predicate := range s.Output {
+		condition, arg := predicate.sql("output")
+		query = query.Where(condition, arg)
+	}
+	for _, filter := range s.attributes {
+		query = query.Where(filter.attribute.expression()+" = ?", filter.value)
+	}
+	if !s.StartedAfter.IsZero() {
+		query = query.Where("start_time >= ?", s.StartedAfter)
+	}
+	if !s.StartedBefore.IsZero() {
+		query = query.Where("start_time < ?", s.StartedBefore)
+	}
+	if !s.EndedAfter.IsZero() {
+		query = query.Where("end_time >= ?", s.EndedAfter)
+	}
+	if !s.EndedBefore.IsZero() {
+		query = query.Where("end_time < ?", s.EndedBefore)
+	}
+	return query
+}
+
+// page applies the cursor, sort order and limit of the search. One extra row is
+// requested so the caller can tell whether another page exists.
+func (s *executionSearch) page(query *gorm.DB) *gorm.DB {
+	if s.Order == orderAsc {
+		if s.Cursor != nil {
+			query = query.Where("(start_time, execution_id) > (?, ?)", s.Cursor.StartTime, s.Cursor.ExecutionID)
+		}
+		query = query.Order("start_time ASC").Order("execution_id ASC")
+	} else {
+		if s.Cursor != nil {
+			query = query.Where("(start_time, execution_id) < (?, ?)", s.Cursor.StartTime, s.Cursor.ExecutionID)
+		}
+		query = query.Order("start_time DESC").Order("execution_id DESC")
+	}
+	if s.Offset > 0 {
+		query = query.Offset(s.Offset)
+	}
+	return query.Limit(s.Limit + 1)
+}
+
+// searchExecutions runs the search and returns one page of executions and the
+// cursor of the next page, which is empty on the last page
+func searchExecutions(c *gin.Context, repoManager *repository.Manager, search *executionSearch) ([]*repository.ExecutionRecord, string, error) {
+	db, ok := executionsDB(repoManager)
+	if !ok {
+		filter, ok := search.legacyFilter()
+		if !ok {
+			return nil, "", errSearchNotSupported
+		}
+		if !search.hasJSONFilters() {
+			records, err := repoManager.ListExecutions(c.Request.Context(), filter)
+			return records, "", err
+		}
+		records, err := scanExecutions(c, repoManager, filter, search, search.Offset+search.Limit)
+		if err != nil || len(records) <= search.Offset {
+			return nil, "", err
+		}
+		return records[search.Offset:], "", nil
+	}
+
+	var rows []repository.ExecutionModel
+	query := search.page(search.where(db.WithContext(c.Request.Context()).Model(&repository.ExecutionModel{})))
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(rows) > search.Limit {
+		rows = rows[:search.Limit]
+		last := rows[len(rows)-1]
+		next = encodeCursor(executionCursor{StartTime: last.StartTime, ExecutionID: last.ExecutionID, Order: search.Order})
+	}
+
+	records := make([]*repository.ExecutionRecord, len(rows))
+	for i := range rows {
+		records[i] = executionRecord(&rows[i])
+	}
+	return records, next, nil
+}
+
+// countExecutions counts the executions matching the filters of the search
+func countExecutions(c *gin.Context, repoManager *repository.Manager, search *executionSearch) (int64, error) {
+	db, ok := executionsDB(repoManager)
+	if !ok {
+		filter, ok := search.legacyFilter()
+		if !ok {
+			return 0, errSearchNotSupported
+		}
+		filter.Limit, filter.Offset = 0, 0
+		if !search.hasJSONFilters() {
+			return repoManager.CountExecutions(c.Request.Context(), filter)
+		}
+		records, err := scanExecutions(c, repoManager, filter, search, 0)
+		return int64(len(records)), err
+	}
+
+	var count int64
+	err := search.where(db.WithContext(c.Request.Context()).Model(&repository.ExecutionModel{})).Count(&count).Error
+	return count, err
+}
+
+// scanExecutions pages through the executions matching filter and keeps those matching the
+// JSON filters of the search, until want executions were found (all if want is 0). It
+// returns errScanLimitExceeded rather than a partial result when that takes inspecting
+// more than maxScannedExecutions executions.
+func scanExecutions(c *gin.Context, repoManager *repository.Manager, filter *repository.ExecutionFilter, search *executionSearch, want int) ([]*repository.ExecutionRecord, error) {
+	page := *filter
+	page.Limit = maxListLimit
+	page.Offset = 0
+
+	var matches []*repository.ExecutionRecord
+	for {
+		records, err := repoManager.ListExecutions(c.Request.Context(), &page)
+		if err != nil {
+			return nil, err
+		}
+		if page.Offset+len(records) > maxScannedExecutions {
+			return nil, errScanLimitExceeded
+		}
+		for _, record := range records {
+			if search.matchJSON(record) {
+				matches = append(matches, record)
+				if want > 0 && len(matches) == want {
+					return matches, nil
+				}
+			}
+		}
+		if len(records) < page.Limit {
+			return matches, nil
+		}
+		page.Offset += page.Limit
+	}
+}
+
+// executionsDB returns the database handle of the repository, if it exposes one
+func executionsDB(repoManager *repository.Manager) (*gorm.DB, bool) {
+	repo, ok := repoManager.GetRepository().(gormRepository)
+	if !ok {
+		return nil, false
+	}
+	db := repo.GetDB()
+	return db, db != nil
+}
+
+// respondSearchError reports a failed execution search
+func respondSearchError(c *gin.Context, title string, err error) {
+	if errors.Is(err, errSearchNotSupported) {
+		respondError(c, http.StatusNotImplemented, models.CodeSearchNotSupported, "Search not supported", err.Error())
+		return
+	}
+	if errors.Is(err, errScanLimitExceeded) {
+		respondError(c, http.StatusUnprocessableEntity, models.CodeSearchScanLimitExceeded, "Search scan limit exceeded", err.Error())
+		return
+	}
+	respondRepositoryError(c, title, err)
+}
+
+// executionRecord converts a row of the executions table the same way the gorm repository does
+func executionRecord(model *repository.ExecutionModel) *repository.ExecutionRecord {
+	record := &repository.ExecutionRecord{
+		ExecutionID:           model.ExecutionID,
+		StateMachineID:        model.StateMachineID,
+		Name:                  model.Name,
+		Status:                model.Status,
+		CurrentState:          model.CurrentState,
+		Error:                 model.Error,
+		HistorySequenceNumber: model.HistorySequenceNumber,
+		CreatedAt:             model.CreatedAt,
+		UpdatedAt:             model.UpdatedAt,
+	}
+	if model.Input != nil {
+		record.Input = map[string]interface{}(model.Input)
+	}
+	if model.Output != nil {
+		record.Output = map[string]interface{}(model.Output)
+	}
+	if model.Metadata != nil {
+		record.Metadata = model.Metadata
+	}
+	if !model.StartTime.IsZero() {
+		record.StartTime = &model.StartTime
+	}
+	if !model.EndTime.IsZero() {
+		record.EndTime = &model.EndTime
+	}
+	return record
+}
+
+// executionResponses converts repository records into API responses
+func executionResponses(records []*repository.ExecutionRecord) []*models.ExecutionResponse {
+	executions := make([]*models.ExecutionResponse, len(records))
+	for i, record := range records {
+		executions[i] = &models.ExecutionResponse{
+			ExecutionID:    record.ExecutionID,
+			StateMachineID: record.StateMachineID,
+			Name:           record.Name,
+			Status:         record.Status,
+			CurrentState:   record.CurrentState,
+			Input:          record.Input,
+			Output:         record.Output,
+			StartTime:      record.StartTime,
+			EndTime:        record.EndTime,
+			Error:          record.Error,
+			Metadata:       record.Metadata,
+			Tags:           middleware.ExecutionTagsFrom(record.Metadata),
+		}
+	}
+	return executions
+}
+
+// escapeLike escapes the LIKE wildcards of a literal value
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// globToLike converts a glob pattern using * and ? into a LIKE pattern
+func globToLike(pattern string) string {
+	return strings.NewReplacer("*", "%", "?", "_").Replace(escapeLike(pattern))
+}
+
+func sortedStringKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
@@ -15,67 +17,58 @@ import (
 func CreateStateMachine(c *gin.Context) {
 	repoManager, ok := middleware.GetRepositoryManager(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Repository manager not configured",
-			Message: "Repository manager is not available in context",
-			Code:    http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 
 	var req models.CreateStateMachineRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Invalid request",
-			Message: err.Error(),
-			Code:    http.StatusBadRequest,
-		})
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	// Reject invalid schemas before anything is stored
+	metadata := schemaMetadata(req.Metadata, req.InputSchema, req.OutputSchema)
+	if _, err := compileExecutionSchemas(metadata); err != nil {
+		respondError(c, http.StatusBadRequest, models.CodeInvalidSchema, "Invalid schema", err.Error())
 		return
 	}
 
 	// Convert definition to JSON bytes
 	defBytes, err := json.Marshal(req.Definition)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Invalid definition",
-			Message: err.Error(),
-			Code:    http.StatusBadRequest,
-		})
+		respondError(c, http.StatusBadRequest, models.CodeInvalidDefinition, "Invalid definition", err.Error())
 		return
 	}
 
 	// Create state machine
 	sm, err := persistent.New(defBytes, true, req.ID, repoManager)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to create state machine",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondError(c, http.StatusBadRequest, models.CodeInvalidDefinition, "Invalid state machine definition", err.Error())
 		return
 	}
 
 	// Save definition
 	if err := sm.SaveDefinition(c.Request.Context()); err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to save state machine definition",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondRepositoryError(c, "Failed to save state machine definition", err)
 		return
 	}
 
 	// Retrieve saved definition
 	record, err := repoManager.GetStateMachine(c.Request.Context(), req.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to retrieve state machine",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondRepositoryError(c, "Failed to retrieve state machine", err)
 		return
 	}
 
+	// The definition record does not carry metadata, store it separately
+	if len(metadata) > 0 {
+		record.Metadata = metadata
+		if err := repoManager.SaveStateMachine(c.Request.Context(), record); err != nil {
+			respondRepositoryError(c, "Failed to save state machine metadata", err)
+			return
+		}
+	}
+
 	c.JSON(http.StatusCreated, models.StateMachineResponse{
 		ID:          record.ID,
 		Name:        record.Name,
@@ -93,21 +86,14 @@ func CreateStateMachine(c *gin.Context) {
 func GetStateMachine(c *gin.Context) {
 	repoManager, ok := middleware.GetRepositoryManager(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Repository manager not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 
 	stateMachineID := c.Param("stateMachineId")
 	record, err := repoManager.GetStateMachine(c.Request.Context(), stateMachineID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error:   "State machine not found",
-			Message: err.Error(),
-			Code:    http.StatusNotFound,
-		})
+		respondLookupError(c, err, models.CodeStateMachineNotFound, "State machine not found")
 		return
 	}
 
@@ -128,10 +114,7 @@ func GetStateMachine(c *gin.Context) {
 func ListStateMachines(c *gin.Context) {
 	repoManager, ok := middleware.GetRepositoryManager(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Repository manager not configured",
-			Code:  http.StatusInternalServerError,
-		})
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
 		return
 	}
 
@@ -144,11 +127,7 @@ func ListStateMachines(c *gin.Context) {
 
 	records, err := repoManager.ListStateMachines(c.Request.Context(), filter)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Failed to list state machines",
-			Message: err.Error(),
-			Code:    http.StatusInternalServerError,
-		})
+		respondRepositoryError(c, "Failed to list state machines", err)
 		return
 	}
 
@@ -173,3 +152,66 @@ func ListStateMachines(c *gin.Context) {
 		Total:         len(stateMachines),
 	})
 }
+
+// UpdateStateMachineSchemas replaces the input/output JSON Schemas stored in a state machine's metadata
+func UpdateStateMachineSchemas(c *gin.Context) {
+	repoManager, ok := middleware.GetRepositoryManager(c)
+	if !ok {
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
+		return
+	}
+
+	var req models.UpdateStateMachineSchemasRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	schemas := map[string]interface{}{
+		inputSchemaKey:  req.InputSchema,
+		outputSchemaKey: req.OutputSchema,
+	}
+	if _, err := compileExecutionSchemas(schemas); err != nil {
+		respondError(c, http.StatusBadRequest, models.CodeInvalidSchema, "Invalid schema", err.Error())
+		return
+	}
+
+	stateMachineID := c.Param("stateMachineId")
+	record, err := repoManager.GetStateMachine(c.Request.Context(), stateMachineID)
+	if err != nil {
+		respondLookupError(c, err, models.CodeStateMachineNotFound, "State machine not found")
+		return
+	}
+
+	updated, err := updateStateMachineMetadata(c.Request.Context(), repoManager, record, schemas)
+	if err != nil {
+		respondRepositoryError(c, "Failed to save state machine metadata", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.StateMachineResponse{
+		ID:          updated.ID,
+		Name:        updated.Name,
+		Description: updated.Description,
+		Definition:  json.RawMessage(updated.Definition),
+		Type:        updated.Type,
+		Version:     updated.Version,
+		CreatedAt:   updated.CreatedAt,
+		UpdatedAt:   updated.UpdatedAt,
+		Metadata:    updated.Metadata,
+	})
+}
+
+// updateStateMachineMetadata saves a state machine with the given metadata entries set,
+// removing those whose value is nil, and returns the saved record. The record passed in
+// is left unchanged.
+func updateStateMachineMetadata(ctx context.Context, repoManager *repository.Manager, record *repository.StateMachineRecord, entries map[string]interface{}) (*repository.StateMachineRecord, error) {
+	updated := *record
+	for key, value := range entries {
+		updated.Metadata = middleware.WithMetadata(updated.Metadata, key, value)
+	}
+	updated.UpdatedAt = time.Now()
+	if err := repoManager.SaveStateMachine(ctx, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
+	"github.com/hussainpithawala/state-machine-amz-gin/models"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+)
+
+// Tag limits keep tags small enough to be stored with every execution
+const (
+	maxExecutionTags  = 50
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+// validateTags checks the tags of a request. Keys cannot contain ':' because the tag
+// query filter is written as key:value.
+func validateTags(errs *fieldErrors, field string, tags map[string]string) {
+	if len(tags) > maxExecutionTags {
+		errs.add(field, "must not have more than %d tags", maxExecutionTags)
+	}
+	for _, key := range sortedStringKeys(tags) {
+		validateTagKey(errs, field, key)
+		if utf8.RuneCountInString(tags[key]) > maxTagValueLength {
+			errs.add(field+"."+key, "must not be longer than %d characters", maxTagValueLength)
+		}
+	}
+}
+
+func validateTagKey(errs *fieldErrors, field, key string) {
+	switch {
+	case strings.TrimSpace(key) == "":
+		errs.add(field, "tag keys must not be empty")
+	case strings.Contains(key, ":"):
+		errs.add(field+"."+key, "tag keys must not contain ':'")
+	case utf8.RuneCountInString(key) > maxTagKeyLength:
+		errs.add(field+"."+key, "tag keys must not be longer than %d characters", maxTagKeyLength)
+	}
+}
+
+// taggedRepositoryManager returns the manager and context executions are started with so
+// they are saved with tags
+func taggedRepositoryManager(ctx context.Context, repoManager *repository.Manager, tags map[string]string) (context.Context, *repository.Manager) {
+	if len(tags) == 0 || repoManager == nil {
+		return ctx, repoManager
+	}
+	return middleware.WithExecutionTags(ctx, tags), middleware.NewTaggingRepositoryManager(repoManager)
+}
+
+// UpdateExecutionTags adds, changes and removes tags of an execution. Tags in "set" are
+// merged into the existing tags and keys in "remove" are deleted.
+func UpdateExecutionTags(c *gin.Context) {
+	repoManager, ok := middleware.GetRepositoryManager(c)
+	if !ok {
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
+		return
+	}
+
+	var req models.UpdateExecutionTagsRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	var errs fieldErrors
+	validateTags(&errs, "set", req.Set)
+	for _, key := range req.Remove {
+		validateTagKey(&errs, "remove", key)
+		if _, ok := req.Set[key]; ok {
+			errs.add("remove", "tag %q cannot be both set and removed", key)
+		}
+	}
+	if errs.respond(c) {
+		return
+	}
+
+	executionID := c.Param("executionId")
+	record, err := repoManager.GetExecution(c.Request.Context(), executionID)
+	if err != nil {
+		respondLookupError(c, err, models.CodeExecutionNotFound, "Execution not found")
+		return
+	}
+
+	tags := middleware.ExecutionTagsFrom(record.Metadata)
+	if tags == nil {
+		tags = make(map[string]string, len(req.Set))
+	}
+	for key, value := range req.Set {
+		tags[key] = value
+	}
+	for _, key := range req.Remove {
+		delete(tags, key)
+	}
+	if len(tags) > maxExecutionTags {
+		errs.add("set", "execution must not have more than %d tags", maxExecutionTags)
+		errs.respond(c)
+		return
+	}
+
+	if err := saveExecutionTags(c.Request.Context(), repoManager, record, &req); err != nil {
+		respondRepositoryError(c, "Failed to update execution tags", err)
+		return
+	}
+
+	// Report the stored tags, which include changes made concurrently
+	if updated, err := repoManager.GetExecution(c.Request.Context(), executionID); err == nil {
+		tags = middleware.ExecutionTagsFrom(updated.Metadata)
+	}
+	if tags == nil {
+		tags = map[string]string{}
+	}
+
+	c.JSON(http.StatusOK, models.ExecutionTagsResponse{
+		ExecutionID: executionID,
+		Tags:        tags,
+	})
+}
+
+// saveExecutionTags stores the tag changes. On the gorm-postgres repository the change is
+// applied in a single UPDATE so concurrent updates do not overwrite each other; other
+// repositories save the whole record. A running execution keeps the stored tags when it is
+// saved again, see middleware.NewTaggingRepositoryManager.
+func saveExecutionTags(ctx context.Context, repoManager *repository.Manager, record *repository.ExecutionRecord, req *models.UpdateExecutionTagsRequest) error {
+	db, ok := executionsDB(repoManager)
+	if !ok {
+		tags := middleware.ExecutionTagsFrom(record.Metadata)
+		stored := make(map[string]interface{}, len(tags)+len(req.Set))
+		for key, value := range tags {
+			stored[key] = value
+		}
+		for key, value := range req.Set {
+			stored[key] = value
+		}
+		for _, key := range req.Remove {
+			delete(stored, key)
+		}
+
+		updated := *record
+		updated.Metadata = middleware.WithMetadata(record.Metadata, middleware.ExecutionTagsMetadataKey, stored)
+		return repoManager.GetRepository().SaveExecution(ctx, &updated)
+	}
+
+	set := req.Set
+	if set == nil {
+		set = map[string]string{}
+	}
+	setJSON, err := json.Marshal(set)
+	if err != nil {
+		return err
+	}
+	remove := make([]string, len(req.Remove))
+	for i, key := range req.Remove {
+		remove[i] = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(key) + `"`
+	}
+
+	return db.WithContext(ctx).Exec(
+		"UPDATE executions SET metadata = jsonb_set(COALESCE(metadata, '{}'::jsonb), '{"+middleware.ExecutionTagsMetadataKey+"}', "+
+			"(COALESCE(metadata -> '"+middleware.ExecutionTagsMetadataKey+"', '{}'::jsonb) || CAST(? AS jsonb)) - CAST(? AS text[])), updated_at = NOW() "+
+			"WHERE execution_id = ?",
+		string(setJSON), "{"+strings.Join(remove, ",")+"}", record.ExecutionID,
+	).Error
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hussainpithawala/state-machine-amz-gin/activities"
+	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
+	"github.com/hussainpithawala/state-machine-amz-gin/models"
+)
+
+// respondTaskError maps the errors of reports on callback tasks onto problems
+func respondTaskError(c *gin.Context, err error, title string) {
+	switch {
+	case errors.Is(err, activities.ErrTaskNotFound):
+		respondError(c, http.StatusNotFound, models.CodeTaskNotFound, "Task not found", "The task token is unknown or has expired")
+	case errors.Is(err, activities.ErrTaskNotRunning):
+		respondError(c, http.StatusConflict, models.CodeTaskNotRunning, "Task is not waiting", err.Error())
+	default:
+		respondActivityError(c, err, title)
+	}
+}
+
+// resumeParkedExecution queues the resume of the execution a worker parked on the task
+// reported on, if any, or writes an error
+func resumeParkedExecution(c *gin.Context, execution *activities.ParkedExecution, token string) bool {
+	if execution == nil {
+		return true
+	}
+	enqueuer := queueEnqueuer(c)
+	if enqueuer == nil {
+		respondNotConfigured(c, models.CodeQueueNotConfigured, "Queue client not configured")
+		return false
+	}
+	if _, err := middleware.EnqueueTaskTokenResume(enqueuer, execution, token, taskOptions(c, execution.StateMachineID, time.Time{})...); err != nil {
+		respondEnqueueError(c, err, "Failed to resume execution")
+		return false
+	}
+	return true
+}
+
+// SendTaskSuccess resumes the execution waiting on a ".waitForTaskToken" Task; the
+// output becomes the result of the Task state. A token can be reported on once.
+func SendTaskSuccess(c *gin.Context) {
+	store, ok := activityStore(c)
+	if !ok {
+		return
+	}
+	var req models.TaskSuccessRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	token := c.Param("token")
+	execution, err := store.Succeed(c.Request.Context(), token, req.Output)
+	if err != nil {
+		respondTaskError(c, err, "Failed to complete task")
+		return
+	}
+	if !resumeParkedExecution(c, execution, token) {
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Message: "Task succeeded",
+		Data:    gin.H{"taskToken": token},
+	})
+}
+
+// SendTaskFailure fails the ".waitForTaskToken" Task waiting on a token. The error name
+// is matched by the Retry and Catch fields of the Task state.
+func SendTaskFailure(c *gin.Context) {
+	store, ok := activityStore(c)
+	if !ok {
+		return
+	}
+	var req models.TaskFailureRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	token := c.Param("token")
+	execution, err := store.Fail(c.Request.Context(), token, req.Error, req.Cause)
+	if err != nil {
+		respondTaskError(c, err, "Failed to fail task")
+		return
+	}
+	if !resumeParkedExecution(c, execution, token) {
+		return
+	}
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Message: "Task failed",
+		Data:    gin.H{"taskToken": token},
+	})
+}
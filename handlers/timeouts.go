@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
+	"github.com/hussainpithawala/state-machine-amz-gin/models"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+)
+
+// UpdateWaitTimeouts replaces the wait timeouts of a state machine. Executions pausing in
+// a Message state afterwards time out after the configured wait: the worker resumes them
+// along the state's TimeoutPath or Catch, or fails them with States.Timeout. Message states
+// with their own TimeoutSeconds keep it.
+func UpdateWaitTimeouts(c *gin.Context) {
+	repoManager, ok := middleware.GetRepositoryManager(c)
+	if !ok {
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
+		return
+	}
+
+	var req models.UpdateWaitTimeoutsRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	stateMachineID := c.Param("stateMachineId")
+	record, err := repoManager.GetStateMachine(c.Request.Context(), stateMachineID)
+	if err != nil {
+		respondLookupError(c, err, models.CodeStateMachineNotFound, "State machine not found")
+		return
+	}
+
+	messageStates, err := middleware.MessageStates(record.Definition)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, models.CodeInvalidDefinition, "Stored state machine definition is invalid", err.Error())
+		return
+	}
+	var errs fieldErrors
+	for name := range req.States {
+		if _, ok := messageStates[name]; !ok {
+			errs.add(fmt.Sprintf("states.%s", name), "%q is not a Message state of the state machine", name)
+		}
+	}
+	if errs.respond(c) {
+		return
+	}
+
+	timeouts := middleware.WaitTimeouts{DefaultSeconds: req.DefaultSeconds, States: req.States}
+	var value interface{}
+	if timeouts.DefaultSeconds != 0 || len(timeouts.States) > 0 {
+		value = timeouts
+	}
+	if _, err := updateStateMachineMetadata(c.Request.Context(), repoManager, record, map[string]interface{}{middleware.WaitTimeoutsKey: value}); err != nil {
+		respondRepositoryError(c, "Failed to save state machine metadata", err)
+		return
+	}
+
+	states := timeouts.States
+	if states == nil {
+		states = map[string]int{}
+	}
+	c.JSON(http.StatusOK, models.WaitTimeoutsResponse{
+		StateMachineID: stateMachineID,
+		DefaultSeconds: timeouts.DefaultSeconds,
+		States:         states,
+	})
+}
+
+// ListExpiringExecutions lists the executions of a state machine waiting in a Message state
+// whose timeout expires within a window, soonest first. Executions whose timeout is
+// overdue are included.
+//
+// Query parameters:
+// - withinSeconds: Window from now, 0-31536000 (optional, default: 3600)
+// - limit: Page size, 1-1000 (optional, default: 100)
+// - offset: Executions to skip (optional, default: 0)
+func ListExpiringExecutions(c *gin.Context) {
+	repoManager, ok := middleware.GetRepositoryManager(c)
+	if !ok {
+		respondNotConfigured(c, models.CodeRepositoryNotConfigured, "Repository manager not configured")
+		return
+	}
+
+	var errs fieldErrors
+	within := queryInt(c, &errs, "withinSeconds", 3600, 0, middleware.MaxWaitTimeoutSeconds)
+	limit := queryInt(c, &errs, "limit", 100, 1, 1000)
+	offset := queryInt(c, &errs, "offset", 0, 0, math.MaxInt32)
+	if errs.respond(c) {
+		return
+	}
+
+	stateMachineID := c.Param("stateMachineId")
+	if _, err := repoManager.GetStateMachine(c.Request.Context(), stateMachineID); err != nil {
+		respondLookupError(c, err, models.CodeStateMachineNotFound, "State machine not found")
+		return
+	}
+
+	now := time.Now()
+	correlations, err := repoManager.ProcessTimedOutMessages(c.Request.Context(), now.Add(time.Duration(within)*time.Second).Unix())
+	if err != nil {
+		respondRepositoryError(c, "Failed to list waiting executions", err)
+		return
+	}
+
+	expiring := make([]*repository.MessageCorrelationRecord, 0, len(correlations))
+	for _, correlation := range correlations {
+		if correlation.StateMachineID == stateMachineID && correlation.TimeoutAt != nil {
+			expiring = append(expiring, correlation)
+		}
+	}
+	sort.Slice(expiring, func(i, j int) bool {
+		if *expiring[i].TimeoutAt != *expiring[j].TimeoutAt {
+			return *expiring[i].TimeoutAt < *expiring[j].TimeoutAt
+		}
+		return expiring[i].ExecutionID < expiring[j].ExecutionID
+	})
+	page := expiring[min(offset, len(expiring)):min(offset+limit, len(expiring))]
+
+	executions := make([]*models.ExpiringExecutionResponse, len(page))
+	for i, correlation := range page {
+		expiresAt := time.Unix(*correlation.TimeoutAt, 0).UTC()
+		executions[i] = &models.ExpiringExecutionResponse{
+			ExecutionID:      correlation.ExecutionID,
+			StateMachineID:   correlation.StateMachineID,
+			StateName:        correlation.StateName,
+			CorrelationID:    correlation.ID,
+			CorrelationKey:   correlation.CorrelationKey,
+			CorrelationValue: correlation.CorrelationValue,
+			WaitingSince:     time.Unix(correlation.CreatedAt, 0).UTC(),
+			ExpiresAt:        expiresAt,
+			ExpiresIn:        int64(expiresAt.Sub(now).Seconds()),
+		}
+	}
+
+	c.JSON(http.StatusOK, models.ListExpiringExecutionsResponse{
+		Executions: executions,
+		Total:      len(expiring),
+		Limit:      limit,
+		Offset:     offset,
+	})
+}
@@ -0,0 +1,416 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/hussainpithawala/state-machine-amz-gin/models"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 100
+)
+
+// executionStatuses are the values accepted by status filters
+var executionStatuses = []string{"RUNNING", "SUCCEEDED", "FAILED", "CANCELLED", "TIMED_OUT", "ABORTED", "PAUSED", "WAITING"}
+
+// executionModes are the values accepted by the batch and bulk "mode" field
+var executionModes = []string{"distributed", "concurrent", "sequential"}
+
+// fieldErrors collects every problem found in a request so they can be reported together
+type fieldErrors []models.FieldError
+
+func (e *fieldErrors) add(field, format string, args ...interface{}) {
+	*e = append(*e, models.FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// respond writes a 400 listing the collected problems. It returns true if a response was written.
+func (e fieldErrors) respond(c *gin.Context) bool {
+	if len(e) == 0 {
+		return false
+	}
+	problem := newProblem(c, http.StatusBadRequest, models.CodeValidationFailed, "Invalid request", "Request validation failed")
+	problem.Details = e
+	writeProblem(c, problem)
+	return true
+}
+
+// bindJSON decodes the request body into obj and validates it. Unknown fields, values of the
+// wrong JSON type and binding tag violations are all reported in a single 400 response.
+// It returns false if the request was rejected.
+func bindJSON(c *gin.Context, obj interface{}) bool {
+	errs := decodeJSON(c.Request.Body, obj)
+	if len(errs) == 0 {
+		errs = validateStruct(obj)
+	}
+	return !errs.respond(c)
+}
+
+// decodeJSON checks the shape of the JSON body against the type of obj before decoding into it
+func decodeJSON(body io.Reader, obj interface{}) fieldErrors {
+	var errs fieldErrors
+	if body == nil {
+		errs.add("body", "request body is required")
+		return errs
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		errs.add("body", "failed to read request body: %v", err)
+		return errs
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		errs.add("body", "request body is required")
+		return errs
+	}
+
+	var raw interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		errs.add("body", "malformed JSON: %v", err)
+		return errs
+	}
+	if decoder.More() {
+		errs.add("body", "request body must contain a single JSON value")
+		return errs
+	}
+
+	checkJSONType(&errs, "", raw, reflect.TypeOf(obj))
+	if len(errs) > 0 {
+		return errs
+	}
+
+	if err := json.Unmarshal(data, obj); err != nil {
+		errs.add("body", "%v", err)
+	}
+	return errs
+}
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// checkJSONType walks a decoded JSON value alongside the Go type it will be decoded into
+// and records unknown fields and type mismatches
+func checkJSONType(errs *fieldErrors, path string, value interface{}, t reflect.Type) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if value == nil || t.Kind() == reflect.Interface {
+		return
+	}
+	// Types that decode themselves, other than time.Time, report their own errors
+	if t != timeType && reflect.PointerTo(t).Implements(unmarshalerType) {
+		return
+	}
+
+	field := path
+	if field == "" {
+		field = "body"
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if t == timeType {
+			if _, ok := value.(string); !ok {
+				errs.add(field, "must be an RFC3339 timestamp string")
+			}
+			return
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			errs.add(field, "must be an object")
+			return
+		}
+		for _, key := range sortedKeys(object) {
+			structField, ok := jsonField(t, key)
+			if !ok {
+				errs.add(joinPath(path, key), "unknown field")
+				continue
+			}
+			checkJSONType(errs, joinPath(path, key), object[key], structField.Type)
+		}
+	case reflect.Map:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			errs.add(field, "must be an object")
+			return
+		}
+		for _, key := range sortedKeys(object) {
+			checkJSONType(errs, joinPath(path, key), object[key], t.Elem())
+		}
+	case reflect.Slice, reflect.Array:
+		items, ok := value.([]interface{})
+		if !ok {
+			errs.add(field, "must be an array")
+			return
+		}
+		for i, item := range items {
+			checkJSONType(errs, fmt.Sprintf("%s[%d]", path, i), item, t.Elem())
+		}
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			errs.add(field, "must be a string")
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			errs.add(field, "must be a boolean")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, ok := value.(json.Number)
+		if !ok {
+			errs.add(field, "must be an integer")
+			return
+		}
+		if _, err := strconv.ParseInt(number.String(), 10, t.Bits()); err != nil {
+			errs.add(field, "must be an integer")
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number, ok := value.(json.Number)
+		if !ok {
+			errs.add(field, "must be a non-negative integer")
+			return
+		}
+		if _, err := strconv.ParseUint(number.String(), 10, t.Bits()); err != nil {
+			errs.add(field, "must be a non-negative integer")
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := value.(json.Number); !ok {
+			errs.add(field, "must be a number")
+		}
+	}
+}
+
+// jsonField finds the struct field a JSON key decodes into, using the same
+// case-insensitive matching as encoding/json
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	var fallback *reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := jsonName(field)
+		if name == "-" {
+			continue
+		}
+		if name == key {
+			return field, true
+		}
+		if fallback == nil && strings.EqualFold(name, key) {
+			fallback = &field
+		}
+	}
+	if fallback != nil {
+		return *fallback, true
+	}
+	return reflect.StructField{}, false
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// validateStruct runs the binding tags of obj and reports violations by JSON field path
+func validateStruct(obj interface{}) fieldErrors {
+	var errs fieldErrors
+	err := binding.Validator.ValidateStruct(obj)
+	if err == nil {
+		return errs
+	}
+
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		errs.add("body", "%v", err)
+		return errs
+	}
+
+	for _, fe := range validationErrs {
+		errs.add(jsonPath(reflect.TypeOf(obj), fe.StructNamespace()), "%s", validationMessage(fe))
+	}
+	return errs
+}
+
+// jsonPath converts a validator namespace such as "ExecuteBatchRequest.Filter.Limit"
+// into the JSON path "filter.limit"
+func jsonPath(t reflect.Type, namespace string) string {
+	parts := strings.Split(namespace, ".")
+	var path string
+	for _, part := range parts[1:] {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+
+		name, index, hasIndex := strings.Cut(part, "[")
+		if t.Kind() != reflect.Struct {
+			path = joinPath(path, part)
+			continue
+		}
+		field, ok := t.FieldByName(name)
+		if !ok {
+			path = joinPath(path, part)
+			continue
+		}
+		path = joinPath(path, jsonName(field))
+		t = field.Type
+		if hasIndex {
+			path += "[" + index
+			for t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			if t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+				t = t.Elem()
+			}
+		}
+	}
+	return path
+}
+
+func validationMessage(fe validator.FieldError) string {
+	isNumber := false
+	switch fe.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		isNumber = true
+	}
+
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "min", "gte":
+		if isNumber {
+			return "must be greater than or equal to " + fe.Param()
+		}
+		return "must contain at least " + fe.Param() + " item(s)"
+	case "max", "lte":
+		if isNumber {
+			return "must be less than or equal to " + fe.Param()
+		}
+		return "must contain at most " + fe.Param() + " item(s)"
+	case "gt":
+		return "must be greater than " + fe.Param()
+	case "oneof":
+		return "must be one of: " + strings.Join(strings.Fields(fe.Param()), ", ")
+	default:
+		return fmt.Sprintf("failed the '%s' validation", fe.Tag())
+	}
+}
+
+// queryInt parses an optional integer query parameter and checks it is within [minValue, maxValue]
+func queryInt(c *gin.Context, errs *fieldErrors, name string, defaultValue, minValue, maxValue int) int {
+	raw, ok := c.GetQuery(name)
+	if !ok || raw == "" {
+		return defaultValue
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		errs.add(name, "must be an integer")
+		return defaultValue
+	}
+	if value < minValue || value > maxValue {
+		errs.add(name, "must be between %d and %d", minValue, maxValue)
+		return defaultValue
+	}
+	return value
+}
+
+// queryOneOf validates an optional query parameter against a fixed set of values
+func queryOneOf(c *gin.Context, errs *fieldErrors, name string, allowed ...string) string {
+	return oneOf(errs, name, c.Query(name), allowed...)
+}
+
+// oneOf returns value if it is empty or one of allowed, and records an error otherwise
+func oneOf(errs *fieldErrors, name, value string, allowed ...string) string {
+	if value == "" {
+		return ""
+	}
+	for _, candidate := range allowed {
+		if value == candidate {
+			return value
+		}
+	}
+	errs.add(name, "must be one of: %s", strings.Join(allowed, ", "))
+	return ""
+}
+
+// formInt parses an optional integer form field that must be at least minValue
+func formInt(c *gin.Context, errs *fieldErrors, name string, defaultValue, minValue int) int {
+	raw := c.PostForm(name)
+	if raw == "" {
+		return defaultValue
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		errs.add(name, "must be an integer")
+		return defaultValue
+	}
+	if value < minValue {
+		errs.add(name, "must be greater than or equal to %d", minValue)
+		return defaultValue
+	}
+	return value
+}
+
+// formBool parses an optional boolean form field
+func formBool(c *gin.Context, errs *fieldErrors, name string) bool {
+	raw := c.PostForm(name)
+	if raw == "" {
+		return false
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		errs.add(name, "must be a boolean")
+		return false
+	}
+	return value
+}
+
+// formTime parses an optional RFC 3339 form field
+func formTime(c *gin.Context, errs *fieldErrors, name string) *time.Time {
+	raw := c.PostForm(name)
+	if raw == "" {
+		return nil
+	}
+	value, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		errs.add(name, "must be an RFC 3339 time")
+		return nil
+	}
+	return &value
+}
@@ -0,0 +1,363 @@
+// Package httptask provides the built-in "http:invoke" Task resource, which calls an
+// HTTP endpoint without a Go function per service. The request is described by the
+// Parameters of the Task state; {$.path} placeholders in them take values from the state
+// input, and the response is mapped into the state output with ResultSelector. Failures
+// are reported under error names the Retry and Catch fields match.
+package httptask
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/executor"
+)
+
+// Resource is the Task resource that invokes an HTTP endpoint
+const Resource = "http:invoke"
+
+// Errors reported to the Task state. Retry and Catch match them by name; an unsuccessful
+// response is reported as StatusCodePrefix followed by the status, e.g.
+// "States.Http.StatusCode.503".
+const (
+	ErrorInvalidParameters = "States.Runtime"
+	ErrorTimeout           = "States.Timeout"
+	ErrorConnection        = "States.Http.ConnectionFailed"
+	StatusCodePrefix       = "States.Http.StatusCode."
+)
+
+// Request defaults and limits
+const (
+	defaultTimeout   = 60 * time.Second
+	maxResponseBytes = 10 << 20
+	maxCauseBytes    = 1024
+)
+
+var (
+	methods            = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodHead, http.MethodOptions}
+	statusClassPattern = regexp.MustCompile(`^[1-5][xX][xX]$`)
+)
+
+// Parameters describe the request. They are the Parameters of the Task state; Url,
+// header, query and body strings may contain {$.path} placeholders resolved against Input,
+// and {$$.Task.Token} placeholders.
+type Parameters struct {
+	Input           interface{}            `json:"Input,omitempty"`  // The document placeholders read; "$", the state input, in a Task state
+	Method          string                 `json:"Method,omitempty"` // Default: GET
+	URL             string                 `json:"Url"`              // Placeholders are path-escaped
+	QueryParameters map[string]interface{} `json:"QueryParameters,omitempty"`
+	Headers         map[string]string      `json:"Headers,omitempty"`
+	RequestBody     interface{}            `json:"RequestBody,omitempty"`    // Strings are sent as they are, anything else as JSON
+	TimeoutSeconds  int                    `json:"TimeoutSeconds,omitempty"` // Default: 60
+	SuccessCodes    []interface{}          `json:"SuccessCodes,omitempty"`   // Status codes (200) or classes ("2XX"); default: 2XX
+	TaskToken       string                 `json:"TaskToken,omitempty"`      // Set for http:invoke.waitForTaskToken; read with {$$.Task.Token}
+}
+
+// Error is a failed invocation. Its message is the error name, which the Retry and Catch
+// fields of the Task state match; Cause describes what went wrong.
+type Error struct {
+	Name  string
+	Cause string
+}
+
+func (e *Error) Error() string {
+	return e.Name
+}
+
+// Invoker calls the endpoints of "http:invoke" Task states
+type Invoker struct {
+	client *http.Client
+}
+
+// NewInvoker returns an invoker using client, or http.DefaultClient when it is nil
+func NewInvoker(client *http.Client) *Invoker {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Invoker{client: client}
+}
+
+// Register makes "http:invoke" available to the Task states run by baseExecutor. A
+// handler the application registered under the same resource is kept.
+func Register(baseExecutor *executor.BaseExecutor) {
+	if baseExecutor == nil {
+		return
+	}
+	if _, exists := executor.NewExecutionContextAdapter(baseExecutor).GetTaskHandler(Resource); exists {
+		return
+	}
+	baseExecutor.RegisterGoFunction(Resource, NewInvoker(nil).invokeTask)
+}
+
+// invokeTask is the handler of Resource Task states. It resolves the Input parameter to
+// the state input, then invokes the endpoint.
+func (i *Invoker) invokeTask(ctx context.Context, input interface{}) (interface{}, error) {
+	if params, ok := input.(map[string]interface{}); ok {
+		if value, ok := params["Input"]; ok {
+			document, err := stateInput(value)
+			if err != nil {
+				return nil, &Error{Name: ErrorInvalidParameters, Cause: err.Error()}
+			}
+			params = maps.Clone(params)
+			params["Input"] = document
+			input = params
+		}
+	}
+	return i.Invoke(ctx, input)
+}
+
+// Invoke sends the request described by the Parameters input and returns the response
+// as {"StatusCode", "Headers", "ResponseBody"}. Placeholders read the Input parameter as
+// it is. JSON bodies are decoded; others are returned as a string.
+func (i *Invoker) Invoke(ctx context.Context, input interface{}) (interface{}, error) {
+	params, err := parseParameters(input)
+	if err != nil {
+		return nil, &Error{Name: ErrorInvalidParameters, Cause: err.Error()}
+	}
+
+	timeout := defaultTimeout
+	if params.TimeoutSeconds > 0 {
+		timeout = time.Duration(params.TimeoutSeconds) * time.Second
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := params.request(reqCtx)
+	if err != nil {
+		return nil, &Error{Name: ErrorInvalidParameters, Cause: err.Error()}
+	}
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return nil, transportError(err)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, transportError(err)
+	}
+
+	if !params.succeeded(resp.StatusCode) {
+		cause := string(body)
+		if len(cause) > maxCauseBytes {
+			cause = cause[:maxCauseBytes]
+		}
+		return nil, &Error{Name: StatusCodePrefix + strconv.Itoa(resp.StatusCode), Cause: cause}
+	}
+	return map[string]interface{}{
+		"StatusCode":   resp.StatusCode,
+		"Headers":      responseHeaders(resp.Header),
+		"ResponseBody": responseBody(body),
+	}, nil
+}
+
+// transportError names a request that got no complete response
+func transportError(err error) *Error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &Error{Name: ErrorTimeout, Cause: err.Error()}
+	}
+	return &Error{Name: ErrorConnection, Cause: err.Error()}
+}
+
+// parseParameters reads the expanded Parameters of the Task state
+func parseParameters(input interface{}) (*Parameters, error) {
+	fields, ok := input.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("http:invoke needs Parameters describing the request")
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var params Parameters
+	if err := decoder.Decode(&params); err != nil {
+		return nil, fmt.Errorf("invalid http:invoke Parameters: %w", err)
+	}
+
+	if params.Method == "" {
+		params.Method = http.MethodGet
+	}
+	params.Method = strings.ToUpper(params.Method)
+	if !slices.Contains(methods, params.Method) {
+		return nil, fmt.Errorf("method must be one of %s", strings.Join(methods, ", "))
+	}
+	if params.URL == "" {
+		return nil, errors.New("url is required")
+	}
+	if params.TimeoutSeconds < 0 {
+		return nil, errors.New("timeoutSeconds must not be negative")
+	}
+	for _, code := range params.SuccessCodes {
+		if _, ok := statusMatcher(code); !ok {
+			return nil, fmt.Errorf("success code %v is not a status code or class such as 2XX", code)
+		}
+	}
+	return &params, nil
+}
+
+// request builds the HTTP request
+func (p *Parameters) request(ctx context.Context) (*http.Request, error) {
+	t := &template{input: p.Input, taskToken: p.TaskToken}
+	target, err := p.url(t)
+	if err != nil {
+		return nil, err
+	}
+	body, contentType, err := p.body(t)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, p.Method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range p.Headers {
+		if value, err = t.expand(value, identity); err != nil {
+			return nil, err
+		}
+		req.Header.Set(name, value)
+	}
+	if contentType != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req, nil
+}
+
+// url fills the Url placeholders and appends the query parameters
+func (p *Parameters) url(t *template) (string, error) {
+	rawURL, err := t.expand(p.URL, url.PathEscape)
+	if err != nil {
+		return "", err
+	}
+
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid url: %w", err)
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return "", errors.New("url must be an absolute http or https URL")
+	}
+	if len(p.QueryParameters) > 0 {
+		query := target.Query()
+		for name, value := range p.QueryParameters {
+			value, err := t.value(value)
+			if err != nil {
+				return "", err
+			}
+			if values, ok := value.([]interface{}); ok {
+				for _, v := range values {
+					query.Add(name, stringValue(v))
+				}
+				continue
+			}
+			query.Add(name, stringValue(value))
+		}
+		target.RawQuery = query.Encode()
+	}
+	return target.String(), nil
+}
+
+// body encodes the request body and returns its default content type
+func (p *Parameters) body(t *template) (io.Reader, string, error) {
+	requestBody, err := t.value(p.RequestBody)
+	if err != nil {
+		return nil, "", err
+	}
+	switch requestBody := requestBody.(type) {
+	case nil:
+		return nil, "", nil
+	case string:
+		return strings.NewReader(requestBody), "text/plain; charset=utf-8", nil
+	default:
+		data, err := json.Marshal(requestBody)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid request body: %w", err)
+		}
+		return bytes.NewReader(data), "application/json", nil
+	}
+}
+
+// succeeded reports whether status counts as success
+func (p *Parameters) succeeded(status int) bool {
+	if len(p.SuccessCodes) == 0 {
+		return status >= 200 && status < 300
+	}
+	for _, code := range p.SuccessCodes {
+		if match, ok := statusMatcher(code); ok && match(status) {
+			return true
+		}
+	}
+	return false
+}
+
+// statusMatcher parses a SuccessCodes entry: a status code, or a class such as "2XX"
+func statusMatcher(code interface{}) (func(int) bool, bool) {
+	switch c := code.(type) {
+	case float64:
+		status := int(c)
+		if float64(status) != c || status < 100 || status > 599 {
+			return nil, false
+		}
+		return func(s int) bool { return s == status }, true
+	case string:
+		if statusClassPattern.MatchString(c) {
+			class := int(c[0] - '0')
+			return func(s int) bool { return s/100 == class }, true
+		}
+		status, err := strconv.Atoi(c)
+		if err != nil {
+			return nil, false
+		}
+		return statusMatcher(float64(status))
+	}
+	return nil, false
+}
+
+// stringValue formats a path, query or header value
+func stringValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+}
+
+func responseHeaders(header http.Header) map[string]interface{} {
+	headers := make(map[string]interface{}, len(header))
+	for name, values := range header {
+		headers[name] = strings.Join(values, ", ")
+	}
+	return headers
+}
+
+func responseBody(body []byte) interface{} {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(body, &decoded); err == nil {
+		return decoded
+	}
+	return string(body)
+}
@@ -0,0 +1,227 @@
+package httptask
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/executor"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/statemachine"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// runHTTPTaskMachine runs the Task state task, with URL replaced by url, and returns the
+// output of the execution. Its Catch fields may go to the Pass state "Recovered".
+func runHTTPTaskMachine(t *testing.T, url, task string, input interface{}) (interface{}, error) {
+	t.Helper()
+	states := `"Call": ` + strings.ReplaceAll(task, "URL", url)
+	if strings.Contains(task, `"Recovered"`) {
+		states += `, "Recovered": {"Type": "Pass", "End": true}`
+	}
+	definition := `{"StartAt": "Call", "States": {` + states + `}}`
+	sm, err := statemachine.New([]byte(definition), true)
+	if err != nil {
+		t.Fatalf("invalid state machine: %v", err)
+	}
+	baseExecutor := executor.NewBaseExecutor()
+	Register(baseExecutor)
+	ctx := context.WithValue(context.Background(), types.ExecutionContextKey, executor.NewExecutionContextAdapter(baseExecutor))
+	exec, err := sm.Execute(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return exec.Output, nil
+}
+
+func TestHTTPTask_Invoke(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"method":      r.Method,
+			"path":        r.URL.EscapedPath(),
+			"query":       r.URL.RawQuery,
+			"auth":        r.Header.Get("Authorization"),
+			"contentType": r.Header.Get("Content-Type"),
+			"body":        json.RawMessage(body),
+		})
+	}))
+	defer server.Close()
+
+	output, err := runHTTPTaskMachine(t, server.URL, `{
+		"Type": "Task",
+		"Resource": "http:invoke",
+		"Parameters": {
+			"Input": "$",
+			"Method": "post",
+			"Url": "URL/orders/{$.order.id}/ship",
+			"QueryParameters": {"notify": true, "tag": "{$.order.tags}"},
+			"Headers": {"Authorization": "Bearer {$.token}"},
+			"RequestBody": "{$.shipment}",
+			"SuccessCodes": [201]
+		},
+		"ResultSelector": {"status": "$.StatusCode", "echo": "$.ResponseBody"},
+		"ResultPath": "$.shipping",
+		"End": true
+	}`, map[string]interface{}{
+		"order":    map[string]interface{}{"id": "A 1", "tags": []interface{}{"gift", "rush"}},
+		"token":    "secret",
+		"shipment": map[string]interface{}{"carrier": "ups"},
+	})
+	assert.NoError(t, err)
+
+	shipping := output.(map[string]interface{})["shipping"].(map[string]interface{})
+	assert.EqualValues(t, http.StatusCreated, shipping["status"])
+	echo := shipping["echo"].(map[string]interface{})
+	assert.Equal(t, http.MethodPost, echo["method"])
+	assert.Equal(t, "/orders/A%201/ship", echo["path"])
+	assert.Equal(t, "notify=true&tag=gift&tag=rush", echo["query"])
+	assert.Equal(t, "Bearer secret", echo["auth"])
+	assert.Equal(t, "application/json", echo["contentType"])
+	assert.Equal(t, map[string]interface{}{"carrier": "ups"}, echo["body"])
+}
+
+func TestHTTPTask_Errors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("down for maintenance"))
+	}))
+	defer server.Close()
+
+	// An unsuccessful status is retried, then caught by its error name
+	_, err := runHTTPTaskMachine(t, server.URL, `{
+		"Type": "Task",
+		"Resource": "http:invoke",
+		"Parameters": {"Url": "URL/health"},
+		"Retry": [{"ErrorEquals": ["States.Http.StatusCode.503"], "MaxAttempts": 1, "IntervalSeconds": 1}],
+		"Catch": [{"ErrorEquals": ["States.Http.StatusCode.503"], "Next": "Recovered"}],
+		"Next": "Recovered"
+	}`, map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, calls.Load())
+
+	// Status classes count as success
+	output, err := runHTTPTaskMachine(t, server.URL, `{
+		"Type": "Task",
+		"Resource": "http:invoke",
+		"Parameters": {"Url": "URL", "SuccessCodes": ["5XX"]},
+		"ResultSelector": {"status": "$.StatusCode", "body": "$.ResponseBody"},
+		"End": true
+	}`, map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"status": http.StatusServiceUnavailable, "body": "down for maintenance"}, output)
+
+	// Invalid parameters fail the state with States.Runtime
+	result, err := NewInvoker(nil).Invoke(context.Background(), map[string]interface{}{"Url": server.URL + "/{$.id}", "Input": map[string]interface{}{}})
+	assert.Nil(t, result)
+	var taskErr *Error
+	assert.True(t, errors.As(err, &taskErr))
+	assert.Equal(t, ErrorInvalidParameters, taskErr.Name)
+	assert.Contains(t, taskErr.Cause, "$.id")
+
+	_, err = NewInvoker(nil).Invoke(context.Background(), map[string]interface{}{"Url": server.URL + "/{$.id}"})
+	assert.True(t, errors.As(err, &taskErr))
+	assert.Contains(t, taskErr.Cause, "Input")
+
+	_, err = NewInvoker(nil).Invoke(context.Background(), map[string]interface{}{"Url": server.URL, "Mehtod": "GET"})
+	assert.EqualError(t, err, ErrorInvalidParameters)
+
+	// A handler registered by the application is kept
+	baseExecutor := executor.NewBaseExecutor()
+	baseExecutor.RegisterGoFunction(Resource, func(context.Context, interface{}) (interface{}, error) {
+		return "custom", nil
+	})
+	Register(baseExecutor)
+	handler, _ := executor.NewExecutionContextAdapter(baseExecutor).GetTaskHandler(Resource)
+	result, _ = handler(context.Background(), nil)
+	assert.Equal(t, "custom", result)
+}
+
+func TestHTTPTask_Timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(3 * time.Second):
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	_, err := NewInvoker(nil).Invoke(context.Background(), map[string]interface{}{"Url": server.URL, "TimeoutSeconds": 1})
+	assert.EqualError(t, err, ErrorTimeout)
+}
+
+func TestHTTPTask_ResolvesTheStateInput(t *testing.T) {
+	paths := make(chan string, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+	}))
+	defer server.Close()
+
+	// State inputs shaped like the Parameters, or like an expansion root, are read as
+	// they are
+	for _, input := range []interface{}{
+		map[string]interface{}{"Input": map[string]interface{}{"id": "a"}, "Url": "URL/{$.Input.id}", "id": "b"},
+		map[string]interface{}{"$": map[string]interface{}{"id": "c"}, "id": "d"},
+	} {
+		_, err := runHTTPTaskMachine(t, server.URL, `{
+			"Type": "Task",
+			"Resource": "http:invoke",
+			"Parameters": {"Input": "$", "Url": "URL/{$.id}"},
+			"End": true
+		}`, input)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, "/b", <-paths)
+	assert.Equal(t, "/d", <-paths)
+
+	// A Task state reads placeholders from the state input only
+	_, err := runHTTPTaskMachine(t, server.URL, `{
+		"Type": "Task",
+		"Resource": "http:invoke",
+		"Parameters": {"Input": {"id": "e"}, "Url": "URL/{$.id}"},
+		"End": true
+	}`, map[string]interface{}{})
+	var taskErr *Error
+	if assert.True(t, errors.As(err, &taskErr)) {
+		assert.Equal(t, ErrorInvalidParameters, taskErr.Name)
+		assert.Equal(t, errStateInput.Error(), taskErr.Cause)
+	}
+}
+
+func TestStateInput(t *testing.T) {
+	input := map[string]interface{}{"id": 1.0}
+	expanded := map[string]interface{}{"$": map[string]interface{}{
+		"Url":   "https://example.com",
+		"Input": map[string]interface{}{"$": input},
+	}}
+	document, err := stateInput(expanded)
+	assert.NoError(t, err)
+	assert.Equal(t, input, document)
+
+	document, err = stateInput(map[string]interface{}{"$": map[string]interface{}{"Input": map[string]interface{}{"$": nil}}})
+	assert.NoError(t, err)
+	assert.Nil(t, document)
+
+	for _, value := range []interface{}{
+		nil,
+		"$",
+		input,
+		map[string]interface{}{"$": input},
+		map[string]interface{}{"$": map[string]interface{}{"Input": input}},
+	} {
+		_, err := stateInput(value)
+		assert.ErrorIs(t, err, errStateInput, value)
+	}
+}
@@ -0,0 +1,184 @@
+package httptask
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// placeholderPattern matches the {$.path} placeholders of Url, header, query and body
+// strings
+var placeholderPattern = regexp.MustCompile(`\{(\$[^{}]*)\}`)
+
+// taskTokenPath is the placeholder of the token of a ".waitForTaskToken" Task
+const taskTokenPath = "$$.Task.Token"
+
+// errNoInput is returned when placeholders are used without an Input parameter
+var errNoInput = errors.New(`placeholders need the parameter "Input": "$"`)
+
+// errStateInput is returned when the Input parameter of a Task state is not "$"
+var errStateInput = errors.New(`the parameter "Input" must be "$", the state input`)
+
+// stateInput returns the state input the "Input": "$" parameter of a Task state refers
+// to. The Task state expands Parameters against the root {"$": <state input>}, and the
+// result again against the root {"$": <first expansion>} before it calls the handler,
+// so "$" arrives as {"$": {..., "Input": {"$": <state input>}}}.
+func stateInput(input interface{}) (interface{}, error) {
+	expanded, ok := root(input)
+	if !ok {
+		return nil, errStateInput
+	}
+	params, ok := expanded.(map[string]interface{})
+	if !ok {
+		return nil, errStateInput
+	}
+	document, ok := root(params["Input"])
+	if !ok {
+		return nil, errStateInput
+	}
+	return document, nil
+}
+
+// root returns the document of a {"$": document} expansion root
+func root(value interface{}) (interface{}, bool) {
+	fields, ok := value.(map[string]interface{})
+	if !ok || len(fields) != 1 {
+		return nil, false
+	}
+	document, ok := fields["$"]
+	return document, ok
+}
+
+// template resolves placeholders against the input document
+type template struct {
+	input     interface{}
+	taskToken string
+}
+
+// lookup returns the value of a placeholder
+func (t *template) lookup(path string) (interface{}, error) {
+	if path == taskTokenPath {
+		if t.taskToken == "" {
+			return nil, errors.New(taskTokenPath + " is only set for " + Resource + ".waitForTaskToken")
+		}
+		return t.taskToken, nil
+	}
+	if t.input == nil {
+		return nil, errNoInput
+	}
+	return lookup(t.input, path)
+}
+
+// expand replaces the placeholders of s with their values, formatted by escape
+func (t *template) expand(s string, escape func(string) string) (string, error) {
+	var err error
+	expanded := placeholderPattern.ReplaceAllStringFunc(s, func(placeholder string) string {
+		value, lookupErr := t.lookup(placeholder[1 : len(placeholder)-1])
+		if lookupErr != nil {
+			if err == nil {
+				err = lookupErr
+			}
+			return placeholder
+		}
+		return escape(stringValue(value))
+	})
+	return expanded, err
+}
+
+// value expands the strings of a JSON value. A string made of a single placeholder is
+// replaced by the value itself, keeping its JSON type.
+func (t *template) value(v interface{}) (interface{}, error) {
+	switch value := v.(type) {
+	case string:
+		if match := placeholderPattern.FindStringSubmatch(value); match != nil && match[0] == value {
+			return t.lookup(match[1])
+		}
+		return t.expand(value, identity)
+	case map[string]interface{}:
+		expanded := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			var err error
+			if expanded[key], err = t.value(item); err != nil {
+				return nil, err
+			}
+		}
+		return expanded, nil
+	case []interface{}:
+		expanded := make([]interface{}, len(value))
+		for i, item := range value {
+			var err error
+			if expanded[i], err = t.value(item); err != nil {
+				return nil, err
+			}
+		}
+		return expanded, nil
+	default:
+		return v, nil
+	}
+}
+
+func identity(s string) string {
+	return s
+}
+
+// lookup evaluates a JSONPath made of $, .name, ['name'] and [index] steps
+func lookup(document interface{}, path string) (interface{}, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("placeholder %s must be a JSONPath starting with $", path)
+	}
+	current := document
+	for rest := path[1:]; rest != ""; {
+		key, index, remaining, err := nextStep(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSONPath %s: %w", path, err)
+		}
+		rest = remaining
+
+		var found bool
+		if index >= 0 {
+			items, ok := current.([]interface{})
+			if found = ok && index < len(items); found {
+				current = items[index]
+			}
+		} else {
+			fields, _ := current.(map[string]interface{})
+			current, found = fields[key]
+		}
+		if !found {
+			return nil, fmt.Errorf("%s is not in the input", path)
+		}
+	}
+	return current, nil
+}
+
+// nextStep parses the first step of rest: a member name, or an index when index >= 0
+func nextStep(rest string) (key string, index int, remaining string, err error) {
+	switch {
+	case rest[0] == '.':
+		end := strings.IndexAny(rest[1:], ".[") + 1
+		if end == 0 {
+			end = len(rest)
+		}
+		return rest[1:end], -1, rest[end:], nil
+	case strings.HasPrefix(rest, "['"):
+		end := strings.Index(rest, "']")
+		if end < 0 {
+			return "", -1, "", errors.New("unterminated member name")
+		}
+		return rest[2:end], -1, rest[end+2:], nil
+	case rest[0] == '[':
+		end := strings.IndexByte(rest, ']')
+		if end < 0 {
+			return "", -1, "", errors.New("unterminated index")
+		}
+		index, err := strconv.Atoi(rest[1:end])
+		if err != nil || index < 0 {
+			return "", -1, "", fmt.Errorf("invalid index %q", rest[1:end])
+		}
+		return "", index, rest[end+1:], nil
+	default:
+		return "", -1, "", fmt.Errorf("unexpected %q", rest)
+	}
+}
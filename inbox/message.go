@@ -0,0 +1,63 @@
+// Package inbox buffers correlation messages that arrive before any execution waits for
+// them. A message is kept in Redis until its TTL expires, keyed by state machine and
+// correlation key/value, and is delivered to the first execution of that state machine
+// that pauses in a Message state waiting for the same correlation.
+package inbox
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Message statuses
+const (
+	StatusPending   = "PENDING"   // Waiting for an execution to deliver it to
+	StatusDelivered = "DELIVERED" // Handed to an execution; kept until it expires for inspection
+)
+
+// DefaultTTL is how long a message is kept when no TTL is given
+const DefaultTTL = 24 * time.Hour
+
+// MaxTTL is the longest a message is kept
+const MaxTTL = 7 * 24 * time.Hour
+
+var (
+	// ErrNotFound is returned when a message does not exist or has expired
+	ErrNotFound = errors.New("message not found")
+	// ErrClaimed is returned when a pending message is being delivered by another caller
+	ErrClaimed = errors.New("message is being delivered")
+)
+
+// Message is a correlation message waiting for, or delivered to, an execution
+type Message struct {
+	ID               string      `json:"id"`
+	StateMachineID   string      `json:"stateMachineId"`
+	CorrelationKey   string      `json:"correlationKey"`
+	CorrelationValue interface{} `json:"correlationValue"` // A string, json.Number or bool
+	Output           interface{} `json:"output,omitempty"` // Output of the Message state the execution resumes with
+	Status           string      `json:"status"`           // PENDING or DELIVERED
+	CreatedAt        time.Time   `json:"createdAt"`
+	ExpiresAt        time.Time   `json:"expiresAt"`
+	DeliveredAt      *time.Time  `json:"deliveredAt,omitempty"`
+	ExecutionID      string      `json:"executionId,omitempty"` // The execution it was delivered to last
+	Deliveries       int         `json:"deliveries"`
+}
+
+// correlation identifies the executions a message is for. The value is normalized so a
+// number matches the value the execution recorded whatever its formatting, while keeping
+// "12345" and 12345 apart.
+func correlation(stateMachineID, key string, value interface{}) (string, error) {
+	if number, ok := value.(json.Number); ok {
+		f, err := number.Float64()
+		if err != nil {
+			return "", err
+		}
+		value = f
+	}
+	data, err := json.Marshal([]interface{}{stateMachineID, key, value})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
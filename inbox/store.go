@@ -0,0 +1,252 @@
+package inbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis keys. Every message is a JSON string expiring with the message; the pending
+// messages of a correlation are a sorted set by creation time, and the messages of a
+// state machine a sorted set by expiry. Expired IDs are dropped from both as they are read.
+const (
+	messageKeyPrefix = "state-machine:inbox:message:"
+	pendingKeyPrefix = "state-machine:inbox:pending:"
+	machineKeyPrefix = "state-machine:inbox:machine:"
+)
+
+// Store keeps messages in Redis
+type Store struct {
+	client *redis.Client
+	now    func() time.Time
+}
+
+// NewStore returns a message store over client
+func NewStore(client *redis.Client) *Store {
+	return &Store{client: client, now: time.Now}
+}
+
+// Put assigns an ID to a new message and buffers it for ttl, or DefaultTTL when ttl is 0
+func (s *Store) Put(ctx context.Context, message *Message, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	pendingKey, err := pendingKey(message)
+	if err != nil {
+		return err
+	}
+	now := s.now().UTC()
+	message.ID = uuid.NewString()
+	message.Status = StatusPending
+	message.CreatedAt = now
+	message.ExpiresAt = now.Add(ttl)
+	message.DeliveredAt = nil
+	message.ExecutionID = ""
+	message.Deliveries = 0
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	machineKey := machineKeyPrefix + message.StateMachineID
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, messageKeyPrefix+message.ID, data, ttl)
+		pipe.ZAdd(ctx, pendingKey, redis.Z{Score: float64(now.UnixMilli()), Member: message.ID})
+		pipe.ZAdd(ctx, machineKey, redis.Z{Score: float64(message.ExpiresAt.UnixMilli()), Member: message.ID})
+		// The indexes live as long as their longest-lived message
+		for _, key := range []string{pendingKey, machineKey} {
+			pipe.ExpireNX(ctx, key, ttl)
+			pipe.ExpireGT(ctx, key, ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// Get returns a message, or ErrNotFound
+func (s *Store) Get(ctx context.Context, id string) (*Message, error) {
+	data, err := s.client.Get(ctx, messageKeyPrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decode(id, data)
+}
+
+func decode(id string, data []byte) (*Message, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var message Message
+	if err := decoder.Decode(&message); err != nil {
+		return nil, fmt.Errorf("invalid message %s: %w", id, err)
+	}
+	return &message, nil
+}
+
+// List returns the messages of a state machine that have not expired, oldest first
+func (s *Store) List(ctx context.Context, stateMachineID string) ([]*Message, error) {
+	machineKey := machineKeyPrefix + stateMachineID
+	now := strconv.FormatInt(s.now().UnixMilli(), 10)
+	if err := s.client.ZRemRangeByScore(ctx, machineKey, "-inf", now).Err(); err != nil {
+		return nil, err
+	}
+	ids, err := s.client.ZRange(ctx, machineKey, 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = messageKeyPrefix + id
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*Message, 0, len(ids))
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			// Deleted or expired ahead of its score
+			continue
+		}
+		message, err := decode(ids[i], []byte(data))
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		if !messages[i].CreatedAt.Equal(messages[j].CreatedAt) {
+			return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+		}
+		return messages[i].ID < messages[j].ID
+	})
+	return messages, nil
+}
+
+// Delete removes a message; it returns ErrNotFound if it does not exist
+func (s *Store) Delete(ctx context.Context, id string) error {
+	message, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	pendingKey, err := pendingKey(message)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, messageKeyPrefix+id)
+		pipe.ZRem(ctx, pendingKey, id)
+		pipe.ZRem(ctx, machineKeyPrefix+message.StateMachineID, id)
+		return nil
+	})
+	return err
+}
+
+// Claim takes the oldest pending message of a correlation for delivery, or returns nil
+// when there is none. The caller marks it Delivered, or Releases it when the delivery
+// fails.
+func (s *Store) Claim(ctx context.Context, stateMachineID, correlationKey string, correlationValue interface{}) (*Message, error) {
+	key, err := correlation(stateMachineID, correlationKey, correlationValue)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		popped, err := s.client.ZPopMin(ctx, pendingKeyPrefix+key, 1).Result()
+		if err != nil || len(popped) == 0 {
+			return nil, err
+		}
+		id, _ := popped[0].Member.(string)
+		message, err := s.Get(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			// Expired or deleted
+			continue
+		}
+		return message, err
+	}
+}
+
+// Take claims a message by ID for delivery, like Claim. Delivered messages can be taken
+// again to deliver them once more; ErrClaimed is returned for a pending message another
+// caller is delivering.
+func (s *Store) Take(ctx context.Context, id string) (*Message, error) {
+	message, err := s.Get(ctx, id)
+	if err != nil || message.Status != StatusPending {
+		return message, err
+	}
+	pendingKey, err := pendingKey(message)
+	if err != nil {
+		return nil, err
+	}
+	removed, err := s.client.ZRem(ctx, pendingKey, id).Result()
+	if err != nil {
+		return nil, err
+	}
+	if removed == 0 {
+		return nil, ErrClaimed
+	}
+	return message, nil
+}
+
+// Release returns a claimed message to the pending messages of its correlation
+func (s *Store) Release(ctx context.Context, message *Message) error {
+	message.Status = StatusPending
+	if err := s.save(ctx, message); err != nil {
+		return err
+	}
+	pendingKey, err := pendingKey(message)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, pendingKey, redis.Z{Score: float64(message.CreatedAt.UnixMilli()), Member: message.ID})
+		// The index may have expired with the other messages of the correlation
+		pipe.ExpireNX(ctx, pendingKey, message.ExpiresAt.Sub(s.now()))
+		return nil
+	})
+	return err
+}
+
+// Delivered records the delivery of a claimed message to an execution. The message is
+// kept until it expires.
+func (s *Store) Delivered(ctx context.Context, message *Message, executionID string) error {
+	now := s.now().UTC()
+	message.Status = StatusDelivered
+	message.DeliveredAt = &now
+	message.ExecutionID = executionID
+	message.Deliveries++
+	return s.save(ctx, message)
+}
+
+// save overwrites a message, keeping its expiry. It returns ErrNotFound when the message
+// expired in the meantime.
+func (s *Store) save(ctx context.Context, message *Message) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	err = s.client.SetArgs(ctx, messageKeyPrefix+message.ID, data, redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+	if errors.Is(err, redis.Nil) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func pendingKey(message *Message) (string, error) {
+	key, err := correlation(message.StateMachineID, message.CorrelationKey, message.CorrelationValue)
+	if err != nil {
+		return "", err
+	}
+	return pendingKeyPrefix + key, nil
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"gorm.io/gorm"
+)
+
+// repositoryDecorator is embedded by the repositories that add behavior to the saves of
+// the repository they wrap. Every call they do not override goes to the wrapped
+// repository.
+type repositoryDecorator struct {
+	repository.Repository
+}
+
+// GetDB exposes the database handle of the wrapped repository, if it has one
+func (d *repositoryDecorator) GetDB() *gorm.DB {
+	if repo, ok := d.Repository.(interface{ GetDB() *gorm.DB }); ok {
+		return repo.GetDB()
+	}
+	return nil
+}
+
+// decorator is a repository built on repositoryDecorator
+type decorator interface {
+	repository.Repository
+	GetDB() *gorm.DB
+}
+
+// decoratedMessageRepository keeps message correlation support of the repository a
+// decorator wraps
+type decoratedMessageRepository struct {
+	decorator
+	repository.MessageRepository
+}
+
+// decorateRepository returns a manager over the repository of manager wrapped by wrap.
+// The message correlation support of the repository is kept. A manager whose repository
+// is already wrapped in a D is returned as it is.
+func decorateRepository[D decorator](manager *repository.Manager, wrap func(inner repository.Repository) D) *repository.Manager {
+	inner := manager.GetRepository()
+	outermost := inner
+	if messages, ok := inner.(*decoratedMessageRepository); ok {
+		outermost = messages.decorator
+	}
+	if _, ok := outermost.(D); ok {
+		return manager
+	}
+
+	decorated := wrap(inner)
+	if messages, ok := inner.(repository.MessageRepository); ok {
+		return repository.NewManagerWithRepository(&decoratedMessageRepository{decorator: decorated, MessageRepository: messages})
+	}
+	return repository.NewManagerWithRepository(decorated)
+}
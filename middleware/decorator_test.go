@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hibiken/asynq"
+	"github.com/hussainpithawala/state-machine-amz-gin/activities"
+	"github.com/hussainpithawala/state-machine-amz-gin/inbox"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// memoryMessageRepository adds message correlations and a database handle to a
+// memoryRepository
+type memoryMessageRepository struct {
+	*memoryRepository
+	db           *gorm.DB
+	correlations map[string]*repository.MessageCorrelationRecord
+}
+
+func newMemoryMessageRepository(definitions map[string]string) *memoryMessageRepository {
+	return &memoryMessageRepository{
+		memoryRepository: newMemoryRepository(definitions),
+		db:               &gorm.DB{},
+		correlations:     map[string]*repository.MessageCorrelationRecord{},
+	}
+}
+
+func (r *memoryMessageRepository) GetDB() *gorm.DB {
+	return r.db
+}
+
+func (r *memoryMessageRepository) SaveMessageCorrelation(_ context.Context, record *repository.MessageCorrelationRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	saved := *record
+	r.correlations[record.ID] = &saved
+	return nil
+}
+
+func (r *memoryMessageRepository) GetMessageCorrelation(_ context.Context, id string) (*repository.MessageCorrelationRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	record, ok := r.correlations[id]
+	if !ok {
+		return nil, errors.New("correlation not found")
+	}
+	found := *record
+	return &found, nil
+}
+
+func (r *memoryMessageRepository) FindWaitingCorrelations(_ context.Context, _ *repository.MessageCorrelationFilter) ([]*repository.MessageCorrelationRecord, error) {
+	return nil, nil
+}
+
+func (r *memoryMessageRepository) UpdateCorrelationStatus(_ context.Context, id string, status string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	record, ok := r.correlations[id]
+	if !ok {
+		return errors.New("correlation not found")
+	}
+	record.Status = status
+	return nil
+}
+
+func (r *memoryMessageRepository) DeleteMessageCorrelation(_ context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.correlations, id)
+	return nil
+}
+
+func (r *memoryMessageRepository) ListTimedOutCorrelations(_ context.Context, _ int64) ([]*repository.MessageCorrelationRecord, error) {
+	return nil, nil
+}
+
+// pause records an execution paused in a Message state waiting on orderId, as the Message
+// state does before the execution is saved
+func (r *memoryMessageRepository) pause(executionID, stateName string, orderID interface{}) *repository.ExecutionRecord {
+	started := time.Now()
+	record := &repository.ExecutionRecord{
+		ExecutionID:    executionID,
+		StateMachineID: "orders",
+		Name:           "order-" + executionID,
+		Status:         "PAUSED",
+		CurrentState:   stateName,
+		StartTime:      &started,
+	}
+	r.correlations[correlationID(record)] = &repository.MessageCorrelationRecord{
+		ID:               correlationID(record),
+		ExecutionID:      executionID,
+		StateMachineID:   "orders",
+		StateName:        stateName,
+		CorrelationKey:   "orderId",
+		CorrelationValue: orderID,
+		CreatedAt:        started.Unix(),
+		Status:           "WAITING",
+	}
+	return record
+}
+
+// recordingQueue records the resumes and timeouts queued on it, failing the resumes of
+// the executions in fail
+type recordingQueue struct {
+	resumes  []*queue.ExecutionTaskPayload
+	taskIDs  []string
+	timeouts []*queue.TimeoutTaskPayload
+	fail     map[string]bool
+}
+
+func (q *recordingQueue) EnqueueExecution(payload *queue.ExecutionTaskPayload, opts ...asynq.Option) (*asynq.TaskInfo, error) {
+	if q.fail[payload.ExecutionID] {
+		return nil, errors.New("queue unavailable")
+	}
+	var taskID string
+	for _, opt := range opts {
+		if opt.Type() == asynq.TaskIDOpt {
+			taskID = opt.Value().(string)
+		}
+	}
+	q.resumes = append(q.resumes, payload)
+	q.taskIDs = append(q.taskIDs, taskID)
+	return &asynq.TaskInfo{ID: taskID}, nil
+}
+
+func (q *recordingQueue) ScheduleTimeout(payload *queue.TimeoutTaskPayload, _ time.Duration) (*asynq.TaskInfo, error) {
+	q.timeouts = append(q.timeouts, payload)
+	return &asynq.TaskInfo{ID: "timeout-" + payload.CorrelationID}, nil
+}
+
+func TestDecorateRepository_KeepsMessageRepositoryAndDatabase(t *testing.T) {
+	redisClient := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	repo := newMemoryMessageRepository(nil)
+	q := &recordingQueue{}
+	messages := inbox.NewStore(redisClient)
+	store := activities.NewStore(redisClient)
+
+	// Decorated as the worker does
+	manager := NewWaitTimeoutRepositoryManager(repository.NewManagerWithRepository(repo), q)
+	manager = NewInboxRepositoryManager(manager, messages, q, nil)
+	manager = NewTaggingRepositoryManager(manager)
+	manager = NewParkingRepositoryManager(manager, store, q, nil)
+
+	_, ok := manager.GetRepository().(repository.MessageRepository)
+	assert.True(t, ok)
+	db, ok := manager.GetRepository().(interface{ GetDB() *gorm.DB })
+	if assert.True(t, ok) {
+		assert.Same(t, repo.db, db.GetDB())
+	}
+	assert.Same(t, manager, NewParkingRepositoryManager(manager, store, q, nil))
+
+	tagged := NewTaggingRepositoryManager(repository.NewManagerWithRepository(repo))
+	assert.Same(t, tagged, NewTaggingRepositoryManager(tagged))
+}
+
+func TestDecorateRepository_WithoutMessageRepository(t *testing.T) {
+	repo := newMemoryRepository(nil)
+	manager := repository.NewManagerWithRepository(repo)
+	q := &recordingQueue{}
+
+	// Repositories without Message states are left alone by the Message state decorators
+	assert.Same(t, manager, NewWaitTimeoutRepositoryManager(manager, q))
+	assert.Same(t, manager, NewInboxRepositoryManager(manager, nil, q, nil))
+
+	tagged := NewTaggingRepositoryManager(manager)
+	assert.NotSame(t, manager, tagged)
+	_, ok := tagged.GetRepository().(repository.MessageRepository)
+	assert.False(t, ok)
+	db, ok := tagged.GetRepository().(interface{ GetDB() *gorm.DB })
+	if assert.True(t, ok) {
+		assert.Nil(t, db.GetDB())
+	}
+}
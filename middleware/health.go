@@ -0,0 +1,292 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"golang.org/x/sync/singleflight"
+)
+
+// Component health states reported by the readiness probe
+const (
+	HealthUp            = "up"
+	HealthDown          = "down"
+	HealthDegraded      = "degraded"
+	HealthNotConfigured = "not_configured"
+)
+
+// Overall readiness states
+const (
+	ReadinessHealthy   = "healthy"
+	ReadinessDegraded  = "degraded"
+	ReadinessUnhealthy = "unhealthy"
+)
+
+const (
+	defaultHealthCheckTimeout = 2 * time.Second
+	defaultHealthCacheTTL     = 5 * time.Second
+)
+
+// HealthConfig configures the readiness probe
+type HealthConfig struct {
+	CheckTimeout time.Duration // Timeout applied to each component check (default: 2s)
+	CacheTTL     time.Duration // How long a readiness result is reused (default: 5s, negative disables caching)
+}
+
+// ComponentHealth is the result of checking a single dependency
+type ComponentHealth struct {
+	Status  string
+	Latency time.Duration
+	Message string
+}
+
+// ReadinessReport is the aggregated result of all component checks
+type ReadinessReport struct {
+	Status     string
+	Components map[string]ComponentHealth
+	CheckedAt  time.Time
+	Cached     bool
+}
+
+// HealthChecker runs dependency checks for the readiness probe and caches the result briefly.
+// Concurrent probes share one run of the checks.
+type HealthChecker struct {
+	config    *Config
+	timeout   time.Duration
+	cacheTTL  time.Duration
+	inspector *asynq.Inspector
+
+	inflight singleflight.Group
+	mu       sync.Mutex
+	last     *ReadinessReport
+}
+
+// NewHealthChecker creates a health checker for the dependencies held by config
+func NewHealthChecker(config *Config) *HealthChecker {
+	checker := &HealthChecker{
+		config:   config,
+		timeout:  defaultHealthCheckTimeout,
+		cacheTTL: defaultHealthCacheTTL,
+	}
+
+	if config.HealthConfig != nil {
+		if config.HealthConfig.CheckTimeout > 0 {
+			checker.timeout = config.HealthConfig.CheckTimeout
+		}
+		if config.HealthConfig.CacheTTL != 0 {
+			checker.cacheTTL = config.HealthConfig.CacheTTL
+		}
+	}
+
+	if config.RedisClient != nil {
+		checker.inspector = asynq.NewInspectorFromRedisClient(config.RedisClient)
+	}
+
+	return checker
+}
+
+// Ready returns the readiness report, reusing the previous one while it is still fresh. The
+// checks run detached from ctx, bounded by their own timeout, so a caller going away neither
+// cancels them nor leaves a failure in the cache; that caller gets an unhealthy report.
+func (h *HealthChecker) Ready(ctx context.Context) ReadinessReport {
+	if cached, ok := h.cached(); ok {
+		return cached
+	}
+
+	result := h.inflight.DoChan("readiness", func() (interface{}, error) {
+		report := h.check(context.WithoutCancel(ctx))
+		h.mu.Lock()
+		h.last = &report
+		h.mu.Unlock()
+		return report, nil
+	})
+	select {
+	case res := <-result:
+		return res.Val.(ReadinessReport)
+	case <-ctx.Done():
+		return ReadinessReport{
+			Status:     ReadinessUnhealthy,
+			Components: map[string]ComponentHealth{},
+			CheckedAt:  time.Now(),
+		}
+	}
+}
+
+// cached returns the previous readiness report if it is still fresh
+func (h *HealthChecker) cached() (ReadinessReport, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.last == nil || h.cacheTTL <= 0 || time.Since(h.last.CheckedAt) >= h.cacheTTL {
+		return ReadinessReport{}, false
+	}
+	cached := *h.last
+	cached.Cached = true
+	return cached, true
+}
+
+// check runs every component check concurrently
+func (h *HealthChecker) check(ctx context.Context) ReadinessReport {
+	checks := map[string]func(context.Context) ComponentHealth{
+		"database":      h.checkDatabase,
+		"redis":         h.checkRedis,
+		"queue":         h.checkQueueServers,
+		"worker":        h.checkWorker,
+		"orchestrators": h.checkOrchestrators,
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	components := make(map[string]ComponentHealth, len(checks))
+
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) ComponentHealth) {
+			defer wg.Done()
+			result := h.runWithTimeout(ctx, check)
+			mu.Lock()
+			components[name] = result
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	return ReadinessReport{
+		Status:     aggregateStatus(components),
+		Components: components,
+		CheckedAt:  time.Now(),
+	}
+}
+
+// runWithTimeout bounds a check by the configured timeout and measures its latency
+func (h *HealthChecker) runWithTimeout(ctx context.Context, check func(context.Context) ComponentHealth) ComponentHealth {
+	checkCtx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	start := time.Now()
+	resultCh := make(chan ComponentHealth, 1)
+	go func() {
+		resultCh <- check(checkCtx)
+	}()
+
+	select {
+	case result := <-resultCh:
+		if result.Status != HealthNotConfigured {
+			result.Latency = time.Since(start)
+		}
+		return result
+	case <-checkCtx.Done():
+		return ComponentHealth{
+			Status:  HealthDown,
+			Latency: time.Since(start),
+			Message: fmt.Sprintf("check timed out after %s", h.timeout),
+		}
+	}
+}
+
+func (h *HealthChecker) checkDatabase(ctx context.Context) ComponentHealth {
+	if h.config.RepositoryManager == nil {
+		return ComponentHealth{Status: HealthNotConfigured}
+	}
+	repo := h.config.RepositoryManager.GetRepository()
+	if repo == nil {
+		return ComponentHealth{Status: HealthDown, Message: "repository is not initialized"}
+	}
+	if err := repo.HealthCheck(ctx); err != nil {
+		return ComponentHealth{Status: HealthDown, Message: err.Error()}
+	}
+	return ComponentHealth{Status: HealthUp}
+}
+
+func (h *HealthChecker) checkRedis(ctx context.Context) ComponentHealth {
+	if h.config.RedisClient == nil {
+		return ComponentHealth{Status: HealthNotConfigured}
+	}
+	if err := h.config.RedisClient.Ping(ctx).Err(); err != nil {
+		return ComponentHealth{Status: HealthDown, Message: err.Error()}
+	}
+	return ComponentHealth{Status: HealthUp}
+}
+
+// checkQueueServers verifies that at least one asynq server is registered to consume tasks
+func (h *HealthChecker) checkQueueServers(_ context.Context) ComponentHealth {
+	if h.config.QueueClient == nil {
+		return ComponentHealth{Status: HealthNotConfigured}
+	}
+	if h.inspector == nil {
+		return ComponentHealth{Status: HealthDegraded, Message: "redis client not configured, cannot inspect queue servers"}
+	}
+
+	servers, err := h.inspector.Servers()
+	if err != nil {
+		return ComponentHealth{Status: HealthDown, Message: err.Error()}
+	}
+	if len(servers) == 0 {
+		return ComponentHealth{Status: HealthDown, Message: "no asynq servers are registered"}
+	}
+	return ComponentHealth{Status: HealthUp, Message: fmt.Sprintf("%d server(s) registered", len(servers))}
+}
+
+func (h *HealthChecker) checkWorker(_ context.Context) ComponentHealth {
+	if h.config.Worker == nil {
+		return ComponentHealth{Status: HealthNotConfigured}
+	}
+
+	switch state := h.config.Worker.State(); state {
+	case WorkerStateRunning:
+		return ComponentHealth{Status: HealthUp, Message: state}
+	case WorkerStateFailed:
+		msg := state
+		if err := h.config.Worker.Err(); err != nil {
+			msg = err.Error()
+		}
+		return ComponentHealth{Status: HealthDown, Message: msg}
+	default:
+		return ComponentHealth{Status: HealthDown, Message: state}
+	}
+}
+
+func (h *HealthChecker) checkOrchestrators(_ context.Context) ComponentHealth {
+	if h.config.QueueClient == nil {
+		return ComponentHealth{Status: HealthNotConfigured}
+	}
+
+	batchOrchestrator, bulkOrchestrator := h.config.BatchOrchestrator, h.config.BulkOrchestrator
+	if h.config.WorkerConfig != nil {
+		if batchOrchestrator == nil {
+			batchOrchestrator = h.config.WorkerConfig.BatchOrchestrator
+		}
+		if bulkOrchestrator == nil {
+			bulkOrchestrator = h.config.WorkerConfig.BulkOrchestrator
+		}
+	}
+
+	var missing []string
+	if batchOrchestrator == nil {
+		missing = append(missing, "batch")
+	}
+	if bulkOrchestrator == nil {
+		missing = append(missing, "bulk")
+	}
+	if len(missing) > 0 {
+		return ComponentHealth{Status: HealthDegraded, Message: fmt.Sprintf("orchestrators not initialized: %v", missing)}
+	}
+	return ComponentHealth{Status: HealthUp}
+}
+
+// aggregateStatus derives the overall readiness from component states
+func aggregateStatus(components map[string]ComponentHealth) string {
+	status := ReadinessHealthy
+	for _, component := range components {
+		switch component.Status {
+		case HealthDown:
+			return ReadinessUnhealthy
+		case HealthDegraded:
+			status = ReadinessDegraded
+		}
+	}
+	return status
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowRepository answers health checks after a delay, counting them
+type slowRepository struct {
+	repository.Repository
+	delay  time.Duration
+	checks atomic.Int32
+	err    error
+}
+
+func (r *slowRepository) HealthCheck(ctx context.Context) error {
+	r.checks.Add(1)
+	select {
+	case <-time.After(r.delay):
+		return r.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestHealthChecker_SharesConcurrentChecks(t *testing.T) {
+	repo := &slowRepository{delay: 50 * time.Millisecond}
+	checker := NewHealthChecker(&Config{RepositoryManager: repository.NewManagerWithRepository(repo)})
+
+	var wg sync.WaitGroup
+	reports := make([]ReadinessReport, 5)
+	for i := range reports {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			reports[i] = checker.Ready(context.Background())
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), repo.checks.Load())
+	for _, report := range reports {
+		assert.Equal(t, ReadinessHealthy, report.Status)
+		assert.Equal(t, HealthUp, report.Components["database"].Status)
+	}
+
+	// Fresh results are served from the cache
+	report := checker.Ready(context.Background())
+	assert.True(t, report.Cached)
+	assert.Equal(t, int32(1), repo.checks.Load())
+}
+
+func TestHealthChecker_CancelledCallerDoesNotCacheFailure(t *testing.T) {
+	repo := &slowRepository{delay: 50 * time.Millisecond}
+	checker := NewHealthChecker(&Config{RepositoryManager: repository.NewManagerWithRepository(repo)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	assert.Equal(t, ReadinessUnhealthy, checker.Ready(ctx).Status)
+
+	// The checks kept running on their own context and cached a healthy result
+	assert.Eventually(t, func() bool {
+		report := checker.Ready(context.Background())
+		return report.Status == ReadinessHealthy && report.Components["database"].Status == HealthUp
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), repo.checks.Load())
+}
+
+func TestHealthChecker_ChecksTimeOut(t *testing.T) {
+	repo := &slowRepository{delay: time.Second, err: errors.New("unreachable")}
+	checker := NewHealthChecker(&Config{
+		RepositoryManager: repository.NewManagerWithRepository(repo),
+		HealthConfig:      &HealthConfig{CheckTimeout: 20 * time.Millisecond, CacheTTL: -1},
+	})
+
+	report := checker.Ready(context.Background())
+	assert.Equal(t, ReadinessUnhealthy, report.Status)
+	assert.Contains(t, report.Components["database"].Message, "timed out")
+}
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"context"
+	"log"
+
+	"github.com/hibiken/asynq"
+	"github.com/hussainpithawala/state-machine-amz-gin/inbox"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/statemachine/persistent"
+)
+
+// ResumeEnqueuer queues the resume tasks of delivered messages
+type ResumeEnqueuer interface {
+	EnqueueExecution(payload *queue.ExecutionTaskPayload, opts ...asynq.Option) (*asynq.TaskInfo, error)
+}
+
+// inboxRepository delivers messages buffered in the inbox to the executions that pause in
+// a Message state waiting for them. The message is delivered by queueing the resume of
+// the execution, so the execution pausing is not resumed from within its own save.
+type inboxRepository struct {
+	repositoryDecorator
+	messages    repository.MessageRepository
+	inbox       *inbox.Store
+	enqueuer    ResumeEnqueuer
+	queueConfig *queue.Config
+}
+
+func (r *inboxRepository) SaveExecution(ctx context.Context, record *repository.ExecutionRecord) error {
+	if err := r.Repository.SaveExecution(ctx, record); err != nil {
+		return err
+	}
+	if record.Status == persistent.PAUSED {
+		r.deliver(ctx, record)
+	}
+	return nil
+}
+
+// deliver queues the resume of a paused execution with the oldest message buffered for
+// the correlation it waits on. Failures are logged: the message stays buffered and can be
+// redelivered.
+func (r *inboxRepository) deliver(ctx context.Context, record *repository.ExecutionRecord) {
+	correlation, err := r.messages.GetMessageCorrelation(ctx, correlationID(record))
+	if err != nil || correlation.Status != "WAITING" {
+		return
+	}
+
+	message, err := r.inbox.Claim(ctx, record.StateMachineID, correlation.CorrelationKey, correlation.CorrelationValue)
+	if err != nil {
+		log.Printf("Failed to check the inbox for execution %s: %v", record.ExecutionID, err)
+		return
+	}
+	if message == nil {
+		return
+	}
+
+	if _, err := r.enqueuer.EnqueueExecution(NewResumeTaskPayload(record, message.Output), r.taskOptions(record, message)...); err != nil {
+		log.Printf("Failed to deliver message %s to execution %s: %v", message.ID, record.ExecutionID, err)
+		if err := r.inbox.Release(ctx, message); err != nil {
+			log.Printf("Failed to release message %s: %v", message.ID, err)
+		}
+		return
+	}
+	if err := r.inbox.Delivered(ctx, message, record.ExecutionID); err != nil {
+		log.Printf("Failed to mark message %s delivered: %v", message.ID, err)
+	}
+}
+
+// taskOptions queues the resume on the queue of the state machine. The task ID names the
+// message, since the execution may pause again while an earlier resume task still runs.
+func (r *inboxRepository) taskOptions(record *repository.ExecutionRecord, message *inbox.Message) []asynq.Option {
+	opts := []asynq.Option{
+		asynq.Queue(record.StateMachineID),
+		asynq.TaskID(ResumeTaskIDPrefix + record.ExecutionID + "-" + message.ID),
+	}
+	if r.queueConfig != nil && r.queueConfig.RetryPolicy != nil {
+		opts = append(opts, asynq.MaxRetry(r.queueConfig.RetryPolicy.MaxRetry), asynq.Timeout(r.queueConfig.RetryPolicy.Timeout))
+	}
+	return opts
+}
+
+// NewInboxRepositoryManager returns a manager over the same repository that delivers the
+// messages buffered in messages to executions pausing in a Message state, by queueing
+// their resume on enqueuer. Repositories without message correlation have no Message
+// states to deliver to and are returned as they are.
+func NewInboxRepositoryManager(manager *repository.Manager, messages *inbox.Store, enqueuer ResumeEnqueuer, queueConfig *queue.Config) *repository.Manager {
+	correlations, ok := manager.GetRepository().(repository.MessageRepository)
+	if !ok {
+		return manager
+	}
+
+	return decorateRepository(manager, func(inner repository.Repository) *inboxRepository {
+		return &inboxRepository{
+			repositoryDecorator: repositoryDecorator{inner},
+			messages:            correlations,
+			inbox:               messages,
+			enqueuer:            enqueuer,
+			queueConfig:         queueConfig,
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hussainpithawala/state-machine-amz-gin/inbox"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newInboxFixture(t *testing.T, q *recordingQueue) (*memoryMessageRepository, *inbox.Store, *repository.Manager) {
+	redisClient := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { _ = redisClient.Close() })
+	repo := newMemoryMessageRepository(nil)
+	messages := inbox.NewStore(redisClient)
+	return repo, messages, NewInboxRepositoryManager(repository.NewManagerWithRepository(repo), messages, q, nil)
+}
+
+func TestInboxRepository_DeliversBufferedMessageOnPause(t *testing.T) {
+	q := &recordingQueue{}
+	repo, messages, manager := newInboxFixture(t, q)
+	ctx := context.Background()
+
+	first := &inbox.Message{StateMachineID: "orders", CorrelationKey: "orderId", CorrelationValue: float64(1001), Output: map[string]interface{}{"paid": true}}
+	second := &inbox.Message{StateMachineID: "orders", CorrelationKey: "orderId", CorrelationValue: float64(1001), Output: map[string]interface{}{"paid": false}}
+	assert.NoError(t, messages.Put(ctx, first, 0))
+	assert.NoError(t, messages.Put(ctx, second, 0))
+
+	// Executions that are not paused get nothing
+	record := repo.pause("exec-1", "WaitForPayment", float64(1001))
+	running := *record
+	running.Status = "RUNNING"
+	assert.NoError(t, manager.GetRepository().SaveExecution(ctx, &running))
+	assert.Empty(t, q.resumes)
+
+	// The oldest message is delivered by queueing the resume, under an ID naming it
+	assert.NoError(t, manager.GetRepository().SaveExecution(ctx, record))
+	if assert.Len(t, q.resumes, 1) {
+		assert.True(t, IsResumeTask(q.resumes[0]))
+		assert.Equal(t, "exec-1", q.resumes[0].ExecutionID)
+		assert.Equal(t, map[string]interface{}{"paid": true}, q.resumes[0].Input)
+		assert.Equal(t, ResumeTaskIDPrefix+"exec-1-"+first.ID, q.taskIDs[0])
+	}
+	delivered, err := messages.Get(ctx, first.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, inbox.StatusDelivered, delivered.Status)
+		assert.Equal(t, "exec-1", delivered.ExecutionID)
+	}
+	pending, err := messages.Get(ctx, second.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, inbox.StatusPending, pending.Status)
+	}
+
+	// A correlation that is no longer waiting gets nothing more
+	assert.NoError(t, repo.UpdateCorrelationStatus(ctx, correlationID(record), "RECEIVED"))
+	assert.NoError(t, manager.GetRepository().SaveExecution(ctx, record))
+	assert.Len(t, q.resumes, 1)
+}
+
+func TestInboxRepository_ReleasesMessageWhenResumeFails(t *testing.T) {
+	q := &recordingQueue{fail: map[string]bool{"exec-1": true}}
+	repo, messages, manager := newInboxFixture(t, q)
+	ctx := context.Background()
+
+	message := &inbox.Message{StateMachineID: "orders", CorrelationKey: "orderId", CorrelationValue: "A-1"}
+	assert.NoError(t, messages.Put(ctx, message, 0))
+
+	// The execution is saved even though the message could not be delivered
+	record := repo.pause("exec-1", "WaitForPayment", "A-1")
+	assert.NoError(t, manager.GetRepository().SaveExecution(ctx, record))
+	saved, err := repo.GetExecution(ctx, "exec-1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "PAUSED", saved.Status)
+	}
+
+	// The message stays buffered for the next execution waiting on it
+	released, err := messages.Get(ctx, message.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, inbox.StatusPending, released.Status)
+		assert.Zero(t, released.Deliveries)
+	}
+	record = repo.pause("exec-2", "WaitForPayment", "A-1")
+	assert.NoError(t, manager.GetRepository().SaveExecution(ctx, record))
+	if assert.Len(t, q.resumes, 1) {
+		assert.Equal(t, "exec-2", q.resumes[0].ExecutionID)
+	}
+}
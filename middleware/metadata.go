@@ -0,0 +1,18 @@
+package middleware
+
+import "maps"
+
+// WithMetadata returns a copy of metadata with key set to value, or removed when value is
+// nil. The metadata passed in is left unchanged, as it may be shared with a stored record.
+func WithMetadata(metadata map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	updated := maps.Clone(metadata)
+	if updated == nil {
+		updated = make(map[string]interface{}, 1)
+	}
+	if value == nil {
+		delete(updated, key)
+	} else {
+		updated[key] = value
+	}
+	return updated
+}
@@ -0,0 +1,233 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/hussainpithawala/state-machine-amz-gin/activities"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/handler"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/statemachine/persistent"
+)
+
+// ParkingQueue queues the resumes and schedules the timeouts of parked executions
+type ParkingQueue interface {
+	ResumeEnqueuer
+	TimeoutScheduler
+}
+
+// EnqueueTaskTokenResume queues the resume of an execution parked on the callback task
+// token, once the task is reported on. The task ID names the token, so a report resumes
+// the execution once.
+func EnqueueTaskTokenResume(enqueuer ResumeEnqueuer, execution *activities.ParkedExecution, token string, opts ...asynq.Option) (*asynq.TaskInfo, error) {
+	payload := &queue.ExecutionTaskPayload{
+		StateMachineID: execution.StateMachineID,
+		ExecutionID:    execution.ExecutionID,
+		ExecutionName:  execution.ExecutionName,
+		Options:        map[string]interface{}{ResumeOption: true, TaskTokenOption: token},
+	}
+	opts = append(opts, asynq.TaskID(ResumeTaskIDPrefix+execution.ExecutionID+"-"+token))
+	return enqueuer.EnqueueExecution(payload, opts...)
+}
+
+// resumeTaskOptions queues a resume on the queue of the state machine with the retry
+// policy of the queue
+func resumeTaskOptions(stateMachineID string, queueConfig *queue.Config) []asynq.Option {
+	opts := []asynq.Option{asynq.Queue(stateMachineID)}
+	if queueConfig != nil && queueConfig.RetryPolicy != nil {
+		opts = append(opts, asynq.MaxRetry(queueConfig.RetryPolicy.MaxRetry), asynq.Timeout(queueConfig.RetryPolicy.Timeout))
+	}
+	return opts
+}
+
+// parkingRepository saves the executions that park on a callback task as PAUSED in the
+// Task state. The execution stops with the Task state failed, caught or cancelled; its
+// history entry is saved WAITING and the execution PAUSED with the input of the Task
+// state as output, as a Message state does. Once saved, the execution is recorded on the
+// task so a report resumes it, and the timeout of the task is scheduled.
+type parkingRepository struct {
+	repositoryDecorator
+	store       *activities.Store
+	queue       ParkingQueue
+	queueConfig *queue.Config
+	now         func() time.Time
+}
+
+func (r *parkingRepository) SaveStateHistory(ctx context.Context, record *repository.StateHistoryRecord) error {
+	parking := activities.ParkingFrom(ctx)
+	task := parking.Parked()
+	if task == nil {
+		parking.StateFinished()
+		return r.Repository.SaveStateHistory(ctx, record)
+	}
+	if task.StateName == "" {
+		task.StateName = record.StateName
+		task.Input = record.Input
+		record.Status = "WAITING"
+		record.Output = map[string]interface{}{"taskToken": task.Token}
+		record.Error = ""
+	}
+	// The execution context is cancelled once it parks
+	return r.Repository.SaveStateHistory(context.WithoutCancel(ctx), record)
+}
+
+func (r *parkingRepository) SaveExecution(ctx context.Context, record *repository.ExecutionRecord) error {
+	task := activities.ParkingFrom(ctx).Parked()
+	if task == nil || task.StateName == "" {
+		return r.Repository.SaveExecution(ctx, record)
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	record.Status = persistent.PAUSED
+	record.CurrentState = task.StateName
+	record.Output = task.Input
+	record.Error = ""
+	record.EndTime = nil
+	if err := r.Repository.SaveExecution(ctx, record); err != nil {
+		return err
+	}
+	r.park(ctx, record, task)
+	return nil
+}
+
+// park records a paused execution on its callback task, and the attempts of the task
+// for its resume. A task reported on before the execution was saved resumes it at once;
+// otherwise the timeout of the task is scheduled. Failures are logged: the execution then
+// stays paused.
+func (r *parkingRepository) park(ctx context.Context, record *repository.ExecutionRecord, task *activities.ParkedTask) {
+	execution := &activities.ParkedExecution{
+		ExecutionID:    record.ExecutionID,
+		StateMachineID: record.StateMachineID,
+		ExecutionName:  record.Name,
+	}
+	reported, err := r.store.Park(ctx, task.Attempts, execution)
+	if err != nil {
+		log.Printf("Failed to park execution %s on task %s: %v", record.ExecutionID, task.Token, err)
+		return
+	}
+	if reported {
+		if _, err := EnqueueTaskTokenResume(r.queue, execution, task.Token, resumeTaskOptions(record.StateMachineID, r.queueConfig)...); err != nil {
+			log.Printf("Failed to resume execution %s from task %s: %v", record.ExecutionID, task.Token, err)
+		}
+		return
+	}
+	if task.Timeout <= 0 {
+		return
+	}
+	if _, err := r.queue.ScheduleTimeout(&queue.TimeoutTaskPayload{
+		ExecutionID:    record.ExecutionID,
+		StateMachineID: record.StateMachineID,
+		StateName:      task.StateName,
+		CorrelationID:  activities.TimeoutCorrelationPrefix + task.Token,
+		TimeoutSeconds: int(task.Timeout / time.Second),
+		ScheduledAt:    r.now().Unix(),
+	}, task.Timeout); err != nil {
+		log.Printf("Failed to schedule the timeout of task %s: %v", task.Token, err)
+	}
+}
+
+// NewParkingRepositoryManager returns a manager over the same repository that saves the
+// executions parking on a callback task of store as PAUSED, and resumes or times them out
+// through queueClient
+func NewParkingRepositoryManager(manager *repository.Manager, store *activities.Store, queueClient ParkingQueue, queueConfig *queue.Config) *repository.Manager {
+	return decorateRepository(manager, func(inner repository.Repository) *parkingRepository {
+		return &parkingRepository{
+			repositoryDecorator: repositoryDecorator{inner},
+			store:               store,
+			queue:               queueClient,
+			queueConfig:         queueConfig,
+			now:                 time.Now,
+		}
+	})
+}
+
+// parkingExecutionHandler runs every execution of a task on its own parking context, so
+// executions park on their callback tasks instead of holding the worker. A task whose
+// execution parked succeeds. It also handles the timeouts of parked callback tasks.
+type parkingExecutionHandler struct {
+	queue.ExecutionHandler
+	store       *activities.Store
+	enqueuer    ResumeEnqueuer
+	queueConfig *queue.Config
+}
+
+func (h *parkingExecutionHandler) HandleExecution(ctx context.Context, payload *queue.ExecutionTaskPayload) error {
+	ctx, parking, cancel := activities.WithParking(ctx)
+	defer cancel()
+	err := h.ExecutionHandler.HandleExecution(ctx, payload)
+	if task := parking.Parked(); task != nil {
+		log.Printf("Execution %s parked on task %s", payload.ExecutionName, task.Token)
+		return nil
+	}
+	return err
+}
+
+// HandleBatchExecution runs the tasks of a group as groups of one, each on its own
+// parking context, with the concurrency of the group
+func (h *parkingExecutionHandler) HandleBatchExecution(ctx context.Context, payload *queue.BatchTaskPayload) error {
+	concurrency := min(max(payload.GroupConcurrency, handler.MinGroupConcurrency), handler.MaxGroupConcurrency)
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed int
+	for _, task := range payload.Tasks {
+		group := *payload
+		group.Tasks = []json.RawMessage{task}
+		group.TaskCount = 1
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			ctx, parking, cancel := activities.WithParking(ctx)
+			defer cancel()
+			if err := h.ExecutionHandler.HandleBatchExecution(ctx, &group); err != nil && parking.Parked() == nil {
+				mu.Lock()
+				failed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if failed > 0 {
+		return fmt.Errorf("batch execution completed with %d/%d failures in group %s", failed, len(payload.Tasks), payload.GroupID)
+	}
+	return nil
+}
+
+// HandleTimeout fails a parked callback task that got no report in time with
+// States.Timeout and queues the resume of its execution. Tasks reported on in the
+// meantime are left alone. Other timeouts resume their execution on a parking context.
+func (h *parkingExecutionHandler) HandleTimeout(ctx context.Context, payload *queue.TimeoutTaskPayload) error {
+	token, ok := strings.CutPrefix(payload.CorrelationID, activities.TimeoutCorrelationPrefix)
+	if !ok {
+		ctx, _, cancel := activities.WithParking(ctx)
+		defer cancel()
+		return h.ExecutionHandler.HandleTimeout(ctx, payload)
+	}
+
+	execution, err := h.store.TimeOut(ctx, token, time.Duration(payload.TimeoutSeconds)*time.Second)
+	if errors.Is(err, activities.ErrTaskNotRunning) || errors.Is(err, activities.ErrTaskNotFound) {
+		log.Printf("Task %s of execution %s is no longer waiting, skipping timeout", token, payload.ExecutionID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to time out task %s: %w", token, err)
+	}
+	if execution == nil {
+		return nil
+	}
+	if _, err := EnqueueTaskTokenResume(h.enqueuer, execution, token, resumeTaskOptions(execution.StateMachineID, h.queueConfig)...); err != nil {
+		return fmt.Errorf("failed to resume execution %s: %w", execution.ExecutionID, err)
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hibiken/asynq"
+	"github.com/hussainpithawala/state-machine-amz-gin/activities"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/executor"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/handler"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryRepository keeps state machines, executions and their history in memory. With
+// insertOnlyMetadata, the metadata of an execution is only written when it is first
+// saved, as the Postgres repositories do.
+type memoryRepository struct {
+	repository.Repository
+	insertOnlyMetadata bool
+	mu                 sync.Mutex
+	stateMachines      map[string]*repository.StateMachineRecord
+	executions         map[string]*repository.ExecutionRecord
+	history            map[string][]*repository.StateHistoryRecord
+}
+
+func newMemoryRepository(definitions map[string]string) *memoryRepository {
+	repo := &memoryRepository{
+		stateMachines: map[string]*repository.StateMachineRecord{},
+		executions:    map[string]*repository.ExecutionRecord{},
+		history:       map[string][]*repository.StateHistoryRecord{},
+	}
+	for id, definition := range definitions {
+		repo.stateMachines[id] = &repository.StateMachineRecord{ID: id, Name: id, Definition: definition}
+	}
+	return repo
+}
+
+func (r *memoryRepository) GetStateMachine(_ context.Context, id string) (*repository.StateMachineRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	record, ok := r.stateMachines[id]
+	if !ok {
+		return nil, errors.New("state machine not found")
+	}
+	return record, nil
+}
+
+func (r *memoryRepository) SaveExecution(ctx context.Context, record *repository.ExecutionRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	saved := *record
+	if stored, ok := r.executions[record.ExecutionID]; ok && r.insertOnlyMetadata {
+		saved.Metadata = stored.Metadata
+	}
+	r.executions[record.ExecutionID] = &saved
+	return nil
+}
+
+func (r *memoryRepository) GetExecution(_ context.Context, id string) (*repository.ExecutionRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	record, ok := r.executions[id]
+	if !ok {
+		return nil, errors.New("execution not found")
+	}
+	found := *record
+	return &found, nil
+}
+
+func (r *memoryRepository) GetExecutionByName(_ context.Context, stateMachineID, name string) (*repository.ExecutionRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, record := range r.executions {
+		if record.StateMachineID == stateMachineID && record.Name == name {
+			found := *record
+			return &found, nil
+		}
+	}
+	return nil, errors.New("execution not found")
+}
+
+func (r *memoryRepository) SaveStateHistory(ctx context.Context, record *repository.StateHistoryRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	saved := *record
+	r.history[record.ExecutionID] = append(r.history[record.ExecutionID], &saved)
+	return nil
+}
+
+// execution returns the only execution saved under a name
+func (r *memoryRepository) execution(t *testing.T, name string) *repository.ExecutionRecord {
+	t.Helper()
+	record, err := r.GetExecutionByName(context.Background(), "orders", name)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return record
+}
+
+type parkingFixture struct {
+	repo      *memoryRepository
+	store     *activities.Store
+	queue     *queue.Client
+	inspector *asynq.Inspector
+	handler   *parkingExecutionHandler
+	tokens    chan string
+}
+
+// newParkingFixture runs the executions of the "orders" state machine through the
+// handlers of a worker. The "notify" handler hands the task tokens it receives to
+// tokens, after calling report with them when it is set.
+func newParkingFixture(t *testing.T, definition string, report func(store *activities.Store, token string)) *parkingFixture {
+	server := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	config := queue.DefaultConfig()
+	config.RedisClientOpt = &asynq.RedisClientOpt{Addr: server.Addr()}
+	queueClient, err := queue.NewClient(config)
+	assert.NoError(t, err)
+	f := &parkingFixture{
+		repo:      newMemoryRepository(map[string]string{"orders": definition}),
+		store:     activities.NewStore(redisClient),
+		queue:     queueClient,
+		inspector: asynq.NewInspector(asynq.RedisClientOpt{Addr: server.Addr()}),
+		tokens:    make(chan string, 4),
+	}
+	t.Cleanup(func() {
+		_ = queueClient.Close()
+		_ = f.inspector.Close()
+		_ = redisClient.Close()
+	})
+
+	baseExecutor := executor.NewBaseExecutor()
+	baseExecutor.RegisterGoFunction("notify", func(_ context.Context, input interface{}) (interface{}, error) {
+		token := input.(map[string]interface{})[activities.TaskTokenField].(string)
+		if report != nil {
+			report(f.store, token)
+		}
+		f.tokens <- token
+		return nil, nil
+	})
+	execCtx := activities.NewExecutionContext(executor.NewExecutionContextAdapter(baseExecutor), f.store)
+	manager := NewParkingRepositoryManager(repository.NewManagerWithRepository(f.repo), f.store, queueClient, config)
+	f.handler = &parkingExecutionHandler{
+		ExecutionHandler: &resumingExecutionHandler{
+			ExecutionHandler:  handler.NewExecutionHandlerWithContext(manager, queueClient, execCtx, nil),
+			repositoryManager: manager,
+			store:             f.store,
+			queueClient:       queueClient,
+			executionContext:  execCtx,
+		},
+		store:       f.store,
+		enqueuer:    queueClient,
+		queueConfig: config,
+	}
+	return f
+}
+
+// start runs a queued execution of "orders" and returns the token it parked on
+func (f *parkingFixture) start(t *testing.T, name string, input interface{}) string {
+	t.Helper()
+	assert.NoError(t, f.handler.HandleExecution(context.Background(), &queue.ExecutionTaskPayload{
+		StateMachineID: "orders",
+		ExecutionName:  name,
+		Input:          input,
+	}))
+	select {
+	case token := <-f.tokens:
+		return token
+	default:
+		t.Fatal("the execution did not hand out a task token")
+		return ""
+	}
+}
+
+// runQueued runs a task queued on queueName as the worker does
+func (f *parkingFixture) runQueued(t *testing.T, queueName, taskID string) error {
+	t.Helper()
+	info, err := f.inspector.GetTaskInfo(queueName, taskID)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.NoError(t, f.inspector.DeleteTask(queueName, taskID))
+	if queueName == "timeout" {
+		var payload queue.TimeoutTaskPayload
+		assert.NoError(t, json.Unmarshal(info.Payload, &payload))
+		return f.handler.HandleTimeout(context.Background(), &payload)
+	}
+	var payload queue.ExecutionTaskPayload
+	assert.NoError(t, json.Unmarshal(info.Payload, &payload))
+	return f.handler.HandleExecution(context.Background(), &payload)
+}
+
+const approvalDefinition = `{
+	"StartAt": "Approve",
+	"States": {
+		"Approve": {
+			"Type": "Task",
+			"Resource": "notify.waitForTaskToken",
+			"Parameters": {"orderId": "$.orderId", "TaskTokenTimeoutSeconds": 60},
+			"ResultPath": "$.approval",
+			"Retry": [{"ErrorEquals": ["States.ALL"], "MaxAttempts": 1}],
+			"Catch": [{"ErrorEquals": ["States.ALL"], "Next": "Escalate"}],
+			"Next": "Ship"
+		},
+		"Ship": {"Type": "Pass", "Result": "shipped", "ResultPath": "$.shipping", "End": true},
+		"Escalate": {"Type": "Pass", "Result": "escalated", "ResultPath": "$.shipping", "End": true}
+	}
+}`
+
+func TestParking_ReportResumesTheParkedExecution(t *testing.T) {
+	f := newParkingFixture(t, approvalDefinition, nil)
+	ctx := context.Background()
+
+	// The execution parks in the Task state rather than running its Retry or Catch
+	token := f.start(t, "order-1", map[string]interface{}{"orderId": "A1"})
+	parked := f.repo.execution(t, "order-1")
+	assert.Equal(t, "PAUSED", parked.Status)
+	assert.Equal(t, "Approve", parked.CurrentState)
+	assert.Equal(t, map[string]interface{}{"orderId": "A1"}, parked.Output)
+	attempts, err := f.store.ParkedAttempts(ctx, parked.ExecutionID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{token}, attempts)
+	assert.Empty(t, parked.Error)
+	if history := f.repo.history[parked.ExecutionID]; assert.Len(t, history, 1) {
+		assert.Equal(t, "WAITING", history[0].Status)
+		assert.Equal(t, "Approve", history[0].StateName)
+	}
+	_, err = f.inspector.GetTaskInfo("timeout", "timeout-"+activities.TimeoutCorrelationPrefix+token)
+	assert.NoError(t, err)
+
+	// The report queues the resume, which reruns the Task state with the outcome
+	execution, err := f.store.Succeed(ctx, token, map[string]interface{}{"approved": true})
+	assert.NoError(t, err)
+	assert.Equal(t, &activities.ParkedExecution{ExecutionID: parked.ExecutionID, StateMachineID: "orders", ExecutionName: "order-1"}, execution)
+	_, err = EnqueueTaskTokenResume(f.queue, execution, token, resumeTaskOptions("orders", nil)...)
+	assert.NoError(t, err)
+	assert.NoError(t, f.runQueued(t, "orders", ResumeTaskIDPrefix+parked.ExecutionID+"-"+token))
+
+	resumed := f.repo.execution(t, "order-1")
+	assert.Equal(t, "SUCCEEDED", resumed.Status)
+	assert.Equal(t, map[string]interface{}{"orderId": "A1", "approval": map[string]interface{}{"approved": true}, "shipping": "shipped"}, resumed.Output)
+
+	// The timeout of a task reported on is dropped
+	assert.NoError(t, f.runQueued(t, "timeout", "timeout-"+activities.TimeoutCorrelationPrefix+token))
+	_, err = f.inspector.GetTaskInfo("orders", ResumeTaskIDPrefix+parked.ExecutionID+"-"+token)
+	assert.ErrorIs(t, err, asynq.ErrTaskNotFound)
+}
+
+func TestParking_ReportBeforeTheExecutionIsSaved(t *testing.T) {
+	// The system called back before the execution was saved paused
+	f := newParkingFixture(t, approvalDefinition, func(store *activities.Store, token string) {
+		execution, err := store.Fail(context.Background(), token, "Rejected", "out of stock")
+		assert.NoError(t, err)
+		assert.Nil(t, execution)
+	})
+
+	token := f.start(t, "order-1", map[string]interface{}{"orderId": "A1"})
+	parked := f.repo.execution(t, "order-1")
+	assert.Equal(t, "PAUSED", parked.Status)
+
+	// Saving it queued the resume right away, and no timeout
+	_, err := f.inspector.GetTaskInfo("timeout", "timeout-"+activities.TimeoutCorrelationPrefix+token)
+	assert.ErrorIs(t, err, asynq.ErrQueueNotFound)
+	assert.NoError(t, f.runQueued(t, "orders", ResumeTaskIDPrefix+parked.ExecutionID+"-"+token))
+
+	// The failure is retried with a new token. The retry fails as well and is caught,
+	// once: the rerun Task state counts the attempt made before it parked.
+	retried := <-f.tokens
+	assert.NotEqual(t, token, retried)
+	attempts, err := f.store.ParkedAttempts(context.Background(), parked.ExecutionID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{token, retried}, attempts)
+	assert.NoError(t, f.runQueued(t, "orders", ResumeTaskIDPrefix+parked.ExecutionID+"-"+retried))
+	assert.Empty(t, f.tokens)
+
+	caught := f.repo.execution(t, "order-1")
+	assert.Equal(t, "SUCCEEDED", caught.Status)
+	assert.Equal(t, "escalated", caught.Output.(map[string]interface{})["shipping"])
+}
+
+func TestParking_ResumesWithoutStoredMetadata(t *testing.T) {
+	f := newParkingFixture(t, approvalDefinition, nil)
+	f.repo.insertOnlyMetadata = true
+	ctx := context.Background()
+
+	// The task tokens are kept out of the execution, which is saved paused again
+	token := f.start(t, "order-1", map[string]interface{}{"orderId": "A1"})
+	parked := f.repo.execution(t, "order-1")
+	assert.Equal(t, "PAUSED", parked.Status)
+
+	execution, err := f.store.Fail(ctx, token, "Rejected", "out of stock")
+	assert.NoError(t, err)
+	_, err = EnqueueTaskTokenResume(f.queue, execution, token, resumeTaskOptions("orders", nil)...)
+	assert.NoError(t, err)
+	assert.NoError(t, f.runQueued(t, "orders", ResumeTaskIDPrefix+parked.ExecutionID+"-"+token))
+
+	// The failure is retried with a new token, and a resume from the old token is skipped
+	retried := <-f.tokens
+	assert.NoError(t, f.handler.HandleExecution(ctx, &queue.ExecutionTaskPayload{
+		StateMachineID: "orders",
+		ExecutionID:    parked.ExecutionID,
+		Options:        map[string]interface{}{ResumeOption: true, TaskTokenOption: token},
+	}))
+	assert.Equal(t, "PAUSED", f.repo.execution(t, "order-1").Status)
+	assert.Empty(t, f.tokens)
+
+	execution, err = f.store.Succeed(ctx, retried, map[string]interface{}{"approved": true})
+	assert.NoError(t, err)
+	_, err = EnqueueTaskTokenResume(f.queue, execution, retried, resumeTaskOptions("orders", nil)...)
+	assert.NoError(t, err)
+	assert.NoError(t, f.runQueued(t, "orders", ResumeTaskIDPrefix+parked.ExecutionID+"-"+retried))
+	assert.Equal(t, "SUCCEEDED", f.repo.execution(t, "order-1").Status)
+}
+
+func TestParking_TimeoutFailsTheTask(t *testing.T) {
+	f := newParkingFixture(t, `{
+		"StartAt": "Approve",
+		"States": {
+			"Approve": {
+				"Type": "Task",
+				"Resource": "notify.waitForTaskToken",
+				"Parameters": {"TaskTokenTimeoutSeconds": 60},
+				"End": true
+			}
+		}
+	}`, nil)
+
+	token := f.start(t, "order-1", map[string]interface{}{"orderId": "A1"})
+	parked := f.repo.execution(t, "order-1")
+	assert.NoError(t, f.runQueued(t, "timeout", "timeout-"+activities.TimeoutCorrelationPrefix+token))
+	assert.EqualError(t, f.runQueued(t, "orders", ResumeTaskIDPrefix+parked.ExecutionID+"-"+token), "resume failed: "+activities.ErrorTimeout)
+
+	timedOut := f.repo.execution(t, "order-1")
+	assert.Equal(t, "FAILED", timedOut.Status)
+	assert.Equal(t, activities.ErrorTimeout, timedOut.Error)
+
+	// A late report finds the task timed out
+	_, err := f.store.Succeed(context.Background(), token, nil)
+	assert.ErrorIs(t, err, activities.ErrTaskNotRunning)
+
+	// A stale resume of the failed execution is skipped
+	assert.NoError(t, f.handler.HandleExecution(context.Background(), &queue.ExecutionTaskPayload{
+		StateMachineID: "orders",
+		ExecutionID:    parked.ExecutionID,
+		Options:        map[string]interface{}{ResumeOption: true, TaskTokenOption: token},
+	}))
+	assert.Equal(t, "FAILED", f.repo.execution(t, "order-1").Status)
+}
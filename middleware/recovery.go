@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/hussainpithawala/state-machine-amz-gin/recovery"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/execution"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/statemachine/persistent"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/types"
+)
+
+// recoveringExecutionHandler runs the orphaned executions queued by the recovery scanner
+// again from their current state and passes other tasks to the wrapped handler
+type recoveringExecutionHandler struct {
+	queue.ExecutionHandler
+	repositoryManager *repository.Manager
+	store             *recovery.Store
+	queueClient       *queue.Client
+	executionContext  types.ExecutionContext
+}
+
+func (h *recoveringExecutionHandler) HandleExecution(ctx context.Context, payload *queue.ExecutionTaskPayload) error {
+	attempt, ok := recovery.TaskAttempt(payload)
+	if !ok {
+		return h.ExecutionHandler.HandleExecution(ctx, payload)
+	}
+
+	record, err := h.repositoryManager.GetExecution(ctx, payload.ExecutionID)
+	if err != nil {
+		return fmt.Errorf("failed to get execution: %w", err)
+	}
+	metadata, err := h.store.RecoveryMetadata(ctx, payload.ExecutionID)
+	if err != nil {
+		return fmt.Errorf("failed to get the recovery attempt: %w", err)
+	}
+	// The execution may have finished, or been recovered again, since the task was queued
+	if record.Status != "RUNNING" || metadata == nil || metadata.RecoveryAttemptCount != attempt {
+		log.Printf("Execution %s is no longer waiting for recovery attempt %d, skipping", record.ExecutionID, attempt)
+		return nil
+	}
+	record.RecoveryMetadata = metadata
+
+	sm, err := persistent.NewFromDefnId(ctx, record.StateMachineID, h.repositoryManager)
+	if err != nil {
+		return fmt.Errorf("failed to load state machine: %w", err)
+	}
+	sm.SetQueueClient(h.queueClient)
+	if h.executionContext != nil {
+		ctx = context.WithValue(ctx, types.ExecutionContextKey, h.executionContext)
+	}
+
+	result, err := sm.RunExecution(WithExecutionTags(ctx, ExecutionTagsFrom(record.Metadata)), payload.Input, recoveredExecution(record))
+	if err != nil {
+		return fmt.Errorf("recovery failed: %w", err)
+	}
+	if result.Status == persistent.FAILED {
+		return fmt.Errorf("execution completed with FAILED status: %v", result.Error)
+	}
+	return nil
+}
+
+// recoveredExecution returns the execution of record with its recovery metadata
+func recoveredExecution(record *repository.ExecutionRecord) *execution.Execution {
+	execCtx := &execution.Execution{
+		ID:                    record.ExecutionID,
+		StateMachineID:        record.StateMachineID,
+		Name:                  record.Name,
+		Status:                record.Status,
+		CurrentState:          record.CurrentState,
+		Input:                 record.Input,
+		HistorySequenceNumber: record.HistorySequenceNumber,
+	}
+	if record.StartTime != nil {
+		execCtx.StartTime = *record.StartTime
+	}
+	if metadata := record.RecoveryMetadata; metadata != nil {
+		execCtx.RecoveryMetadata = &execution.RecoveryMetadata{
+			LastSuccessfulState:       metadata.LastSuccessfulState,
+			LastSuccessfulStateOutput: metadata.LastSuccessfulStateOutput,
+			RecoveryAttemptCount:      metadata.RecoveryAttemptCount,
+			LastRecoveryAttemptAt:     metadata.LastRecoveryAttemptAt,
+			MaxRecoveryAttempts:       metadata.MaxRecoveryAttempts,
+			RecoveryStrategy:          metadata.RecoveryStrategy,
+			CrashDetectedAt:           metadata.CrashDetectedAt,
+		}
+	}
+	return execCtx
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hussainpithawala/state-machine-amz-gin/recovery"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+const shippingDefinition = `{
+	"StartAt": "Charge",
+	"States": {
+		"Charge": {"Type": "Pass", "Next": "Ship"},
+		"Ship": {"Type": "Pass", "Next": "Done"},
+		"Done": {"Type": "Succeed"}
+	}
+}`
+
+func TestRecoveringExecutionHandler_RunsTheLatestAttempt(t *testing.T) {
+	repo := newMemoryRepository(map[string]string{"orders": shippingDefinition})
+	manager := repository.NewManagerWithRepository(repo)
+	redisClient := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { _ = redisClient.Close() })
+	store := recovery.NewStore(redisClient)
+	recorder := &contextRecordingHandler{}
+	h := &recoveringExecutionHandler{ExecutionHandler: recorder, repositoryManager: manager, store: store}
+	ctx := context.Background()
+
+	// An execution orphaned in Ship, whose first recovery attempt was queued. The attempt
+	// is only in the store, as the repositories do not keep recovery metadata.
+	started := time.Now().Add(-time.Hour)
+	assert.NoError(t, repo.SaveExecution(ctx, &repository.ExecutionRecord{
+		ExecutionID:    "exec-1",
+		StateMachineID: "orders",
+		Name:           "order-1",
+		Status:         "RUNNING",
+		CurrentState:   "Ship",
+		Input:          map[string]interface{}{"orderId": float64(1001)},
+		StartTime:      &started,
+	}))
+	assert.NoError(t, store.SaveRecoveryMetadata(ctx, "exec-1", &repository.RecoveryMetadata{RecoveryAttemptCount: 1, MaxRecoveryAttempts: 3, RecoveryStrategy: recovery.StrategyRetry}))
+	task := func(attempt int) *queue.ExecutionTaskPayload {
+		return queuedPayload(t, &queue.ExecutionTaskPayload{
+			StateMachineID: "orders",
+			ExecutionID:    "exec-1",
+			ExecutionName:  "order-1",
+			Input:          map[string]interface{}{"charged": true},
+			Options:        map[string]interface{}{recovery.TaskOption: attempt},
+		})
+	}
+
+	// Other tasks are passed on
+	assert.NoError(t, h.HandleExecution(ctx, queuedPayload(t, &queue.ExecutionTaskPayload{ExecutionName: "order-2"})))
+	assert.Len(t, recorder.tags, 1)
+
+	// A task of another attempt is skipped
+	assert.NoError(t, h.HandleExecution(ctx, task(2)))
+	assert.Equal(t, "RUNNING", repo.execution(t, "order-1").Status)
+	assert.Empty(t, repo.history["exec-1"])
+
+	// The task of the latest attempt runs the execution on from its current state
+	assert.NoError(t, h.HandleExecution(ctx, task(1)))
+	record := repo.execution(t, "order-1")
+	assert.Equal(t, "SUCCEEDED", record.Status)
+	assert.Equal(t, map[string]interface{}{"charged": true}, record.Output)
+	states := make([]string, 0, len(repo.history["exec-1"]))
+	for _, history := range repo.history["exec-1"] {
+		states = append(states, history.StateName)
+	}
+	assert.NotContains(t, states, "Charge")
+	assert.Contains(t, states, "Ship")
+
+	// Once the execution finished, the task is skipped when it runs again
+	assert.NoError(t, h.HandleExecution(ctx, task(1)))
+	assert.Len(t, repo.history["exec-1"], len(states))
+	assert.Len(t, recorder.tags, 1)
+}
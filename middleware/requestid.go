@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the request ID on requests and responses
+const RequestIDHeader = "X-Request-ID"
+
+const requestIDKey = "requestId"
+
+// maxRequestIDLength bounds client supplied request IDs
+const maxRequestIDLength = 128
+
+// RequestID assigns every request an ID, reusing a client supplied X-Request-ID header
+// when present. The ID is echoed in the response header and included in error responses.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = uuid.NewString()
+		}
+
+		c.Set(requestIDKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+// GetRequestID retrieves the request ID from gin context
+func GetRequestID(c *gin.Context) (string, bool) {
+	value, exists := c.Get(requestIDKey)
+	if !exists {
+		return "", false
+	}
+	requestID, ok := value.(string)
+	return requestID, ok
+}
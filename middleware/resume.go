@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/hussainpithawala/state-machine-amz-gin/activities"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/execution"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/statemachine/persistent"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/types"
+)
+
+// ResumeOption is the ExecutionTaskPayload option marking a task that resumes the paused
+// execution ExecutionID instead of starting one. The payload Input is the output of the
+// state the execution waits in.
+const ResumeOption = "resume"
+
+// TaskTokenOption is the option of a resume task naming the callback task token whose
+// report resumes the execution. The execution reruns the Task state it parked in, which
+// returns the reported outcome.
+const TaskTokenOption = "taskToken"
+
+// ResumeTaskIDPrefix prefixes the IDs of resume tasks. The rest names the execution and
+// what resumes it: the state it waits in, the buffered message or the callback task
+// token, so an execution has a single resume queued at a time for each wait.
+const ResumeTaskIDPrefix = "resume-"
+
+// NewResumeTaskPayload returns the task that resumes the paused execution of record with
+// output
+func NewResumeTaskPayload(record *repository.ExecutionRecord, output interface{}) *queue.ExecutionTaskPayload {
+	return &queue.ExecutionTaskPayload{
+		StateMachineID: record.StateMachineID,
+		ExecutionID:    record.ExecutionID,
+		ExecutionName:  record.Name,
+		Input:          output,
+		Options:        map[string]interface{}{ResumeOption: true},
+	}
+}
+
+// IsResumeTask reports whether payload resumes a paused execution
+func IsResumeTask(payload *queue.ExecutionTaskPayload) bool {
+	resume, _ := payload.Options[ResumeOption].(bool)
+	return resume
+}
+
+// ResumePausedExecution resumes the paused execution of record with output, the output
+// of the state it waits in. The execution keeps the tags it was started with.
+func ResumePausedExecution(ctx context.Context, sm *persistent.StateMachine, record *repository.ExecutionRecord, output interface{}) (*execution.Execution, error) {
+	execCtx := &execution.Execution{
+		ID:             record.ExecutionID,
+		StateMachineID: record.StateMachineID,
+		Name:           record.Name,
+		Status:         record.Status,
+		CurrentState:   record.CurrentState,
+		Input:          record.Input,
+		Output:         output,
+	}
+	if record.StartTime != nil {
+		execCtx.StartTime = *record.StartTime
+	}
+	return sm.ResumeExecution(WithExecutionTags(ctx, ExecutionTagsFrom(record.Metadata)), execCtx)
+}
+
+// resumingExecutionHandler resumes the executions of tasks queued with
+// NewResumeTaskPayload and passes other tasks to the wrapped handler
+type resumingExecutionHandler struct {
+	queue.ExecutionHandler
+	repositoryManager *repository.Manager
+	store             *activities.Store
+	queueClient       *queue.Client
+	executionContext  types.ExecutionContext
+}
+
+func (h *resumingExecutionHandler) HandleExecution(ctx context.Context, payload *queue.ExecutionTaskPayload) error {
+	if !IsResumeTask(payload) {
+		return h.ExecutionHandler.HandleExecution(ctx, payload)
+	}
+
+	record, err := h.repositoryManager.GetExecution(ctx, payload.ExecutionID)
+	if err != nil {
+		return fmt.Errorf("failed to get execution: %w", err)
+	}
+	// A message or timeout may have resumed the execution since the task was queued
+	if record.Status != persistent.PAUSED {
+		log.Printf("Execution %s is %s, skipping queued resume", record.ExecutionID, record.Status)
+		return nil
+	}
+	output := payload.Input
+	if token, ok := payload.Options[TaskTokenOption].(string); ok {
+		attempts, err := h.store.ParkedAttempts(ctx, record.ExecutionID)
+		if err != nil {
+			return fmt.Errorf("failed to get the parked task: %w", err)
+		}
+		if len(attempts) == 0 || attempts[len(attempts)-1] != token {
+			log.Printf("Execution %s is not parked on task %s, skipping queued resume", record.ExecutionID, token)
+			return nil
+		}
+		parking := activities.ParkingFrom(ctx)
+		if parking == nil {
+			var cancel context.CancelFunc
+			ctx, parking, cancel = activities.WithParking(ctx)
+			defer cancel()
+		}
+		parking.Resume(attempts)
+		output = record.Output
+	}
+
+	sm, err := persistent.NewFromDefnId(ctx, record.StateMachineID, h.repositoryManager)
+	if err != nil {
+		return fmt.Errorf("failed to load state machine: %w", err)
+	}
+	sm.SetQueueClient(h.queueClient)
+	if h.executionContext != nil {
+		ctx = context.WithValue(ctx, types.ExecutionContextKey, h.executionContext)
+	}
+
+	result, err := ResumePausedExecution(ctx, sm, record, output)
+	if err != nil {
+		return fmt.Errorf("resume failed: %w", err)
+	}
+	if result.Status == persistent.FAILED {
+		return fmt.Errorf("execution completed with FAILED status: %v", result.Error)
+	}
+	return nil
+}
@@ -3,8 +3,17 @@ package middleware
 import (
 	"context"
 	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
 
 	"github.com/gin-gonic/gin"
+	"github.com/hibiken/asynq"
+	"github.com/hussainpithawala/state-machine-amz-gin/datasets"
+	"github.com/hussainpithawala/state-machine-amz-gin/httptask"
+	"github.com/hussainpithawala/state-machine-amz-gin/inbox"
+	"github.com/hussainpithawala/state-machine-amz-gin/models"
+	"github.com/hussainpithawala/state-machine-amz-gin/recovery"
 	"github.com/hussainpithawala/state-machine-amz-go/pkg/batch"
 	"github.com/hussainpithawala/state-machine-amz-go/pkg/executor"
 	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
@@ -15,6 +24,12 @@ import (
 
 const bulkOrchestratorKey = "bulkOrchestrator"
 const batchOrchestratorKey = "batchOrchestrator"
+const healthCheckerKey = "healthChecker"
+const datasetStoreKey = "datasetStore"
+const queueConfigKey = "queueConfig"
+const queueInspectorKey = "queueInspector"
+const messageInboxKey = "messageInbox"
+const recoveryScannerKey = "recoveryScanner"
 
 // Config holds the configuration for the state machine middleware
 type Config struct {
@@ -27,13 +42,58 @@ type Config struct {
 	WorkerConfig        *WorkerConfig        // Optional: Configuration for background worker
 	BasePath            string               // e.g., "/api/v1"
 	TransformerRegistry *TransformerRegistry // Optional: Registry of custom transformers
+	Worker              *Worker              // Optional: In-process worker, reported by the readiness probe
+	HealthConfig        *HealthConfig        // Optional: Timeouts and caching for the readiness probe
+	DatasetStore        *datasets.Store      // Optional: Store of uploaded bulk input datasets
+	QueueConfig         *queue.Config        // Optional: Queue the delayed executions are inspected in, and the retry policy of queued tasks (default: WorkerConfig.QueueConfig)
+	Recovery            *recovery.Scanner    // Optional: Scanner of orphaned executions managed through the recovery endpoints; only a worker runs it, so set it on WorkerConfig for periodic scans (default: WorkerConfig.Recovery)
 }
 
 // StateMachineMiddleware injects shared runtime dependencies into gin context
 func StateMachineMiddleware(config *Config) gin.HandlerFunc {
+	healthChecker := NewHealthChecker(config)
+
+	// Task states can call HTTP endpoints through the built-in "http:invoke" resource
+	httptask.Register(config.BaseExecutor)
+
+	// Delayed executions are listed, cancelled and rescheduled through an inspector of the queue
+	queueConfig := config.QueueConfig
+	if queueConfig == nil && config.WorkerConfig != nil {
+		queueConfig = config.WorkerConfig.QueueConfig
+	}
+	var queueInspector *asynq.Inspector
+	if queueConfig != nil && queueConfig.RedisClientOpt != nil {
+		queueInspector = asynq.NewInspector(queueConfig.GetRedisClientOpt())
+	}
+
+	// The recovery endpoints manage the scanner the worker runs unless another is given.
+	// The middleware does not run a scanner, so one given only here scans on demand.
+	recoveryScanner := config.Recovery
+	if recoveryScanner == nil && config.WorkerConfig != nil {
+		recoveryScanner = config.WorkerConfig.Recovery
+	}
+	if config.Recovery != nil && (config.WorkerConfig == nil || config.WorkerConfig.Recovery != config.Recovery) {
+		log.Println("Warning: the recovery scanner is not run by a worker; set it on WorkerConfig.Recovery for periodic scans")
+	}
+
+	// Executions pausing in a Message state get their wait timeout scheduled on the queue.
+	// Correlation messages no execution waits for yet are buffered in Redis and delivered
+	// through the queue when an execution pauses waiting for them.
+	repositoryManager := config.RepositoryManager
+	if repositoryManager != nil && config.QueueClient != nil {
+		repositoryManager = NewWaitTimeoutRepositoryManager(repositoryManager, config.QueueClient)
+	}
+	var messageInbox *inbox.Store
+	if repositoryManager != nil && config.RedisClient != nil && config.QueueClient != nil {
+		messageInbox = inbox.NewStore(config.RedisClient)
+		repositoryManager = NewInboxRepositoryManager(repositoryManager, messageInbox, config.QueueClient, queueConfig)
+	}
+
 	return func(c *gin.Context) {
-		if config.RepositoryManager != nil {
-			c.Set("repositoryManager", config.RepositoryManager)
+		c.Set(healthCheckerKey, healthChecker)
+
+		if repositoryManager != nil {
+			c.Set("repositoryManager", repositoryManager)
 		}
 		if config.RedisClient != nil {
 			c.Set("redisClient", config.RedisClient)
@@ -54,6 +114,21 @@ func StateMachineMiddleware(config *Config) gin.HandlerFunc {
 		if config.TransformerRegistry != nil {
 			c.Set("transformerRegistry", config.TransformerRegistry)
 		}
+		if config.DatasetStore != nil {
+			c.Set(datasetStoreKey, config.DatasetStore)
+		}
+		if queueConfig != nil {
+			c.Set(queueConfigKey, queueConfig)
+		}
+		if queueInspector != nil {
+			c.Set(queueInspectorKey, queueInspector)
+		}
+		if messageInbox != nil {
+			c.Set(messageInboxKey, messageInbox)
+		}
+		if recoveryScanner != nil {
+			c.Set(recoveryScannerKey, recoveryScanner)
+		}
 
 		// Setup micro-batch bulkOrchestrator (optional)
 		if config.QueueClient != nil {
@@ -122,6 +197,16 @@ func GetRedisClient(c *gin.Context) (*redis.Client, bool) {
 	return redisClient, ok
 }
 
+// GetHealthChecker retrieves the readiness health checker from gin context
+func GetHealthChecker(c *gin.Context) (*HealthChecker, bool) {
+	checker, exists := c.Get(healthCheckerKey)
+	if !exists {
+		return nil, false
+	}
+	healthChecker, ok := checker.(*HealthChecker)
+	return healthChecker, ok
+}
+
 func GetTransformerRegistry(c *gin.Context) (TransformerRegistry, bool) {
 	registry, exists := c.Get("transformerRegistry")
 	if !exists {
@@ -131,17 +216,89 @@ func GetTransformerRegistry(c *gin.Context) (TransformerRegistry, bool) {
 	return reg, ok
 }
 
-// ErrorHandler is a middleware that handles panics and returns proper error responses
+// GetDatasetStore retrieves the dataset store from gin context
+func GetDatasetStore(c *gin.Context) (*datasets.Store, bool) {
+	store, exists := c.Get(datasetStoreKey)
+	if !exists {
+		return nil, false
+	}
+	datasetStore, ok := store.(*datasets.Store)
+	return datasetStore, ok
+}
+
+// GetQueueConfig retrieves the configuration of the queue from gin context
+func GetQueueConfig(c *gin.Context) (*queue.Config, bool) {
+	config, exists := c.Get(queueConfigKey)
+	if !exists {
+		return nil, false
+	}
+	queueConfig, ok := config.(*queue.Config)
+	return queueConfig, ok
+}
+
+// GetRetryPolicy retrieves the retry policy of the queue from gin context. Without a
+// queue configuration it is the policy of queue.DefaultConfig, the one queue clients are
+// created with by default.
+func GetRetryPolicy(c *gin.Context) *queue.RetryPolicy {
+	if config, ok := GetQueueConfig(c); ok && config.RetryPolicy != nil {
+		return config.RetryPolicy
+	}
+	return queue.DefaultConfig().RetryPolicy
+}
+
+// GetQueueInspector retrieves the inspector of the queue from gin context
+func GetQueueInspector(c *gin.Context) (*asynq.Inspector, bool) {
+	inspector, exists := c.Get(queueInspectorKey)
+	if !exists {
+		return nil, false
+	}
+	queueInspector, ok := inspector.(*asynq.Inspector)
+	return queueInspector, ok
+}
+
+// GetMessageInbox retrieves the inbox of buffered correlation messages from gin context
+func GetMessageInbox(c *gin.Context) (*inbox.Store, bool) {
+	store, exists := c.Get(messageInboxKey)
+	if !exists {
+		return nil, false
+	}
+	messageInbox, ok := store.(*inbox.Store)
+	return messageInbox, ok
+}
+
+// GetRecoveryScanner retrieves the scanner of orphaned executions from gin context
+func GetRecoveryScanner(c *gin.Context) (*recovery.Scanner, bool) {
+	scanner, exists := c.Get(recoveryScannerKey)
+	if !exists {
+		return nil, false
+	}
+	recoveryScanner, ok := scanner.(*recovery.Scanner)
+	return recoveryScanner, ok
+}
+
+// ErrorHandler is a middleware that recovers from panics and returns a problem+json response.
+// The panic value is logged with the request ID and never sent to the client.
 func ErrorHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		defer func() {
-			if err := recover(); err != nil {
-				c.JSON(500, gin.H{
-					"error":   "Internal Server Error",
-					"message": err,
-					"code":    500,
+			if recovered := recover(); recovered != nil {
+				requestID, _ := GetRequestID(c)
+				log.Printf("panic recovered (request %s): %v\n%s", requestID, recovered, debug.Stack())
+
+				if c.Writer.Written() {
+					c.Abort()
+					return
+				}
+				c.Header("Content-Type", models.ProblemContentType)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, models.ErrorResponse{
+					Type:      models.ProblemTypePrefix + models.CodeInternalError,
+					Title:     "Internal server error",
+					Status:    http.StatusInternalServerError,
+					Detail:    "An unexpected error occurred while processing the request",
+					Instance:  c.Request.URL.Path,
+					Code:      models.CodeInternalError,
+					RequestID: requestID,
 				})
-				c.Abort()
 			}
 		}()
 		c.Next()
@@ -153,7 +310,8 @@ func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Request-ID")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
 
 		if c.Request.Method == "OPTIONS" {
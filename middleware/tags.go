@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+)
+
+// ExecutionTagsMetadataKey is the execution metadata key holding the execution tags
+const ExecutionTagsMetadataKey = "tags"
+
+// ExecutionTagsOption is the ExecutionTaskPayload option carrying the tags of a queued execution
+const ExecutionTagsOption = "tags"
+
+type executionTagsContextKey struct{}
+
+// WithExecutionTags returns a context whose executions are saved with the given tags
+func WithExecutionTags(ctx context.Context, tags map[string]string) context.Context {
+	if len(tags) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, executionTagsContextKey{}, tags)
+}
+
+// ExecutionTagsFromContext returns the tags set with WithExecutionTags
+func ExecutionTagsFromContext(ctx context.Context) map[string]string {
+	tags, _ := ctx.Value(executionTagsContextKey{}).(map[string]string)
+	return tags
+}
+
+// ExecutionTagsFrom reads the tags stored in execution metadata
+func ExecutionTagsFrom(metadata map[string]interface{}) map[string]string {
+	raw, ok := metadata[ExecutionTagsMetadataKey].(map[string]interface{})
+	if !ok || len(raw) == 0 {
+		return nil
+	}
+	tags := make(map[string]string, len(raw))
+	for key, value := range raw {
+		if s, ok := value.(string); ok {
+			tags[key] = s
+		}
+	}
+	return tags
+}
+
+// taggingRepository stores the tags of an execution with every save of it. The upstream
+// manager does not persist execution metadata, so tags are added to the record on its way
+// to the repository: the tags saved with the execution before, which include changes made
+// through the tags endpoint while it runs, or else the tags carried by the context.
+type taggingRepository struct {
+	repositoryDecorator
+}
+
+func (r *taggingRepository) SaveExecution(ctx context.Context, record *repository.ExecutionRecord) error {
+	if _, ok := record.Metadata[ExecutionTagsMetadataKey]; !ok {
+		if tags, ok := r.storedTags(ctx, record.ExecutionID); ok {
+			record.Metadata = WithMetadata(record.Metadata, ExecutionTagsMetadataKey, tags)
+		} else if tags := ExecutionTagsFromContext(ctx); len(tags) > 0 {
+			stored := make(map[string]interface{}, len(tags))
+			for key, value := range tags {
+				stored[key] = value
+			}
+			record.Metadata = WithMetadata(record.Metadata, ExecutionTagsMetadataKey, stored)
+		}
+	}
+	return r.Repository.SaveExecution(ctx, record)
+}
+
+// storedTags returns the tags saved with an execution, if it was saved with tags
+func (r *taggingRepository) storedTags(ctx context.Context, executionID string) (interface{}, bool) {
+	stored, err := r.Repository.GetExecution(ctx, executionID)
+	if err != nil || stored == nil {
+		return nil, false
+	}
+	tags, ok := stored.Metadata[ExecutionTagsMetadataKey]
+	return tags, ok && tags != nil
+}
+
+// NewTaggingRepositoryManager returns a manager over the same repository that saves
+// executions with the tags carried by the context
+func NewTaggingRepositoryManager(manager *repository.Manager) *repository.Manager {
+	return decorateRepository(manager, func(inner repository.Repository) *taggingRepository {
+		return &taggingRepository{repositoryDecorator{inner}}
+	})
+}
+
+// taggingExecutionHandler puts the tags carried in the options of a queued execution on
+// the context before the wrapped handler starts it
+type taggingExecutionHandler struct {
+	queue.ExecutionHandler
+}
+
+func (h *taggingExecutionHandler) HandleExecution(ctx context.Context, payload *queue.ExecutionTaskPayload) error {
+	return h.ExecutionHandler.HandleExecution(h.withTags(ctx, payload), payload)
+}
+
+// HandleBatchExecution tags a task group with the tags of its first task. A group is
+// enqueued by a single batch or bulk run, so all its tasks carry the same tags.
+func (h *taggingExecutionHandler) HandleBatchExecution(ctx context.Context, payload *queue.BatchTaskPayload) error {
+	if len(payload.Tasks) > 0 {
+		var first queue.ExecutionTaskPayload
+		if err := json.Unmarshal(payload.Tasks[0], &first); err == nil {
+			ctx = h.withTags(ctx, &first)
+		}
+	}
+	return h.ExecutionHandler.HandleBatchExecution(ctx, payload)
+}
+
+func (h *taggingExecutionHandler) withTags(ctx context.Context, payload *queue.ExecutionTaskPayload) context.Context {
+	raw, ok := payload.Options[ExecutionTagsOption].(map[string]interface{})
+	if !ok {
+		return ctx
+	}
+	return WithExecutionTags(ctx, ExecutionTagsFrom(map[string]interface{}{ExecutionTagsMetadataKey: raw}))
+}
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+// contextRecordingHandler records the tags on the context of the tasks it handles
+type contextRecordingHandler struct {
+	queue.ExecutionHandler
+	tags []map[string]string
+}
+
+func (h *contextRecordingHandler) HandleExecution(ctx context.Context, _ *queue.ExecutionTaskPayload) error {
+	h.tags = append(h.tags, ExecutionTagsFromContext(ctx))
+	return nil
+}
+
+func (h *contextRecordingHandler) HandleBatchExecution(ctx context.Context, _ *queue.BatchTaskPayload) error {
+	h.tags = append(h.tags, ExecutionTagsFromContext(ctx))
+	return nil
+}
+
+// queuedPayload returns a payload as the worker decodes it from a task
+func queuedPayload(t *testing.T, payload *queue.ExecutionTaskPayload) *queue.ExecutionTaskPayload {
+	data, err := json.Marshal(payload)
+	assert.NoError(t, err)
+	var decoded queue.ExecutionTaskPayload
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	return &decoded
+}
+
+func TestTaggingExecutionHandler_TagsFromTaskOptions(t *testing.T) {
+	recorder := &contextRecordingHandler{}
+	handler := &taggingExecutionHandler{ExecutionHandler: recorder}
+
+	tagged := queuedPayload(t, &queue.ExecutionTaskPayload{
+		ExecutionName: "orders-0",
+		Options:       map[string]interface{}{ExecutionTagsOption: map[string]string{"customer": "acme"}},
+	})
+	assert.NoError(t, handler.HandleExecution(context.Background(), tagged))
+
+	// An execution sharing the name prefix without tags in its options stays untagged
+	untagged := queuedPayload(t, &queue.ExecutionTaskPayload{ExecutionName: "orders-1"})
+	assert.NoError(t, handler.HandleExecution(context.Background(), untagged))
+
+	assert.Equal(t, []map[string]string{{"customer": "acme"}, nil}, recorder.tags)
+}
+
+func TestTaggingExecutionHandler_TagsGroupsFromFirstTask(t *testing.T) {
+	recorder := &contextRecordingHandler{}
+	handler := &taggingExecutionHandler{ExecutionHandler: recorder}
+
+	first, err := json.Marshal(&queue.ExecutionTaskPayload{
+		ExecutionName: "orders-0",
+		Options:       map[string]interface{}{ExecutionTagsOption: map[string]string{"region": "eu"}},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, handler.HandleBatchExecution(context.Background(), &queue.BatchTaskPayload{
+		GroupID: "orders",
+		Tasks:   []json.RawMessage{first},
+	}))
+
+	assert.Equal(t, []map[string]string{{"region": "eu"}}, recorder.tags)
+}
+
+func TestWithMetadata_CopiesTheMetadata(t *testing.T) {
+	stored := map[string]interface{}{"owner": "billing", ExecutionTagsMetadataKey: map[string]interface{}{"region": "eu"}}
+
+	updated := WithMetadata(stored, ExecutionTagsMetadataKey, map[string]interface{}{"region": "us"})
+	assert.Equal(t, map[string]interface{}{"owner": "billing", ExecutionTagsMetadataKey: map[string]interface{}{"region": "us"}}, updated)
+	assert.Equal(t, map[string]interface{}{"region": "eu"}, stored[ExecutionTagsMetadataKey])
+
+	assert.Equal(t, map[string]interface{}{ExecutionTagsMetadataKey: map[string]interface{}{"region": "eu"}}, WithMetadata(stored, "owner", nil))
+	assert.Equal(t, "billing", stored["owner"])
+	assert.Equal(t, map[string]interface{}{"owner": "ops"}, WithMetadata(nil, "owner", "ops"))
+}
+
+func TestTaggingRepository_KeepsTagsChangedWhileRunning(t *testing.T) {
+	repo := newMemoryRepository(nil)
+	manager := NewTaggingRepositoryManager(repository.NewManagerWithRepository(repo))
+	ctx := WithExecutionTags(context.Background(), map[string]string{"customer": "acme"})
+	// The upstream manager saves execution records without metadata, replacing the stored one
+	save := func(ctx context.Context, executionID string) {
+		assert.NoError(t, manager.GetRepository().SaveExecution(ctx, &repository.ExecutionRecord{ExecutionID: executionID, StateMachineID: "orders", Name: executionID}))
+	}
+	patch := func(executionID string, tags map[string]interface{}) {
+		record, err := repo.GetExecution(context.Background(), executionID)
+		assert.NoError(t, err)
+		record.Metadata = WithMetadata(record.Metadata, ExecutionTagsMetadataKey, tags)
+		assert.NoError(t, repo.SaveExecution(context.Background(), record))
+	}
+	tags := func(executionID string) map[string]string {
+		record, err := repo.GetExecution(context.Background(), executionID)
+		assert.NoError(t, err)
+		return ExecutionTagsFrom(record.Metadata)
+	}
+
+	// The first save stores the tags of the context
+	save(ctx, "exec-1")
+	assert.Equal(t, map[string]string{"customer": "acme"}, tags("exec-1"))
+
+	// Tags changed or removed while the execution runs survive its next saves
+	patch("exec-1", map[string]interface{}{"customer": "globex", "region": "eu"})
+	save(ctx, "exec-1")
+	assert.Equal(t, map[string]string{"customer": "globex", "region": "eu"}, tags("exec-1"))
+	patch("exec-1", map[string]interface{}{})
+	save(ctx, "exec-1")
+	assert.Nil(t, tags("exec-1"))
+
+	// So do the tags added to an execution started without any
+	save(context.Background(), "exec-2")
+	assert.Nil(t, tags("exec-2"))
+	patch("exec-2", map[string]interface{}{"region": "us"})
+	save(context.Background(), "exec-2")
+	assert.Equal(t, map[string]string{"region": "us"}, tags("exec-2"))
+}
@@ -0,0 +1,232 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/errors"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/statemachine/persistent"
+)
+
+// WaitTimeoutsKey is the state machine metadata key holding its wait timeouts
+const WaitTimeoutsKey = "waitTimeouts"
+
+// MaxWaitTimeoutSeconds is the longest wait timeout that can be configured (one year)
+const MaxWaitTimeoutSeconds = 365 * 24 * 60 * 60
+
+// WaitTimeouts bounds how long executions stay paused in the Message states of a state
+// machine. A state waits for its entry in States, or DefaultSeconds; zero waits forever.
+// Message states declaring their own TimeoutSeconds keep it.
+type WaitTimeouts struct {
+	DefaultSeconds int            `json:"defaultSeconds,omitempty"`
+	States         map[string]int `json:"states,omitempty"`
+}
+
+// WaitTimeoutsFrom reads the wait timeouts stored in state machine metadata. It returns
+// nil when none are configured.
+func WaitTimeoutsFrom(metadata map[string]interface{}) (*WaitTimeouts, error) {
+	raw, ok := metadata[WaitTimeoutsKey]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var timeouts WaitTimeouts
+	if err := json.Unmarshal(data, &timeouts); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", WaitTimeoutsKey, err)
+	}
+	return &timeouts, nil
+}
+
+// For returns how long an execution waits in a state before it times out, or 0
+func (t *WaitTimeouts) For(stateName string) time.Duration {
+	if t == nil {
+		return 0
+	}
+	if seconds, ok := t.States[stateName]; ok {
+		return time.Duration(seconds) * time.Second
+	}
+	return time.Duration(t.DefaultSeconds) * time.Second
+}
+
+// MessageState is the part of a Message state definition that decides how it times out
+type MessageState struct {
+	TimeoutSeconds int             `json:"TimeoutSeconds,omitempty"`
+	TimeoutPath    string          `json:"TimeoutPath,omitempty"`
+	Catch          json.RawMessage `json:"Catch,omitempty"`
+}
+
+// MessageStates returns the Message states of a state machine definition by name
+func MessageStates(definition string) (map[string]MessageState, error) {
+	var parsed struct {
+		States map[string]struct {
+			Type string `json:"Type"`
+			MessageState
+		} `json:"States"`
+	}
+	if err := json.Unmarshal([]byte(definition), &parsed); err != nil {
+		return nil, fmt.Errorf("invalid definition: %w", err)
+	}
+	states := make(map[string]MessageState)
+	for name, state := range parsed.States {
+		if state.Type == "Message" {
+			states[name] = state.MessageState
+		}
+	}
+	return states, nil
+}
+
+// correlationID is the ID the Message state saves its correlation under before pausing the
+// execution
+func correlationID(record *repository.ExecutionRecord) string {
+	return "corr-" + record.ExecutionID + "-" + record.CurrentState
+}
+
+// TimeoutScheduler schedules the timeout tasks of waiting executions
+type TimeoutScheduler interface {
+	ScheduleTimeout(payload *queue.TimeoutTaskPayload, delay time.Duration) (*asynq.TaskInfo, error)
+}
+
+// waitTimeoutRepository schedules the configured wait timeout of executions pausing in a
+// Message state without a timeout of its own. The task is the one the Message state would
+// schedule, so resuming the execution cancels it.
+type waitTimeoutRepository struct {
+	repositoryDecorator
+	messages  repository.MessageRepository
+	scheduler TimeoutScheduler
+	now       func() time.Time
+}
+
+func (r *waitTimeoutRepository) SaveExecution(ctx context.Context, record *repository.ExecutionRecord) error {
+	if err := r.Repository.SaveExecution(ctx, record); err != nil {
+		return err
+	}
+	if record.Status == persistent.PAUSED {
+		r.schedule(ctx, record)
+	}
+	return nil
+}
+
+// schedule starts the wait timeout of a paused execution and records when it expires on
+// its correlation. Failures are logged: the execution then waits without a timeout.
+func (r *waitTimeoutRepository) schedule(ctx context.Context, record *repository.ExecutionRecord) {
+	correlation, err := r.messages.GetMessageCorrelation(ctx, correlationID(record))
+	if err != nil || correlation.Status != "WAITING" || correlation.TimeoutAt != nil {
+		return
+	}
+	stateMachine, err := r.Repository.GetStateMachine(ctx, record.StateMachineID)
+	if err != nil {
+		log.Printf("Failed to load wait timeouts for execution %s: %v", record.ExecutionID, err)
+		return
+	}
+	timeouts, err := WaitTimeoutsFrom(stateMachine.Metadata)
+	if err != nil {
+		log.Printf("Failed to load wait timeouts for execution %s: %v", record.ExecutionID, err)
+		return
+	}
+	timeout := timeouts.For(record.CurrentState)
+	if timeout <= 0 {
+		return
+	}
+
+	now := r.now()
+	if _, err := r.scheduler.ScheduleTimeout(&queue.TimeoutTaskPayload{
+		ExecutionID:    record.ExecutionID,
+		StateMachineID: record.StateMachineID,
+		StateName:      record.CurrentState,
+		CorrelationID:  correlation.ID,
+		TimeoutSeconds: int(timeout / time.Second),
+		ScheduledAt:    now.Unix(),
+	}, timeout); err != nil {
+		log.Printf("Failed to schedule the wait timeout of execution %s: %v", record.ExecutionID, err)
+		return
+	}
+	timeoutAt := now.Add(timeout).Unix()
+	correlation.TimeoutAt = &timeoutAt
+	if err := r.messages.SaveMessageCorrelation(ctx, correlation); err != nil {
+		log.Printf("Failed to record the wait timeout of execution %s: %v", record.ExecutionID, err)
+	}
+}
+
+// NewWaitTimeoutRepositoryManager returns a manager over the same repository that schedules
+// the wait timeouts configured in state machine metadata on scheduler when executions pause
+// in a Message state. Repositories without message correlation have no Message states and
+// are returned as they are.
+func NewWaitTimeoutRepositoryManager(manager *repository.Manager, scheduler TimeoutScheduler) *repository.Manager {
+	correlations, ok := manager.GetRepository().(repository.MessageRepository)
+	if !ok {
+		return manager
+	}
+
+	return decorateRepository(manager, func(inner repository.Repository) *waitTimeoutRepository {
+		return &waitTimeoutRepository{
+			repositoryDecorator: repositoryDecorator{inner},
+			messages:            correlations,
+			scheduler:           scheduler,
+			now:                 time.Now,
+		}
+	})
+}
+
+// timeoutExecutionHandler handles the timeouts of waiting executions. An execution whose
+// Message state has a TimeoutPath or Catch is resumed along it by the wrapped handler;
+// any other execution fails with States.Timeout. Timeouts of executions that were resumed
+// in the meantime are dropped.
+type timeoutExecutionHandler struct {
+	queue.ExecutionHandler
+	repositoryManager *repository.Manager
+	now               func() time.Time
+}
+
+func (h *timeoutExecutionHandler) HandleTimeout(ctx context.Context, payload *queue.TimeoutTaskPayload) error {
+	correlation, err := h.repositoryManager.GetMessageCorrelation(ctx, payload.CorrelationID)
+	if err != nil {
+		return fmt.Errorf("failed to get correlation record: %w", err)
+	}
+	record, err := h.repositoryManager.GetExecution(ctx, payload.ExecutionID)
+	if err != nil {
+		return fmt.Errorf("failed to get execution: %w", err)
+	}
+	if correlation.Status != "WAITING" || record.Status != persistent.PAUSED || record.CurrentState != payload.StateName {
+		log.Printf("Execution %s is no longer waiting in %s, skipping timeout", payload.ExecutionID, payload.StateName)
+		return nil
+	}
+
+	stateMachine, err := h.repositoryManager.GetStateMachine(ctx, payload.StateMachineID)
+	if err != nil {
+		return fmt.Errorf("failed to load state machine: %w", err)
+	}
+	states, err := MessageStates(stateMachine.Definition)
+	if err != nil {
+		return err
+	}
+	if state := states[payload.StateName]; state.TimeoutPath != "" || len(state.Catch) > 0 {
+		return h.ExecutionHandler.HandleTimeout(ctx, payload)
+	}
+	return h.fail(ctx, record, payload)
+}
+
+// fail ends a timed out execution with States.Timeout
+func (h *timeoutExecutionHandler) fail(ctx context.Context, record *repository.ExecutionRecord, payload *queue.TimeoutTaskPayload) error {
+	if err := h.repositoryManager.UpdateCorrelationStatus(ctx, payload.CorrelationID, "TIMEOUT"); err != nil {
+		return fmt.Errorf("failed to update correlation status: %w", err)
+	}
+	now := h.now()
+	failed := *record
+	failed.Status = persistent.FAILED
+	failed.Error = errors.NewTimeoutError(fmt.Sprintf("message state '%s' timed out after %d seconds", payload.StateName, payload.TimeoutSeconds), nil).Error()
+	failed.EndTime = &now
+	if err := h.repositoryManager.GetRepository().SaveExecution(ctx, &failed); err != nil {
+		return fmt.Errorf("failed to save timed out execution: %w", err)
+	}
+	log.Printf("Execution %s timed out waiting in %s", record.ExecutionID, payload.StateName)
+	return nil
+}
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+const paymentDefinition = `{
+	"StartAt": "WaitForPayment",
+	"States": {
+		"WaitForPayment": {"Type": "Message", "CorrelationKey": "orderId", "Next": "Done"},
+		"WaitForShipping": {
+			"Type": "Message", "CorrelationKey": "orderId", "Next": "Done",
+			"Catch": [{"ErrorEquals": ["States.Timeout"], "Next": "Done"}]
+		},
+		"Done": {"Type": "Succeed"}
+	}
+}`
+
+// timeoutRecordingHandler records the timeouts handed to it
+type timeoutRecordingHandler struct {
+	queue.ExecutionHandler
+	timeouts []*queue.TimeoutTaskPayload
+}
+
+func (h *timeoutRecordingHandler) HandleTimeout(_ context.Context, payload *queue.TimeoutTaskPayload) error {
+	h.timeouts = append(h.timeouts, payload)
+	return nil
+}
+
+func TestWaitTimeoutRepository_SchedulesConfiguredTimeout(t *testing.T) {
+	repo := newMemoryMessageRepository(map[string]string{"orders": paymentDefinition})
+	repo.stateMachines["orders"].Metadata = map[string]interface{}{
+		WaitTimeoutsKey: map[string]interface{}{"defaultSeconds": 3600, "states": map[string]interface{}{"WaitForShipping": 0}},
+	}
+	q := &recordingQueue{}
+	now := time.Unix(1700000000, 0)
+	timeouts := &waitTimeoutRepository{repositoryDecorator: repositoryDecorator{repo}, messages: repo, scheduler: q, now: func() time.Time { return now }}
+	ctx := context.Background()
+
+	// The timeout is scheduled once, and recorded on the correlation
+	record := repo.pause("exec-1", "WaitForPayment", float64(1001))
+	assert.NoError(t, timeouts.SaveExecution(ctx, record))
+	assert.NoError(t, timeouts.SaveExecution(ctx, record))
+	if assert.Len(t, q.timeouts, 1) {
+		assert.Equal(t, &queue.TimeoutTaskPayload{
+			ExecutionID:    "exec-1",
+			StateMachineID: "orders",
+			StateName:      "WaitForPayment",
+			CorrelationID:  correlationID(record),
+			TimeoutSeconds: 3600,
+			ScheduledAt:    now.Unix(),
+		}, q.timeouts[0])
+	}
+	correlation, err := repo.GetMessageCorrelation(ctx, correlationID(record))
+	if assert.NoError(t, err) && assert.NotNil(t, correlation.TimeoutAt) {
+		assert.Equal(t, now.Add(time.Hour).Unix(), *correlation.TimeoutAt)
+	}
+
+	// A state configured with no timeout waits forever
+	assert.NoError(t, timeouts.SaveExecution(ctx, repo.pause("exec-2", "WaitForShipping", float64(1002))))
+	assert.Len(t, q.timeouts, 1)
+
+	// A Message state that scheduled a timeout of its own keeps it
+	record = repo.pause("exec-3", "WaitForPayment", float64(1003))
+	timeoutAt := now.Add(time.Minute).Unix()
+	repo.correlations[correlationID(record)].TimeoutAt = &timeoutAt
+	assert.NoError(t, timeouts.SaveExecution(ctx, record))
+	assert.Len(t, q.timeouts, 1)
+}
+
+func TestTimeoutExecutionHandler_FailsOrResumesWaitingExecutions(t *testing.T) {
+	repo := newMemoryMessageRepository(map[string]string{"orders": paymentDefinition})
+	manager := repository.NewManagerWithRepository(repo)
+	recorder := &timeoutRecordingHandler{}
+	now := time.Unix(1700000000, 0)
+	h := &timeoutExecutionHandler{ExecutionHandler: recorder, repositoryManager: manager, now: func() time.Time { return now }}
+	ctx := context.Background()
+
+	timeout := func(record *repository.ExecutionRecord) *queue.TimeoutTaskPayload {
+		return &queue.TimeoutTaskPayload{
+			ExecutionID:    record.ExecutionID,
+			StateMachineID: "orders",
+			StateName:      record.CurrentState,
+			CorrelationID:  correlationID(record),
+			TimeoutSeconds: 60,
+		}
+	}
+
+	// A Message state without TimeoutPath or Catch fails the execution
+	failing := repo.pause("exec-1", "WaitForPayment", float64(1001))
+	assert.NoError(t, repo.SaveExecution(ctx, failing))
+	assert.NoError(t, h.HandleTimeout(ctx, timeout(failing)))
+	failed, err := repo.GetExecution(ctx, "exec-1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "FAILED", failed.Status)
+		assert.Equal(t, "States.Timeout: message state 'WaitForPayment' timed out after 60 seconds", failed.Error)
+		assert.Equal(t, &now, failed.EndTime)
+	}
+	assert.Equal(t, "TIMEOUT", repo.correlations[correlationID(failing)].Status)
+	assert.Empty(t, recorder.timeouts)
+
+	// A Message state with a Catch is resumed along it
+	catching := repo.pause("exec-2", "WaitForShipping", float64(1002))
+	assert.NoError(t, repo.SaveExecution(ctx, catching))
+	assert.NoError(t, h.HandleTimeout(ctx, timeout(catching)))
+	if assert.Len(t, recorder.timeouts, 1) {
+		assert.Equal(t, "exec-2", recorder.timeouts[0].ExecutionID)
+	}
+
+	// Executions resumed in the meantime are left alone
+	resumed := repo.pause("exec-3", "WaitForPayment", float64(1003))
+	assert.NoError(t, repo.SaveExecution(ctx, resumed))
+	assert.NoError(t, repo.UpdateCorrelationStatus(ctx, correlationID(resumed), "RECEIVED"))
+	assert.NoError(t, h.HandleTimeout(ctx, timeout(resumed)))
+	moved := repo.pause("exec-4", "WaitForPayment", float64(1004))
+	moved.CurrentState = "Done"
+	assert.NoError(t, repo.SaveExecution(ctx, moved))
+	assert.NoError(t, h.HandleTimeout(ctx, &queue.TimeoutTaskPayload{
+		ExecutionID: "exec-4", StateMachineID: "orders", StateName: "WaitForPayment", CorrelationID: "corr-exec-4-WaitForPayment",
+	}))
+	for _, id := range []string{"exec-3", "exec-4"} {
+		record, err := repo.GetExecution(ctx, id)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "PAUSED", record.Status)
+		}
+	}
+	assert.Len(t, recorder.timeouts, 1)
+}
@@ -5,8 +5,15 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/hussainpithawala/state-machine-amz-gin/activities"
+	"github.com/hussainpithawala/state-machine-amz-gin/httptask"
+	"github.com/hussainpithawala/state-machine-amz-gin/inbox"
+	"github.com/hussainpithawala/state-machine-amz-gin/recovery"
+	"github.com/hussainpithawala/state-machine-amz-gin/schedules"
 	"github.com/hussainpithawala/state-machine-amz-go/pkg/batch"
 	"github.com/hussainpithawala/state-machine-amz-go/pkg/executor"
 	"github.com/hussainpithawala/state-machine-amz-go/pkg/handler"
@@ -26,13 +33,30 @@ type WorkerConfig struct {
 	BulkOrchestrator  *batch.Orchestrator
 	EnableWorker      bool // Flag to enable/disable worker
 	RedisClient       *redis.Client
+	EnableScheduler   bool              // Fire schedules from this worker; workers sharing a Redis elect one to fire
+	Recovery          *recovery.Scanner // Optional: Scanner of orphaned executions, run while the worker runs; the worker runs the executions it recovers
 }
 
+// Worker run states reported through State
+const (
+	WorkerStateCreated = "created"
+	WorkerStateRunning = "running"
+	WorkerStateStopped = "stopped"
+	WorkerStateFailed  = "failed"
+)
+
 // Worker represents a background worker that consumes from Redis queue
 type Worker struct {
-	queueWorker *queue.Worker
-	ctx         context.Context
-	cancel      context.CancelFunc
+	queueWorker  *queue.Worker
+	scheduler    *schedules.Scheduler
+	recovery     *recovery.Scanner
+	ctx          context.Context
+	cancel       context.CancelFunc
+	backgroundWG sync.WaitGroup
+
+	mu    sync.RWMutex
+	state string
+	err   error
 }
 
 // NewWorker creates a new background worker instance
@@ -71,8 +95,11 @@ func NewWorker(config *WorkerConfig) (*Worker, error) {
 		return nil, nil
 	}
 
-	// Create execution context adapter
-	execAdapter := executor.NewExecutionContextAdapter(config.BaseExecutor)
+	httptask.Register(config.BaseExecutor)
+
+	// Create execution context adapter. With Redis, Task states can also run on activities.
+	activityStore := activities.NewStore(config.RedisClient)
+	var execAdapter types.ExecutionContext = activities.NewExecutionContext(executor.NewExecutionContextAdapter(config.BaseExecutor), activityStore)
 
 	queueClient, _ := queue.NewClient(config.QueueConfig)
 
@@ -90,16 +117,49 @@ func NewWorker(config *WorkerConfig) (*Worker, error) {
 		}
 	}
 
-	// Create execution handler with executor
+	// Create execution handler with executor. Executions are saved with the tags of their
+	// task; when they pause in a Message state their wait timeout is scheduled and they
+	// receive the messages buffered for them. Executions parking on a callback task are
+	// saved paused.
+	waitTimeoutRepositoryManager := NewWaitTimeoutRepositoryManager(config.RepositoryManager, config.QueueClient)
+	inboxRepositoryManager := NewInboxRepositoryManager(waitTimeoutRepositoryManager, inbox.NewStore(config.RedisClient), config.QueueClient, config.QueueConfig)
+	taggedRepositoryManager := NewTaggingRepositoryManager(inboxRepositoryManager)
+	parkingRepositoryManager := NewParkingRepositoryManager(taggedRepositoryManager, activityStore, config.QueueClient, config.QueueConfig)
 	newExecutionHandlerWithContext := handler.NewExecutionHandlerWithContext(
-		config.RepositoryManager,
+		parkingRepositoryManager,
 		queueClient,
 		execAdapter,
 		config.BulkOrchestrator,
 	)
 
-	// Create queue worker with handler
-	queueWorker, err := queue.NewWorker(config.QueueConfig, newExecutionHandlerWithContext)
+	// Create queue worker with handler. Every execution runs on its own parking context.
+	// Resume tasks queued by the message and task token endpoints, the executions queued
+	// by the recovery scanner and the timeouts of waiting executions are handled before
+	// the tasks reach the execution handler.
+	queueWorker, err := queue.NewWorker(config.QueueConfig, &parkingExecutionHandler{
+		ExecutionHandler: &resumingExecutionHandler{
+			ExecutionHandler: &recoveringExecutionHandler{
+				ExecutionHandler: &timeoutExecutionHandler{
+					ExecutionHandler: &taggingExecutionHandler{
+						ExecutionHandler: newExecutionHandlerWithContext,
+					},
+					repositoryManager: parkingRepositoryManager,
+					now:               time.Now,
+				},
+				repositoryManager: parkingRepositoryManager,
+				store:             recovery.NewStore(config.RedisClient),
+				queueClient:       queueClient,
+				executionContext:  execAdapter,
+			},
+			repositoryManager: parkingRepositoryManager,
+			store:             activityStore,
+			queueClient:       queueClient,
+			executionContext:  execAdapter,
+		},
+		store:       activityStore,
+		enqueuer:    config.QueueClient,
+		queueConfig: config.QueueConfig,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -108,11 +168,47 @@ func NewWorker(config *WorkerConfig) (*Worker, error) {
 
 	ctx = context.WithValue(ctx, types.ExecutionContextKey, execAdapter)
 
-	return &Worker{
+	worker := &Worker{
 		queueWorker: queueWorker,
 		ctx:         ctx,
 		cancel:      cancel,
-	}, nil
+		state:       WorkerStateCreated,
+		recovery:    config.Recovery,
+	}
+	if config.EnableScheduler {
+		worker.scheduler = schedules.NewScheduler(config.RedisClient, config.RepositoryManager, config.QueueClient)
+		if config.QueueConfig != nil && config.QueueConfig.RetryPolicy != nil {
+			worker.scheduler.RetryPolicy = config.QueueConfig.RetryPolicy
+		}
+	}
+	return worker, nil
+}
+
+// State returns the current run state of the worker
+func (w *Worker) State() string {
+	if w == nil {
+		return WorkerStateStopped
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.state
+}
+
+// Err returns the error that caused the worker to fail, if any
+func (w *Worker) Err() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.err
+}
+
+func (w *Worker) setState(state string, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.state = state
+	w.err = err
 }
 
 // Start starts the worker in a separate goroutine
@@ -123,13 +219,34 @@ func (w *Worker) Start() error {
 
 	log.Println("Starting background worker to consume from Redis queue...")
 
+	w.setState(WorkerStateRunning, nil)
+
 	// Start worker in goroutine
 	go func() {
 		if err := w.queueWorker.Run(); err != nil {
 			log.Printf("Worker error: %v", err)
+			w.setState(WorkerStateFailed, err)
 		}
 	}()
 
+	if w.scheduler != nil {
+		w.backgroundWG.Add(1)
+		go func() {
+			defer w.backgroundWG.Done()
+			w.scheduler.Run(w.ctx)
+		}()
+		log.Println("Scheduler started")
+	}
+
+	if w.recovery != nil {
+		w.backgroundWG.Add(1)
+		go func() {
+			defer w.backgroundWG.Done()
+			w.recovery.Run(w.ctx)
+		}()
+		log.Println("Recovery scanner started")
+	}
+
 	log.Println("Background worker started successfully")
 	return nil
 }
@@ -142,7 +259,9 @@ func (w *Worker) Stop() {
 
 	log.Println("Stopping background worker...")
 	w.cancel()
+	w.backgroundWG.Wait()
 	w.queueWorker.Shutdown()
+	w.setState(WorkerStateStopped, nil)
 	log.Println("Background worker stopped successfully")
 }
 
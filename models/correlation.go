@@ -0,0 +1,90 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Types of correlation values, as named by the correlationValueType query parameter
+const (
+	CorrelationValueString  = "string"
+	CorrelationValueNumber  = "number"
+	CorrelationValueBoolean = "boolean"
+)
+
+// CorrelationValue is the value of a correlation key: a string, number or boolean. Values
+// keep their JSON type, so "12345" and 12345 do not match each other.
+type CorrelationValue struct {
+	value interface{}
+}
+
+// ParseCorrelationValue reads a correlation value from a query string as valueType, one
+// of the CorrelationValue* types. An empty type reads a string.
+func ParseCorrelationValue(raw, valueType string) (CorrelationValue, error) {
+	switch valueType {
+	case "", CorrelationValueString:
+		return CorrelationValue{value: raw}, nil
+	case CorrelationValueNumber:
+		value, err := decodeScalar([]byte(raw))
+		if _, ok := value.(json.Number); err != nil || !ok {
+			return CorrelationValue{}, fmt.Errorf("%q is not a number", raw)
+		}
+		return CorrelationValue{value: value}, nil
+	case CorrelationValueBoolean:
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return CorrelationValue{}, fmt.Errorf("%q is not a boolean", raw)
+		}
+		return CorrelationValue{value: value}, nil
+	}
+	return CorrelationValue{}, fmt.Errorf("unknown correlation value type %q", valueType)
+}
+
+// NewCorrelationValue returns a correlation value holding value, a string, json.Number,
+// float64 or bool
+func NewCorrelationValue(value interface{}) CorrelationValue {
+	return CorrelationValue{value: value}
+}
+
+// Value returns the value as a string, json.Number or bool, or nil when it is not set
+func (v CorrelationValue) Value() interface{} {
+	return v.value
+}
+
+// MarshalJSON writes the value with its JSON type
+func (v CorrelationValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.value)
+}
+
+// UnmarshalJSON reads a string, number or boolean. Null leaves the value unset.
+func (v *CorrelationValue) UnmarshalJSON(data []byte) error {
+	value, err := decodeScalar(data)
+	if err != nil {
+		return err
+	}
+	switch value.(type) {
+	case nil:
+		return nil
+	case string, json.Number, bool:
+		v.value = value
+		return nil
+	}
+	return errors.New("correlation value must be a string, number or boolean")
+}
+
+// decodeScalar decodes a single JSON value, keeping numbers as json.Number
+func decodeScalar(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("correlation value must be a single JSON value")
+	}
+	return value, nil
+}
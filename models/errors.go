@@ -0,0 +1,61 @@
+package models
+
+// ProblemContentType is the media type of ErrorResponse documents
+const ProblemContentType = "application/problem+json"
+
+// ProblemTypePrefix prefixes the error code to form the problem "type" URI
+const ProblemTypePrefix = "urn:state-machine:problem:"
+
+// Stable error codes returned in ErrorResponse.Code. Clients should branch on
+// these rather than on titles or HTTP status codes.
+const (
+	// Request problems (4xx)
+	CodeInvalidRequest             = "INVALID_REQUEST"
+	CodeValidationFailed           = "VALIDATION_FAILED"
+	CodeInvalidSchema              = "INVALID_SCHEMA"
+	CodeInvalidDefinition          = "INVALID_DEFINITION"
+	CodeNotFound                   = "NOT_FOUND"
+	CodeStateMachineNotFound       = "STATE_MACHINE_NOT_FOUND"
+	CodeExecutionNotFound          = "EXECUTION_NOT_FOUND"
+	CodeNoWaitingExecutions        = "NO_WAITING_EXECUTIONS"
+	CodeExecutionNotPaused         = "EXECUTION_NOT_PAUSED"
+	CodeExecutionNotRunning        = "EXECUTION_NOT_RUNNING"
+	CodeTooManyMatches             = "TOO_MANY_MATCHES"
+	CodeDatasetNotFound            = "DATASET_NOT_FOUND"
+	CodeDatasetNotReady            = "DATASET_NOT_READY"
+	CodeDatasetCompleted           = "DATASET_COMPLETED"
+	CodeNoFailedExecutions         = "NO_FAILED_EXECUTIONS"
+	CodeScheduleNotFound           = "SCHEDULE_NOT_FOUND"
+	CodeScheduledExecutionNotFound = "SCHEDULED_EXECUTION_NOT_FOUND"
+	CodeExecutionNotScheduled      = "EXECUTION_NOT_SCHEDULED"
+	CodeDuplicateTask              = "DUPLICATE_TASK"
+	CodeActivityNotFound           = "ACTIVITY_NOT_FOUND"
+	CodeActivityExists             = "ACTIVITY_EXISTS"
+	CodeActivityTaskNotFound       = "ACTIVITY_TASK_NOT_FOUND"
+	CodeActivityTaskNotRunning     = "ACTIVITY_TASK_NOT_RUNNING"
+	CodeTaskNotFound               = "TASK_NOT_FOUND"
+	CodeTaskNotRunning             = "TASK_NOT_RUNNING"
+	CodeMessageNotFound            = "MESSAGE_NOT_FOUND"
+	CodeMessageBeingDelivered      = "MESSAGE_BEING_DELIVERED"
+	CodeRecoveryScanInProgress     = "RECOVERY_SCAN_IN_PROGRESS"
+	CodeSearchScanLimitExceeded    = "SEARCH_SCAN_LIMIT_EXCEEDED"
+
+	// Server problems (5xx)
+	CodeInternalError             = "INTERNAL_ERROR"
+	CodeRepositoryUnavailable     = "REPOSITORY_UNAVAILABLE"
+	CodeQueueUnavailable          = "QUEUE_UNAVAILABLE"
+	CodeRedisUnavailable          = "REDIS_UNAVAILABLE"
+	CodeExecutionFailed           = "EXECUTION_FAILED"
+	CodeOrchestratorFailed        = "ORCHESTRATOR_FAILED"
+	CodeResumeTimedOut            = "RESUME_TIMED_OUT"
+	CodeRepositoryNotConfigured   = "REPOSITORY_NOT_CONFIGURED"
+	CodeQueueNotConfigured        = "QUEUE_NOT_CONFIGURED"
+	CodeRedisNotConfigured        = "REDIS_NOT_CONFIGURED"
+	CodeExecutorNotConfigured     = "EXECUTOR_NOT_CONFIGURED"
+	CodeOrchestratorNotConfigured = "ORCHESTRATOR_NOT_CONFIGURED"
+	CodeTransformerNotConfigured  = "TRANSFORMER_REGISTRY_NOT_CONFIGURED"
+	CodeSearchNotSupported        = "SEARCH_NOT_SUPPORTED"
+	CodeDatasetStoreUnavailable   = "DATASET_STORE_UNAVAILABLE"
+	CodeDatasetStoreNotConfigured = "DATASET_STORE_NOT_CONFIGURED"
+	CodeRecoveryNotConfigured     = "RECOVERY_NOT_CONFIGURED"
+)
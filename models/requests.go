@@ -1,14 +1,56 @@
 package models
 
+import "time"
+
 // CreateStateMachineRequest represents a request to create a new state machine
 type CreateStateMachineRequest struct {
-	ID          string                 `json:"id" binding:"required"`
-	Name        string                 `json:"name" binding:"required"`
-	Description string                 `json:"description"`
-	Definition  interface{}            `json:"definition" binding:"required"`
-	Type        string                 `json:"type"`
-	Version     string                 `json:"version"`
-	Metadata    map[string]interface{} `json:"metadata"`
+	ID           string                 `json:"id" binding:"required"`
+	Name         string                 `json:"name" binding:"required"`
+	Description  string                 `json:"description"`
+	Definition   interface{}            `json:"definition" binding:"required"`
+	Type         string                 `json:"type"`
+	Version      string                 `json:"version"`
+	Metadata     map[string]interface{} `json:"metadata"`
+	InputSchema  interface{}            `json:"inputSchema,omitempty"`  // Optional: JSON Schema every execution input must match
+	OutputSchema interface{}            `json:"outputSchema,omitempty"` // Optional: JSON Schema every resume output must match
+}
+
+// UpdateStateMachineSchemasRequest replaces the input/output JSON Schemas of a state machine.
+// Omitted or null schemas are removed.
+type UpdateStateMachineSchemasRequest struct {
+	InputSchema  interface{} `json:"inputSchema"`
+	OutputSchema interface{} `json:"outputSchema"`
+}
+
+// SearchAttribute declares a field of execution input or output that can be searched with attr=name:value
+type SearchAttribute struct {
+	Name   string `json:"name" binding:"required"`
+	Source string `json:"source,omitempty" binding:"omitempty,oneof=input output"` // Optional: "input" (default) or "output"
+	Path   string `json:"path" binding:"required"`                                 // JSONPath of the field, e.g. $.orderId
+}
+
+// UpdateSearchAttributesRequest replaces the search attributes of a state machine.
+// An empty list removes them.
+type UpdateSearchAttributesRequest struct {
+	Attributes []SearchAttribute `json:"attributes" binding:"dive"`
+}
+
+// UpdateWaitTimeoutsRequest replaces the wait timeouts of a state machine: how long its
+// executions stay paused in a Message state before they time out. States maps Message
+// state names to their timeout; other Message states use DefaultSeconds. Omitted or zero
+// values wait forever.
+type UpdateWaitTimeoutsRequest struct {
+	DefaultSeconds int            `json:"defaultSeconds,omitempty" binding:"min=0,max=31536000"`
+	States         map[string]int `json:"states,omitempty" binding:"dive,min=1,max=31536000"`
+}
+
+// RecoverySettings override the recovery scanner defaults for one state machine. Omitted
+// fields keep the default.
+type RecoverySettings struct {
+	Enabled                  *bool  `json:"enabled,omitempty"`                                                  // Whether the scanner recovers orphaned executions on its own
+	OrphanedThresholdSeconds int    `json:"orphanedThresholdSeconds,omitempty" binding:"min=0,max=31536000"`    // How long an execution runs before it is considered orphaned
+	Strategy                 string `json:"strategy,omitempty" binding:"omitempty,oneof=RETRY SKIP FAIL PAUSE"` // How orphaned executions are recovered
+	MaxRecoveryAttempts      int    `json:"maxRecoveryAttempts,omitempty" binding:"min=0,max=100"`              // Attempts before an execution is marked FAILED
 }
 
 // UpdateStateMachineRequest represents a request to update a state machine
@@ -22,11 +64,14 @@ type UpdateStateMachineRequest struct {
 
 // StartExecutionRequest represents a request to start an execution
 type StartExecutionRequest struct {
-	Name                   string      `json:"name" binding:"required"`
-	Input                  interface{} `json:"input"`
-	SourceExecutionID      string      `json:"sourceExecutionId,omitempty"`      // ID of execution whose output will be used as input
-	SourceStateName        string      `json:"sourceStateName,omitempty"`        // Optional: specific state's output to use from source execution
-	SourceInputTransformer string      `json:"sourceInputTransformer,omitempty"` // Optional: JSONPath or transformation expression to apply
+	Name                   string            `json:"name" binding:"required"`
+	Input                  interface{}       `json:"input"`
+	SourceExecutionID      string            `json:"sourceExecutionId,omitempty"`            // ID of execution whose output will be used as input
+	SourceStateName        string            `json:"sourceStateName,omitempty"`              // Optional: specific state's output to use from source execution
+	SourceInputTransformer string            `json:"sourceInputTransformer,omitempty"`       // Optional: JSONPath or transformation expression to apply
+	Tags                   map[string]string `json:"tags,omitempty"`                         // Optional: key/value labels stored with the execution
+	StartAt                *time.Time        `json:"startAt,omitempty"`                      // Optional: queue the execution to start at this time (RFC 3339)
+	DelaySeconds           int               `json:"delaySeconds,omitempty" binding:"min=0"` // Optional: queue the execution to start after this delay
 }
 
 // ResumeExecutionRequest represents a request to resume a paused execution
@@ -36,9 +81,13 @@ type ResumeExecutionRequest struct {
 
 // ResumeByCorrelationRequest represents a request to resume executions by correlation
 type ResumeByCorrelationRequest struct {
-	CorrelationKey   string      `json:"correlationKey" binding:"required"`
-	CorrelationValue interface{} `json:"correlationValue" binding:"required"`
-	Output           interface{} `json:"output"`
+	CorrelationKey    string           `json:"correlationKey" binding:"required"`
+	CorrelationValue  CorrelationValue `json:"correlationValue"` // Required: a string, number or boolean
+	Output            interface{}      `json:"output"`
+	MaxMatches        int              `json:"maxMatches,omitempty" binding:"omitempty,min=1,max=10000"`         // Optional: resume nothing when more executions match (default: 100)
+	DryRun            bool             `json:"dryRun,omitempty"`                                                 // Optional: list the matching executions without resuming them
+	Concurrency       int              `json:"concurrency,omitempty" binding:"omitempty,min=1,max=100"`          // Optional: executions resumed at once in the request (default: 10)
+	MessageTTLSeconds int              `json:"messageTtlSeconds,omitempty" binding:"omitempty,min=1,max=604800"` // Optional: how long a message no execution waits for is buffered (default: 86400)
 }
 
 // ExecuteBatchRequest represents a request to execute a batch of executions
@@ -46,54 +95,69 @@ type ExecuteBatchRequest struct {
 	Filter            *BatchExecutionFilterRequest `json:"filter"`
 	GroupEnqueue      bool                         `json:"groupEnqueue"`
 	NamePrefix        string                       `json:"namePrefix"`
-	Concurrency       int                          `json:"concurrency"`
-	Mode              string                       `json:"mode"` // "distributed", "concurrent", "sequential"
+	Concurrency       int                          `json:"concurrency" binding:"min=0"`
+	Mode              string                       `json:"mode" binding:"omitempty,oneof=distributed concurrent sequential"`
 	StopOnError       bool                         `json:"stopOnError"`
-	ExecutionNameList []string                     `json:"executionNameList"` // Explicit list of execution names
+	ExecutionNameList []string                     `json:"executionNameList" binding:"omitempty,dive,required"` // Optional: source executions by exact name, combined with the filter
 	DoMicroBatch      bool                         `json:"doMicroBatch"`
-	MicroBatchSize    int                          `json:"microBatchSize"`
+	MicroBatchSize    int                          `json:"microBatchSize" binding:"min=0"`
+	Tags              map[string]string            `json:"tags,omitempty"`                               // Optional: tags applied to every execution of the batch
+	DryRun            bool                         `json:"dryRun,omitempty"`                             // Optional: preview the source selection without starting anything
+	SampleSize        int                          `json:"sampleSize,omitempty" binding:"min=0,max=100"` // Optional: sources previewed by a dry run (default: 5)
 }
 
 // BatchExecutionFilterRequest represents filter parameters for listing executions
 type BatchExecutionFilterRequest struct {
 	SourceStateMachineId   string   `json:"sourceStateMachineId"`
-	CurrentState           string   `json:"currentState"`
+	CurrentState           string   `json:"currentState"`                     // Optional: current state of the sources, matched as a substring
 	SourceStateName        string   `json:"sourceStateName,omitempty"`        // Optional: specific state's output to use from source execution
 	SourceInputTransformer string   `json:"sourceInputTransformer,omitempty"` // Optional: JSONPath or transformation expression to apply
 	ApplyUnique            bool     `json:"applyUnique,omitempty"`
-	Status                 string   `json:"status,omitempty"`
-	StartTimeFrom          int64    `json:"startTimeFrom"`
-	StartTimeTo            int64    `json:"startTimeTo"`
-	NamePattern            string   `json:"namePattern"`
-	Limit                  int      `json:"limit"`
-	Offset                 int      `json:"offset"`
-	States                 []string `json:"states"`
+	Status                 string   `json:"status,omitempty" binding:"omitempty,oneof=RUNNING SUCCEEDED FAILED CANCELLED TIMED_OUT ABORTED PAUSED WAITING"`
+	StartTimeFrom          int64    `json:"startTimeFrom" binding:"min=0"`
+	StartTimeTo            int64    `json:"startTimeTo" binding:"min=0"`
+	NamePattern            string   `json:"namePattern"` // Optional: source name glob using * and ?, or a regular expression written as /expr/
+	Limit                  int      `json:"limit" binding:"min=0"`
+	Offset                 int      `json:"offset" binding:"min=0"`
+	States                 []string `json:"states" binding:"omitempty,dive,required"` // Optional: current states of the sources, any of which matches
 }
 
 // EnqueueExecutionRequest represents a request to enqueue an execution task
 type EnqueueExecutionRequest struct {
-	StateMachineID    string      `json:"stateMachineId" binding:"required"`
-	ExecutionName     string      `json:"executionName" binding:"required"`
-	Input             interface{} `json:"input"`
-	Queue             string      `json:"queue"`
-	SourceExecutionID string      `json:"sourceExecutionId"`
-	SourceStateName   string      `json:"sourceStateName"`
+	StateMachineID    string            `json:"stateMachineId" binding:"required"`
+	ExecutionName     string            `json:"executionName" binding:"required"`
+	Input             interface{}       `json:"input"`
+	Queue             string            `json:"queue"` // Optional: one of the configured queues (default: the state machine ID)
+	SourceExecutionID string            `json:"sourceExecutionId"`
+	SourceStateName   string            `json:"sourceStateName"`
+	Tags              map[string]string `json:"tags,omitempty"`
+	StartAt           *time.Time        `json:"startAt,omitempty"`                                            // Optional: start the execution at this time (RFC 3339)
+	DelaySeconds      int               `json:"delaySeconds,omitempty" binding:"min=0"`                       // Optional: start the execution after this delay
+	Priority          string            `json:"priority,omitempty" binding:"omitempty,oneof=high normal low"` // Optional: pick the configured queue by weight, instead of queue
+	MaxRetry          *int              `json:"maxRetry,omitempty" binding:"omitempty,min=0,max=100"`         // Optional: retries of the task (default: the queue's retry policy)
+	TimeoutSeconds    int               `json:"timeoutSeconds,omitempty" binding:"min=0"`                     // Optional: processing timeout of the task (default: the queue's retry policy)
+	UniqueForSeconds  int               `json:"uniqueForSeconds,omitempty" binding:"min=0"`                   // Optional: reject identical tasks for this long
 }
 
 // ExecuteBulkRequest represents a request to execute a bulk operation with orchestration
 type ExecuteBulkRequest struct {
-	NamePrefix     string        `json:"namePrefix"`
-	GroupEnqueue   bool          `json:"groupEnqueue"`
-	Concurrency    int           `json:"concurrency"`
-	Mode           string        `json:"mode"` // "distributed", "concurrent", "sequential"
-	StopOnError    bool          `json:"stopOnError"`
-	Inputs         []interface{} `json:"inputs"` // Raw JSON array of inputs
-	DoMicroBatch   bool          `json:"doMicroBatch"`
-	MicroBatchSize int           `json:"microBatchSize"`
-	OrchestratorID string        `json:"orchestratorId"` // Optional: custom orchestrator ID
-	PauseThreshold float64       `json:"pauseThreshold"` // Optional: failure rate threshold for auto-pause (0.0-1.0)
-	ResumeStrategy string        `json:"resumeStrategy"` // Optional: "manual", "automatic", "timeout"
-	TimeoutSeconds int           `json:"timeoutSeconds"` // Optional: timeout for automatic resume
+	NamePrefix     string            `json:"namePrefix"`
+	GroupEnqueue   bool              `json:"groupEnqueue"`
+	Concurrency    int               `json:"concurrency" binding:"min=0"`
+	Mode           string            `json:"mode" binding:"omitempty,oneof=distributed concurrent sequential"`
+	StopOnError    bool              `json:"stopOnError"`
+	Inputs         []interface{}     `json:"inputs"`              // Raw JSON array of inputs
+	DatasetID      string            `json:"datasetId,omitempty"` // Optional: read the inputs from an uploaded dataset instead
+	Selector       string            `json:"selector,omitempty"`  // Optional: JSONPath selecting the input from each dataset row, e.g. $.payload
+	DoMicroBatch   bool              `json:"doMicroBatch"`
+	MicroBatchSize int               `json:"microBatchSize" binding:"min=0"`
+	OrchestratorID string            `json:"orchestratorId"`                                                    // Optional: custom orchestrator ID
+	PauseThreshold float64           `json:"pauseThreshold" binding:"min=0,max=1"`                              // Optional: failure rate threshold for auto-pause (0.0-1.0)
+	ResumeStrategy string            `json:"resumeStrategy" binding:"omitempty,oneof=manual automatic timeout"` // Optional: "manual", "automatic", "timeout"
+	TimeoutSeconds int               `json:"timeoutSeconds" binding:"min=0"`                                    // Optional: timeout for automatic resume
+	Tags           map[string]string `json:"tags,omitempty"`                                                    // Optional: tags applied to every execution of the bulk
+	StartAt        *time.Time        `json:"startAt,omitempty"`                                                 // Optional: start every execution at this time (RFC 3339)
+	DelaySeconds   int               `json:"delaySeconds,omitempty" binding:"min=0"`                            // Optional: start every execution after this delay
 }
 
 // ResumeOrchestratorRequest represents a request to resume a stuck orchestrator
@@ -122,3 +186,74 @@ type RevokeResumeRequest struct {
 type CheckResumeRequest struct {
 	BatchID string `json:"batchId" binding:"required"`
 }
+
+// UpdateExecutionTagsRequest changes the tags of an execution. Tags in Set are added or
+// overwritten; keys in Remove are deleted.
+type UpdateExecutionTagsRequest struct {
+	Set    map[string]string `json:"set"`
+	Remove []string          `json:"remove"`
+}
+
+// CreateDatasetRequest starts the upload of a dataset
+type CreateDatasetRequest struct {
+	Name             string            `json:"name"`
+	Format           string            `json:"format" binding:"required,oneof=ndjson csv"`
+	Mapping          map[string]string `json:"mapping,omitempty"`                                    // Optional: CSV header -> input field; dotted fields nest
+	Types            map[string]string `json:"types,omitempty"`                                      // Optional: CSV input field -> string, number, integer, boolean or json
+	ExpiresInSeconds int               `json:"expiresInSeconds,omitempty" binding:"omitempty,min=1"` // Optional: lifetime of the dataset, default 7 days
+}
+
+// CompleteDatasetRequest finishes the upload of a dataset
+type CompleteDatasetRequest struct {
+	Chunks int `json:"chunks,omitempty" binding:"min=0"` // Optional: expected number of chunks, checked against the uploaded ones
+}
+
+// CreateScheduleRequest creates a schedule starting executions of a state machine on a
+// cron expression or a fixed interval
+type CreateScheduleRequest struct {
+	Name            string      `json:"name"`
+	StateMachineID  string      `json:"stateMachineId" binding:"required"`
+	Cron            string      `json:"cron,omitempty"`                                                               // Standard 5-field cron expression or descriptor such as @daily
+	IntervalSeconds int         `json:"intervalSeconds,omitempty" binding:"omitempty,min=1"`                          // Fixed interval, instead of cron
+	TimeZone        string      `json:"timeZone,omitempty"`                                                           // Optional: IANA time zone of the cron expression (default: UTC)
+	Input           interface{} `json:"input,omitempty"`                                                              // Optional: input template; strings may contain {{scheduledTime}} and other placeholders
+	OverlapPolicy   string      `json:"overlapPolicy,omitempty" binding:"omitempty,oneof=skip allow cancel_previous"` // Optional: when the previous execution is still running (default: skip)
+	Paused          bool        `json:"paused,omitempty"`                                                             // Optional: create the schedule paused
+}
+
+// RescheduleExecutionRequest moves the start of a scheduled execution. Exactly one of
+// StartAt and DelaySeconds is set; a time that has passed starts the execution now.
+type RescheduleExecutionRequest struct {
+	StartAt      *time.Time `json:"startAt,omitempty"`
+	DelaySeconds *int       `json:"delaySeconds,omitempty" binding:"omitempty,min=0"`
+}
+
+// CreateActivityRequest represents a request to create an activity that external
+// workers poll for tasks
+type CreateActivityRequest struct {
+	Name             string `json:"name" binding:"required"`
+	Description      string `json:"description,omitempty"`
+	HeartbeatSeconds int    `json:"heartbeatSeconds,omitempty" binding:"min=0"` // Optional: fail a started task that gets no heartbeat for this long
+}
+
+// ActivityTaskSuccessRequest reports the output of an activity task
+type ActivityTaskSuccessRequest struct {
+	Output interface{} `json:"output"`
+}
+
+// ActivityTaskFailureRequest reports the failure of an activity task
+type ActivityTaskFailureRequest struct {
+	Error string `json:"error,omitempty"` // Optional: error name matched by Retry and Catch (default: States.TaskFailed)
+	Cause string `json:"cause,omitempty"` // Optional: description of the failure
+}
+
+// TaskSuccessRequest reports the output of a callback task
+type TaskSuccessRequest struct {
+	Output interface{} `json:"output"`
+}
+
+// TaskFailureRequest reports the failure of a callback task
+type TaskFailureRequest struct {
+	Error string `json:"error,omitempty"` // Optional: error name matched by Retry and Catch (default: States.TaskFailed)
+	Cause string `json:"cause,omitempty"` // Optional: description of the failure
+}
@@ -2,11 +2,22 @@ package models
 
 import "time"
 
-// ErrorResponse represents an error response
+// ErrorResponse is an RFC 7807 problem document, served as application/problem+json
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message,omitempty"`
-	Code    int    `json:"code"`
+	Type      string       `json:"type"`                // URI identifying the problem type, derived from Code
+	Title     string       `json:"title"`               // Short summary of the problem type
+	Status    int          `json:"status"`              // HTTP status code
+	Detail    string       `json:"detail,omitempty"`    // Explanation specific to this occurrence
+	Instance  string       `json:"instance,omitempty"`  // Request path that produced the problem
+	Code      string       `json:"code"`                // Stable machine-readable error code, e.g. STATE_MACHINE_NOT_FOUND
+	RequestID string       `json:"requestId,omitempty"` // Value of the X-Request-ID response header
+	Details   []FieldError `json:"details,omitempty"`   // Per-field problems for validation errors
+}
+
+// FieldError describes a single invalid field in a request body or query string
+type FieldError struct {
+	Field   string `json:"field"` // JSON path of the field, e.g. "filter.limit" or "inputs[2]"
+	Message string `json:"message"`
 }
 
 // SuccessResponse represents a generic success response
@@ -24,6 +35,9 @@ type StartExecutionResponse struct {
 	Status         string      `json:"status"`
 	StartTime      time.Time   `json:"startTime"`
 	Input          interface{} `json:"input,omitempty"`
+	ScheduledAt    *time.Time  `json:"scheduledAt,omitempty"` // When a delayed execution starts; it has no ID until then
+	TaskID         string      `json:"taskId,omitempty"`      // Queue task of a delayed execution
+	Queue          string      `json:"queue,omitempty"`       // Queue a delayed execution waits in
 }
 
 // ExecutionResponse represents a full execution response
@@ -39,6 +53,7 @@ type ExecutionResponse struct {
 	EndTime               *time.Time             `json:"endTime,omitempty"`
 	Error                 string                 `json:"error,omitempty"`
 	Metadata              map[string]interface{} `json:"metadata,omitempty"`
+	Tags                  map[string]string      `json:"tags,omitempty"`
 	HistorySequenceNumber int                    `json:"historySequenceNumber,omitempty"`
 }
 
@@ -59,12 +74,102 @@ type StateHistoryResponse struct {
 	Metadata       map[string]interface{} `json:"metadata,omitempty"`
 }
 
+// SearchAttributesResponse represents the search attributes of a state machine
+type SearchAttributesResponse struct {
+	StateMachineID string            `json:"stateMachineId"`
+	Attributes     []SearchAttribute `json:"attributes"`
+	Indexed        bool              `json:"indexed"` // Whether expression indexes back the attributes
+}
+
+// WaitTimeoutsResponse represents the wait timeouts of a state machine
+type WaitTimeoutsResponse struct {
+	StateMachineID string         `json:"stateMachineId"`
+	DefaultSeconds int            `json:"defaultSeconds"`
+	States         map[string]int `json:"states"`
+}
+
+// RecoveryConfigResponse represents the recovery policy of a state machine: the scanner
+// defaults with its overrides applied
+type RecoveryConfigResponse struct {
+	StateMachineID           string                `json:"stateMachineId"`
+	Enabled                  bool                  `json:"enabled"`
+	OrphanedThresholdSeconds int64                 `json:"orphanedThresholdSeconds"`
+	Strategy                 string                `json:"strategy"`
+	MaxRecoveryAttempts      int                   `json:"maxRecoveryAttempts"`
+	ScanIntervalSeconds      int64                 `json:"scanIntervalSeconds"`
+	Overrides                RecoverySettings      `json:"overrides"`          // Settings stored for the state machine
+	LastScan                 *RecoveryScanResponse `json:"lastScan,omitempty"` // Unset until the state machine is scanned
+}
+
+// RecoveryScanResponse represents a scan of the orphaned executions of a state machine
+type RecoveryScanResponse struct {
+	StateMachineID string    `json:"stateMachineId"`
+	StartedAt      time.Time `json:"startedAt"`
+	FinishedAt     time.Time `json:"finishedAt"`
+	Orphans        int       `json:"orphans"`
+	AttemptIDs     []string  `json:"attemptIds"`
+	Error          string    `json:"error,omitempty"`
+	Manual         bool      `json:"manual"` // Triggered through the API
+}
+
+// RecoveryAttemptResponse represents the recovery of an orphaned execution
+type RecoveryAttemptResponse struct {
+	ID             string    `json:"id"`
+	ExecutionID    string    `json:"executionId"`
+	StateMachineID string    `json:"stateMachineId"`
+	CurrentState   string    `json:"currentState"`
+	Strategy       string    `json:"strategy"`
+	Attempt        int       `json:"attempt"` // 1 for the first recovery of the execution
+	StartedAt      time.Time `json:"startedAt"`
+	FinishedAt     time.Time `json:"finishedAt"`
+	Outcome        string    `json:"outcome"`          // "RECOVERED", "PAUSED", "ABANDONED" or "ERROR"
+	Status         string    `json:"status,omitempty"` // Status of the execution after the attempt
+	Error          string    `json:"error,omitempty"`
+	Manual         bool      `json:"manual"`
+}
+
+// TriggerRecoveryScanResponse reports a scan triggered through the API
+type TriggerRecoveryScanResponse struct {
+	Scan     *RecoveryScanResponse      `json:"scan"`
+	Attempts []*RecoveryAttemptResponse `json:"attempts"`
+}
+
+// ListRecoveryAttemptsResponse represents a page of recovery attempts, newest first
+type ListRecoveryAttemptsResponse struct {
+	Attempts []*RecoveryAttemptResponse `json:"attempts"`
+	Total    int                        `json:"total"`
+	Limit    int                        `json:"limit"`
+	Offset   int                        `json:"offset"`
+}
+
+// OrphanedExecutionResponse represents an execution left RUNNING longer than the orphaned
+// threshold
+type OrphanedExecutionResponse struct {
+	ExecutionID      string    `json:"executionId"`
+	StateMachineID   string    `json:"stateMachineId"`
+	CurrentState     string    `json:"currentState"`
+	Status           string    `json:"status"`
+	StartTime        time.Time `json:"startTime"`
+	LastUpdateTime   time.Time `json:"lastUpdateTime"`
+	RunningSeconds   int64     `json:"runningSeconds"`
+	RecoveryAttempts int       `json:"recoveryAttempts"` // Attempts made so far
+}
+
+// ListOrphanedExecutionsResponse represents a page of orphaned executions, oldest first
+type ListOrphanedExecutionsResponse struct {
+	Executions []*OrphanedExecutionResponse `json:"executions"`
+	Total      int                          `json:"total"`
+	Limit      int                          `json:"limit"`
+	Offset     int                          `json:"offset"`
+}
+
 // ListExecutionsResponse represents a paginated list of executions
 type ListExecutionsResponse struct {
 	Executions []*ExecutionResponse `json:"executions"`
 	Total      int64                `json:"total"`
 	Limit      int                  `json:"limit"`
 	Offset     int                  `json:"offset"`
+	NextCursor string               `json:"nextCursor,omitempty"` // Pass as "cursor" to fetch the next page; empty on the last page
 }
 
 // BatchExecutionResponse represents the response for batch execution
@@ -75,17 +180,117 @@ type BatchExecutionResponse struct {
 	Mode          string `json:"mode"`
 }
 
+// BatchDryRunResponse previews the source executions a batch would start from
+type BatchDryRunResponse struct {
+	DryRun           bool              `json:"dryRun"`
+	MatchCount       int               `json:"matchCount"`                 // Source executions the batch would start from
+	ExcludedByUnique int               `json:"excludedByUnique,omitempty"` // Matches skipped by applyUnique because they are already linked
+	MicroBatchCount  int               `json:"microBatchCount"`            // Micro-batches of microBatchSize, 0 without doMicroBatch
+	Sample           []BatchDryRunItem `json:"sample"`
+	Warnings         []string          `json:"warnings,omitempty"`
+}
+
+// BatchDryRunItem is a source execution of a dry run and the input derived from it
+type BatchDryRunItem struct {
+	SourceExecutionID string      `json:"sourceExecutionId"`
+	ExecutionName     string      `json:"executionName"` // Name the chained execution would get
+	Input             interface{} `json:"input,omitempty"`
+	Error             string      `json:"error,omitempty"` // Why no input could be derived
+}
+
 // ResumeByCorrelationResponse represents the response for resume by correlation
 type ResumeByCorrelationResponse struct {
-	ResumedCount int      `json:"resumedCount"`
-	ExecutionIDs []string `json:"executionIds"`
+	MatchCount   int            `json:"matchCount"`   // Executions waiting for the correlation
+	ResumedCount int            `json:"resumedCount"` // Executions resumed, or queued for resume when queued is set
+	FailedCount  int            `json:"failedCount"`
+	ExecutionIDs []string       `json:"executionIds"` // Executions resumed or queued
+	Results      []ResumeResult `json:"results"`
+	Queued       bool           `json:"queued,omitempty"`      // The resumes were queued for a worker
+	DryRun       bool           `json:"dryRun,omitempty"`      // Nothing was resumed
+	MoreMatches  bool           `json:"moreMatches,omitempty"` // A dry run found more than maxMatches executions
+	Buffered     bool           `json:"buffered,omitempty"`    // No execution was waiting; the message is kept in the inbox
+	MessageID    string         `json:"messageId,omitempty"`   // The buffered message
+}
+
+// ResumeResult is the outcome of resuming one execution
+type ResumeResult struct {
+	ExecutionID     string `json:"executionId"`
+	Name            string `json:"name,omitempty"`
+	Result          string `json:"result"`                    // RESUMED, QUEUED, FAILED or MATCHED (dry run)
+	ExecutionStatus string `json:"executionStatus,omitempty"` // Status of the execution after a resume in the request
+	Code            string `json:"code,omitempty"`            // Error code of a failed resume
+	Error           string `json:"error,omitempty"`
+}
+
+// ResumeQueuedResponse represents a resume queued for a worker. The execution stays
+// PAUSED until a worker picks up the task.
+type ResumeQueuedResponse struct {
+	ExecutionID    string `json:"executionId"`
+	StateMachineID string `json:"stateMachineId"`
+	TaskID         string `json:"taskId"`
+	Queue          string `json:"queue"`
+}
+
+// MessageResponse describes a correlation message buffered in the inbox
+type MessageResponse struct {
+	ID               string      `json:"id"`
+	StateMachineID   string      `json:"stateMachineId"`
+	CorrelationKey   string      `json:"correlationKey"`
+	CorrelationValue interface{} `json:"correlationValue"`
+	Output           interface{} `json:"output,omitempty"`
+	Status           string      `json:"status"` // "PENDING" or "DELIVERED"
+	CreatedAt        time.Time   `json:"createdAt"`
+	ExpiresAt        time.Time   `json:"expiresAt"`
+	DeliveredAt      *time.Time  `json:"deliveredAt,omitempty"`
+	ExecutionID      string      `json:"executionId,omitempty"` // The execution it was delivered to last
+	Deliveries       int         `json:"deliveries"`
+}
+
+// ListMessagesResponse represents a page of buffered messages
+type ListMessagesResponse struct {
+	Messages []*MessageResponse `json:"messages"`
+	Total    int                `json:"total"`
+	Limit    int                `json:"limit"`
+	Offset   int                `json:"offset"`
+}
+
+// ExpiringExecutionResponse represents an execution waiting in a Message state that times
+// out at ExpiresAt
+type ExpiringExecutionResponse struct {
+	ExecutionID      string      `json:"executionId"`
+	StateMachineID   string      `json:"stateMachineId"`
+	StateName        string      `json:"stateName"`
+	CorrelationID    string      `json:"correlationId"`
+	CorrelationKey   string      `json:"correlationKey"`
+	CorrelationValue interface{} `json:"correlationValue"`
+	WaitingSince     time.Time   `json:"waitingSince"`
+	ExpiresAt        time.Time   `json:"expiresAt"`
+	ExpiresIn        int64       `json:"expiresInSeconds"` // Negative once the timeout is overdue
+}
+
+// ListExpiringExecutionsResponse represents a page of waiting executions, soonest to
+// expire first
+type ListExpiringExecutionsResponse struct {
+	Executions []*ExpiringExecutionResponse `json:"executions"`
+	Total      int                          `json:"total"`
+	Limit      int                          `json:"limit"`
+	Offset     int                          `json:"offset"`
+}
+
+// RedeliverMessageResponse reports the redelivery of a message. When no execution waits
+// for it, the message is kept pending.
+type RedeliverMessageResponse struct {
+	Message   *MessageResponse `json:"message"`
+	Delivered bool             `json:"delivered"`
+	Result    *ResumeResult    `json:"result,omitempty"` // Outcome of resuming the execution it was delivered to
 }
 
 // EnqueueExecutionResponse represents the response for enqueuing an execution
 type EnqueueExecutionResponse struct {
-	TaskID     string    `json:"taskId"`
-	Queue      string    `json:"queue"`
-	EnqueuedAt time.Time `json:"enqueuedAt"`
+	TaskID      string     `json:"taskId"`
+	Queue       string     `json:"queue"`
+	EnqueuedAt  time.Time  `json:"enqueuedAt"`
+	ScheduledAt *time.Time `json:"scheduledAt,omitempty"` // When a delayed execution starts
 }
 
 // QueueStatsResponse represents queue statistics
@@ -106,6 +311,27 @@ type HealthResponse struct {
 	Services map[string]string `json:"services"`
 }
 
+// LivenessResponse represents the liveness probe response
+type LivenessResponse struct {
+	Status string    `json:"status"`
+	Time   time.Time `json:"time"`
+}
+
+// ReadinessResponse represents the readiness probe response
+type ReadinessResponse struct {
+	Status     string                             `json:"status"` // "healthy", "degraded", "unhealthy"
+	Components map[string]ComponentHealthResponse `json:"components"`
+	CheckedAt  time.Time                          `json:"checkedAt"`
+	Cached     bool                               `json:"cached"`
+}
+
+// ComponentHealthResponse represents the health of a single dependency
+type ComponentHealthResponse struct {
+	Status    string  `json:"status"` // "up", "down", "degraded", "not_configured"
+	LatencyMs float64 `json:"latencyMs"`
+	Message   string  `json:"message,omitempty"`
+}
+
 // StateMachineResponse represents a state machine definition response
 type StateMachineResponse struct {
 	ID          string                 `json:"id"`
@@ -131,14 +357,82 @@ type ListTransformersResponse struct {
 
 // BulkExecutionResponse represents the response for bulk execution with orchestration
 type BulkExecutionResponse struct {
-	OrchestratorID string  `json:"orchestratorId"`
-	BatchID        string  `json:"batchId"`
-	Status         string  `json:"status"` // "Running", "Paused", "Completed", "Failed", "Cancelled"
-	TotalEnqueued  int     `json:"totalEnqueued"`
-	TotalFailed    int     `json:"totalFailed"`
-	Mode           string  `json:"mode"`
-	PausedAtBatch  int     `json:"pausedAtBatch,omitempty"`
-	FailureRate    float64 `json:"failureRate,omitempty"`
+	OrchestratorID string         `json:"orchestratorId"`
+	BatchID        string         `json:"batchId"`
+	Status         string         `json:"status"` // "Running", "Paused", "Completed", "Failed", "Cancelled"
+	TotalEnqueued  int            `json:"totalEnqueued"`
+	TotalFailed    int            `json:"totalFailed"`
+	Mode           string         `json:"mode"`
+	PausedAtBatch  int            `json:"pausedAtBatch,omitempty"`
+	FailureRate    float64        `json:"failureRate,omitempty"`
+	TotalRejected  int            `json:"totalRejected,omitempty"` // Inputs rejected by the input schema
+	Rejected       []RejectedItem `json:"rejected,omitempty"`
+	ScheduledAt    *time.Time     `json:"scheduledAt,omitempty"` // When the executions of a delayed bulk start
+}
+
+// RejectedItem describes a bulk input that failed validation and was not executed
+type RejectedItem struct {
+	Index  int          `json:"index"` // Position of the input in the request
+	Errors []FieldError `json:"errors"`
+}
+
+// Event types of the streaming bulk upload progress stream
+const (
+	BulkStreamEventError    = "error"
+	BulkStreamEventProgress = "progress"
+	BulkStreamEventSummary  = "summary"
+)
+
+// BulkStreamEvent is one line of the NDJSON progress stream returned by the streaming bulk
+// upload. Error events report a line that was not enqueued; progress events follow every
+// enqueued micro-batch; the summary event is always last.
+type BulkStreamEvent struct {
+	Type      string       `json:"type"`                // "error", "progress" or "summary"
+	Line      int          `json:"line,omitempty"`      // Line of the upload the error refers to
+	Error     string       `json:"error,omitempty"`     // Parse or enqueue error; on the summary, why reading stopped early
+	Errors    []FieldError `json:"errors,omitempty"`    // Input schema violations
+	BatchID   string       `json:"batchId,omitempty"`   // Name prefix of the executions
+	LinesRead int          `json:"linesRead"`           // Lines of the upload read so far
+	Enqueued  int          `json:"enqueued"`            // Executions enqueued so far
+	Rejected  int          `json:"rejected"`            // Items that failed to parse or validate
+	Failed    int          `json:"failed"`              // Items that could not be enqueued
+	Completed bool         `json:"completed,omitempty"` // Set on the summary when the whole upload was read
+}
+
+// ExecutionResult is the outcome of one execution of a batch or bulk
+type ExecutionResult struct {
+	Index       int                    `json:"index"` // Position of the input in the batch or bulk
+	ExecutionID string                 `json:"executionId"`
+	Name        string                 `json:"name"`
+	Status      string                 `json:"status"`
+	Error       string                 `json:"error,omitempty"`
+	StartTime   *time.Time             `json:"startTime,omitempty"`
+	EndTime     *time.Time             `json:"endTime,omitempty"`
+	Fields      map[string]interface{} `json:"fields,omitempty"` // Output fields selected with field=name:$.path
+}
+
+// ExecutionResultsResponse is the JSON export of the results of a batch or bulk
+type ExecutionResultsResponse struct {
+	BatchID string            `json:"batchId"`
+	Results []ExecutionResult `json:"results"`
+	Total   int               `json:"total"`
+}
+
+// RetryFailedResponse is the response of a retry of the failed executions of a batch or bulk
+type RetryFailedResponse struct {
+	BatchID      string         `json:"batchId"`  // ID of the new batch, <parentId>-retry<n>
+	ParentID     string         `json:"parentId"` // ID of the retried batch or bulk
+	Attempt      int            `json:"attempt"`
+	TotalRetried int            `json:"totalRetried"`
+	Skipped      []SkippedRetry `json:"skipped,omitempty"`
+	Mode         string         `json:"mode,omitempty"`
+}
+
+// SkippedRetry describes a failed execution that could not be retried
+type SkippedRetry struct {
+	Index       int    `json:"index"`
+	ExecutionID string `json:"executionId"`
+	Reason      string `json:"reason"`
 }
 
 // BulkStatusResponse represents the status of a bulk execution
@@ -147,6 +441,9 @@ type BulkStatusResponse struct {
 	Status         string        `json:"status"`
 	Progress       *BulkProgress `json:"progress,omitempty"`
 	Metrics        *BulkMetrics  `json:"metrics,omitempty"`
+	ParentID       string        `json:"parentId,omitempty"` // Batch or bulk this run retries
+	Attempt        int           `json:"attempt,omitempty"`  // 1 for the original run
+	Retries        []string      `json:"retries,omitempty"`  // IDs of the retries of this run
 }
 
 // BulkProgress represents the progress of a bulk execution
@@ -207,3 +504,148 @@ type CheckResumeResponse struct {
 	ResumedAt     string `json:"resumedAt,omitempty"`
 	SignalPresent bool   `json:"signalPresent"` // true if signal was present and consumed
 }
+
+// ExecutionTagsResponse represents the tags of an execution
+type ExecutionTagsResponse struct {
+	ExecutionID string            `json:"executionId"`
+	Tags        map[string]string `json:"tags"`
+}
+
+// DatasetResponse describes an uploaded dataset
+type DatasetResponse struct {
+	ID             string            `json:"id"`
+	Name           string            `json:"name,omitempty"`
+	Format         string            `json:"format"`
+	Mapping        map[string]string `json:"mapping,omitempty"`
+	Types          map[string]string `json:"types,omitempty"`
+	Status         string            `json:"status"`                   // "UPLOADING" or "READY"
+	Chunks         int               `json:"chunks"`                   // Chunks of the dataset; while uploading, chunks received so far
+	Size           int64             `json:"size"`                     // Size in bytes; while uploading, bytes received so far
+	RowCount       int               `json:"rowCount"`                 // Number of inputs, known once the upload is complete
+	UploadedChunks []DatasetChunk    `json:"uploadedChunks,omitempty"` // Chunks received while uploading, to resume an upload
+	CreatedAt      time.Time         `json:"createdAt"`
+	CompletedAt    *time.Time        `json:"completedAt,omitempty"`
+	ExpiresAt      time.Time         `json:"expiresAt"`
+}
+
+// DatasetChunk describes an uploaded chunk of a dataset
+type DatasetChunk struct {
+	Index int   `json:"index"`
+	Size  int64 `json:"size"`
+}
+
+// DatasetChunkResponse acknowledges an uploaded chunk
+type DatasetChunkResponse struct {
+	DatasetID string `json:"datasetId"`
+	Index     int    `json:"index"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"` // Hex SHA-256 of the stored chunk
+}
+
+// ListDatasetsResponse represents a page of datasets
+type ListDatasetsResponse struct {
+	Datasets []*DatasetResponse `json:"datasets"`
+	Total    int                `json:"total"`
+	Limit    int                `json:"limit"`
+	Offset   int                `json:"offset"`
+}
+
+// DatasetPreviewResponse shows the first inputs of a dataset
+type DatasetPreviewResponse struct {
+	DatasetID string       `json:"datasetId"`
+	RowCount  int          `json:"rowCount"`
+	Rows      []DatasetRow `json:"rows"`
+}
+
+// DatasetRow is an input of a dataset
+type DatasetRow struct {
+	Index int         `json:"index"`           // Position of the row in the dataset
+	Line  int         `json:"line"`            // Line of the file the row starts on
+	Input interface{} `json:"input,omitempty"` // The row, or the value the selector picked from it
+	Error string      `json:"error,omitempty"` // Why the row yields no input
+}
+
+// ScheduleResponse describes a schedule
+type ScheduleResponse struct {
+	ID              string       `json:"id"`
+	Name            string       `json:"name,omitempty"`
+	StateMachineID  string       `json:"stateMachineId"`
+	Cron            string       `json:"cron,omitempty"`
+	IntervalSeconds int          `json:"intervalSeconds,omitempty"`
+	TimeZone        string       `json:"timeZone"`
+	Input           interface{}  `json:"input,omitempty"`
+	OverlapPolicy   string       `json:"overlapPolicy"`
+	Paused          bool         `json:"paused"`
+	CreatedAt       time.Time    `json:"createdAt"`
+	UpdatedAt       time.Time    `json:"updatedAt"`
+	NextRunAt       *time.Time   `json:"nextRunAt,omitempty"` // Unset while paused
+	LastRun         *ScheduleRun `json:"lastRun,omitempty"`
+}
+
+// ScheduleRun describes a firing of a schedule
+type ScheduleRun struct {
+	ScheduledAt   time.Time `json:"scheduledAt"`
+	FiredAt       time.Time `json:"firedAt"`
+	Outcome       string    `json:"outcome"` // "STARTED", "SKIPPED" or "FAILED"
+	ExecutionName string    `json:"executionName,omitempty"`
+	Manual        bool      `json:"manual,omitempty"` // Fired with the trigger endpoint
+	Reason        string    `json:"reason,omitempty"` // Why the run was skipped or failed
+}
+
+// ListSchedulesResponse represents a page of schedules
+type ListSchedulesResponse struct {
+	Schedules []*ScheduleResponse `json:"schedules"`
+	Total     int                 `json:"total"`
+	Limit     int                 `json:"limit"`
+	Offset    int                 `json:"offset"`
+}
+
+// TriggerScheduleResponse reports a run started with the trigger endpoint
+type TriggerScheduleResponse struct {
+	ScheduleID string      `json:"scheduleId"`
+	Run        ScheduleRun `json:"run"`
+}
+
+// ScheduledExecutionResponse describes a queued execution that has not started yet
+type ScheduledExecutionResponse struct {
+	ExecutionName     string            `json:"executionName"`
+	StateMachineID    string            `json:"stateMachineId"`
+	Status            string            `json:"status"` // SCHEDULED, or PENDING once rescheduled to start now
+	StartAt           time.Time         `json:"startAt"`
+	Input             interface{}       `json:"input,omitempty"`
+	SourceExecutionID string            `json:"sourceExecutionId,omitempty"`
+	SourceStateName   string            `json:"sourceStateName,omitempty"`
+	Tags              map[string]string `json:"tags,omitempty"`
+}
+
+// ListScheduledExecutionsResponse represents a page of scheduled executions
+type ListScheduledExecutionsResponse struct {
+	Executions []*ScheduledExecutionResponse `json:"executions"`
+	Total      int                           `json:"total"` // Scheduled executions of the state machine in the queue
+	Page       int                           `json:"page"`
+	PageSize   int                           `json:"pageSize"`
+}
+
+// ActivityResponse represents an activity
+type ActivityResponse struct {
+	Name             string    `json:"name"`
+	Resource         string    `json:"resource"` // Resource of the Task states that run on the activity
+	Description      string    `json:"description,omitempty"`
+	HeartbeatSeconds int       `json:"heartbeatSeconds,omitempty"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+// ListActivitiesResponse represents the list of activities
+type ListActivitiesResponse struct {
+	Activities []*ActivityResponse `json:"activities"`
+	Total      int                 `json:"total"`
+}
+
+// ActivityTaskResponse is a task handed to a polling worker
+type ActivityTaskResponse struct {
+	TaskToken        string      `json:"taskToken"`
+	Activity         string      `json:"activity"`
+	Input            interface{} `json:"input"`
+	HeartbeatSeconds int         `json:"heartbeatSeconds,omitempty"` // Heartbeat at least this often while working on the task
+	StartedAt        *time.Time  `json:"startedAt,omitempty"`
+}
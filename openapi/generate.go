@@ -0,0 +1,558 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Route describes an HTTP route registered on the router
+type Route struct {
+	Method  string // e.g. "GET"
+	Path    string // gin path relative to the base path, e.g. "/executions/:executionId"
+	Handler string // fully qualified handler name as reported by gin
+}
+
+// Model describes a request/response struct parsed from the models package
+type Model struct {
+	Name     string
+	Fields   []ModelField
+	Required []string
+}
+
+// ModelField describes a single JSON property of a model
+type ModelField struct {
+	JSONName    string
+	Schema      map[string]interface{}
+	Description string
+	Constraints map[string]interface{} // minimum, maximum, enum, ... derived from binding tags
+}
+
+// Drift lists the differences between the specification and the code
+type Drift struct {
+	MissingOperations []string // "GET /executions/{executionId}"
+	StaleOperations   []string // operations in the spec without a registered route
+	MissingSchemas    []string // models without a component schema
+	MissingFields     []string // "Schema.field"
+	StaleFields       []string // schema properties without a model field
+	ChangedFields     []string // "Schema.field" whose constraints differ from the binding tags
+}
+
+// Empty reports whether the specification matches the code
+func (d *Drift) Empty() bool {
+	return len(d.MissingOperations) == 0 && len(d.StaleOperations) == 0 &&
+		len(d.MissingSchemas) == 0 && len(d.MissingFields) == 0 && len(d.StaleFields) == 0 &&
+		len(d.ChangedFields) == 0
+}
+
+// String renders the drift as a human readable list
+func (d *Drift) String() string {
+	var b strings.Builder
+	write := func(title string, items []string) {
+		for _, item := range items {
+			fmt.Fprintf(&b, "%s: %s\n", title, item)
+		}
+	}
+	write("missing operation", d.MissingOperations)
+	write("stale operation", d.StaleOperations)
+	write("missing schema", d.MissingSchemas)
+	write("missing field", d.MissingFields)
+	write("stale field", d.StaleFields)
+	write("changed constraints", d.ChangedFields)
+	return b.String()
+}
+
+// Check compares a specification document with the routes and models without modifying it
+func Check(specJSON []byte, routes []Route, models []Model) (*Drift, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(specJSON, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI specification: %w", err)
+	}
+	return Sync(doc, routes, models), nil
+}
+
+// Sync brings doc in line with the routes and models. Missing operations and schema
+// properties are generated from the code, stale ones are removed and hand-written
+// descriptions of everything that still exists are kept. The returned drift describes
+// what had to change.
+func Sync(doc map[string]interface{}, routes []Route, models []Model) *Drift {
+	drift := &Drift{}
+	syncPaths(doc, routes, drift)
+	syncSchemas(doc, models, drift)
+
+	sort.Strings(drift.MissingOperations)
+	sort.Strings(drift.StaleOperations)
+	sort.Strings(drift.MissingSchemas)
+	sort.Strings(drift.MissingFields)
+	sort.Strings(drift.StaleFields)
+	sort.Strings(drift.ChangedFields)
+	return drift
+}
+
+var ginParamPattern = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+// OpenAPIPath converts a gin route path into an OpenAPI path template
+func OpenAPIPath(ginPath string) string {
+	return ginParamPattern.ReplaceAllString(ginPath, "{$1}")
+}
+
+func syncPaths(doc map[string]interface{}, routes []Route, drift *Drift) {
+	paths := objectField(doc, "paths")
+
+	registered := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		method := strings.ToLower(route.Method)
+		path := OpenAPIPath(route.Path)
+		registered[method+" "+path] = true
+
+		item := objectField(paths, path)
+		if _, exists := item[method]; exists {
+			continue
+		}
+		item[method] = operationStub(doc, route, path)
+		drift.MissingOperations = append(drift.MissingOperations, route.Method+" "+path)
+	}
+
+	for path, rawItem := range paths {
+		item, ok := rawItem.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for method := range item {
+			if !isHTTPMethod(method) || registered[method+" "+path] {
+				continue
+			}
+			delete(item, method)
+			drift.StaleOperations = append(drift.StaleOperations, strings.ToUpper(method)+" "+path)
+		}
+		if len(item) == 0 {
+			delete(paths, path)
+		}
+	}
+}
+
+func syncSchemas(doc map[string]interface{}, models []Model, drift *Drift) {
+	schemas := objectField(objectField(doc, "components"), "schemas")
+
+	for _, model := range models {
+		schema, exists := schemas[model.Name].(map[string]interface{})
+		if !exists {
+			schema = map[string]interface{}{"type": "object"}
+			schemas[model.Name] = schema
+			drift.MissingSchemas = append(drift.MissingSchemas, model.Name)
+		}
+		// Types without JSON fields encode themselves; their schema is written by hand
+		if len(model.Fields) == 0 {
+			continue
+		}
+
+		properties := objectField(schema, "properties")
+		known := make(map[string]bool, len(model.Fields))
+		for _, field := range model.Fields {
+			known[field.JSONName] = true
+			if existing, exists := properties[field.JSONName].(map[string]interface{}); exists {
+				if applyConstraints(existing, field.Constraints) {
+					drift.ChangedFields = append(drift.ChangedFields, model.Name+"."+field.JSONName)
+				}
+				continue
+			}
+			property := copySchema(field.Schema)
+			if _, isRef := property["$ref"]; !isRef {
+				if field.Description != "" {
+					property["description"] = field.Description
+				}
+				applyConstraints(property, field.Constraints)
+			}
+			properties[field.JSONName] = property
+			drift.MissingFields = append(drift.MissingFields, model.Name+"."+field.JSONName)
+		}
+
+		for name := range properties {
+			if !known[name] {
+				delete(properties, name)
+				drift.StaleFields = append(drift.StaleFields, model.Name+"."+name)
+			}
+		}
+
+		if len(model.Required) > 0 {
+			required := map[string]bool{}
+			var list []interface{}
+			if existing, ok := schema["required"].([]interface{}); ok {
+				for _, r := range existing {
+					if name, ok := r.(string); ok && known[name] && !required[name] {
+						required[name] = true
+						list = append(list, name)
+					}
+				}
+			}
+			for _, name := range model.Required {
+				if !required[name] {
+					required[name] = true
+					list = append(list, name)
+				}
+			}
+			schema["required"] = list
+		}
+	}
+}
+
+// applyConstraints sets the given constraint keywords on a property schema and
+// reports whether anything changed. Keywords not derived from binding tags are kept.
+func applyConstraints(property, constraints map[string]interface{}) bool {
+	if _, isRef := property["$ref"]; isRef {
+		return false
+	}
+	changed := false
+	for key, value := range constraints {
+		if !reflect.DeepEqual(property[key], value) {
+			property[key] = value
+			changed = true
+		}
+	}
+	return changed
+}
+
+// operationStub builds a minimal operation for a route that is missing from the spec
+func operationStub(doc map[string]interface{}, route Route, path string) map[string]interface{} {
+	name := handlerName(route.Handler)
+	tag := tagForPath(path)
+	ensureTag(doc, tag)
+
+	operation := map[string]interface{}{
+		"tags":        []interface{}{tag},
+		"summary":     humanize(name),
+		"operationId": lowerFirst(name),
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{"description": "Successful response"},
+		},
+	}
+
+	var parameters []interface{}
+	for _, match := range ginParamPattern.FindAllStringSubmatch(route.Path, -1) {
+		parameters = append(parameters, pathParameter(doc, match[1]))
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+	return operation
+}
+
+// pathParameter references a shared component parameter when one exists for the name
+func pathParameter(doc map[string]interface{}, name string) map[string]interface{} {
+	parameters := objectField(objectField(doc, "components"), "parameters")
+	componentName := strings.ToUpper(name[:1]) + name[1:]
+	if _, exists := parameters[componentName]; exists {
+		return map[string]interface{}{"$ref": "#/components/parameters/" + componentName}
+	}
+	return map[string]interface{}{
+		"name":     name,
+		"in":       "path",
+		"required": true,
+		"schema":   map[string]interface{}{"type": "string"},
+	}
+}
+
+var pathTags = map[string]string{
+	"health":         "Health",
+	"openapi.json":   "Documentation",
+	"docs":           "Documentation",
+	"state-machines": "State Machines",
+	"executions":     "Executions",
+	"transformers":   "Executions",
+	"batch":          "Batch",
+	"queue":          "Queue",
+	"bulk":           "Bulk",
+	"orchestrator":   "Batch",
+}
+
+// tagForPath groups an operation by its leading path segment
+func tagForPath(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) > 2 && segments[0] == "state-machines" {
+		switch segments[2] {
+		case "executions":
+			if len(segments) > 3 && (segments[3] == "batch" || segments[3] == "bulk" || segments[3] == "bulk-form") {
+				return pathTags[strings.TrimSuffix(segments[3], "-form")]
+			}
+			return "Executions"
+		case "resume-by-correlation", "waiting":
+			return "Messages"
+		}
+	}
+	if len(segments) > 2 && segments[0] == "executions" && segments[2] == "resume" {
+		return "Messages"
+	}
+	if tag, ok := pathTags[segments[0]]; ok {
+		return tag
+	}
+	return humanize(strings.ReplaceAll(segments[0], "-", " "))
+}
+
+func ensureTag(doc map[string]interface{}, tag string) {
+	tags, _ := doc["tags"].([]interface{})
+	for _, existing := range tags {
+		if entry, ok := existing.(map[string]interface{}); ok && entry["name"] == tag {
+			return
+		}
+	}
+	doc["tags"] = append(tags, map[string]interface{}{"name": tag})
+}
+
+// handlerName extracts "StartExecution" from "github.com/.../handlers.StartExecution"
+func handlerName(handler string) string {
+	name := handler[strings.LastIndex(handler, "/")+1:]
+	if idx := strings.LastIndex(name, "."); idx != -1 {
+		name = name[idx+1:]
+	}
+	return strings.TrimSuffix(name, "-fm")
+}
+
+// humanize turns "StartExecution" into "Start execution"
+func humanize(name string) string {
+	var words []string
+	start := 0
+	for i := 1; i < len(name); i++ {
+		if name[i] >= 'A' && name[i] <= 'Z' && name[i-1] >= 'a' && name[i-1] <= 'z' {
+			words = append(words, name[start:i])
+			start = i
+		}
+	}
+	words = append(words, name[start:])
+	for i := 1; i < len(words); i++ {
+		words[i] = strings.ToLower(words[i])
+	}
+	if len(words) > 0 && words[0] != "" {
+		words[0] = strings.ToUpper(words[0][:1]) + words[0][1:]
+	}
+	return strings.Join(words, " ")
+}
+
+func lowerFirst(name string) string {
+	if name == "" {
+		return name
+	}
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+func isHTTPMethod(method string) bool {
+	switch method {
+	case "get", "put", "post", "delete", "options", "head", "patch", "trace":
+		return true
+	}
+	return false
+}
+
+// objectField returns parent[key] as an object, creating it when absent
+func objectField(parent map[string]interface{}, key string) map[string]interface{} {
+	if existing, ok := parent[key].(map[string]interface{}); ok {
+		return existing
+	}
+	created := map[string]interface{}{}
+	parent[key] = created
+	return created
+}
+
+func copySchema(schema map[string]interface{}) map[string]interface{} {
+	data, _ := json.Marshal(schema)
+	var out map[string]interface{}
+	_ = json.Unmarshal(data, &out)
+	if out == nil {
+		out = map[string]interface{}{}
+	}
+	return out
+}
+
+// ParseModels parses every exported struct in the Go package at dir into a Model
+func ParseModels(dir string) ([]Model, error) {
+	fset := token.NewFileSet()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read models directory: %w", err)
+	}
+
+	types := map[string]ast.Expr{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			if spec, ok := n.(*ast.TypeSpec); ok {
+				types[spec.Name.Name] = spec.Type
+			}
+			return true
+		})
+	}
+
+	var models []Model
+	for name, expr := range types {
+		st, ok := expr.(*ast.StructType)
+		if !ok || !ast.IsExported(name) {
+			continue
+		}
+		model := Model{Name: name}
+		for _, field := range st.Fields.List {
+			if len(field.Names) == 0 {
+				continue
+			}
+			jsonName, rules, skip := fieldTags(field)
+			if skip {
+				continue
+			}
+			if jsonName == "" {
+				jsonName = field.Names[0].Name
+			}
+			description := ""
+			if field.Comment != nil {
+				description = strings.TrimSpace(field.Comment.Text())
+			}
+			schema := exprSchema(field.Type, types)
+			model.Fields = append(model.Fields, ModelField{
+				JSONName:    jsonName,
+				Schema:      schema,
+				Description: description,
+				Constraints: bindingConstraints(rules, schema),
+			})
+			if containsRule(rules, "required") {
+				model.Required = append(model.Required, jsonName)
+			}
+		}
+		models = append(models, model)
+	}
+
+	sort.Slice(models, func(i, j int) bool { return models[i].Name < models[j].Name })
+	return models, nil
+}
+
+// fieldTags reads the json name and the binding rules that apply to the field itself.
+// Rules after "dive" apply to elements and are not returned.
+func fieldTags(field *ast.Field) (jsonName string, rules []string, skip bool) {
+	if field.Tag == nil {
+		return "", nil, !field.Names[0].IsExported()
+	}
+	tag := reflect.StructTag(strings.Trim(field.Tag.Value, "`"))
+	jsonTag := tag.Get("json")
+	if jsonTag == "-" || !field.Names[0].IsExported() {
+		return "", nil, true
+	}
+	jsonName = strings.Split(jsonTag, ",")[0]
+	for _, rule := range strings.Split(tag.Get("binding"), ",") {
+		if rule == "dive" {
+			break
+		}
+		if rule != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return jsonName, rules, false
+}
+
+func containsRule(rules []string, name string) bool {
+	for _, rule := range rules {
+		if rule == name {
+			return true
+		}
+	}
+	return false
+}
+
+// bindingConstraints maps min, max and oneof binding rules onto JSON schema keywords
+func bindingConstraints(rules []string, schema map[string]interface{}) map[string]interface{} {
+	constraints := map[string]interface{}{}
+	minKey, maxKey := "minimum", "maximum"
+	switch schema["type"] {
+	case "string":
+		minKey, maxKey = "minLength", "maxLength"
+	case "array":
+		minKey, maxKey = "minItems", "maxItems"
+	case "object":
+		minKey, maxKey = "minProperties", "maxProperties"
+	}
+
+	for _, rule := range rules {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "min", "gte", "max", "lte":
+			value, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				continue
+			}
+			if name == "min" || name == "gte" {
+				constraints[minKey] = value
+			} else {
+				constraints[maxKey] = value
+			}
+		case "oneof":
+			var enum []interface{}
+			for _, value := range strings.Fields(param) {
+				enum = append(enum, value)
+			}
+			constraints["enum"] = enum
+		}
+	}
+	if len(constraints) == 0 {
+		return nil
+	}
+	return constraints
+}
+
+// exprSchema maps a Go type expression onto a JSON schema. Named structs of the
+// package become references, other named types are inlined.
+func exprSchema(expr ast.Expr, types map[string]ast.Expr) map[string]interface{} {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return exprSchema(t.X, types)
+	case *ast.ArrayType:
+		if ident, ok := t.Elt.(*ast.Ident); ok && ident.Name == "byte" {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": exprSchema(t.Elt, types)}
+	case *ast.MapType:
+		return map[string]interface{}{"type": "object", "additionalProperties": exprSchema(t.Value, types)}
+	case *ast.InterfaceType:
+		return map[string]interface{}{}
+	case *ast.SelectorExpr:
+		switch fmt.Sprintf("%s.%s", t.X, t.Sel.Name) {
+		case "time.Time":
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		case "time.Duration":
+			return map[string]interface{}{"type": "integer", "format": "int64"}
+		}
+		return map[string]interface{}{}
+	case *ast.Ident:
+		switch t.Name {
+		case "string":
+			return map[string]interface{}{"type": "string"}
+		case "bool":
+			return map[string]interface{}{"type": "boolean"}
+		case "int", "int8", "int16", "int32", "uint", "uint8", "uint16", "uint32":
+			return map[string]interface{}{"type": "integer"}
+		case "int64", "uint64":
+			return map[string]interface{}{"type": "integer", "format": "int64"}
+		case "float32", "float64":
+			return map[string]interface{}{"type": "number"}
+		case "any":
+			return map[string]interface{}{}
+		}
+		if named, ok := types[t.Name]; ok {
+			if _, isStruct := named.(*ast.StructType); isStruct {
+				return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name}
+			}
+			return exprSchema(named, types)
+		}
+	}
+	return map[string]interface{}{}
+}
@@ -0,0 +1,34 @@
+// Package openapi embeds the OpenAPI specification and the offline API documentation page,
+// and keeps the specification in sync with the registered routes and request/response models.
+package openapi
+
+import (
+	_ "embed"
+	"io/fs"
+
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+//go:generate go run ../cmd/openapi-gen -spec openapi.json -models ../models
+
+//go:embed openapi.json
+var spec []byte
+
+//go:embed docs.html
+var docsPage []byte
+
+// Spec returns the embedded OpenAPI document
+func Spec() []byte {
+	return spec
+}
+
+// DocsPage returns the HTML template of the bundled Swagger-UI page.
+// The template expects SpecURL and AssetsURL fields.
+func DocsPage() []byte {
+	return docsPage
+}
+
+// SwaggerUIAssets returns the bundled Swagger-UI static files
+func SwaggerUIAssets() fs.FS {
+	return swaggerFiles.FS
+}
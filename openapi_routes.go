@@ -0,0 +1,34 @@
+package statemachinegin
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
+	"github.com/hussainpithawala/state-machine-amz-gin/openapi"
+)
+
+const routeTableBasePath = "/api"
+
+// APIRoutes returns the API routes registered by SetupRouter, relative to the base path.
+// Static documentation assets are not part of the API and are left out.
+func APIRoutes() []openapi.Route {
+	mode := gin.Mode()
+	gin.SetMode(gin.ReleaseMode)
+	defer gin.SetMode(mode)
+
+	router := SetupRouter(&middleware.Config{BasePath: routeTableBasePath})
+
+	var routes []openapi.Route
+	for _, info := range router.Routes() {
+		if info.Method == "HEAD" || strings.Contains(info.Path, "*") {
+			continue
+		}
+		routes = append(routes, openapi.Route{
+			Method:  info.Method,
+			Path:    strings.TrimPrefix(info.Path, routeTableBasePath),
+			Handler: info.Handler,
+		})
+	}
+	return routes
+}
@@ -0,0 +1,22 @@
+package statemachinegin
+
+import (
+	"testing"
+
+	"github.com/hussainpithawala/state-machine-amz-gin/openapi"
+	"github.com/stretchr/testify/require"
+)
+
+// TestOpenAPISpecMatchesRoutes fails when a route or model field is added or removed
+// without updating openapi/openapi.json. Run `make openapi` to resync the spec.
+func TestOpenAPISpecMatchesRoutes(t *testing.T) {
+	models, err := openapi.ParseModels("models")
+	require.NoError(t, err)
+
+	drift, err := openapi.Check(openapi.Spec(), APIRoutes(), models)
+	require.NoError(t, err)
+
+	if !drift.Empty() {
+		t.Fatalf("openapi/openapi.json is out of date, run `make openapi`:\n%s", drift.String())
+	}
+}
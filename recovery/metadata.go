@@ -0,0 +1,52 @@
+package recovery
+
+import (
+	"context"
+	"time"
+
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+)
+
+// metadataRepository reads the recovery metadata of executions from the store. The
+// repositories do not keep the metadata the recovery manager saves, so without it every
+// recovery would count as the first attempt.
+type metadataRepository struct {
+	repository.Repository
+	store *Store
+}
+
+func (r *metadataRepository) GetExecution(ctx context.Context, executionID string) (*repository.ExecutionRecord, error) {
+	record, err := r.Repository.GetExecution(ctx, executionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.withMetadata(ctx, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (r *metadataRepository) FindOrphanedExecutions(ctx context.Context, stateMachineID string, threshold time.Duration) ([]*repository.ExecutionRecord, error) {
+	records, err := r.Repository.FindOrphanedExecutions(ctx, stateMachineID, threshold)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if err := r.withMetadata(ctx, record); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// withMetadata sets the stored recovery metadata on record
+func (r *metadataRepository) withMetadata(ctx context.Context, record *repository.ExecutionRecord) error {
+	metadata, err := r.store.RecoveryMetadata(ctx, record.ExecutionID)
+	if err != nil {
+		return err
+	}
+	if metadata != nil {
+		record.RecoveryMetadata = metadata
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+// Package recovery recovers orphaned executions: executions left RUNNING by a process
+// that stopped while running them. A Scanner covers every state machine in the
+// repository, including the ones created after it started; each state machine can
+// override the scanner defaults through its metadata. Scans and the outcome of every
+// recovery attempt are kept in Redis.
+package recovery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	smrecovery "github.com/hussainpithawala/state-machine-amz-go/pkg/recovery"
+)
+
+// SettingsKey is the state machine metadata key holding its recovery settings
+const SettingsKey = "recovery"
+
+// Recovery strategies, see the state-machine-amz-go recovery package
+const (
+	StrategyRetry = string(smrecovery.StrategyRetry) // Run the execution again from its current state
+	StrategySkip  = string(smrecovery.StrategySkip)  // Continue the execution from its current state
+	StrategyFail  = string(smrecovery.StrategyFail)  // Mark the execution FAILED
+	StrategyPause = string(smrecovery.StrategyPause) // Mark the execution PAUSED for manual intervention
+)
+
+// Outcomes of a recovery attempt
+const (
+	OutcomeRecovered = "RECOVERED" // The execution was queued to run again on a worker
+	OutcomePaused    = "PAUSED"    // The execution was paused for manual intervention
+	OutcomeAbandoned = "ABANDONED" // The execution was marked FAILED, by strategy or after its last attempt
+	OutcomeError     = "ERROR"     // The attempt failed; Status tells what became of the execution
+)
+
+// Scanner defaults
+const (
+	DefaultScanInterval        = 30 * time.Second
+	DefaultOrphanedThreshold   = 5 * time.Minute
+	DefaultMaxRecoveryAttempts = 3
+	DefaultScanTimeout         = 5 * time.Minute
+)
+
+var (
+	// ErrScanInProgress is returned when the state machine is being scanned already
+	ErrScanInProgress = errors.New("a recovery scan of the state machine is in progress")
+)
+
+// Policy is how the orphaned executions of a state machine are recovered
+type Policy struct {
+	Enabled             bool          `json:"enabled"` // Whether the scanner recovers them on its own
+	OrphanedThreshold   time.Duration `json:"orphanedThreshold"`
+	Strategy            string        `json:"strategy"`
+	MaxRecoveryAttempts int           `json:"maxRecoveryAttempts"`
+}
+
+// DefaultPolicy returns the policy of state machines without settings of their own
+func DefaultPolicy() Policy {
+	return Policy{
+		Enabled:             true,
+		OrphanedThreshold:   DefaultOrphanedThreshold,
+		Strategy:            StrategyRetry,
+		MaxRecoveryAttempts: DefaultMaxRecoveryAttempts,
+	}
+}
+
+// Settings override the default policy for one state machine. Unset fields keep the
+// default.
+type Settings struct {
+	Enabled                  *bool  `json:"enabled,omitempty"`
+	OrphanedThresholdSeconds int    `json:"orphanedThresholdSeconds,omitempty"`
+	Strategy                 string `json:"strategy,omitempty"`
+	MaxRecoveryAttempts      int    `json:"maxRecoveryAttempts,omitempty"`
+}
+
+// IsZero reports whether the settings override nothing
+func (s Settings) IsZero() bool {
+	return s == Settings{}
+}
+
+// SettingsFrom reads the recovery settings stored in state machine metadata
+func SettingsFrom(metadata map[string]interface{}) (Settings, error) {
+	var settings Settings
+	raw, ok := metadata[SettingsKey]
+	if !ok || raw == nil {
+		return settings, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return settings, err
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return settings, fmt.Errorf("invalid %s: %w", SettingsKey, err)
+	}
+	return settings, nil
+}
+
+// Apply returns the policy with the settings applied
+func (p Policy) Apply(settings Settings) Policy {
+	if settings.Enabled != nil {
+		p.Enabled = *settings.Enabled
+	}
+	if settings.OrphanedThresholdSeconds > 0 {
+		p.OrphanedThreshold = time.Duration(settings.OrphanedThresholdSeconds) * time.Second
+	}
+	if settings.Strategy != "" {
+		p.Strategy = settings.Strategy
+	}
+	if settings.MaxRecoveryAttempts > 0 {
+		p.MaxRecoveryAttempts = settings.MaxRecoveryAttempts
+	}
+	return p
+}
+
+// config is the state-machine-amz-go recovery configuration of the policy for a state
+// machine. It is always enabled: whether to scan is decided by the Scanner.
+func (p Policy) config(stateMachineID string) *smrecovery.RecoveryConfig {
+	return &smrecovery.RecoveryConfig{
+		Enabled:                    true,
+		OrphanedThreshold:          p.OrphanedThreshold,
+		DefaultRecoveryStrategy:    smrecovery.RecoveryStrategy(p.Strategy),
+		DefaultMaxRecoveryAttempts: p.MaxRecoveryAttempts,
+		StateMachineID:             stateMachineID,
+	}
+}
+
+// Attempt records the recovery of an orphaned execution
+type Attempt struct {
+	ID             string    `json:"id"`
+	ExecutionID    string    `json:"executionId"`
+	StateMachineID string    `json:"stateMachineId"`
+	CurrentState   string    `json:"currentState"`
+	Strategy       string    `json:"strategy"`
+	Attempt        int       `json:"attempt"` // 1 for the first recovery of the execution
+	StartedAt      time.Time `json:"startedAt"`
+	FinishedAt     time.Time `json:"finishedAt"`
+	Outcome        string    `json:"outcome"`          // RECOVERED, PAUSED, ABANDONED or ERROR
+	Status         string    `json:"status,omitempty"` // Status of the execution after the attempt
+	Error          string    `json:"error,omitempty"`
+	Manual         bool      `json:"manual,omitempty"` // Made by a scan triggered through the API
+}
+
+// Scan records a scan of the orphaned executions of a state machine
+type Scan struct {
+	StateMachineID string    `json:"stateMachineId"`
+	StartedAt      time.Time `json:"startedAt"`
+	FinishedAt     time.Time `json:"finishedAt"`
+	Orphans        int       `json:"orphans"`
+	Attempts       []string  `json:"attempts"` // IDs of the attempts made
+	Error          string    `json:"error,omitempty"`
+	Manual         bool      `json:"manual,omitempty"`
+}
@@ -0,0 +1,283 @@
+package recovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/execution"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
+	smrecovery "github.com/hussainpithawala/state-machine-amz-go/pkg/recovery"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/statemachine/persistent"
+	"github.com/redis/go-redis/v9"
+)
+
+// TaskOption is the ExecutionTaskPayload option marking a task that runs an orphaned
+// execution again from its current state. It holds the number of the recovery attempt;
+// the payload Input is the input the state runs with.
+const TaskOption = "recover"
+
+// TaskIDPrefix prefixes the IDs of recovery tasks. The rest names the execution and the
+// attempt, so an attempt is queued once.
+const TaskIDPrefix = "recover-"
+
+// Enqueuer queues the recovered executions for a worker. *queue.Client satisfies it.
+type Enqueuer interface {
+	EnqueueExecution(payload *queue.ExecutionTaskPayload, opts ...asynq.Option) (*asynq.TaskInfo, error)
+}
+
+// TaskAttempt returns the recovery attempt a task runs, and whether it is a recovery task
+func TaskAttempt(payload *queue.ExecutionTaskPayload) (int, bool) {
+	switch attempt := payload.Options[TaskOption].(type) {
+	case int:
+		return attempt, true
+	case float64:
+		return int(attempt), true
+	}
+	return 0, false
+}
+
+// Scanner recovers the orphaned executions of every state machine in a repository.
+// Several scanners may run against the same Redis; a state machine is scanned by one of
+// them at a time, at most once per ScanInterval. Executions recovered by running them
+// again are queued for a worker rather than run by the scanner.
+type Scanner struct {
+	store       *Store
+	repoManager *repository.Manager
+	enqueuer    Enqueuer
+	instance    string
+	now         func() time.Time
+
+	Defaults     Policy             // Policy of state machines without settings of their own
+	ScanInterval time.Duration      // How often each state machine is scanned
+	ScanTimeout  time.Duration      // How long a scan may run
+	RetryPolicy  *queue.RetryPolicy // Retries and timeout of the recovery tasks, default those of queue.DefaultConfig
+}
+
+// NewScanner returns a scanner of the executions in repoManager that keeps its scans in
+// client and queues the executions it recovers on enqueuer
+func NewScanner(client *redis.Client, repoManager *repository.Manager, enqueuer Enqueuer) *Scanner {
+	return &Scanner{
+		store:        NewStore(client),
+		repoManager:  repoManager,
+		enqueuer:     enqueuer,
+		instance:     uuid.NewString(),
+		now:          time.Now,
+		Defaults:     DefaultPolicy(),
+		ScanInterval: DefaultScanInterval,
+		ScanTimeout:  DefaultScanTimeout,
+		RetryPolicy:  queue.DefaultConfig().RetryPolicy,
+	}
+}
+
+// Store returns the store of scans and recovery attempts
+func (s *Scanner) Store() *Store {
+	return s.store
+}
+
+// Run scans the state machines whose scan is due every ScanInterval. It returns when ctx
+// is done.
+func (s *Scanner) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.ScanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.ScanDue(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Warning: recovery scanner failed to list state machines: %v", err)
+		}
+	}
+}
+
+// ScanDue scans every state machine with recovery enabled that was not scanned within
+// ScanInterval. State machines are listed on every call, so new ones are covered.
+func (s *Scanner) ScanDue(ctx context.Context) error {
+	stateMachines, err := s.repoManager.ListStateMachines(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, record := range stateMachines {
+		if _, _, err := s.scan(ctx, record, false); err != nil && !errors.Is(err, ErrScanInProgress) && ctx.Err() == nil {
+			log.Printf("Warning: recovery scan of %s failed: %v", record.ID, err)
+		}
+	}
+	return nil
+}
+
+// Policy returns the recovery settings of a state machine and the policy they make
+func (s *Scanner) Policy(record *repository.StateMachineRecord) (Settings, Policy, error) {
+	settings, err := SettingsFrom(record.Metadata)
+	if err != nil {
+		return settings, Policy{}, err
+	}
+	return settings, s.Defaults.Apply(settings), nil
+}
+
+// Orphans returns the executions of a state machine its policy considers orphaned,
+// whether or not recovery is enabled
+func (s *Scanner) Orphans(ctx context.Context, record *repository.StateMachineRecord) ([]*smrecovery.OrphanedExecution, error) {
+	_, policy, err := s.Policy(record)
+	if err != nil {
+		return nil, err
+	}
+	return s.recoveryManager(record.ID, policy).FindOrphanedExecutions(ctx)
+}
+
+// recoveryManager returns the recovery manager of a state machine under its policy. It
+// counts the recovery attempts of executions in the store.
+func (s *Scanner) recoveryManager(stateMachineID string, policy Policy) *smrecovery.RecoveryManager {
+	repoManager := repository.NewManagerWithRepository(&metadataRepository{Repository: s.repoManager.GetRepository(), store: s.store})
+	return smrecovery.NewRecoveryManager(repoManager, policy.config(stateMachineID))
+}
+
+// Scan recovers the orphaned executions of a state machine now, even when its recovery
+// is disabled. It returns ErrScanInProgress while another scan of the state machine runs.
+func (s *Scanner) Scan(ctx context.Context, record *repository.StateMachineRecord) (*Scan, []*Attempt, error) {
+	return s.scan(ctx, record, true)
+}
+
+// scan recovers the orphaned executions of a state machine under its scan lock. Scans that
+// are not manual only run when recovery is enabled and the last scan is ScanInterval old.
+func (s *Scanner) scan(ctx context.Context, record *repository.StateMachineRecord, manual bool) (*Scan, []*Attempt, error) {
+	_, policy, err := s.Policy(record)
+	if err != nil || (!manual && !policy.Enabled) {
+		return nil, nil, err
+	}
+	locked, err := s.store.lock(ctx, record.ID, s.instance, s.ScanTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !locked {
+		return nil, nil, ErrScanInProgress
+	}
+	defer func() {
+		if err := s.store.unlock(context.Background(), record.ID, s.instance); err != nil {
+			log.Printf("Warning: failed to release the recovery scan lock of %s: %v", record.ID, err)
+		}
+	}()
+	if !manual {
+		last, err := s.store.LastScan(ctx, record.ID)
+		if err != nil || (last != nil && s.now().Sub(last.StartedAt) < s.ScanInterval) {
+			return nil, nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.ScanTimeout)
+	defer cancel()
+
+	scan := &Scan{StateMachineID: record.ID, StartedAt: s.now().UTC(), Attempts: []string{}, Manual: manual}
+	attempts := []*Attempt{}
+	manager := s.recoveryManager(record.ID, policy)
+	orphans, findErr := manager.FindOrphanedExecutions(ctx)
+	if findErr != nil {
+		scan.Error = findErr.Error()
+	}
+	scan.Orphans = len(orphans)
+	for _, orphan := range orphans {
+		attempt := s.recover(ctx, manager, policy, orphan, manual)
+		if err := s.store.AddAttempt(ctx, attempt); err != nil {
+			log.Printf("Warning: failed to record the recovery of execution %s: %v", orphan.ExecutionID, err)
+		}
+		attempts = append(attempts, attempt)
+		scan.Attempts = append(scan.Attempts, attempt.ID)
+	}
+	scan.FinishedAt = s.now().UTC()
+	return scan, attempts, errors.Join(findErr, s.store.SaveScan(context.Background(), scan))
+}
+
+// recover applies the policy to an orphaned execution and reports the outcome
+func (s *Scanner) recover(ctx context.Context, manager *smrecovery.RecoveryManager, policy Policy, orphan *smrecovery.OrphanedExecution, manual bool) *Attempt {
+	attempt := &Attempt{
+		ExecutionID:    orphan.ExecutionID,
+		StateMachineID: orphan.StateMachineID,
+		CurrentState:   orphan.CurrentState,
+		Strategy:       policy.Strategy,
+		Attempt:        1,
+		StartedAt:      s.now().UTC(),
+		Manual:         manual,
+	}
+	if orphan.RecoveryMetadata != nil {
+		attempt.Attempt = orphan.RecoveryMetadata.RecoveryAttemptCount + 1
+	}
+
+	queued := false
+	err := manager.RecoverExecution(ctx, orphan, func(ctx context.Context, exec *execution.Execution) (*execution.Execution, error) {
+		if err := s.enqueue(ctx, exec); err != nil {
+			return nil, err
+		}
+		queued = true
+		return exec, nil
+	})
+	attempt.FinishedAt = s.now().UTC()
+	if record, err := s.repoManager.GetExecution(ctx, orphan.ExecutionID); err == nil {
+		attempt.Status = record.Status
+	}
+
+	switch {
+	case err != nil:
+		attempt.Outcome = OutcomeError
+		attempt.Error = err.Error()
+	case queued:
+		attempt.Outcome = OutcomeRecovered
+	case attempt.Status == persistent.PAUSED:
+		attempt.Outcome = OutcomePaused
+	default:
+		attempt.Outcome = OutcomeAbandoned
+	}
+	return attempt
+}
+
+// enqueue queues an orphaned execution to run again from its current state on a worker.
+// The recovery attempt is saved in the store first; the worker only runs the task of the
+// execution's latest attempt.
+func (s *Scanner) enqueue(ctx context.Context, exec *execution.Execution) error {
+	if s.enqueuer == nil {
+		return errors.New("no queue to run the recovered execution on")
+	}
+	metadata := exec.RecoveryMetadata
+	if err := s.store.SaveRecoveryMetadata(ctx, exec.ID, &repository.RecoveryMetadata{
+		LastSuccessfulState:       metadata.LastSuccessfulState,
+		LastSuccessfulStateOutput: metadata.LastSuccessfulStateOutput,
+		RecoveryAttemptCount:      metadata.RecoveryAttemptCount,
+		LastRecoveryAttemptAt:     metadata.LastRecoveryAttemptAt,
+		MaxRecoveryAttempts:       metadata.MaxRecoveryAttempts,
+		RecoveryStrategy:          metadata.RecoveryStrategy,
+		CrashDetectedAt:           metadata.CrashDetectedAt,
+	}); err != nil {
+		return fmt.Errorf("failed to save the recovery attempt: %w", err)
+	}
+
+	_, err := s.enqueuer.EnqueueExecution(&queue.ExecutionTaskPayload{
+		StateMachineID: exec.StateMachineID,
+		ExecutionID:    exec.ID,
+		ExecutionName:  exec.Name,
+		Input:          exec.Input,
+		Options:        map[string]interface{}{TaskOption: metadata.RecoveryAttemptCount},
+	}, s.taskOptions(exec)...)
+	if err != nil {
+		return fmt.Errorf("failed to queue the recovered execution: %w", err)
+	}
+	return nil
+}
+
+// taskOptions queue the recovery of an execution on the queue of its state machine
+func (s *Scanner) taskOptions(exec *execution.Execution) []asynq.Option {
+	opts := []asynq.Option{
+		asynq.Queue(exec.StateMachineID),
+		asynq.TaskID(TaskIDPrefix + exec.ID + "-" + strconv.Itoa(exec.RecoveryMetadata.RecoveryAttemptCount)),
+	}
+	if s.RetryPolicy != nil {
+		opts = append(opts, asynq.MaxRetry(s.RetryPolicy.MaxRetry), asynq.Timeout(s.RetryPolicy.Timeout))
+	}
+	return opts
+}
@@ -0,0 +1,305 @@
+package recovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hibiken/asynq"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// orphansRepository serves state machines and executions, reporting every RUNNING
+// execution as orphaned. Like the Postgres repositories, it does not keep the recovery
+// metadata of executions.
+type orphansRepository struct {
+	repository.Repository
+	stateMachines []*repository.StateMachineRecord
+	executions    map[string]*repository.ExecutionRecord
+	history       map[string][]*repository.StateHistoryRecord
+}
+
+func (r *orphansRepository) ListStateMachines(_ context.Context, _ *repository.DefinitionFilter) ([]*repository.StateMachineRecord, error) {
+	return r.stateMachines, nil
+}
+
+func (r *orphansRepository) FindOrphanedExecutions(_ context.Context, stateMachineID string, _ time.Duration) ([]*repository.ExecutionRecord, error) {
+	var orphans []*repository.ExecutionRecord
+	for _, record := range r.executions {
+		if record.StateMachineID == stateMachineID && record.Status == "RUNNING" {
+			found := *record
+			orphans = append(orphans, &found)
+		}
+	}
+	return orphans, nil
+}
+
+func (r *orphansRepository) GetExecution(_ context.Context, id string) (*repository.ExecutionRecord, error) {
+	record, ok := r.executions[id]
+	if !ok {
+		return nil, errors.New("execution not found")
+	}
+	found := *record
+	return &found, nil
+}
+
+func (r *orphansRepository) GetStateHistory(_ context.Context, executionID string) ([]*repository.StateHistoryRecord, error) {
+	return r.history[executionID], nil
+}
+
+func (r *orphansRepository) SaveExecution(_ context.Context, record *repository.ExecutionRecord) error {
+	saved := *record
+	saved.RecoveryMetadata = nil
+	r.executions[record.ExecutionID] = &saved
+	return nil
+}
+
+// orphan records an execution left RUNNING in the Ship state of orders
+func (r *orphansRepository) orphan(executionID string) {
+	started := time.Now().Add(-time.Hour)
+	r.executions[executionID] = &repository.ExecutionRecord{
+		ExecutionID:    executionID,
+		StateMachineID: "orders",
+		Name:           "order-" + executionID,
+		Status:         "RUNNING",
+		CurrentState:   "Ship",
+		Input:          map[string]interface{}{"orderId": executionID},
+		StartTime:      &started,
+	}
+}
+
+type scannerFixture struct {
+	client    *redis.Client
+	repo      *orphansRepository
+	queue     *queue.Client
+	inspector *asynq.Inspector
+	now       time.Time
+}
+
+// newFixture runs scanners against miniredis with a real queue client, at a clock the
+// test moves
+func newFixture(t *testing.T, stateMachines ...*repository.StateMachineRecord) *scannerFixture {
+	server := miniredis.RunT(t)
+	config := queue.DefaultConfig()
+	config.RedisClientOpt = &asynq.RedisClientOpt{Addr: server.Addr()}
+	queueClient, err := queue.NewClient(config)
+	assert.NoError(t, err)
+	f := &scannerFixture{
+		client: redis.NewClient(&redis.Options{Addr: server.Addr()}),
+		repo: &orphansRepository{
+			stateMachines: stateMachines,
+			executions:    map[string]*repository.ExecutionRecord{},
+			history:       map[string][]*repository.StateHistoryRecord{},
+		},
+		queue:     queueClient,
+		inspector: asynq.NewInspector(asynq.RedisClientOpt{Addr: server.Addr()}),
+		now:       time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC),
+	}
+	t.Cleanup(func() {
+		_ = queueClient.Close()
+		_ = f.inspector.Close()
+		_ = f.client.Close()
+	})
+	return f
+}
+
+func (f *scannerFixture) scanner(enqueuer Enqueuer) *Scanner {
+	scanner := NewScanner(f.client, repository.NewManagerWithRepository(f.repo), enqueuer)
+	scanner.now = func() time.Time { return f.now }
+	return scanner
+}
+
+// task returns the payload of a recovery task queued on orders
+func (f *scannerFixture) task(t *testing.T, taskID string) *queue.ExecutionTaskPayload {
+	info, err := f.inspector.GetTaskInfo("orders", taskID)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	var payload queue.ExecutionTaskPayload
+	assert.NoError(t, json.Unmarshal(info.Payload, &payload))
+	return &payload
+}
+
+func stateMachine(id string, settings map[string]interface{}) *repository.StateMachineRecord {
+	record := &repository.StateMachineRecord{ID: id}
+	if settings != nil {
+		record.Metadata = map[string]interface{}{SettingsKey: settings}
+	}
+	return record
+}
+
+func TestScan_QueuesOrphansForTheWorker(t *testing.T) {
+	orders := stateMachine("orders", map[string]interface{}{"maxRecoveryAttempts": 2})
+	f := newFixture(t, orders)
+	f.repo.orphan("exec-1")
+	charged := time.Now().Add(-time.Hour)
+	f.repo.history["exec-1"] = []*repository.StateHistoryRecord{
+		{ExecutionID: "exec-1", StateName: "Charge", Status: "SUCCEEDED", Output: map[string]interface{}{"charged": true}, StartTime: &charged},
+	}
+	scanner := f.scanner(f.queue)
+	ctx := context.Background()
+
+	// The execution is queued to run again from its current state, on the output of the
+	// last state that succeeded, and the attempt is stored
+	scan, attempts, err := scanner.Scan(ctx, orders)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, scan.Orphans)
+	if assert.Len(t, attempts, 1) {
+		assert.Equal(t, OutcomeRecovered, attempts[0].Outcome, attempts[0].Error)
+		assert.Equal(t, "RUNNING", attempts[0].Status)
+		assert.Equal(t, 1, attempts[0].Attempt)
+	}
+	payload := f.task(t, TaskIDPrefix+"exec-1-1")
+	if assert.NotNil(t, payload) {
+		assert.Equal(t, "exec-1", payload.ExecutionID)
+		assert.Equal(t, map[string]interface{}{"charged": true}, payload.Input)
+		attempt, ok := TaskAttempt(payload)
+		assert.True(t, ok)
+		assert.Equal(t, 1, attempt)
+	}
+	metadata, err := scanner.Store().RecoveryMetadata(ctx, "exec-1")
+	if assert.NoError(t, err) && assert.NotNil(t, metadata) {
+		assert.Equal(t, 1, metadata.RecoveryAttemptCount)
+		assert.Equal(t, "Charge", metadata.LastSuccessfulState)
+	}
+	record := f.repo.executions["exec-1"]
+	assert.Nil(t, record.RecoveryMetadata)
+	assert.Equal(t, map[string]interface{}{"orderId": "exec-1"}, record.Input)
+
+	// Still orphaned, it is queued again under the next attempt
+	_, attempts, err = scanner.Scan(ctx, orders)
+	assert.NoError(t, err)
+	if assert.Len(t, attempts, 1) {
+		assert.Equal(t, OutcomeRecovered, attempts[0].Outcome)
+		assert.Equal(t, 2, attempts[0].Attempt)
+	}
+	assert.NotNil(t, f.task(t, TaskIDPrefix+"exec-1-2"))
+
+	// After its last attempt it is marked FAILED, its attempts counted in the store
+	_, attempts, err = scanner.Scan(ctx, orders)
+	assert.NoError(t, err)
+	if assert.Len(t, attempts, 1) {
+		assert.Equal(t, OutcomeAbandoned, attempts[0].Outcome)
+		assert.Equal(t, "FAILED", attempts[0].Status)
+	}
+	stored, err := scanner.Store().Attempts(ctx, "orders")
+	assert.NoError(t, err)
+	assert.Len(t, stored, 3)
+}
+
+func TestScan_Outcomes(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		enqueuer bool
+		outcome  string
+		status   string
+	}{
+		{name: "fail", strategy: StrategyFail, enqueuer: true, outcome: OutcomeAbandoned, status: "FAILED"},
+		{name: "pause", strategy: StrategyPause, enqueuer: true, outcome: OutcomePaused, status: "PAUSED"},
+		{name: "no queue", strategy: StrategyRetry, outcome: OutcomeError, status: "RUNNING"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders := stateMachine("orders", map[string]interface{}{"strategy": tt.strategy})
+			f := newFixture(t, orders)
+			f.repo.orphan("exec-1")
+			var enqueuer Enqueuer
+			if tt.enqueuer {
+				enqueuer = f.queue
+			}
+
+			_, attempts, err := f.scanner(enqueuer).Scan(context.Background(), orders)
+			assert.NoError(t, err)
+			if assert.Len(t, attempts, 1) {
+				assert.Equal(t, tt.outcome, attempts[0].Outcome)
+				assert.Equal(t, tt.status, attempts[0].Status)
+				assert.Equal(t, tt.strategy, attempts[0].Strategy)
+			}
+			_, err = f.inspector.GetTaskInfo("orders", TaskIDPrefix+"exec-1-1")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestScanDue_ScansEnabledStateMachinesEveryInterval(t *testing.T) {
+	orders := stateMachine("orders", nil)
+	reports := stateMachine("reports", map[string]interface{}{"enabled": false})
+	f := newFixture(t, orders, reports)
+	scanner := f.scanner(f.queue)
+	ctx := context.Background()
+
+	assert.NoError(t, scanner.ScanDue(ctx))
+	first, err := scanner.Store().LastScan(ctx, "orders")
+	if assert.NoError(t, err) && assert.NotNil(t, first) {
+		assert.Equal(t, f.now, first.StartedAt)
+		assert.False(t, first.Manual)
+	}
+	skipped, err := scanner.Store().LastScan(ctx, "reports")
+	assert.NoError(t, err)
+	assert.Nil(t, skipped)
+
+	// Within the interval the state machine is not scanned again, by any scanner
+	f.now = f.now.Add(scanner.ScanInterval / 2)
+	assert.NoError(t, f.scanner(f.queue).ScanDue(ctx))
+	last, err := scanner.Store().LastScan(ctx, "orders")
+	assert.NoError(t, err)
+	assert.Equal(t, first.StartedAt, last.StartedAt)
+
+	// New state machines are covered once they are listed
+	f.now = f.now.Add(scanner.ScanInterval)
+	f.repo.stateMachines = append(f.repo.stateMachines, stateMachine("invoices", nil))
+	assert.NoError(t, scanner.ScanDue(ctx))
+	for _, id := range []string{"orders", "invoices"} {
+		last, err := scanner.Store().LastScan(ctx, id)
+		if assert.NoError(t, err) && assert.NotNil(t, last) {
+			assert.Equal(t, f.now, last.StartedAt)
+		}
+	}
+}
+
+func TestScan_OneScannerAtATime(t *testing.T) {
+	orders := stateMachine("orders", nil)
+	f := newFixture(t, orders)
+	scanner := f.scanner(f.queue)
+	ctx := context.Background()
+
+	locked, err := scanner.store.lock(ctx, "orders", "another-instance", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, locked)
+	_, _, err = scanner.Scan(ctx, orders)
+	assert.ErrorIs(t, err, ErrScanInProgress)
+
+	// The lock is only released by the instance holding it
+	assert.NoError(t, scanner.store.unlock(ctx, "orders", scanner.instance))
+	_, _, err = scanner.Scan(ctx, orders)
+	assert.ErrorIs(t, err, ErrScanInProgress)
+	assert.NoError(t, scanner.store.unlock(ctx, "orders", "another-instance"))
+	scan, _, err := scanner.Scan(ctx, orders)
+	if assert.NoError(t, err) {
+		assert.True(t, scan.Manual)
+	}
+}
+
+func TestScanner_TaskOptionsFollowTheRetryPolicy(t *testing.T) {
+	orders := stateMachine("orders", nil)
+	f := newFixture(t, orders)
+	f.repo.orphan("exec-1")
+	scanner := f.scanner(f.queue)
+	scanner.RetryPolicy = &queue.RetryPolicy{MaxRetry: 1, Timeout: time.Minute}
+
+	_, _, err := scanner.Scan(context.Background(), orders)
+	assert.NoError(t, err)
+	info, err := f.inspector.GetTaskInfo("orders", TaskIDPrefix+"exec-1-1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, info.MaxRetry)
+		assert.Equal(t, time.Minute, info.Timeout)
+	}
+}
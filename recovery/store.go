@@ -0,0 +1,141 @@
+package recovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis keys, suffixed with the state machine ID. The attempts of a state machine are a
+// list, newest first, capped at MaxAttempts; the last scan is a JSON string; the lock is
+// held by the scanner instance scanning the state machine. The recovery metadata of an
+// execution is a JSON string under its execution ID, as repositories do not keep it.
+const (
+	attemptsKeyPrefix = "state-machine:recovery:attempts:"
+	scanKeyPrefix     = "state-machine:recovery:scan:"
+	lockKeyPrefix     = "state-machine:recovery:lock:"
+	metadataKeyPrefix = "state-machine:recovery:execution:"
+)
+
+// metadataTTL bounds how long the recovery metadata of an execution is kept after its
+// last recovery attempt
+const metadataTTL = 30 * 24 * time.Hour
+
+// MaxAttempts is how many recovery attempts are kept per state machine
+const MaxAttempts = 1000
+
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// Store keeps scans and recovery attempts in Redis
+type Store struct {
+	client *redis.Client
+}
+
+// NewStore returns a recovery store over client
+func NewStore(client *redis.Client) *Store {
+	return &Store{client: client}
+}
+
+// AddAttempt assigns an ID to an attempt and records it
+func (s *Store) AddAttempt(ctx context.Context, attempt *Attempt) error {
+	attempt.ID = uuid.NewString()
+	data, err := json.Marshal(attempt)
+	if err != nil {
+		return err
+	}
+	key := attemptsKeyPrefix + attempt.StateMachineID
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, key, data)
+		pipe.LTrim(ctx, key, 0, MaxAttempts-1)
+		return nil
+	})
+	return err
+}
+
+// Attempts returns the recovery attempts of a state machine, newest first
+func (s *Store) Attempts(ctx context.Context, stateMachineID string) ([]*Attempt, error) {
+	values, err := s.client.LRange(ctx, attemptsKeyPrefix+stateMachineID, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	attempts := make([]*Attempt, 0, len(values))
+	for _, value := range values {
+		var attempt Attempt
+		if err := json.Unmarshal([]byte(value), &attempt); err != nil {
+			return nil, fmt.Errorf("invalid recovery attempt of %s: %w", stateMachineID, err)
+		}
+		attempts = append(attempts, &attempt)
+	}
+	return attempts, nil
+}
+
+// SaveScan records the last scan of a state machine
+func (s *Store) SaveScan(ctx context.Context, scan *Scan) error {
+	data, err := json.Marshal(scan)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, scanKeyPrefix+scan.StateMachineID, data, 0).Err()
+}
+
+// LastScan returns the last scan of a state machine, or nil if it was never scanned
+func (s *Store) LastScan(ctx context.Context, stateMachineID string) (*Scan, error) {
+	data, err := s.client.Get(ctx, scanKeyPrefix+stateMachineID).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var scan Scan
+	if err := json.Unmarshal(data, &scan); err != nil {
+		return nil, fmt.Errorf("invalid recovery scan of %s: %w", stateMachineID, err)
+	}
+	return &scan, nil
+}
+
+// SaveRecoveryMetadata records the recovery metadata of an execution
+func (s *Store) SaveRecoveryMetadata(ctx context.Context, executionID string, metadata *repository.RecoveryMetadata) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, metadataKeyPrefix+executionID, data, metadataTTL).Err()
+}
+
+// RecoveryMetadata returns the recovery metadata of an execution, or nil if it was never
+// recovered
+func (s *Store) RecoveryMetadata(ctx context.Context, executionID string) (*repository.RecoveryMetadata, error) {
+	data, err := s.client.Get(ctx, metadataKeyPrefix+executionID).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var metadata repository.RecoveryMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("invalid recovery metadata of %s: %w", executionID, err)
+	}
+	return &metadata, nil
+}
+
+// lock takes the scan lock of a state machine for owner, reporting whether it did
+func (s *Store) lock(ctx context.Context, stateMachineID, owner string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, lockKeyPrefix+stateMachineID, owner, ttl).Result()
+}
+
+// unlock releases the scan lock of a state machine if owner holds it
+func (s *Store) unlock(ctx context.Context, stateMachineID, owner string) error {
+	return unlockScript.Run(ctx, s.client, []string{lockKeyPrefix + stateMachineID}, owner).Err()
+}
@@ -1,9 +1,12 @@
 package statemachinegin
 
 import (
+	"net/http"
+
 	"github.com/gin-gonic/gin"
 	"github.com/hussainpithawala/state-machine-amz-gin/handlers"
 	"github.com/hussainpithawala/state-machine-amz-gin/middleware"
+	"github.com/hussainpithawala/state-machine-amz-gin/openapi"
 )
 
 // SetupRouter sets up the Gin router with all state machine endpoints
@@ -11,6 +14,7 @@ func SetupRouter(config *middleware.Config) *gin.Engine {
 	router := gin.Default()
 
 	// Apply global middleware
+	router.Use(middleware.RequestID())
 	router.Use(middleware.ErrorHandler())
 	router.Use(middleware.CORSMiddleware())
 	router.Use(middleware.StateMachineMiddleware(config))
@@ -25,30 +29,41 @@ func SetupRouter(config *middleware.Config) *gin.Engine {
 	{
 		// Health & Monitoring
 		api.GET("/health", handlers.HealthCheck)
+		api.GET("/health/live", handlers.LivenessCheck)
+		api.GET("/health/ready", handlers.ReadinessCheck)
 		// api.GET("/queue/stats", handlers.GetQueueStats)
 
 		// API Documentation
 		api.GET("/openapi.json", handlers.GetOpenAPISpec)
+		api.GET("/docs", handlers.GetAPIDocs)
+		api.StaticFS("/docs/assets", http.FS(openapi.SwaggerUIAssets()))
 
 		// State Machine Management
 		api.POST("/state-machines", handlers.CreateStateMachine)
 		api.GET("/state-machines/:stateMachineId", handlers.GetStateMachine)
 		api.GET("/state-machines", handlers.ListStateMachines)
+		api.PUT("/state-machines/:stateMachineId/schemas", handlers.UpdateStateMachineSchemas)
+		api.PUT("/state-machines/:stateMachineId/search-attributes", handlers.UpdateSearchAttributes)
+		api.PUT("/state-machines/:stateMachineId/wait-timeouts", handlers.UpdateWaitTimeouts)
 
 		// Execution Management
 		api.POST("/state-machines/:stateMachineId/executions", handlers.StartExecution)
 		api.GET("/state-machines/:stateMachineId/executions", handlers.ListExecutions)
 		api.GET("/transformers", handlers.ListTransformers)
 		api.GET("/state-machines/:stateMachineId/executions/count", handlers.CountExecutions)
+		api.GET("/executions", handlers.SearchExecutions)
 		api.GET("/executions/:executionId", handlers.GetExecution)
 		api.DELETE("/executions/:executionId", handlers.StopExecution)
 		api.GET("/executions/:executionId/history", handlers.GetExecutionHistory)
+		api.PATCH("/executions/:executionId/tags", handlers.UpdateExecutionTags)
 
 		// Batch Execution
 		api.POST("/state-machines/:stateMachineId/executions/batch", handlers.ExecuteBatch)
 		api.GET("/batch/:batchId/status", handlers.GetBatchStatus)
+		api.GET("/batch/:batchId/results", handlers.GetBatchResults)
 		api.POST("/batch/:batchId/pause", handlers.PauseBatchExecution)
 		api.POST("/batch/:batchId/resume", handlers.ResumeBatchExecution)
+		api.POST("/batch/:batchId/retry-failed", handlers.RetryFailedBatch)
 		api.DELETE("/batch/:batchId", handlers.CancelBatchExecution)
 		api.GET("/batch", handlers.ListBatches)
 		api.POST("/queue/enqueue", handlers.EnqueueExecution)
@@ -56,18 +71,71 @@ func SetupRouter(config *middleware.Config) *gin.Engine {
 		// Bulk Execution with Orchestration
 		api.POST("/state-machines/:stateMachineId/executions/bulk", handlers.ExecuteBulk)
 		api.POST("/state-machines/:stateMachineId/executions/bulk-form", handlers.ExecuteBulkForm)
+		api.POST("/state-machines/:stateMachineId/executions/bulk-stream", handlers.ExecuteBulkStream)
 		api.GET("/bulk/:orchestratorId/status", handlers.GetBulkStatus)
+		api.GET("/bulk/:orchestratorId/results", handlers.GetBulkResults)
 		api.POST("/bulk/:orchestratorId/pause", handlers.PauseBulkExecution)
 		api.POST("/bulk/:orchestratorId/resume", handlers.ResumeBulkExecution)
+		api.POST("/bulk/:orchestratorId/retry-failed", handlers.RetryFailedBulk)
 		api.DELETE("/bulk/:orchestratorId", handlers.CancelBulkExecution)
 		api.GET("/bulk", handlers.ListBulkExecutions)
 
+		// Datasets
+		api.POST("/datasets", handlers.CreateDataset)
+		api.GET("/datasets", handlers.ListDatasets)
+		api.GET("/datasets/:datasetId", handlers.GetDataset)
+		api.DELETE("/datasets/:datasetId", handlers.DeleteDataset)
+		api.PUT("/datasets/:datasetId/chunks/:index", handlers.UploadDatasetChunk)
+		api.POST("/datasets/:datasetId/complete", handlers.CompleteDataset)
+		api.GET("/datasets/:datasetId/preview", handlers.PreviewDataset)
+
+		// Schedules
+		api.POST("/schedules", handlers.CreateSchedule)
+		api.GET("/schedules", handlers.ListSchedules)
+		api.GET("/schedules/:scheduleId", handlers.GetSchedule)
+		api.DELETE("/schedules/:scheduleId", handlers.DeleteSchedule)
+		api.POST("/schedules/:scheduleId/pause", handlers.PauseSchedule)
+		api.POST("/schedules/:scheduleId/resume", handlers.ResumeSchedule)
+		api.POST("/schedules/:scheduleId/trigger", handlers.TriggerSchedule)
+
+		// Activities run by external workers
+		api.POST("/activities", handlers.CreateActivity)
+		api.GET("/activities", handlers.ListActivities)
+		api.GET("/activities/:name", handlers.GetActivity)
+		api.DELETE("/activities/:name", handlers.DeleteActivity)
+		api.GET("/activities/:name/poll", handlers.PollActivityTask)
+		api.POST("/activities/tasks/:token/success", handlers.SendActivityTaskSuccess)
+		api.POST("/activities/tasks/:token/failure", handlers.SendActivityTaskFailure)
+		api.POST("/activities/tasks/:token/heartbeat", handlers.SendActivityTaskHeartbeat)
+
+		// Callbacks for Tasks waiting on a task token
+		api.POST("/tasks/:token/success", handlers.SendTaskSuccess)
+		api.POST("/tasks/:token/failure", handlers.SendTaskFailure)
+
+		// Delayed executions that have not started
+		api.GET("/state-machines/:stateMachineId/scheduled-executions", handlers.ListScheduledExecutions)
+		api.GET("/state-machines/:stateMachineId/scheduled-executions/:executionName", handlers.GetScheduledExecution)
+		api.DELETE("/state-machines/:stateMachineId/scheduled-executions/:executionName", handlers.CancelScheduledExecution)
+		api.POST("/state-machines/:stateMachineId/scheduled-executions/:executionName/reschedule", handlers.RescheduleExecution)
+
 		// Message/Resume
 		api.POST("/executions/:executionId/resume", handlers.ResumeExecution)
 		api.POST("/state-machines/:stateMachineId/resume-by-correlation", handlers.ResumeByCorrelation)
 		api.GET("/state-machines/:stateMachineId/waiting", handlers.FindWaitingExecutions)
+		api.GET("/state-machines/:stateMachineId/waiting/expiring", handlers.ListExpiringExecutions)
+		api.GET("/state-machines/:stateMachineId/messages", handlers.ListMessages)
+		api.GET("/state-machines/:stateMachineId/messages/:messageId", handlers.GetMessage)
+		api.DELETE("/state-machines/:stateMachineId/messages/:messageId", handlers.DeleteMessage)
+		api.POST("/state-machines/:stateMachineId/messages/:messageId/redeliver", handlers.RedeliverMessage)
 		api.POST("/orchestrator/resume", handlers.ResumeOrchestrator)
 
+		// Recovery of orphaned executions
+		api.GET("/state-machines/:stateMachineId/recovery", handlers.GetRecoveryConfig)
+		api.PUT("/state-machines/:stateMachineId/recovery", handlers.UpdateRecoveryConfig)
+		api.GET("/state-machines/:stateMachineId/recovery/orphans", handlers.ListOrphanedExecutions)
+		api.POST("/state-machines/:stateMachineId/recovery/scan", handlers.TriggerRecoveryScan)
+		api.GET("/state-machines/:stateMachineId/recovery/attempts", handlers.ListRecoveryAttempts)
+
 		// Batch Streaming Control (Redis Signaling)
 		api.POST("/batch/resume/signal", handlers.SignalResume)
 		api.POST("/batch/resume/revoke", handlers.RevokeResume)
@@ -0,0 +1,156 @@
+// Package schedules starts state machine executions on a cron expression or a fixed
+// interval. Schedules are kept in Redis and fired by a Scheduler; when several workers
+// run one, the instance holding the Redis lease fires them.
+package schedules
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Overlap policies decide what happens when a schedule fires while the execution it
+// started last is still running
+const (
+	OverlapSkip           = "skip"            // Do not start a new execution
+	OverlapAllow          = "allow"           // Start a new execution alongside it
+	OverlapCancelPrevious = "cancel_previous" // Mark the running execution CANCELLED and start a new one
+)
+
+// Outcomes of a run
+const (
+	OutcomeStarted = "STARTED"
+	OutcomeSkipped = "SKIPPED"
+	OutcomeFailed  = "FAILED"
+)
+
+// ErrNotFound is returned when a schedule does not exist
+var ErrNotFound = errors.New("schedule not found")
+
+// Schedule starts executions of a state machine
+type Schedule struct {
+	ID              string      `json:"id"`
+	Name            string      `json:"name,omitempty"`
+	StateMachineID  string      `json:"stateMachineId"`
+	Cron            string      `json:"cron,omitempty"`            // Standard 5-field expression or descriptor such as @daily
+	IntervalSeconds int         `json:"intervalSeconds,omitempty"` // Fixed interval, when Cron is empty
+	TimeZone        string      `json:"timeZone,omitempty"`        // IANA zone the cron expression is evaluated in, default UTC
+	Input           interface{} `json:"input,omitempty"`           // Input template, see Render
+	OverlapPolicy   string      `json:"overlapPolicy"`
+	Paused          bool        `json:"paused"`
+	CreatedAt       time.Time   `json:"createdAt"`
+	UpdatedAt       time.Time   `json:"updatedAt"`
+	NextRunAt       *time.Time  `json:"nextRunAt,omitempty"` // Unset while paused
+	LastRun         *Run        `json:"lastRun,omitempty"`
+}
+
+// Run records a firing of a schedule
+type Run struct {
+	ScheduledAt   time.Time `json:"scheduledAt"`
+	FiredAt       time.Time `json:"firedAt"`
+	Outcome       string    `json:"outcome"` // STARTED, SKIPPED or FAILED
+	ExecutionName string    `json:"executionName,omitempty"`
+	Manual        bool      `json:"manual,omitempty"` // Fired with Trigger rather than on schedule
+	Reason        string    `json:"reason,omitempty"` // Why the run was skipped or failed
+}
+
+// Location returns the time zone of the schedule
+func (s *Schedule) Location() (*time.Location, error) {
+	if s.TimeZone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(s.TimeZone)
+}
+
+// Spec parses the timing of the schedule
+func (s *Schedule) Spec() (cron.Schedule, error) {
+	switch {
+	case s.Cron != "" && s.IntervalSeconds > 0:
+		return nil, errors.New("set either cron or intervalSeconds, not both")
+	case s.Cron != "":
+		if strings.HasPrefix(s.Cron, "TZ=") || strings.HasPrefix(s.Cron, "CRON_TZ=") {
+			return nil, errors.New("set the time zone with timeZone")
+		}
+		spec, err := cron.ParseStandard(s.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression: %w", err)
+		}
+		return spec, nil
+	case s.IntervalSeconds > 0:
+		return cron.Every(time.Duration(s.IntervalSeconds) * time.Second), nil
+	default:
+		return nil, errors.New("set cron or intervalSeconds")
+	}
+}
+
+// Next returns the first time after t the schedule fires
+func (s *Schedule) Next(t time.Time) (time.Time, error) {
+	spec, err := s.Spec()
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := s.Location()
+	if err != nil {
+		return time.Time{}, err
+	}
+	next := spec.Next(t.In(loc))
+	if next.IsZero() {
+		return time.Time{}, errors.New("the cron expression never fires")
+	}
+	return next.UTC(), nil
+}
+
+// ExecutionName names the execution of a run. Scheduled runs are named after their
+// scheduled time, so the queue rejects a second execution of the same run.
+func (s *Schedule) ExecutionName(scheduledAt time.Time, manual bool) string {
+	if manual {
+		return s.ID + "-manual-" + strconv.FormatInt(scheduledAt.UnixMilli(), 10)
+	}
+	return s.ID + "-" + strconv.FormatInt(scheduledAt.Unix(), 10)
+}
+
+// Render returns the input of a run. String values of the template may contain the
+// placeholders {{scheduleId}}, {{executionName}}, {{scheduledTime}} (RFC 3339, in the
+// time zone of the schedule), {{scheduledUnix}} and {{scheduledDate}} (YYYY-MM-DD).
+func (s *Schedule) Render(scheduledAt time.Time, executionName string) interface{} {
+	if s.Input == nil {
+		return nil
+	}
+	local := scheduledAt
+	if loc, err := s.Location(); err == nil {
+		local = scheduledAt.In(loc)
+	}
+	replacer := strings.NewReplacer(
+		"{{scheduleId}}", s.ID,
+		"{{executionName}}", executionName,
+		"{{scheduledTime}}", local.Format(time.RFC3339),
+		"{{scheduledUnix}}", strconv.FormatInt(scheduledAt.Unix(), 10),
+		"{{scheduledDate}}", local.Format("2006-01-02"),
+	)
+	return render(s.Input, replacer)
+}
+
+func render(value interface{}, replacer *strings.Replacer) interface{} {
+	switch v := value.(type) {
+	case string:
+		return replacer.Replace(v)
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			rendered[key] = render(item, replacer)
+		}
+		return rendered
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, item := range v {
+			rendered[i] = render(item, replacer)
+		}
+		return rendered
+	default:
+		return v
+	}
+}
@@ -0,0 +1,231 @@
+package schedules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"github.com/redis/go-redis/v9"
+)
+
+// leaseKey is the Redis key holding the ID of the scheduler instance that fires schedules
+const leaseKey = "state-machine:schedules:leader"
+
+// Scheduler defaults
+const (
+	DefaultPollInterval = time.Second
+	DefaultLeaseTTL     = 15 * time.Second
+)
+
+var renewLease = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+var releaseLease = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// Enqueuer queues the executions schedules start. *queue.Client satisfies it.
+type Enqueuer interface {
+	EnqueueExecution(payload *queue.ExecutionTaskPayload, opts ...asynq.Option) (*asynq.TaskInfo, error)
+}
+
+// Scheduler fires due schedules by enqueuing an execution for each run. Several
+// schedulers may run against the same Redis; only the one holding the lease fires.
+type Scheduler struct {
+	store       *Store
+	client      *redis.Client
+	repoManager *repository.Manager
+	enqueuer    Enqueuer
+	instance    string
+	now         func() time.Time
+
+	PollInterval time.Duration      // How often due schedules are looked for
+	LeaseTTL     time.Duration      // How long the lease outlives an instance that stopped renewing it
+	RetryPolicy  *queue.RetryPolicy // Retries and timeout of the execution tasks, default those of queue.DefaultConfig
+}
+
+// NewScheduler returns a scheduler for the schedules stored in client. The repository
+// is used to look up the previous execution of a schedule for its overlap policy.
+func NewScheduler(client *redis.Client, repoManager *repository.Manager, enqueuer Enqueuer) *Scheduler {
+	return &Scheduler{
+		store:        NewStore(client),
+		client:       client,
+		repoManager:  repoManager,
+		enqueuer:     enqueuer,
+		instance:     uuid.NewString(),
+		now:          time.Now,
+		PollInterval: DefaultPollInterval,
+		LeaseTTL:     DefaultLeaseTTL,
+		RetryPolicy:  queue.DefaultConfig().RetryPolicy,
+	}
+}
+
+// Run fires due schedules every PollInterval while this instance holds the lease. It
+// returns, releasing the lease, when ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+	defer func() {
+		_ = releaseLease.Run(context.Background(), s.client, []string{leaseKey}, s.instance).Err()
+	}()
+
+	for {
+		leader, err := s.acquire(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Warning: scheduler failed to acquire its lease: %v", err)
+		}
+		if leader {
+			if err := s.FireDue(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Warning: scheduler failed to fire schedules: %v", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// acquire takes the lease, or renews it if this instance holds it
+func (s *Scheduler) acquire(ctx context.Context) (bool, error) {
+	acquired, err := s.client.SetNX(ctx, leaseKey, s.instance, s.LeaseTTL).Result()
+	if err != nil || acquired {
+		return acquired, err
+	}
+	renewed, err := renewLease.Run(ctx, s.client, []string{leaseKey}, s.instance, s.LeaseTTL.Milliseconds()).Int()
+	return renewed == 1, err
+}
+
+// FireDue fires the schedules whose next run is due. A schedule that was due several
+// times, for instance while no scheduler was running, fires once.
+func (s *Scheduler) FireDue(ctx context.Context) error {
+	schedules, err := s.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	now := s.now()
+	for _, schedule := range schedules {
+		if schedule.Paused || schedule.NextRunAt == nil || schedule.NextRunAt.After(now) {
+			continue
+		}
+		if _, err := s.fire(ctx, schedule, *schedule.NextRunAt, false); err != nil && !errors.Is(err, ErrNotFound) {
+			log.Printf("Warning: failed to record the run of schedule %s: %v", schedule.ID, err)
+		}
+	}
+	return nil
+}
+
+// Trigger fires a schedule now, even while it is paused. The next scheduled run is
+// not affected.
+func (s *Scheduler) Trigger(ctx context.Context, id string) (*Run, error) {
+	schedule, err := s.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.fire(ctx, schedule, s.now(), true)
+}
+
+// fire starts the run of a schedule and records it as the last run. Scheduled runs
+// also move the next run past now.
+func (s *Scheduler) fire(ctx context.Context, schedule *Schedule, scheduledAt time.Time, manual bool) (*Run, error) {
+	run := &Run{ScheduledAt: scheduledAt.UTC(), FiredAt: s.now().UTC(), Manual: manual}
+	s.start(ctx, schedule, run)
+
+	_, err := s.store.Update(ctx, schedule.ID, func(stored *Schedule) error {
+		stored.LastRun = run
+		if manual || stored.Paused {
+			return nil
+		}
+		next, err := stored.Next(run.FiredAt)
+		if err != nil {
+			stored.NextRunAt = nil
+			return nil
+		}
+		stored.NextRunAt = &next
+		return nil
+	})
+	return run, err
+}
+
+// start applies the overlap policy and enqueues the execution of a run
+func (s *Scheduler) start(ctx context.Context, schedule *Schedule, run *Run) {
+	if previous := schedule.LastRun; schedule.OverlapPolicy != OverlapAllow && previous != nil && previous.Outcome == OutcomeStarted {
+		record, err := s.repoManager.GetExecutionByName(ctx, schedule.StateMachineID, previous.ExecutionName)
+		switch {
+		case err != nil && !strings.Contains(strings.ToLower(err.Error()), "not found"):
+			run.Outcome = OutcomeFailed
+			run.Reason = fmt.Sprintf("failed to look up execution %s: %v", previous.ExecutionName, err)
+			return
+		case err == nil && active(record.Status):
+			if schedule.OverlapPolicy != OverlapCancelPrevious {
+				run.Outcome = OutcomeSkipped
+				run.Reason = fmt.Sprintf("execution %s is still %s", previous.ExecutionName, record.Status)
+				return
+			}
+			if err := s.cancel(ctx, schedule, record); err != nil {
+				run.Outcome = OutcomeFailed
+				run.Reason = fmt.Sprintf("failed to cancel execution %s: %v", previous.ExecutionName, err)
+				return
+			}
+		}
+	}
+
+	name := schedule.ExecutionName(run.ScheduledAt, run.Manual)
+	_, err := s.enqueuer.EnqueueExecution(&queue.ExecutionTaskPayload{
+		StateMachineID: schedule.StateMachineID,
+		ExecutionName:  name,
+		Input:          schedule.Render(run.ScheduledAt, name),
+	}, s.taskOptions(schedule, name)...)
+	// A conflicting task ID means the run was enqueued already, by an earlier lease holder
+	if err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+		run.Outcome = OutcomeFailed
+		run.Reason = err.Error()
+		return
+	}
+	run.Outcome = OutcomeStarted
+	run.ExecutionName = name
+}
+
+// taskOptions are the options of the execution task of a run. The task is identified by
+// the name of the execution, so a run is queued once whichever instance fires it.
+func (s *Scheduler) taskOptions(schedule *Schedule, executionName string) []asynq.Option {
+	opts := []asynq.Option{asynq.Queue(schedule.StateMachineID), asynq.TaskID(executionName)}
+	if s.RetryPolicy != nil {
+		opts = append(opts, asynq.MaxRetry(s.RetryPolicy.MaxRetry), asynq.Timeout(s.RetryPolicy.Timeout))
+	}
+	return opts
+}
+
+// cancel marks a running execution CANCELLED. Like stopping an execution through the
+// API, a step already in progress is not interrupted.
+func (s *Scheduler) cancel(ctx context.Context, schedule *Schedule, record *repository.ExecutionRecord) error {
+	endTime := s.now().UTC()
+	cancelled := *record
+	cancelled.Status = "CANCELLED"
+	cancelled.EndTime = &endTime
+	cancelled.Error = fmt.Sprintf("cancelled by schedule %s", schedule.ID)
+	return s.repoManager.GetRepository().SaveExecution(ctx, &cancelled)
+}
+
+// active reports whether an execution with this status has not finished
+func active(status string) bool {
+	switch status {
+	case "RUNNING", "PAUSED", "WAITING":
+		return true
+	}
+	return false
+}
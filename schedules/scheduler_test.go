@@ -0,0 +1,249 @@
+package schedules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hibiken/asynq"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/queue"
+	"github.com/hussainpithawala/state-machine-amz-go/pkg/repository"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// executionsRepository serves executions by name and records the executions saved
+type executionsRepository struct {
+	repository.Repository
+	executions map[string]*repository.ExecutionRecord
+	saved      []*repository.ExecutionRecord
+}
+
+func (r *executionsRepository) GetExecutionByName(_ context.Context, _, name string) (*repository.ExecutionRecord, error) {
+	record, ok := r.executions[name]
+	if !ok {
+		return nil, errors.New("execution not found")
+	}
+	return record, nil
+}
+
+func (r *executionsRepository) SaveExecution(_ context.Context, record *repository.ExecutionRecord) error {
+	r.saved = append(r.saved, record)
+	return nil
+}
+
+type schedulerFixture struct {
+	redis     *miniredis.Miniredis
+	client    *redis.Client
+	repo      *executionsRepository
+	queue     *queue.Client
+	inspector *asynq.Inspector
+	now       time.Time
+}
+
+// newFixture runs schedulers against miniredis with a real queue client, at a clock the
+// test moves
+func newFixture(t *testing.T) *schedulerFixture {
+	server := miniredis.RunT(t)
+	config := queue.DefaultConfig()
+	config.RedisClientOpt = &asynq.RedisClientOpt{Addr: server.Addr()}
+	queueClient, err := queue.NewClient(config)
+	assert.NoError(t, err)
+	f := &schedulerFixture{
+		redis:     server,
+		client:    redis.NewClient(&redis.Options{Addr: server.Addr()}),
+		repo:      &executionsRepository{executions: map[string]*repository.ExecutionRecord{}},
+		queue:     queueClient,
+		inspector: asynq.NewInspector(asynq.RedisClientOpt{Addr: server.Addr()}),
+		now:       time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC),
+	}
+	t.Cleanup(func() {
+		_ = queueClient.Close()
+		_ = f.inspector.Close()
+		_ = f.client.Close()
+	})
+	return f
+}
+
+func (f *schedulerFixture) scheduler() *Scheduler {
+	scheduler := NewScheduler(f.client, repository.NewManagerWithRepository(f.repo), f.queue)
+	scheduler.now = func() time.Time { return f.now }
+	scheduler.store.now = scheduler.now
+	return scheduler
+}
+
+func (f *schedulerFixture) create(t *testing.T, schedule *Schedule) *Schedule {
+	assert.NoError(t, f.scheduler().store.Create(context.Background(), schedule))
+	return schedule
+}
+
+// queued returns the execution names of the tasks pending on a queue
+func (f *schedulerFixture) queued(t *testing.T, queueName string) []string {
+	tasks, err := f.inspector.ListPendingTasks(queueName)
+	if errors.Is(err, asynq.ErrQueueNotFound) {
+		return nil
+	}
+	assert.NoError(t, err)
+	names := make([]string, len(tasks))
+	for i, task := range tasks {
+		var payload queue.ExecutionTaskPayload
+		assert.NoError(t, json.Unmarshal(task.Payload, &payload))
+		names[i] = payload.ExecutionName
+	}
+	return names
+}
+
+func TestFireDue_EnqueuesDueRunsOnce(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	due := f.create(t, &Schedule{StateMachineID: "reports", IntervalSeconds: 60, OverlapPolicy: OverlapAllow,
+		Input: map[string]interface{}{"run": "{{executionName}}"}})
+	paused := f.create(t, &Schedule{StateMachineID: "reports", IntervalSeconds: 60, OverlapPolicy: OverlapAllow, Paused: true})
+	later := f.create(t, &Schedule{StateMachineID: "reports", Cron: "0 0 * * *", OverlapPolicy: OverlapAllow})
+
+	// Five runs were missed; the schedule fires once, for the first of them
+	scheduledAt := *due.NextRunAt
+	f.now = f.now.Add(5*time.Minute + time.Second)
+	scheduler := f.scheduler()
+	assert.NoError(t, scheduler.FireDue(ctx))
+
+	name := due.ExecutionName(scheduledAt, false)
+	assert.Equal(t, []string{name}, f.queued(t, "reports"))
+	info, err := f.inspector.GetTaskInfo("reports", name)
+	if assert.NoError(t, err) {
+		assert.Equal(t, queue.DefaultConfig().RetryPolicy.MaxRetry, info.MaxRetry)
+		var payload queue.ExecutionTaskPayload
+		assert.NoError(t, json.Unmarshal(info.Payload, &payload))
+		assert.Equal(t, map[string]interface{}{"run": name}, payload.Input)
+	}
+
+	stored, err := scheduler.store.Get(ctx, due.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, &Run{ScheduledAt: scheduledAt, FiredAt: f.now, Outcome: OutcomeStarted, ExecutionName: name}, stored.LastRun)
+	assert.Equal(t, f.now.Add(time.Minute), *stored.NextRunAt)
+
+	for _, id := range []string{paused.ID, later.ID} {
+		stored, err := scheduler.store.Get(ctx, id)
+		assert.NoError(t, err)
+		assert.Nil(t, stored.LastRun)
+	}
+
+	// An instance that fires the same run again, such as a lease holder that lost its
+	// lease mid-run, finds the task queued already
+	_, err = scheduler.store.Update(ctx, due.ID, func(stored *Schedule) error {
+		stored.NextRunAt = &scheduledAt
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, f.scheduler().FireDue(ctx))
+	assert.Equal(t, []string{name}, f.queued(t, "reports"))
+	stored, err = scheduler.store.Get(ctx, due.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, OutcomeStarted, stored.LastRun.Outcome)
+}
+
+func TestScheduler_TaskOptionsFollowTheRetryPolicy(t *testing.T) {
+	f := newFixture(t)
+	schedule := f.create(t, &Schedule{StateMachineID: "reports", IntervalSeconds: 60, OverlapPolicy: OverlapAllow, Paused: true})
+
+	scheduler := f.scheduler()
+	scheduler.RetryPolicy = &queue.RetryPolicy{MaxRetry: 1, Timeout: time.Minute}
+	run, err := scheduler.Trigger(context.Background(), schedule.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, OutcomeStarted, run.Outcome)
+	assert.True(t, run.Manual)
+
+	info, err := f.inspector.GetTaskInfo("reports", run.ExecutionName)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, info.MaxRetry)
+		assert.Equal(t, time.Minute, info.Timeout)
+	}
+}
+
+func TestFireDue_OverlapPolicies(t *testing.T) {
+	tests := []struct {
+		policy    string
+		status    string
+		outcome   string
+		cancelled bool
+	}{
+		{policy: OverlapSkip, status: "RUNNING", outcome: OutcomeSkipped},
+		{policy: OverlapSkip, status: "SUCCEEDED", outcome: OutcomeStarted},
+		{policy: OverlapAllow, status: "RUNNING", outcome: OutcomeStarted},
+		{policy: OverlapCancelPrevious, status: "PAUSED", outcome: OutcomeStarted, cancelled: true},
+		{policy: OverlapCancelPrevious, status: "FAILED", outcome: OutcomeStarted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy+" "+tt.status, func(t *testing.T) {
+			f := newFixture(t)
+			ctx := context.Background()
+			schedule := f.create(t, &Schedule{StateMachineID: "reports", IntervalSeconds: 60, OverlapPolicy: tt.policy})
+			f.now = f.now.Add(time.Minute)
+			assert.NoError(t, f.scheduler().FireDue(ctx))
+			first := schedule.ExecutionName(*schedule.NextRunAt, false)
+			f.repo.executions[first] = &repository.ExecutionRecord{ExecutionID: "e1", Name: first, Status: tt.status}
+
+			f.now = f.now.Add(time.Minute)
+			scheduler := f.scheduler()
+			assert.NoError(t, scheduler.FireDue(ctx))
+
+			stored, err := scheduler.store.Get(ctx, schedule.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.outcome, stored.LastRun.Outcome, stored.LastRun.Reason)
+			if tt.outcome == OutcomeSkipped {
+				assert.Equal(t, "execution "+first+" is still RUNNING", stored.LastRun.Reason)
+				assert.Len(t, f.queued(t, "reports"), 1)
+			} else {
+				assert.Len(t, f.queued(t, "reports"), 2)
+			}
+			if tt.cancelled {
+				if assert.Len(t, f.repo.saved, 1) {
+					assert.Equal(t, "CANCELLED", f.repo.saved[0].Status)
+					assert.Equal(t, "cancelled by schedule "+schedule.ID, f.repo.saved[0].Error)
+				}
+			} else {
+				assert.Empty(t, f.repo.saved)
+			}
+		})
+	}
+}
+
+func TestScheduler_Lease(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	first, second := f.scheduler(), f.scheduler()
+
+	leader, err := first.acquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, leader)
+	leader, err = second.acquire(ctx)
+	assert.NoError(t, err)
+	assert.False(t, leader)
+
+	// The holder renews its lease; once it stops, the lease passes on when it expires
+	f.redis.FastForward(DefaultLeaseTTL - time.Second)
+	leader, err = first.acquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, leader)
+	f.redis.FastForward(DefaultLeaseTTL - time.Second)
+	leader, err = second.acquire(ctx)
+	assert.NoError(t, err)
+	assert.False(t, leader)
+	f.redis.FastForward(2 * time.Second)
+	leader, err = second.acquire(ctx)
+	assert.NoError(t, err)
+	assert.True(t, leader)
+	leader, err = first.acquire(ctx)
+	assert.NoError(t, err)
+	assert.False(t, leader)
+
+	// Run releases the lease it holds when it stops
+	runCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	second.Run(runCtx)
+	assert.False(t, f.redis.Exists(leaseKey))
+}
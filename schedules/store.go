@@ -0,0 +1,176 @@
+package schedules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// schedulesKey is the Redis hash holding every schedule as JSON, by ID
+const schedulesKey = "state-machine:schedules"
+
+// maxUpdateAttempts bounds the retries of an update that raced another one
+const maxUpdateAttempts = 10
+
+// Store keeps schedules in Redis
+type Store struct {
+	client *redis.Client
+	now    func() time.Time
+}
+
+// NewStore returns a schedule store over client
+func NewStore(client *redis.Client) *Store {
+	return &Store{client: client, now: time.Now}
+}
+
+// Create assigns an ID to a new schedule, computes its first run and saves it
+func (s *Store) Create(ctx context.Context, schedule *Schedule) error {
+	now := s.now().UTC()
+	schedule.ID = uuid.NewString()
+	schedule.CreatedAt = now
+	schedule.UpdatedAt = now
+	schedule.NextRunAt = nil
+	if !schedule.Paused {
+		next, err := schedule.Next(now)
+		if err != nil {
+			return err
+		}
+		schedule.NextRunAt = &next
+	}
+
+	data, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(ctx, schedulesKey, schedule.ID, data).Err()
+}
+
+// Get returns a schedule, or ErrNotFound
+func (s *Store) Get(ctx context.Context, id string) (*Schedule, error) {
+	data, err := s.client.HGet(ctx, schedulesKey, id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decode(id, data)
+}
+
+func decode(id string, data []byte) (*Schedule, error) {
+	var schedule Schedule
+	if err := json.Unmarshal(data, &schedule); err != nil {
+		return nil, fmt.Errorf("invalid schedule %s: %w", id, err)
+	}
+	return &schedule, nil
+}
+
+// List returns every schedule, oldest first
+func (s *Store) List(ctx context.Context) ([]*Schedule, error) {
+	entries, err := s.client.HGetAll(ctx, schedulesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	schedules := make([]*Schedule, 0, len(entries))
+	for id, data := range entries {
+		schedule, err := decode(id, []byte(data))
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		if !schedules[i].CreatedAt.Equal(schedules[j].CreatedAt) {
+			return schedules[i].CreatedAt.Before(schedules[j].CreatedAt)
+		}
+		return schedules[i].ID < schedules[j].ID
+	})
+	return schedules, nil
+}
+
+// Delete removes a schedule; it returns ErrNotFound if it does not exist
+func (s *Store) Delete(ctx context.Context, id string) error {
+	removed, err := s.client.HDel(ctx, schedulesKey, id).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Update applies fn to the stored schedule and saves the result. The update is retried
+// when the schedule changed in the meantime, so fn must not have side effects.
+func (s *Store) Update(ctx context.Context, id string, fn func(*Schedule) error) (*Schedule, error) {
+	var updated *Schedule
+	txf := func(tx *redis.Tx) error {
+		data, err := tx.HGet(ctx, schedulesKey, id).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		schedule, err := decode(id, data)
+		if err != nil {
+			return err
+		}
+		if err := fn(schedule); err != nil {
+			return err
+		}
+		schedule.UpdatedAt = s.now().UTC()
+		if data, err = json.Marshal(schedule); err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return pipe.HSet(ctx, schedulesKey, id, data).Err()
+		})
+		updated = schedule
+		return err
+	}
+
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		err := s.client.Watch(ctx, txf, schedulesKey)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return updated, nil
+	}
+	return nil, fmt.Errorf("schedule %s is updated concurrently", id)
+}
+
+// Pause stops a schedule from firing until it is resumed
+func (s *Store) Pause(ctx context.Context, id string) (*Schedule, error) {
+	return s.Update(ctx, id, func(schedule *Schedule) error {
+		schedule.Paused = true
+		schedule.NextRunAt = nil
+		return nil
+	})
+}
+
+// Resume lets a paused schedule fire again, from its next run after now. Runs missed
+// while it was paused are not made up.
+func (s *Store) Resume(ctx context.Context, id string) (*Schedule, error) {
+	return s.Update(ctx, id, func(schedule *Schedule) error {
+		if !schedule.Paused {
+			return nil
+		}
+		next, err := schedule.Next(s.now())
+		if err != nil {
+			return err
+		}
+		schedule.Paused = false
+		schedule.NextRunAt = &next
+		return nil
+	})
+}